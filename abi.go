@@ -0,0 +1,253 @@
+package edb
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/aj3423/edb/sigdb"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Contract ABI, saved in the context .json as the original ABI json
+type Abi struct {
+	abi.ABI
+	raw json.RawMessage
+}
+
+/*
+Accepts:
+  - the ABI array: [{"type":"function",...}]
+  - build artifacts of hardhat/truffle/foundry: {"abi": [...], ...}
+  - ABI array as string, eg: the "result" of etherscan `getabi` api
+*/
+func ParseAbi(bs []byte) (*Abi, error) {
+	bs = bytes.TrimSpace(bs)
+	if len(bs) == 0 {
+		return nil, errors.New("empty abi")
+	}
+
+	switch bs[0] {
+	case '"':
+		var s string
+		if e := json.Unmarshal(bs, &s); e != nil {
+			return nil, errors.Wrap(e, "invalid abi string")
+		}
+		return ParseAbi([]byte(s))
+	case '{':
+		var artifact struct {
+			Abi json.RawMessage `json:"abi"`
+		}
+		if e := json.Unmarshal(bs, &artifact); e != nil || len(artifact.Abi) == 0 {
+			return nil, errors.New("no \"abi\" field found")
+		}
+		return ParseAbi(artifact.Abi)
+	}
+
+	a := &Abi{}
+	if e := a.UnmarshalJSON(bs); e != nil {
+		return nil, e
+	}
+	return a, nil
+}
+
+func (a *Abi) MarshalJSON() ([]byte, error) {
+	if len(a.raw) == 0 {
+		return []byte("[]"), nil
+	}
+	return a.raw, nil
+}
+func (a *Abi) UnmarshalJSON(bs []byte) error {
+	if e := a.ABI.UnmarshalJSON(bs); e != nil {
+		return errors.Wrap(e, "invalid abi")
+	}
+	a.raw = common.CopyBytes(bs)
+	return nil
+}
+
+func (a *Abi) DecodeCall(input []byte) (*sigdb.Decoded, error) {
+	if len(input) < 4 {
+		return nil, errors.Errorf("data too short: %d bytes", len(input))
+	}
+	m, e := a.MethodById(input[:4])
+	if e != nil {
+		return nil, e
+	}
+	values, e := m.Inputs.UnpackValues(input[4:])
+	if e != nil {
+		return nil, errors.Wrapf(e, "fail decode calldata of %s", m.Sig)
+	}
+	return &sigdb.Decoded{
+		Sig:    sigdb.NewSignature(m.RawName, m.Inputs, m.Outputs),
+		Args:   m.Inputs,
+		Values: values,
+	}, nil
+}
+
+// Decode the return data of a call with calldata `input`
+func (a *Abi) DecodeReturn(input, output []byte) (*sigdb.Decoded, error) {
+	if len(input) < 4 {
+		return nil, errors.Errorf("data too short: %d bytes", len(input))
+	}
+	m, e := a.MethodById(input[:4])
+	if e != nil {
+		return nil, e
+	}
+	values, e := m.Outputs.UnpackValues(output)
+	if e != nil {
+		return nil, errors.Wrapf(e, "fail decode return data of %s", m.Sig)
+	}
+	return &sigdb.Decoded{
+		Sig:    sigdb.NewSignature(m.RawName, m.Inputs, m.Outputs),
+		Args:   m.Outputs,
+		Values: values,
+	}, nil
+}
+
+// Custom errors, `Error(string)` and `Panic(uint256)` are not in the ABI
+func (a *Abi) DecodeError(data []byte) (*sigdb.Decoded, error) {
+	if len(data) < 4 {
+		return nil, errors.Errorf("data too short: %d bytes", len(data))
+	}
+	for _, err := range a.Errors {
+		if !bytes.Equal(err.ID[:4], data[:4]) {
+			continue
+		}
+		values, e := err.Inputs.UnpackValues(data[4:])
+		if e != nil {
+			return nil, errors.Wrapf(e, "fail decode error %s", err.Sig)
+		}
+		return &sigdb.Decoded{
+			Sig:    sigdb.NewSignature(err.Name, err.Inputs, nil),
+			Args:   err.Inputs,
+			Values: values,
+		}, nil
+	}
+	return nil, errors.Errorf("no error with selector %x", data[:4])
+}
+
+func (a *Abi) DecodeLog(topics []common.Hash, data []byte) (*sigdb.Decoded, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("anonymous log")
+	}
+	ev, e := a.EventByID(topics[0])
+	if e != nil {
+		return nil, e
+	}
+	dataValues, e := ev.Inputs.NonIndexed().UnpackValues(data)
+	if e != nil {
+		return nil, errors.Wrapf(e, "fail decode log data of %s", ev.Sig)
+	}
+
+	values := []any{}
+	ti := 1
+	for _, arg := range ev.Inputs {
+		if !arg.Indexed {
+			values = append(values, dataValues[0])
+			dataValues = dataValues[1:]
+			continue
+		}
+		if ti >= len(topics) {
+			return nil, errors.Errorf("not enough topics for %s", ev.Sig)
+		}
+		topic := topics[ti]
+		ti++
+
+		switch arg.Type.T {
+		case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+			values = append(values, topic) // only the hash is logged
+		default:
+			v, e := abi.Arguments{{Type: arg.Type}}.UnpackValues(topic[:])
+			if e != nil {
+				return nil, e
+			}
+			values = append(values, v[0])
+		}
+	}
+	return &sigdb.Decoded{
+		Sig:    sigdb.NewSignature(ev.RawName, ev.Inputs, nil),
+		Args:   ev.Inputs,
+		Values: values,
+	}, nil
+}
+
+// Attach ABI to a contract address, it's used for decoding in tracers
+func (ctx *Context) SetAbi(addr common.Address, a *Abi) {
+	if ctx.Abis == nil {
+		ctx.Abis = map[common.Address]*Abi{}
+	}
+	ctx.Abis[addr] = a
+}
+
+// the first ABI found of `addrs`, or of their implementations if they are proxies
+func (ctx *Context) abi_of(addrs []common.Address) *Abi {
+	for _, addr := range addrs {
+		if a, ok := ctx.Abis[addr]; ok {
+			return a
+		}
+	}
+	for _, addr := range addrs {
+		if a, ok := ctx.Abis[ctx.Implementation(addr)]; ok {
+			return a
+		}
+	}
+	return nil
+}
+
+/*
+The Decode* functions decode with the ABI attached to the first of `addrs`,
+or fallback to the signature database if no ABI or not found in ABI.
+Multiple addresses are for delegatecall, eg: [proxy, implementation]
+*/
+func (ctx *Context) DecodeCall(input []byte, addrs ...common.Address) (*sigdb.Decoded, error) {
+	if a := ctx.abi_of(addrs); a != nil {
+		if d, e := a.DecodeCall(input); e == nil {
+			return d, nil
+		}
+	}
+	return sigdb.Default().DecodeCall(input)
+}
+
+func (ctx *Context) DecodeReturn(input, output []byte, addrs ...common.Address) (*sigdb.Decoded, error) {
+	if a := ctx.abi_of(addrs); a != nil {
+		if d, e := a.DecodeReturn(input, output); e == nil {
+			return d, nil
+		}
+	}
+	if len(input) < 4 {
+		return nil, errors.Errorf("data too short: %d bytes", len(input))
+	}
+	var sel [4]byte
+	copy(sel[:], input)
+	return sigdb.Default().DecodeReturn(sel, output)
+}
+
+// revert data: `Error(string)`, `Panic(uint256)` or custom error
+func (ctx *Context) DecodeRevert(data []byte, addrs ...common.Address) (*sigdb.Decoded, error) {
+	if a := ctx.abi_of(addrs); a != nil {
+		if d, e := a.DecodeError(data); e == nil {
+			return d, nil
+		}
+	}
+	return sigdb.Default().DecodeCall(data)
+}
+
+func (ctx *Context) DecodeLog(topics []common.Hash, data []byte, addrs ...common.Address) (*sigdb.Decoded, error) {
+	if a := ctx.abi_of(addrs); a != nil {
+		if d, e := a.DecodeLog(topics, data); e == nil {
+			return d, nil
+		}
+	}
+	return sigdb.Default().DecodeLog(topics, data)
+}
+
+// Human readable calldata, see `sigdb.DB.CallString`
+func (ctx *Context) CallString(input []byte, addrs ...common.Address) string {
+	if a := ctx.abi_of(addrs); a != nil {
+		if d, e := a.DecodeCall(input); e == nil {
+			return d.String()
+		}
+	}
+	return sigdb.Default().CallString(input)
+}
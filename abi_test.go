@@ -0,0 +1,83 @@
+package edb
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleAbi = `[
+	{"type":"function","name":"getData","inputs":[],"outputs":[{"name":"value","type":"uint256"}]},
+	{"type":"function","name":"setData","inputs":[{"name":"x","type":"uint256"}],"outputs":[]},
+	{"type":"event","name":"Set","inputs":[
+		{"name":"who","type":"address","indexed":true},
+		{"name":"x","type":"uint256","indexed":false}
+	]},
+	{"type":"error","name":"TooLarge","inputs":[{"name":"max","type":"uint256"}]}
+]`
+
+func TestParseAbi(t *testing.T) {
+	for _, s := range []string{
+		sampleAbi,
+		`{"contractName": "Sample", "abi": ` + sampleAbi + `}`,                          // artifact
+		`"[{\"type\":\"function\",\"name\":\"getData\",\"inputs\":[],\"outputs\":[]}]"`, // etherscan
+	} {
+		a, e := ParseAbi([]byte(s))
+		assert.Nil(t, e)
+		assert.Contains(t, a.Methods, "getData")
+	}
+	_, e := ParseAbi([]byte(`{"bytecode": "0x"}`))
+	assert.NotNil(t, e)
+}
+
+func TestAbiDecode(t *testing.T) {
+	ctx := NewSampleContext()
+	a, e := ParseAbi([]byte(sampleAbi))
+	assert.Nil(t, e)
+	ctx.SetAbi(ctx.This(), a)
+	this := ctx.This()
+
+	// calldata
+	input := append(util.HexDec("5b4b73a9"), common.LeftPadBytes([]byte{7}, 32)...)
+	d, e := ctx.DecodeCall(input, this)
+	assert.Nil(t, e)
+	assert.Equal(t, "setData(x: 7)", d.String())
+
+	// return data
+	d, e = ctx.DecodeReturn(util.HexDec("3bc5de30"), common.LeftPadBytes([]byte{1}, 32), this)
+	assert.Nil(t, e)
+	assert.Equal(t, "getData(value: 1)", d.String())
+
+	// custom error
+	sel := a.Errors["TooLarge"].ID
+	d, e = ctx.DecodeRevert(append(sel[:4], common.LeftPadBytes([]byte{100}, 32)...), this)
+	assert.Nil(t, e)
+	assert.Equal(t, "TooLarge(max: 100)", d.String())
+
+	// Panic(uint256) isn't in ABI, fallback to signature database
+	d, e = ctx.DecodeRevert(append(util.HexDec("4e487b71"), common.LeftPadBytes([]byte{0x11}, 32)...), this)
+	assert.Nil(t, e)
+	assert.Equal(t, "Panic(17) // arithmetic overflow or underflow", d.String())
+
+	// log
+	who := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	topics := []common.Hash{a.Events["Set"].ID, common.BytesToHash(who[:])}
+	d, e = ctx.DecodeLog(topics, common.LeftPadBytes([]byte{7}, 32), this)
+	assert.Nil(t, e)
+	assert.Equal(t, "Set(who: 0x1111111111111111111111111111111111111111, x: 7)", d.String())
+	assert.Equal(t, big.NewInt(7), d.Values[1])
+
+	// no ABI for the address, fallback to signature database
+	assert.Equal(t, "5b4b73a9", ctx.CallString(input, common.HexToAddress("0x1234")))
+
+	// saved in .json
+	fn := filepath.Join(t.TempDir(), "a.json")
+	assert.Nil(t, ctx.Save(fn))
+	ctx2 := &Context{}
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Equal(t, "setData(x: 7)", ctx2.CallString(input, this))
+}
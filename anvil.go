@@ -0,0 +1,189 @@
+package edb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// Foundry Anvil `--dump-state` / `--load-state` format,
+// only the accounts and the block env are used, other fields are ignored.
+
+type AnvilAccount struct {
+	Nonce   uint64            `json:"nonce"`
+	Balance *hexutil.Big      `json:"balance"`
+	Code    hexutil.Bytes     `json:"code"`
+	Storage map[string]string `json:"storage"` // map[hex slot]hex value
+}
+
+type AnvilBlock struct {
+	Number     *hexutil.Big    `json:"number,omitempty"`
+	Coinbase   *common.Address `json:"coinbase,omitempty"`
+	Timestamp  *hexutil.Big    `json:"timestamp,omitempty"`
+	GasLimit   *hexutil.Big    `json:"gas_limit,omitempty"`
+	BaseFee    *hexutil.Big    `json:"basefee,omitempty"`
+	Difficulty *hexutil.Big    `json:"difficulty,omitempty"`
+	PrevRandao *common.Hash    `json:"prevrandao,omitempty"`
+}
+
+type AnvilState struct {
+	Block    *AnvilBlock                      `json:"block,omitempty"`
+	Accounts map[common.Address]*AnvilAccount `json:"accounts"`
+}
+
+// "0x0", "0x00ff" -> uint256
+func parse_anvil_word(s string) (*uint256.Int, error) {
+	b, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid hex: %s", s)
+	}
+	v, overflow := uint256.FromBig(b)
+	if overflow {
+		return nil, fmt.Errorf("overflow: %s", s)
+	}
+	return v, nil
+}
+
+// Export the current state of all `Contracts`
+// Only the state that has been fetched is included,
+// export it before running to get the state before the tx.
+func (ctx *Context) ExportAnvilState() *AnvilState {
+	st := &AnvilState{
+		Block: &AnvilBlock{
+			Number:     (*hexutil.Big)(new(big.Int).SetUint64(ctx.Block.Number)),
+			Coinbase:   &ctx.Block.Coinbase,
+			Timestamp:  (*hexutil.Big)(new(big.Int).SetUint64(ctx.Block.Timestamp)),
+			GasLimit:   (*hexutil.Big)(new(big.Int).SetUint64(ctx.Block.GasLimit)),
+			BaseFee:    (*hexutil.Big)(new(big.Int).SetUint64(ctx.Block.BaseFee)),
+			Difficulty: (*hexutil.Big)(big.NewInt(0)),
+			PrevRandao: &ctx.Block.PrevRandao,
+		},
+		Accounts: map[common.Address]*AnvilAccount{},
+	}
+
+	if ctx.Block.Difficulty != nil {
+		st.Block.Difficulty = (*hexutil.Big)(new(big.Int).Set(ctx.Block.Difficulty))
+	}
+
+	for addr, contract := range ctx.Contracts {
+		acc := &AnvilAccount{
+			Nonce:   contract.Nonce,
+			Balance: (*hexutil.Big)(big.NewInt(0)),
+			Code:    hexutil.Bytes(contract.Code.Binary),
+			Storage: map[string]string{},
+		}
+		if acc.Code == nil {
+			acc.Code = hexutil.Bytes{}
+		}
+		if contract.Balance != nil {
+			acc.Balance = (*hexutil.Big)(new(big.Int).Set(contract.Balance))
+		}
+		// contracts always have nonce >= 1 since EIP-161
+		if acc.Nonce == 0 && len(contract.Code.Binary) > 0 {
+			acc.Nonce = 1
+		}
+		for slot, val := range contract.Storage {
+			slotInt := new(uint256.Int).SetBytes(slot.Bytes())
+			acc.Storage[slotInt.Hex()] = val.Hex()
+		}
+		st.Accounts[addr] = acc
+	}
+	return st
+}
+
+func (ctx *Context) SaveAnvilState(fn string) error {
+	bs, e := json.MarshalIndent(ctx.ExportAnvilState(), "", "  ")
+	if e != nil {
+		return e
+	}
+	return ioutil.WriteFile(fn, bs, 0666)
+}
+
+func LoadAnvilState(fn string) (*AnvilState, error) {
+	bs, e := ioutil.ReadFile(fn)
+	if e != nil {
+		return nil, e
+	}
+	st := &AnvilState{}
+	if e = json.Unmarshal(bs, st); e != nil {
+		return nil, errors.Wrap(e, fn)
+	}
+	return st, nil
+}
+
+/*
+Use the dump as the starting state,
+accounts in the dump replace the existing ones in `Contracts`.
+
+If `offline` is set, the dump is considered as the whole world,
+nothing is fetched from the node, and the block env in the dump is also used.
+*/
+func (ctx *Context) ImportAnvilState(st *AnvilState, offline bool) error {
+	contracts := ctx.Contracts
+	if offline { // stale accounts not in the dump are dropped
+		contracts = map[common.Address]*Contract{}
+	}
+	for addr, acc := range st.Accounts {
+		if acc == nil {
+			return fmt.Errorf("accounts[%s]: null", addr.Hex())
+		}
+		contract := NewContract()
+		contract.Nonce = acc.Nonce
+		contract.Balance = big.NewInt(0)
+		if acc.Balance != nil {
+			contract.Balance = acc.Balance.ToInt()
+		}
+		if e := contract.Code.Set(acc.Code); e != nil {
+			return errors.Wrapf(e, "accounts[%s].code", addr.Hex())
+		}
+		for k, v := range acc.Storage {
+			slot, e := parse_anvil_word(k)
+			if e != nil {
+				return errors.Wrapf(e, "accounts[%s].storage", addr.Hex())
+			}
+			val, e := parse_anvil_word(v)
+			if e != nil {
+				return errors.Wrapf(e, "accounts[%s].storage[%s]", addr.Hex(), k)
+			}
+			contract.Storage[common.Hash(slot.Bytes32())] = val
+		}
+		contracts[addr] = contract
+	}
+	ctx.Contracts = contracts
+
+	if !offline {
+		return nil
+	}
+	ctx.Chain.Offline = true
+
+	if b := st.Block; b != nil {
+		if b.Number != nil {
+			ctx.Block.Number = b.Number.ToInt().Uint64()
+		}
+		if b.Coinbase != nil {
+			ctx.Block.Coinbase = *b.Coinbase
+		}
+		if b.Timestamp != nil {
+			ctx.Block.Timestamp = b.Timestamp.ToInt().Uint64()
+		}
+		if b.GasLimit != nil {
+			ctx.Block.GasLimit = b.GasLimit.ToInt().Uint64()
+		}
+		if b.BaseFee != nil {
+			ctx.Block.BaseFee = b.BaseFee.ToInt().Uint64()
+		}
+		if b.Difficulty != nil {
+			ctx.Block.Difficulty = b.Difficulty.ToInt()
+		}
+		if b.PrevRandao != nil {
+			ctx.Block.PrevRandao = *b.PrevRandao
+		}
+	}
+	return nil
+}
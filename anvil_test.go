@@ -0,0 +1,66 @@
+package edb
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnvilStateRoundTrip(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "state.json")
+
+	ctx := NewSampleContext()
+	ctx.Contract().Balance = big.NewInt(7)
+	ctx.Contract().Storage[common.HexToHash("0xff")] = new(uint256.Int).SetAllOne()
+	eoa := common.HexToAddress("0xaa")
+	ctx.Contracts[eoa] = &Contract{
+		Nonce:   5,
+		Balance: big.NewInt(123),
+		Code:    &Code{},
+		Storage: map[common.Hash]*uint256.Int{},
+	}
+	ctx.Block.Number = 100
+	ctx.Block.Timestamp = 200
+	assert.Nil(t, ctx.SaveAnvilState(fn))
+
+	st, e := LoadAnvilState(fn)
+	assert.Nil(t, e)
+	ctx2 := NewContext()
+	assert.Nil(t, ctx2.ImportAnvilState(st, true))
+	assert.True(t, ctx2.Chain.Offline)
+	assert.Equal(t, uint64(100), ctx2.Block.Number)
+	assert.Equal(t, uint64(200), ctx2.Block.Timestamp)
+
+	assert.Equal(t, len(ctx.Contracts), len(ctx2.Contracts))
+	for addr, c := range ctx.Contracts {
+		c2 := ctx2.Contracts[addr]
+		assert.NotNil(t, c2, addr.Hex())
+		assert.Equal(t, c.Balance, c2.Balance, addr.Hex())
+		assert.Equal(t, util.HexEnc(c.Code.Binary), util.HexEnc(c2.Code.Binary), addr.Hex())
+		assert.Equal(t, c.Storage, c2.Storage, addr.Hex())
+	}
+	assert.Equal(t, uint64(5), ctx2.Contracts[eoa].Nonce)
+	assert.Equal(t, uint64(1), ctx2.Contracts[ctx.This()].Nonce) // contract nonce starts from 1
+}
+
+func TestAnvilStateOfflineDropsStale(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "state.json")
+	assert.Nil(t, NewSampleContext().SaveAnvilState(fn))
+	st, e := LoadAnvilState(fn)
+	assert.Nil(t, e)
+
+	stale := common.HexToAddress("0xdead")
+	ctx := NewContext()
+	ctx.Contracts[stale] = NewContract()
+	assert.Nil(t, ctx.ImportAnvilState(st, false))
+	assert.NotNil(t, ctx.Contracts[stale]) // online, merged with existing accounts
+
+	assert.Nil(t, ctx.ImportAnvilState(st, true))
+	assert.Nil(t, ctx.Contracts[stale])
+	assert.Equal(t, len(st.Accounts), len(ctx.Contracts))
+}
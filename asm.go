@@ -1,12 +1,10 @@
 package edb
 
 import (
-	"encoding/binary"
 	"fmt"
 
 	"github.com/aj3423/edb/util"
 	"github.com/ethereum/go-ethereum/core/vm"
-	"github.com/fatih/color"
 )
 
 var ShowHexPC = false
@@ -17,13 +15,39 @@ type Line struct {
 	LineNum uint64
 	Op      *Operation
 	Data    []byte // additional bytes for OpCode, eg: 4 bytes for PUSH4
+
+	// Non-code region, eg: metadata, constructor args,
+	// the whole region is in `Data` and `Op` is `opDataRegion`
+	IsData bool
+	Meta   *Metadata // if the region is compiler metadata
 }
 
+// placeholder operation of data regions, never executed
+var opDataRegion = &Operation{OpCode: vm.OpCode(0xfe)}
+
+// max bytes shown for a data region
+const dataShowLen = 32
+
 func (l *Line) String() string {
+	name := OpName(l.Op.OpCode)
+	data := util.HexEnc(l.Data)
+
+	if l.IsData {
+		name = "DATA"
+		if len(l.Data) > dataShowLen {
+			data = fmt.Sprintf("%s... (%d bytes)", util.HexEnc(l.Data[:dataShowLen]), len(l.Data))
+		}
+		if l.Meta != nil {
+			data += "  // " + l.Meta.String()
+		}
+	} else if _, ok := OpTable[l.Op.OpCode]; !ok {
+		name = fmt.Sprintf("INVALID(%02x)", byte(l.Op.OpCode))
+	}
+
 	if ShowHexPC {
-		return fmt.Sprintf("%8x %12s  %s", l.Pc, l.Op.OpCode.String(), util.HexEnc(l.Data))
+		return fmt.Sprintf("%8x %12s  %s", l.Pc, name, data)
 	} else {
-		return fmt.Sprintf("% 8d %12s  %s", l.Pc, l.Op.OpCode.String(), util.HexEnc(l.Data))
+		return fmt.Sprintf("% 8d %12s  %s", l.Pc, name, data)
 	}
 }
 
@@ -34,6 +58,8 @@ type Asm struct {
 
 	// for finding *Line by pc
 	mapPc map[uint64]*Line // map[pc]*Line
+
+	metadata []*Metadata
 }
 
 func NewAsm() *Asm {
@@ -44,6 +70,7 @@ func NewAsm() *Asm {
 func (a *Asm) Reset() *Asm {
 	a.sequence = nil
 	a.mapPc = map[uint64]*Line{}
+	a.metadata = nil
 	return a
 }
 func (a *Asm) LineCount() int {
@@ -61,53 +88,96 @@ func (a *Asm) AtRow(row int) *Line {
 	return a.sequence[row]
 }
 
+// All compiler metadata found in code, ordered by pc
+func (a *Asm) Metadata() []*Metadata {
+	return a.metadata
+}
+
+func (a *Asm) add_line(line *Line) {
+	line.LineNum = uint64(len(a.sequence))
+	a.sequence = append(a.sequence, line)
+	a.mapPc[line.Pc] = line
+}
+
+/*
+Disassemble with linear sweep, except:
+  - compiler metadata, see `find_metadata`
+  - bytes after the last metadata, eg: constructor args
+  - truncated PUSH at the end of a code region
+
+are marked as data.
+Unknown opcodes are kept as 1-byte lines, executing them fails.
+*/
 func (a *Asm) Disasm(
 	code []byte,
 ) error {
 	a.Reset()
 
-	if len(code) > 2 { // remove trailing CBOR encoded metadata:
-		last_2 := code[len(code)-2:]
-		meta_len := binary.BigEndian.Uint16(last_2)
-		code = code[0 : len(code)-int(meta_len)-2]
-	}
+	a.metadata = find_metadata(code)
 
 	var codeLen = uint64(len(code))
 
-	var pc uint64 = 0
+	// code after the last metadata is data, eg: constructor args
+	codeEnd := codeLen
+	if n := len(a.metadata); n > 0 {
+		last := a.metadata[n-1]
+		codeEnd = last.Pc + last.Size
+	}
 
-	var line *Line
+	var pc uint64 = 0
+	iMeta := 0
 
 	for pc < codeLen {
-		opCode := code[pc]
-		op, ok := OpTable[vm.OpCode(opCode)]
-		if !ok {
-			// invalid op occurs at the end of code,
-			// don't know how to parse the code+metadata perfectly,
-			// stop disassembling with a warning
+		// end of current code region
+		regionEnd := codeEnd
+		if iMeta < len(a.metadata) {
+			meta := a.metadata[iMeta]
+			if pc == meta.Pc {
+				a.add_line(&Line{
+					Pc:     pc,
+					Op:     opDataRegion,
+					Data:   code[pc : pc+meta.Size],
+					IsData: true,
+					Meta:   meta,
+				})
+				pc += meta.Size
+				iMeta++
+				continue
+			}
+			regionEnd = meta.Pc
+		}
 
-			color.Yellow("Warning: Disasm: invalid opcode: %s @pc: %d/%d", vm.OpCode(opCode).String(), pc, codeLen)
-			return nil
+		if pc >= regionEnd { // trailing data
+			a.add_line(&Line{
+				Pc:     pc,
+				Op:     opDataRegion,
+				Data:   code[pc:],
+				IsData: true,
+			})
+			break
 		}
 
-		if pc+1+op.OpSize > codeLen {
-			/*
-				In most cases this error is caused by trailing CBOR metadata, see:
-					https://docs.soliditylang.org/en/v0.8.13/metadata.html#contract-metadata
-			*/
-			// TODO skip metadata
-			color.Yellow("Warning: Disasm: not enough data for opcode: %s at pc: %d/%d, require %d bytes",
-				op.OpCode.String(), pc, codeLen, op.OpSize)
-			return nil
+		opCode := vm.OpCode(code[pc])
+		op, ok := OpTable[opCode]
+		if !ok {
+			op = &Operation{OpCode: opCode}
 		}
-		line = &Line{
-			Pc:      pc,
-			LineNum: uint64(len(a.sequence)),
-			Op:      op,
-			Data:    code[pc+1 : pc+1+op.OpSize],
+
+		if pc+1+op.OpSize > regionEnd { // truncated PUSH
+			a.add_line(&Line{
+				Pc:     pc,
+				Op:     opDataRegion,
+				Data:   code[pc:regionEnd],
+				IsData: true,
+			})
+			pc = regionEnd
+			continue
 		}
-		a.sequence = append(a.sequence, line)
-		a.mapPc[uint64(pc)] = line
+		a.add_line(&Line{
+			Pc:   pc,
+			Op:   op,
+			Data: code[pc+1 : pc+1+op.OpSize],
+		})
 
 		pc += 1 + op.OpSize
 	}
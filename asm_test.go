@@ -0,0 +1,86 @@
+package edb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// {"ipfs": 0x1220.., "solc": 0.8.13} + 0x0033
+const solcMetadata = "a2646970667358221220e5f07a97a4abeb88a5fcf07910fb20896f7f95326c9a7a8f1f2a2686532f5a3164736f6c634300080d0033"
+
+func TestDisasmMetadata(t *testing.T) {
+	code := util.HexDec("6080604052600080fd" + "fe" + solcMetadata)
+
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(code))
+
+	metas := asm.Metadata()
+	assert.Equal(t, 1, len(metas))
+	assert.Equal(t, "solc", metas[0].Compiler)
+	assert.Equal(t, "0.8.13", metas[0].Version)
+	assert.True(t, strings.HasPrefix(metas[0].Ipfs, "Qm"))
+	assert.Equal(t, uint64(10), metas[0].Pc)
+
+	last := asm.AtRow(asm.LineCount() - 1)
+	assert.True(t, last.IsData)
+	assert.Equal(t, metas[0], last.Meta)
+	assert.Contains(t, last.String(), "solc 0.8.13")
+}
+
+func TestDisasmConstructorArgs(t *testing.T) {
+	args := "000000000000000000000000000000000000000000000000000000000000c0fe"
+	code := util.HexDec("6080604052600080fd" + "fe" + solcMetadata + args)
+
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(code))
+	assert.Equal(t, 1, len(asm.Metadata()))
+
+	last := asm.AtRow(asm.LineCount() - 1)
+	assert.True(t, last.IsData)
+	assert.Nil(t, last.Meta)
+	assert.Equal(t, args, util.HexEnc(last.Data))
+}
+
+func TestDisasmMetadataLikeCode(t *testing.T) {
+	// looks like metadata, but not after INVALID and not at the end
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(util.HexDec("6000"+solcMetadata+"6000")))
+	assert.Equal(t, 0, len(asm.Metadata()))
+}
+
+func TestDisasmNoMetadata(t *testing.T) {
+	// ends with a truncated PUSH2, and an unknown opcode in the middle
+	code := util.HexDec("600160020c0161ff")
+
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(code))
+	assert.Equal(t, 0, len(asm.Metadata()))
+	assert.Equal(t, 5, asm.LineCount())
+
+	assert.Contains(t, asm.AtRow(2).String(), "INVALID(0c)")
+	assert.True(t, asm.AtRow(4).IsData)
+}
+
+func TestDisasmVyper(t *testing.T) {
+	// vyper 0.3.4~0.3.9: {"vyper": [0,3,7]} + 0x000b
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(util.HexDec("600035"+"a165767970657283000307"+"000b")))
+	assert.Equal(t, 1, len(asm.Metadata()))
+	assert.Equal(t, "vyper 0.3.7", asm.Metadata()[0].String())
+
+	// vyper 0.3.10~: [3, [], 0, {"vyper": [0,4,0]}] + len including itself
+	asm = NewAsm()
+	assert.Nil(t, asm.Disasm(util.HexDec("600035"+"840380"+"00"+"a165767970657283000400"+"0011")))
+	assert.Equal(t, 1, len(asm.Metadata()))
+	assert.Equal(t, "0.4.0", asm.Metadata()[0].Version)
+}
+
+func TestDisasmShortCode(t *testing.T) {
+	// the last 2 bytes look like a metadata length larger than the code
+	asm := NewAsm()
+	assert.Nil(t, asm.Disasm(util.HexDec("6001ffff")))
+	assert.Equal(t, uint64(0), asm.AtRow(0).Pc)
+}
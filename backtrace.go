@@ -0,0 +1,31 @@
+package edb
+
+import (
+	"fmt"
+)
+
+/*
+Call stack from the innermost call, delegatecall is shown as "proxy → implementation", eg:
+
+	#0  0x4a3f...c1 → 0x9b2e...07 (EIP-1967)  pc: 1234  transfer(0x8ba1...72, 100)
+	#1  0x4a3f...c1  pc: 87  transfer(0x8ba1...72, 100)
+*/
+func (ctx *Context) Backtrace() []string {
+	ret := []string{}
+	n := ctx.CallStack.Len()
+	for i := n - 1; i >= 0; i-- {
+		call := ctx.CallStack.Data[i]
+
+		where := call.This.Hex()
+		if call.CodePtr != nil {
+			kind := "delegatecall"
+			if p, ok := ctx.Proxies[call.This]; ok && p.Implementation == *call.CodePtr {
+				kind = p.Kind.String()
+			}
+			where = fmt.Sprintf("%s → %s (%s)", where, call.CodePtr.Hex(), kind)
+		}
+		ret = append(ret, fmt.Sprintf("#%d  %s  pc: %d  %s",
+			n-1-i, where, call.Pc, ctx.CallString(call.Msg.Data, call.This, call.CodeAddress())))
+	}
+	return ret
+}
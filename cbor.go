@@ -0,0 +1,145 @@
+package edb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Minimal CBOR decoder (RFC 8949) for the compiler metadata,
+// indefinite length and floats are not supported.
+//
+//	uint, negative int -> int64 (uint64 if too large)
+//	bytes              -> []byte
+//	text               -> string
+//	array              -> []any
+//	map                -> map[string]any, only text keys
+//	true, false, null  -> bool, nil
+
+var errCborTruncated = errors.New("cbor: truncated")
+
+const cborMaxDepth = 16
+
+// decode the first item, returns the item and its size
+func cbor_decode(bs []byte) (any, int, error) {
+	return cbor_decode_item(bs, 0)
+}
+
+// the "argument" of the initial byte, the length or the value
+func cbor_read_arg(bs []byte) (major byte, arg uint64, n int, e error) {
+	if len(bs) == 0 {
+		return 0, 0, 0, errCborTruncated
+	}
+	major = bs[0] >> 5
+	info := bs[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+	case info <= 27:
+		size := 1 << (info - 24) // 1, 2, 4, 8
+		if len(bs) < 1+size {
+			return 0, 0, 0, errCborTruncated
+		}
+		switch size {
+		case 1:
+			arg = uint64(bs[1])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(bs[1:]))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(bs[1:]))
+		case 8:
+			arg = binary.BigEndian.Uint64(bs[1:])
+		}
+		return major, arg, 1 + size, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: unsupported additional info: %d", info)
+	}
+}
+
+func cbor_decode_item(bs []byte, depth int) (any, int, error) {
+	if depth > cborMaxDepth {
+		return nil, 0, errors.New("cbor: too deep")
+	}
+	major, arg, n, e := cbor_read_arg(bs)
+	if e != nil {
+		return nil, 0, e
+	}
+
+	switch major {
+	case 0: // uint
+		if arg > 1<<63-1 {
+			return arg, n, nil
+		}
+		return int64(arg), n, nil
+
+	case 1: // negative int, -1-arg
+		if arg > 1<<63-1 {
+			return nil, 0, errors.New("cbor: negative int overflow")
+		}
+		return -1 - int64(arg), n, nil
+
+	case 2, 3: // bytes, text
+		if arg > uint64(len(bs)-n) {
+			return nil, 0, errCborTruncated
+		}
+		data := bs[n : n+int(arg)]
+		if major == 3 {
+			return string(data), n + int(arg), nil
+		}
+		return data, n + int(arg), nil
+
+	case 4: // array
+		if arg > uint64(len(bs)) { // each item takes at least 1 byte
+			return nil, 0, errCborTruncated
+		}
+		arr := make([]any, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, size, e := cbor_decode_item(bs[n:], depth+1)
+			if e != nil {
+				return nil, 0, e
+			}
+			arr = append(arr, item)
+			n += size
+		}
+		return arr, n, nil
+
+	case 5: // map
+		if arg > uint64(len(bs)) {
+			return nil, 0, errCborTruncated
+		}
+		m := make(map[string]any, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, size, e := cbor_decode_item(bs[n:], depth+1)
+			if e != nil {
+				return nil, 0, e
+			}
+			n += size
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("cbor: unsupported map key: %v", k)
+			}
+			v, size, e := cbor_decode_item(bs[n:], depth+1)
+			if e != nil {
+				return nil, 0, e
+			}
+			n += size
+			m[key] = v
+		}
+		return m, n, nil
+
+	case 7: // simple values
+		switch arg {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22:
+			return nil, n, nil
+		}
+		return nil, 0, fmt.Errorf("cbor: unsupported simple value: %d", arg)
+
+	default: // 6: tag
+		return nil, 0, fmt.Errorf("cbor: unsupported major type: %d", major)
+	}
+}
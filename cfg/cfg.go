@@ -0,0 +1,196 @@
+package cfg
+
+import (
+	"sort"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+type EdgeKind int
+
+const (
+	EdgeFall    EdgeKind = iota // fall through to the next block
+	EdgeJump                    // static jump, PUSH + JUMP/JUMPI
+	EdgeDynamic                 // jump observed when running
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeFall:
+		return "fall"
+	case EdgeJump:
+		return "jump"
+	case EdgeDynamic:
+		return "dynamic"
+	}
+	return "unknown"
+}
+
+type Edge struct {
+	From uint64 // start pc of the source block
+	To   uint64 // start pc of the target block
+	Kind EdgeKind
+}
+
+// Basic block, a sequence of lines with only one entry and one exit
+type Block struct {
+	Start uint64 // pc of the first line
+	End   uint64 // pc of the last line
+	Lines []*edb.Line
+
+	Succs []*Edge
+	Preds []*Edge
+}
+
+func (b *Block) Last() *edb.Line {
+	return b.Lines[len(b.Lines)-1]
+}
+
+// the block ends the execution or jumps away, no fall through
+func (b *Block) IsTerminal() bool {
+	switch b.Last().Op.OpCode {
+	case vm.JUMP, vm.STOP, vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, vm.OpCode(0xfe):
+		return true
+	}
+	_, ok := edb.OpTable[b.Last().Op.OpCode]
+	return !ok
+}
+
+type CFG struct {
+	Blocks []*Block // ordered by pc
+
+	// blocks ending with JUMP/JUMPI whose target is not a constant,
+	// eg: function return address, they can be filled with `AddEdge`
+	// by the jumps observed when running, see `Recorder`,
+	// which removes the filled ones from here
+	Unresolved []*Block
+
+	blockAt map[uint64]*Block // map[start pc]*Block
+}
+
+// the line ends a block
+func is_block_end(line *edb.Line) bool {
+	switch line.Op.OpCode {
+	case vm.JUMP, vm.JUMPI, vm.STOP, vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, vm.OpCode(0xfe):
+		return true
+	}
+	_, ok := edb.OpTable[line.Op.OpCode]
+	return !ok
+}
+
+// Build the CFG with static edges only, data regions are excluded
+func Build(asm *edb.Asm) *CFG {
+	g := &CFG{blockAt: map[uint64]*Block{}}
+
+	var cur *Block
+	for row := 0; row < asm.LineCount(); row++ {
+		line := asm.AtRow(row)
+		if line.IsData {
+			cur = nil
+			continue
+		}
+		if cur == nil || line.Op.OpCode == vm.JUMPDEST {
+			cur = &Block{Start: line.Pc}
+			g.Blocks = append(g.Blocks, cur)
+			g.blockAt[cur.Start] = cur
+		}
+		cur.Lines = append(cur.Lines, line)
+		cur.End = line.Pc
+
+		if is_block_end(line) {
+			cur = nil
+		}
+	}
+
+	for i, b := range g.Blocks {
+		// fall through
+		if !b.IsTerminal() && i+1 < len(g.Blocks) {
+			next := g.Blocks[i+1]
+			if next.Start == b.End+1+uint64(len(b.Last().Data)) {
+				g.AddEdge(b.Start, next.Start, EdgeFall)
+			}
+		}
+
+		// static jump
+		op := b.Last().Op.OpCode
+		if op != vm.JUMP && op != vm.JUMPI {
+			continue
+		}
+		if target, ok := static_target(b); ok && g.isJumpDest(target) {
+			g.AddEdge(b.Start, target, EdgeJump)
+		} else {
+			g.Unresolved = append(g.Unresolved, b)
+		}
+	}
+	return g
+}
+
+// PUSH <target>; JUMP
+func static_target(b *Block) (uint64, bool) {
+	if len(b.Lines) < 2 {
+		return 0, false
+	}
+	prev := b.Lines[len(b.Lines)-2]
+	if prev.Op.OpCode < vm.PUSH1 || prev.Op.OpCode > vm.PUSH32 {
+		return 0, false
+	}
+	v := new(uint256.Int).SetBytes(prev.Data)
+	if !v.IsUint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+func (g *CFG) isJumpDest(pc uint64) bool {
+	b, ok := g.blockAt[pc]
+	return ok && b.Lines[0].Op.OpCode == vm.JUMPDEST
+}
+
+// The block that starts at `pc`
+func (g *CFG) BlockAt(pc uint64) *Block {
+	return g.blockAt[pc]
+}
+
+// The block that contains `pc`
+func (g *CFG) BlockOf(pc uint64) *Block {
+	i := sort.Search(len(g.Blocks), func(i int) bool {
+		return g.Blocks[i].Start > pc
+	})
+	if i == 0 {
+		return nil
+	}
+	b := g.Blocks[i-1]
+	if pc > b.End {
+		return nil
+	}
+	return b
+}
+
+// Add edge from the block containing `from` to the block starting at `to`,
+// returns false if either is invalid or the edge already exists
+func (g *CFG) AddEdge(from, to uint64, kind EdgeKind) bool {
+	src, dst := g.BlockOf(from), g.BlockAt(to)
+	if src == nil || dst == nil {
+		return false
+	}
+	for _, e := range src.Succs {
+		if e.To == dst.Start {
+			return false
+		}
+	}
+	e := &Edge{From: src.Start, To: dst.Start, Kind: kind}
+	src.Succs = append(src.Succs, e)
+	dst.Preds = append(dst.Preds, e)
+	return true
+}
+
+// Count of all edges
+func (g *CFG) EdgeCount() int {
+	n := 0
+	for _, b := range g.Blocks {
+		n += len(b.Succs)
+	}
+	return n
+}
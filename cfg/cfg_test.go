@@ -0,0 +1,68 @@
+package cfg
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func build(t *testing.T, code string) *CFG {
+	asm := edb.NewAsm()
+	assert.Nil(t, asm.Disasm(util.HexDec(code)))
+	return Build(asm)
+}
+
+func TestLoop(t *testing.T) {
+	// 0: PUSH1 3
+	// 2: JUMPDEST, PUSH1 1, SWAP1, SUB, DUP1, PUSH1 2, JUMPI
+	// 11: STOP
+	g := build(t, "6003"+"5b6001900380600257"+"00")
+
+	assert.Equal(t, 3, len(g.Blocks))
+	assert.Equal(t, 3, g.EdgeCount())
+	assert.Equal(t, 0, len(g.Unresolved))
+
+	idom := g.Dominators()
+	assert.Equal(t, uint64(0), idom[2])
+	assert.Equal(t, uint64(2), idom[11])
+	assert.True(t, Dominates(idom, 0, 11))
+	assert.False(t, Dominates(idom, 11, 2))
+
+	loops := g.Loops()
+	assert.Equal(t, 1, len(loops))
+	assert.Equal(t, uint64(2), loops[0].Header)
+	assert.Equal(t, []uint64{2}, loops[0].Body)
+
+	assert.True(t, strings.Contains(g.DOT(), "b2 -> b2;"))
+	_, e := g.JSON()
+	assert.Nil(t, e)
+}
+
+func TestDynamicEdge(t *testing.T) {
+	// 0: PUSH1 5, DUP1, JUMP   -- target not resolvable statically
+	// 4: STOP
+	// 5: JUMPDEST, STOP
+	code := "6005805600" + "5b00"
+	g := build(t, code)
+	assert.Equal(t, 1, len(g.Unresolved))
+	_, reachable := g.Dominators()[5]
+	assert.False(t, reachable)
+
+	ctx := edb.NewContext()
+	ctx.Chain.Offline = true
+	contract := edb.NewContract()
+	assert.Nil(t, contract.Code.Set(util.HexDec(code)))
+	ctx.Contracts[ctx.This()] = contract
+
+	rec := NewRecorder()
+	ctx.Hooks.Attach(rec)
+	assert.Nil(t, ctx.Run(-1))
+
+	assert.Equal(t, 1, rec.Apply(g, ctx.This()))
+	assert.Equal(t, EdgeDynamic, g.BlockAt(0).Succs[0].Kind)
+	assert.Equal(t, 0, len(g.Unresolved))
+	assert.Equal(t, uint64(0), g.Dominators()[5])
+}
@@ -0,0 +1,158 @@
+package cfg
+
+import "sort"
+
+// blocks reachable from the entry, in reverse postorder
+func (g *CFG) reverse_postorder() []*Block {
+	if len(g.Blocks) == 0 {
+		return nil
+	}
+	visited := map[uint64]bool{}
+	post := []*Block{}
+
+	var dfs func(b *Block)
+	dfs = func(b *Block) {
+		visited[b.Start] = true
+		for _, e := range b.Succs {
+			if !visited[e.To] {
+				dfs(g.blockAt[e.To])
+			}
+		}
+		post = append(post, b)
+	}
+	dfs(g.Blocks[0])
+
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+/*
+Immediate dominators of blocks reachable from the entry, map[block]idom,
+the entry is dominated by itself.
+
+"A Simple, Fast Dominance Algorithm", Cooper, Harvey, Kennedy
+*/
+func (g *CFG) Dominators() map[uint64]uint64 {
+	rpo := g.reverse_postorder()
+	if len(rpo) == 0 {
+		return nil
+	}
+	order := map[uint64]int{}
+	for i, b := range rpo {
+		order[b.Start] = i
+	}
+
+	entry := rpo[0].Start
+	idom := map[uint64]uint64{entry: entry}
+
+	intersect := func(a, b uint64) uint64 {
+		for a != b {
+			for order[a] > order[b] {
+				a = idom[a]
+			}
+			for order[b] > order[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, b := range rpo[1:] {
+			var newIdom uint64
+			found := false
+			for _, e := range b.Preds {
+				if _, ok := idom[e.From]; !ok {
+					continue // not processed yet, or unreachable
+				}
+				if !found {
+					newIdom, found = e.From, true
+				} else {
+					newIdom = intersect(e.From, newIdom)
+				}
+			}
+			if !found {
+				continue
+			}
+			if old, ok := idom[b.Start]; !ok || old != newIdom {
+				idom[b.Start] = newIdom
+				changed = true
+			}
+		}
+	}
+	return idom
+}
+
+// `a` dominates `b`, every path from the entry to `b` goes through `a`
+func Dominates(idom map[uint64]uint64, a, b uint64) bool {
+	for {
+		if a == b {
+			return true
+		}
+		parent, ok := idom[b]
+		if !ok || parent == b { // unreachable, or reached the entry
+			return false
+		}
+		b = parent
+	}
+}
+
+// Natural loop
+type Loop struct {
+	Header  uint64   `json:"header"`  // the block dominates the whole loop
+	Latches []uint64 `json:"latches"` // blocks jump back to the header
+	Body    []uint64 `json:"body"`    // all blocks in the loop, including header, sorted
+}
+
+// Natural loops found by back edges, loops with the same header are merged
+func (g *CFG) Loops() []*Loop {
+	idom := g.Dominators()
+
+	loops := map[uint64]*Loop{}
+	bodies := map[uint64]map[uint64]bool{}
+
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			if !Dominates(idom, e.To, e.From) {
+				continue
+			}
+			// back edge: From -> To
+			loop, ok := loops[e.To]
+			if !ok {
+				loop = &Loop{Header: e.To}
+				loops[e.To] = loop
+				bodies[e.To] = map[uint64]bool{e.To: true}
+			}
+			loop.Latches = append(loop.Latches, e.From)
+
+			// blocks that reach the latch without going through the header
+			body := bodies[e.To]
+			stack := []uint64{e.From}
+			for len(stack) > 0 {
+				n := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if body[n] {
+					continue
+				}
+				body[n] = true
+				for _, p := range g.blockAt[n].Preds {
+					stack = append(stack, p.From)
+				}
+			}
+		}
+	}
+
+	result := []*Loop{}
+	for header, loop := range loops {
+		for n := range bodies[header] {
+			loop.Body = append(loop.Body, n)
+		}
+		sort.Slice(loop.Body, func(i, j int) bool { return loop.Body[i] < loop.Body[j] })
+		result = append(result, loop)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Header < result[j].Header })
+	return result
+}
@@ -0,0 +1,111 @@
+package cfg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+)
+
+// "PUSH1 80"
+func line_text(line *edb.Line) string {
+	if len(line.Data) == 0 {
+		return edb.OpName(line.Op.OpCode)
+	}
+	return edb.OpName(line.Op.OpCode) + " " + util.HexEnc(line.Data)
+}
+
+var dotEdgeStyle = map[EdgeKind]string{
+	EdgeFall:    "style=dashed",
+	EdgeJump:    "",
+	EdgeDynamic: "color=blue",
+}
+
+// Graphviz format, eg: `dot -Tsvg cfg.dot -o cfg.svg`
+func (g *CFG) DOT() string {
+	idom := g.Dominators()
+	headers := map[uint64]bool{}
+	for _, l := range g.Loops() {
+		headers[l.Header] = true
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("digraph cfg {\n")
+	sb.WriteString("  node [shape=box fontname=monospace];\n")
+
+	for _, b := range g.Blocks {
+		label := strings.Builder{}
+		for _, line := range b.Lines {
+			fmt.Fprintf(&label, "%d: %s\\l", line.Pc, line_text(line))
+		}
+		attr := ""
+		if _, ok := idom[b.Start]; !ok {
+			attr = " color=gray fontcolor=gray" // unreachable
+		} else if headers[b.Start] {
+			attr = " color=red" // loop header
+		}
+		fmt.Fprintf(&sb, "  b%d [label=\"%s\"%s];\n", b.Start, label.String(), attr)
+	}
+	for _, b := range g.Blocks {
+		for _, e := range b.Succs {
+			style := dotEdgeStyle[e.Kind]
+			if style != "" {
+				style = " [" + style + "]"
+			}
+			fmt.Fprintf(&sb, "  b%d -> b%d%s;\n", e.From, e.To, style)
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+type jsonEdge struct {
+	To   uint64 `json:"to"`
+	Kind string `json:"kind"`
+}
+type jsonBlock struct {
+	Start uint64     `json:"start"`
+	End   uint64     `json:"end"`
+	Lines []string   `json:"lines"`
+	Succs []jsonEdge `json:"succs"`
+	Idom  *uint64    `json:"idom"` // null if unreachable
+}
+type jsonCFG struct {
+	Blocks     []jsonBlock `json:"blocks"`
+	Loops      []*Loop     `json:"loops"`
+	Unresolved []uint64    `json:"unresolved"` // start pc of blocks
+}
+
+func (g *CFG) JSON() ([]byte, error) {
+	idom := g.Dominators()
+
+	out := jsonCFG{
+		Blocks:     []jsonBlock{},
+		Loops:      g.Loops(),
+		Unresolved: []uint64{},
+	}
+	for _, b := range g.Blocks {
+		jb := jsonBlock{
+			Start: b.Start,
+			End:   b.End,
+			Lines: []string{},
+			Succs: []jsonEdge{},
+		}
+		for _, line := range b.Lines {
+			jb.Lines = append(jb.Lines, line_text(line))
+		}
+		for _, e := range b.Succs {
+			jb.Succs = append(jb.Succs, jsonEdge{To: e.To, Kind: e.Kind.String()})
+		}
+		if d, ok := idom[b.Start]; ok {
+			jb.Idom = &d
+		}
+		out.Blocks = append(out.Blocks, jb)
+	}
+	for _, b := range g.Unresolved {
+		out.Unresolved = append(out.Unresolved, b.Start)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
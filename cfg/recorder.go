@@ -0,0 +1,71 @@
+package cfg
+
+import (
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+type Jump struct {
+	From uint64 // pc of the JUMP/JUMPI
+	To   uint64
+}
+
+// Hook that records the jumps taken when running, for each code address
+type Recorder struct {
+	edb.EmptyHook
+
+	Jumps map[common.Address]map[Jump]bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		Jumps: map[common.Address]map[Jump]bool{},
+	}
+}
+
+func (r *Recorder) PostRun(call *edb.Call, line *edb.Line) error {
+	op := line.Op.OpCode
+	if op != vm.JUMP && op != vm.JUMPI {
+		return nil
+	}
+	if call.Pc == line.Pc+1 { // JUMPI not taken
+		return nil
+	}
+	addr := call.CodeAddress()
+	if r.Jumps[addr] == nil {
+		r.Jumps[addr] = map[Jump]bool{}
+	}
+	r.Jumps[addr][Jump{From: line.Pc, To: call.Pc}] = true
+	return nil
+}
+
+// Add the recorded jumps of `addr` to `g` as dynamic edges,
+// returns count of new edges
+func (r *Recorder) Apply(g *CFG, addr common.Address) int {
+	n := 0
+	for j := range r.Jumps[addr] {
+		if g.AddEdge(j.From, j.To, EdgeDynamic) {
+			n++
+		}
+	}
+
+	// the blocks that jumped are resolved now
+	unresolved := []*Block{}
+	for _, b := range g.Unresolved {
+		if !has_jump_edge(b) {
+			unresolved = append(unresolved, b)
+		}
+	}
+	g.Unresolved = unresolved
+	return n
+}
+
+func has_jump_edge(b *Block) bool {
+	for _, e := range b.Succs {
+		if e.Kind != EdgeFall {
+			return true
+		}
+	}
+	return false
+}
@@ -2,13 +2,15 @@ package edb
 
 import (
 	"encoding/json"
-	"io/ioutil"
+	"fmt"
 	"math/big"
 
 	"github.com/aj3423/edb/util"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/holiman/uint256"
 )
 
@@ -22,19 +24,57 @@ type Msg struct {
 type Tx struct {
 	Hash     common.Hash // if provided, storage would be auto fetched online when SLOAD
 	Origin   common.Address
-	GasPrice uint64
+	GasPrice uint64 // the effective gas price, `min(maxFeePerGas, baseFee + maxPriorityFeePerGas)` since EIP-1559
+
+	Type uint8 `json:",omitempty"` // 0: legacy, 1: EIP-2930, 2: EIP-1559, 3: EIP-4844
+
+	// Contract creation, the running code is the init code,
+	// the returned runtime code replaces it when done.
+	Create bool `json:",omitempty"`
+
+	AccessList types.AccessList `json:",omitempty"` // EIP-2930, not used since gas is not metered
+	BlobHashes []common.Hash    `json:",omitempty"` // EIP-4844, for BLOBHASH
 }
 type Block struct {
 	Number     uint64         // block.number
 	Timestamp  uint64         // block.timestamp
-	Difficulty uint64         // block.difficulty
+	Difficulty *big.Int       // block.difficulty, 0 after the Merge
+	PrevRandao common.Hash    // block.prevrandao, the `mixHash` in header
 	Coinbase   common.Address // block.coinbase
 	GasLimit   uint64         // block.gaslimit
 	BaseFee    uint64
+
+	// EIP-4844
+	BlobGasUsed   uint64   `json:",omitempty"`
+	ExcessBlobGas uint64   `json:",omitempty"`
+	BlobBaseFee   *big.Int `json:",omitempty"` // block.blobbasefee, from node, or derived from `ExcessBlobGas` if nil
+
+	// EIP-4788, only accessible via the beacon roots contract, not by opcode
+	ParentBeaconRoot common.Hash
 }
+
+// After the Merge, `DIFFICULTY` returns `PrevRandao` instead
+func (b *Block) IsMerged() bool {
+	return b.Difficulty == nil || b.Difficulty.Sign() == 0
+}
+
 type Chain struct {
 	Id      uint64
 	NodeUrl string // should be archive node
+
+	// The block that state is fetched at, 0 means `Block.Number-1`.
+	// It only differs when the block is overridden, see `ContextFromCall`
+	StateBlock uint64 `json:",omitempty"`
+
+	// All state is local, nothing is fetched from node,
+	// missing code/balance/storage are treated as empty.
+	// eg: when running ethereum state tests
+	Offline bool `json:",omitempty"`
+
+	// Forks changing the blob base fee update fraction, sorted by timestamp,
+	// only used when the node doesn't provide the blob base fee, eg: offline.
+	// Empty for `MainnetBlobSchedule`
+	BlobSchedule []BlobFork `json:",omitempty"`
 }
 
 type Code struct {
@@ -55,7 +95,9 @@ func (c *Code) Disasm(code []byte) error {
 	return nil
 }
 
+// replace the code, disasm again
 func (c *Code) Set(code []byte) error {
+	c.Asm = nil
 	e := c.Disasm(code)
 	if e != nil {
 		return e
@@ -68,6 +110,10 @@ type Contract struct {
 	Code    *Code
 	Balance *big.Int
 	Storage map[common.Hash]*uint256.Int
+
+	// only used for checking the account exists(EXTCODEHASH), fetched online when it's 0
+	Nonce        uint64 `json:",omitempty"`
+	nonceFetched bool
 }
 
 func NewContract() *Contract {
@@ -114,6 +160,7 @@ func (c *Call) CodeAddress() common.Address {
 type Context struct {
 	IsDone    bool
 	ethClient *ethclient.Client
+	rpcClient *rpc.Client // for the fields that `ethClient` can't decode
 
 	Chain Chain
 	Tx    Tx
@@ -121,6 +168,22 @@ type Context struct {
 
 	Contracts map[common.Address]*Contract
 
+	// ABI of contracts, for decoding calldata/return/revert/log, see `SetAbi`
+	Abis map[common.Address]*Abi `json:",omitempty"`
+	// Source code and solc source map of contracts, see `SetSourceMap`
+	Sources map[common.Address]*SourceMap `json:",omitempty"`
+	// solc storage layout of contracts, see `SetStorageLayout`
+	Layouts map[common.Address]*StorageLayout `json:",omitempty"`
+
+	// keccak256 preimages of SHA3, for recognizing mapping keys,
+	// only saved with `SavePreimages`
+	Preimages     Preimages `json:"-"`
+	SavePreimages bool      `json:"-"`
+	preimageIndex []*uint256.Int
+	// detected proxies and their implementations, see `ResolveProxy`
+	Proxies  map[common.Address]*Proxy `json:",omitempty"`
+	notProxy map[common.Address]bool
+
 	CallStack Stack[*Call]
 
 	Hooks Hooks
@@ -147,7 +210,13 @@ func (ctx *Context) Run(steps int) error {
 			return e
 		}
 		opcode := line.Op.OpCode
-		op := OpTable[opcode]
+		op, ok := OpTable[opcode]
+		if line.IsData {
+			return fmt.Errorf("executing data region at pc: %d", line.Pc)
+		}
+		if !ok {
+			return fmt.Errorf("invalid opcode 0x%02x at pc: %d", byte(opcode), line.Pc)
+		}
 
 		// 1. run hooks before executing current line
 		if e = ctx.Hooks.PreRunAll(call, line); e != nil && !is_first_step {
@@ -176,6 +245,15 @@ func (ctx *Context) Run(steps int) error {
 	return nil
 }
 
+// from archive node we only get state after the block executed,
+// so query block-1 to get the state before executed
+func (ctx *Context) stateBlock() uint64 {
+	if ctx.Chain.StateBlock != 0 {
+		return ctx.Chain.StateBlock
+	}
+	return ctx.Block.Number - 1
+}
+
 // get current Call
 func (ctx *Context) Call() *Call {
 	return *ctx.CallStack.Peek()
@@ -226,38 +304,6 @@ func (ctx *Context) String() string {
 	bs, _ := json.MarshalIndent(ctx, "", "  ")
 	return string(bs)
 }
-func (ctx *Context) Save(fn string) error {
-	return ioutil.WriteFile(fn, []byte(ctx.String()), 0666)
-}
-func (ctx *Context) Load(fn string) error {
-	bs, e := ioutil.ReadFile(fn)
-	if e != nil {
-		return e
-	}
-	e = json.Unmarshal(bs, ctx)
-	if e != nil {
-		return e
-	}
-
-	// asm not saved in .json since it's too large
-	// so disassemble all contracts after loaded
-	for _, contract := range ctx.Contracts {
-		e = contract.Code.Disasm(contract.Code.Binary)
-		if e != nil {
-			return e
-		}
-	}
-
-	// ethClient
-	if ctx.Chain.NodeUrl != "" {
-		ctx.ethClient, e = ethclient.Dial(ctx.Chain.NodeUrl)
-		if e != nil {
-			return e
-		}
-	}
-	return nil
-}
-
 func NewContext() *Context {
 	ctx := &Context{
 		BlockHashes: map[uint64]common.Hash{},
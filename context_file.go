@@ -0,0 +1,272 @@
+package edb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+/*
+Version of the file written by `Context.Save`
+
+	1: the original format, no "Version" field, memory saved as 32-byte hex chunks
+	2: "Version" field added, memory saved as a single hex string
+
+Files of older versions are migrated when loading.
+Unknown fields are ignored, so adding a field doesn't need a new version,
+the version only changes when existing fields change their format.
+Hooks are versioned separately, see `HookSchema`.
+*/
+const ContextVersion = 2
+
+// The top level of the file, same as `Context` with a "Version"
+type contextFile struct {
+	Version int
+	*Context
+	Preimages Preimages `json:",omitempty"`
+}
+
+// map[from_version]migration, each migrates to version+1
+var migrations = map[int]func(m map[string]any) error{
+	1: migrate_v1,
+}
+
+// memory: ["00..", "00.."] -> "00..00.."
+func migrate_v1(m map[string]any) error {
+	cs, ok := m["CallStack"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	calls, _ := cs["Data"].([]any)
+	for i, c := range calls {
+		call, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		chunks, ok := call["Memory"].([]any)
+		if !ok {
+			continue
+		}
+		sb := strings.Builder{}
+		for _, chunk := range chunks {
+			s, ok := chunk.(string)
+			if !ok {
+				return fmt.Errorf("CallStack.Data[%d].Memory: invalid chunk: %v", i, chunk)
+			}
+			sb.WriteString(s)
+		}
+		call["Memory"] = sb.String()
+	}
+	return nil
+}
+
+var (
+	magicGzip = []byte{0x1f, 0x8b}
+	magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressed by file extension: ".gz" for gzip, ".zst" for zstd
+func compress(fn string, bs []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+
+	switch {
+	case strings.HasSuffix(fn, ".gz"):
+		w = gzip.NewWriter(buf)
+	case strings.HasSuffix(fn, ".zst"):
+		zw, e := zstd.NewWriter(buf)
+		if e != nil {
+			return nil, e
+		}
+		w = zw
+	default:
+		return bs, nil
+	}
+	if _, e := w.Write(bs); e != nil {
+		return nil, e
+	}
+	if e := w.Close(); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+// detect compression by magic number, not by file extension
+func decompress(bs []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(bs, magicGzip):
+		r, e := gzip.NewReader(bytes.NewReader(bs))
+		if e != nil {
+			return nil, e
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case bytes.HasPrefix(bs, magicZstd):
+		r, e := zstd.NewReader(bytes.NewReader(bs))
+		if e != nil {
+			return nil, e
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+	return bs, nil
+}
+
+func (ctx *Context) Marshal() ([]byte, error) {
+	f := &contextFile{
+		Version: ContextVersion,
+		Context: ctx,
+	}
+	if ctx.SavePreimages {
+		f.Preimages = ctx.Preimages
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// Unmarshal and migrate from older versions
+func (ctx *Context) Unmarshal(bs []byte) error {
+	// decode as generic map for migration
+	m := map[string]any{}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.UseNumber() // keep large numbers, eg: balance
+	if e := dec.Decode(&m); e != nil {
+		return e
+	}
+
+	version := 1 // the original format has no "Version"
+	if v, ok := m["Version"]; ok {
+		n, ok := v.(json.Number)
+		if !ok {
+			return fmt.Errorf("Version: invalid value: %v", v)
+		}
+		i, e := n.Int64()
+		if e != nil {
+			return errors.Wrap(e, "Version")
+		}
+		version = int(i)
+	}
+	if version < 1 || version > ContextVersion {
+		return fmt.Errorf("Version: unsupported version %d, current version: %d",
+			version, ContextVersion)
+	}
+	for ; version < ContextVersion; version++ {
+		if e := migrations[version](m); e != nil {
+			return errors.Wrapf(e, "migrate from version %d", version)
+		}
+	}
+	m["Version"] = ContextVersion
+
+	bs, e := json.Marshal(m)
+	if e != nil {
+		return e
+	}
+
+	f := &contextFile{Context: ctx}
+	if e := json.Unmarshal(bs, f); e != nil {
+		return e
+	}
+	ctx.Preimages = f.Preimages
+	ctx.preimageIndex = nil // rebuilt from the loaded preimages
+	return nil
+}
+
+// Save to file, compressed if the file name ends with ".gz" or ".zst"
+func (ctx *Context) Save(fn string) error {
+	bs, e := ctx.Marshal()
+	if e != nil {
+		return e
+	}
+	if bs, e = compress(fn, bs); e != nil {
+		return e
+	}
+	return ioutil.WriteFile(fn, bs, 0666)
+}
+
+func (ctx *Context) Load(fn string) error {
+	bs, e := ioutil.ReadFile(fn)
+	if e != nil {
+		return e
+	}
+	if bs, e = decompress(bs); e != nil {
+		return errors.Wrap(e, fn)
+	}
+	if e = ctx.Unmarshal(bs); e != nil {
+		return errors.Wrap(e, fn)
+	}
+
+	// asm not saved in .json since it's too large
+	// so disassemble all contracts after loaded
+	for addr, contract := range ctx.Contracts {
+		if contract == nil || contract.Code == nil {
+			return fmt.Errorf("%s: Contracts[%s].Code: missing", fn, addr.Hex())
+		}
+		e = contract.Code.Disasm(contract.Code.Binary)
+		if e != nil {
+			return errors.Wrapf(e, "%s: Contracts[%s].Code", fn, addr.Hex())
+		}
+	}
+
+	if e = ctx.Validate(); e != nil {
+		return errors.Wrap(e, fn)
+	}
+
+	// ethClient
+	if ctx.Chain.NodeUrl != "" {
+		if e = ctx.dial(ctx.Chain.NodeUrl); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// Check the loaded context can be run, the error names the invalid field
+func (ctx *Context) Validate() error {
+	if ctx.BlockHashes == nil {
+		ctx.BlockHashes = map[uint64]common.Hash{}
+	}
+	if ctx.Contracts == nil {
+		ctx.Contracts = map[common.Address]*Contract{}
+	}
+	for addr, contract := range ctx.Contracts {
+		if contract.Storage == nil {
+			contract.Storage = map[common.Hash]*uint256.Int{}
+		}
+		for slot, val := range contract.Storage {
+			if val == nil {
+				return fmt.Errorf("Contracts[%s].Storage[%s]: missing value", addr.Hex(), slot.Hex())
+			}
+		}
+	}
+
+	if ctx.CallStack.Len() == 0 {
+		return errors.New("CallStack: empty")
+	}
+	for i, call := range ctx.CallStack.Data {
+		if call == nil {
+			return fmt.Errorf("CallStack[%d]: null", i)
+		}
+		if call.Msg.Value == nil {
+			return fmt.Errorf("CallStack[%d].Msg.Value: missing", i)
+		}
+		codeAddr := call.CodeAddress()
+		contract, ok := ctx.Contracts[codeAddr]
+		if !ok {
+			return fmt.Errorf("CallStack[%d]: no contract for code address %s", i, codeAddr.Hex())
+		}
+		if contract.Code.Asm != nil && contract.Code.Asm.LineCount() > 0 {
+			if _, ok := contract.Code.Asm.mapPc[call.Pc]; !ok {
+				return fmt.Errorf("CallStack[%d].Pc: invalid pc %d for code of %s", i, call.Pc, codeAddr.Hex())
+			}
+		}
+	}
+	return nil
+}
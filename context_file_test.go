@@ -0,0 +1,129 @@
+package edb
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, fn := range []string{"a.json", "a.json.gz", "a.json.zst"} {
+		ctx := NewSampleContext()
+		ctx.Memory().Set(0, 3, []byte{1, 2, 3})
+
+		fn = filepath.Join(dir, fn)
+		assert.Nil(t, ctx.Save(fn))
+
+		ctx2 := &Context{}
+		assert.Nil(t, ctx2.Load(fn))
+		assert.Equal(t, ctx.Memory().Data(), ctx2.Memory().Data())
+		assert.Equal(t, ctx.Msg().Data, ctx2.Msg().Data)
+	}
+}
+
+func TestMigrateV1(t *testing.T) {
+	v1 := `{
+		"CallStack": {"Data": [{
+			"Msg": {"Data": "", "Gas": 0, "Sender": "0x0000000000000000000000000000000000000000", "Value": 0},
+			"This": "0x0000000000000000000000000000000000000000",
+			"Memory": ["0102", "0304"],
+			"Stack": {"Data": []},
+			"Pc": 0
+		}]},
+		"Contracts": {"0x0000000000000000000000000000000000000000": {"Code": {"Binary": "00"}, "Balance": null, "Storage": {}}},
+		"Hooks": %s
+	}`
+	ctx := &Context{}
+	assert.Nil(t, ctx.Unmarshal([]byte(fmt.Sprintf(v1, "[]"))))
+	assert.Equal(t, []byte{1, 2, 3, 4}, ctx.Memory().Data())
+
+	// tracers were saved by version 1 but can't be restored
+	e := ctx.Unmarshal([]byte(fmt.Sprintf(v1, `[{"Type": "HighLevelTracer", "Value": {"CallStack": {"Data": []}}}]`)))
+	assert.ErrorContains(t, e, "Hooks[0].Type: unknown hook type: 'HighLevelTracer'")
+}
+
+func TestUnknownHook(t *testing.T) {
+	ctx := &Context{}
+	e := ctx.Unmarshal([]byte(`{"Version": 2, "Hooks": [{"Type": "NoSuchHook", "Value": {}}]}`))
+	assert.ErrorContains(t, e, "Hooks[0].Type")
+
+	e = ctx.Unmarshal([]byte(`{"Version": 99}`))
+	assert.ErrorContains(t, e, "Version")
+
+	// fields added by newer builds are ignored
+	assert.Nil(t, ctx.Unmarshal([]byte(`{"Version": 2, "NewField": 1}`)))
+}
+
+// version 1 saved a single "Pc"
+type schemaTestHook struct {
+	EmptyHook
+	Pcs []uint64
+}
+
+func init() {
+	RegisterSchema((*schemaTestHook)(nil), HookSchema{
+		Name:    "SchemaTest",
+		Version: 2,
+		Migrations: map[int]func(map[string]any) error{
+			1: func(v map[string]any) error {
+				v["Pcs"] = []any{v["Pc"]}
+				delete(v, "Pc")
+				return nil
+			},
+		},
+	})
+}
+
+func TestHookSchema(t *testing.T) {
+	ctx := &Context{}
+
+	// saved before hooks have a version
+	e := ctx.Unmarshal([]byte(`{"Version": 2, "Hooks": [{"Type": "SchemaTest", "Value": {"Pc": 3}}]}`))
+	assert.Nil(t, e)
+	assert.Equal(t, []uint64{3}, ctx.Hooks.List()[0].(*schemaTestHook).Pcs)
+
+	// saved with the schema name and version
+	bs, e := ctx.Hooks.MarshalJSON()
+	assert.Nil(t, e)
+	assert.JSONEq(t, `[{"Type": "SchemaTest", "Version": 2, "Value": {"Pcs": [3]}}]`, string(bs))
+
+	ctx = &Context{}
+	e = ctx.Unmarshal([]byte(`{"Version": 2, "Hooks": [{"Type": "SchemaTest", "Version": 3, "Value": {}}]}`))
+	assert.ErrorContains(t, e, "Hooks[0].Version")
+
+	e = ctx.Unmarshal([]byte(`{"Version": 2, "Hooks": [{"Type": "SchemaTest", "Version": 2, "Value": {"Pcs": "x"}}]}`))
+	assert.ErrorContains(t, e, "Hooks[0].Value")
+}
+
+func TestSaveRuntimeHook(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.json")
+	ctx := NewSampleContext()
+	ctx.Hooks.Attach(&EmptyHook{}) // not registered
+	assert.Nil(t, ctx.Save(fn))
+
+	ctx2 := &Context{}
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Empty(t, ctx2.Hooks.List())
+}
+
+func TestSavePreimages(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.json")
+	ctx := NewSampleContext()
+	ctx.record_preimage(util.Sha3([]byte{1}), []byte{1})
+
+	// not saved by default
+	assert.Nil(t, ctx.Save(fn))
+	ctx2 := &Context{}
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Empty(t, ctx2.Preimages)
+
+	ctx.SavePreimages = true
+	assert.Nil(t, ctx.Save(fn))
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Equal(t, ctx.Preimages, ctx2.Preimages)
+}
@@ -0,0 +1,870 @@
+package decompile
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/cfg"
+	"github.com/aj3423/edb/hooks/symbolic"
+	"github.com/aj3423/edb/sigdb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxBlocks = 20000 // blocks executed for a function
+	maxVisits = 2     // times a block can be visited in a path, for loops not recognized
+)
+
+// A decompiled external function
+type Function struct {
+	*edb.Function
+	Sig  *sigdb.Signature // nil if unknown
+	Body []*Stmt
+}
+
+// "transfer", or "func_a9059cbb" if unknown
+func (f *Function) Name() string {
+	if f.Sig != nil {
+		return f.Sig.Name
+	}
+	return fmt.Sprintf("func_%x", f.Selector[:])
+}
+
+func (f *Function) String() string {
+	params := []string{}
+	if f.Sig != nil {
+		for i, arg := range f.Sig.Inputs {
+			name := arg.Name
+			if name == "" {
+				name = fmt.Sprintf("arg%d", i)
+			}
+			params = append(params, arg.Type.String()+" "+name)
+		}
+	}
+	mod := ""
+	if f.Payable == edb.Payable {
+		mod = " payable"
+	}
+	return fmt.Sprintf("function %s(%s) public%s {  // %s\n%s}\n",
+		f.Name(), strings.Join(params, ", "), mod, f.SelectorHex(), Print(f.Body, 1))
+}
+
+// a loop being decompiled
+type loopCtx struct {
+	loop    *cfg.Loop
+	exit    uint64         // the block after the loop
+	depth   int            // stack depth at the header
+	vars    map[int]string // map[stack index]variable, for values changed in the loop
+	changed map[int]bool   // stack items changed at the end of the loop body
+}
+
+type state struct {
+	stack  []symbolic.Node
+	mem    map[uint64]symbolic.Node // memory words at constant offsets
+	visits map[uint64]int           // map[block]count, of current path
+	loops  []*loopCtx
+}
+
+func (s *state) clone() *state {
+	c := &state{
+		stack:  append([]symbolic.Node{}, s.stack...),
+		mem:    map[uint64]symbolic.Node{},
+		visits: map[uint64]int{},
+		loops:  append([]*loopCtx{}, s.loops...),
+	}
+	for k, v := range s.mem {
+		c.mem[k] = v
+	}
+	for k, v := range s.visits {
+		c.visits[k] = v
+	}
+	return c
+}
+
+// items below the function entry, eg: the selector pushed by dispatcher
+var stackBottom = &symbolic.Label{Str: "$stack"}
+
+func (s *state) pop() symbolic.Node {
+	if len(s.stack) == 0 {
+		return stackBottom
+	}
+	n := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	return n
+}
+func (s *state) push(n symbolic.Node) {
+	s.stack = append(s.stack, n)
+}
+
+// the i-th item from top
+func (s *state) peek(i int) symbolic.Node {
+	if i >= len(s.stack) {
+		return stackBottom
+	}
+	return s.stack[len(s.stack)-1-i]
+}
+
+func (s *state) swap(i int) {
+	n := len(s.stack)
+	if i >= n {
+		s.stack = append(make([]symbolic.Node, i+1-n), s.stack...)
+		for j := 0; j < i+1-n; j++ {
+			s.stack[j] = stackBottom
+		}
+		n = len(s.stack)
+	}
+	s.stack[n-1], s.stack[n-1-i] = s.stack[n-1-i], s.stack[n-1]
+}
+
+type decompiler struct {
+	ctx  *edb.Context
+	addr common.Address
+	g    *cfg.CFG
+
+	loops    map[uint64]*cfg.Loop // map[header]
+	observed map[uint64][]uint64  // jumps recorded when running, map[from]targets
+	resolved map[cfg.Jump]bool    // constant jump targets found when decompiling
+
+	args   []string // argument names of current function
+	nvars  int
+	budget int
+}
+
+/*
+Decompile the external functions of contract `addr` to Solidity-like pseudocode.
+
+Each function found in the dispatcher is executed symbolically from its entry,
+internal function calls are inlined, branches to REVERT become `require`.
+Loops are recognized by the CFG, with dynamic jumps resolved by:
+  - the jumps observed when running, recorded by `rec`, can be nil
+  - the constant jump targets found in a first pass, eg: internal function returns
+*/
+func Decompile(ctx *edb.Context, addr common.Address, rec *cfg.Recorder) ([]*Function, error) {
+	c, ok := ctx.Contracts[addr]
+	if !ok || len(c.Code.Binary) == 0 {
+		return nil, errors.Errorf("no code for: %s", addr.Hex())
+	}
+	funcs, e := edb.FindFunctions(c.Code.Binary)
+	if e != nil {
+		return nil, e
+	}
+
+	d := &decompiler{
+		ctx:      ctx,
+		addr:     addr,
+		g:        cfg.Build(c.Code.Asm),
+		observed: map[uint64][]uint64{},
+		resolved: map[cfg.Jump]bool{},
+	}
+	if rec != nil {
+		rec.Apply(d.g, addr)
+		for j := range rec.Jumps[addr] {
+			d.observed[j.From] = append(d.observed[j.From], j.To)
+		}
+	}
+
+	// first pass, for resolving jumps
+	d.find_loops()
+	for _, f := range funcs {
+		d.function(f)
+	}
+	for j := range d.resolved {
+		d.g.AddEdge(j.From, j.To, cfg.EdgeDynamic)
+	}
+	d.find_loops()
+
+	result := []*Function{}
+	for _, f := range funcs {
+		result = append(result, d.function(f))
+	}
+	return result, nil
+}
+
+func (d *decompiler) find_loops() {
+	d.loops = map[uint64]*cfg.Loop{}
+	for _, l := range d.g.Loops() {
+		d.loops[l.Header] = l
+	}
+}
+
+func (d *decompiler) function(f *edb.Function) *Function {
+	fn := &Function{Function: f, Sig: d.signature(f.Selector)}
+
+	d.args = nil
+	if fn.Sig != nil {
+		for _, arg := range fn.Sig.Inputs {
+			d.args = append(d.args, arg.Name)
+		}
+	}
+	d.nvars = 0
+	d.budget = maxBlocks
+
+	s := &state{
+		mem:    map[uint64]symbolic.Node{0x40: symbolic.NewConst(uint256.NewInt(0x80))}, // free memory pointer
+		visits: map[uint64]int{},
+	}
+	fn.Body = d.run(f.Entry, s)
+	return fn
+}
+
+// by ABI, or signature database
+func (d *decompiler) signature(sel [4]byte) *sigdb.Signature {
+	if a, ok := d.ctx.Abis[d.addr]; ok {
+		if m, e := a.MethodById(sel[:]); e == nil {
+			return sigdb.NewSignature(m.RawName, m.Inputs, m.Outputs)
+		}
+	}
+	if sigs := sigdb.Default().Funcs(sel); len(sigs) > 0 {
+		return sigs[0]
+	}
+	return nil
+}
+
+func (d *decompiler) new_var() string {
+	d.nvars++
+	return fmt.Sprintf("v%d", d.nvars)
+}
+
+type controlKind int
+
+const (
+	ctlNext controlKind = iota // continue at `next`
+	ctlFork                    // JUMPI with unknown condition
+	ctlEnd                     // path ends
+)
+
+type control struct {
+	kind   controlKind
+	next   uint64 // next block, or the fall through block of JUMPI
+	target uint64 // JUMPI target
+	cond   symbolic.Node
+	pc     uint64 // pc of JUMPI
+}
+
+// run from block `pc` to the end of path
+func (d *decompiler) run(pc uint64, s *state) []*Stmt {
+	out := []*Stmt{}
+	for {
+		d.budget--
+		if d.budget < 0 {
+			return append(out, comment("..."))
+		}
+		b := d.g.BlockAt(pc)
+		if b == nil {
+			return append(out, comment("invalid jump to %d", pc))
+		}
+
+		// end of loop body
+		for i := len(s.loops) - 1; i >= 0; i-- {
+			lc := s.loops[i]
+			if pc == lc.loop.Header {
+				return append(out, d.loop_next(lc, s)...)
+			}
+			if pc == lc.exit {
+				return append(out, line("break;"))
+			}
+		}
+
+		if loop, ok := d.loops[pc]; ok && s.visits[pc] == 0 {
+			if stmts, next, ns, ok := d.run_loop(loop, b, s); ok {
+				out = append(out, stmts...)
+				pc, s = next, ns
+				continue
+			}
+		}
+
+		s.visits[pc]++
+		if s.visits[pc] > maxVisits {
+			return append(out, comment("loop at %d", pc))
+		}
+
+		ctl := d.exec_block(b, s, &out)
+		switch ctl.kind {
+		case ctlNext:
+			pc = ctl.next
+		case ctlEnd:
+			return out
+		case ctlFork:
+			taken := d.run(ctl.target, s.clone())
+			notTaken := d.run(ctl.next, s)
+			return append(out, d.branch(ctl, taken, notTaken)...)
+		}
+	}
+}
+
+func negate(cond symbolic.Node) symbolic.Node {
+	if u, ok := cond.(*symbolic.UnaryOp); ok && u.OpCode == vm.ISZERO {
+		return u.X
+	}
+	return &symbolic.UnaryOp{OpNode: symbolic.OpNode{OpCode: vm.ISZERO}, X: cond}
+}
+
+// merge the two paths of JUMPI
+func (d *decompiler) branch(ctl *control, taken, notTaken []*Stmt) []*Stmt {
+	if is_revert(notTaken) {
+		return append([]*Stmt{require(d.expr(ctl.cond), notTaken[0])}, taken...)
+	}
+	if is_revert(taken) {
+		return append([]*Stmt{require(d.expr(negate(ctl.cond)), taken[0])}, notTaken...)
+	}
+
+	taken, notTaken, tail := common_suffix(taken, notTaken)
+	if len(taken) == 0 && len(notTaken) == 0 {
+		return tail
+	}
+
+	s := &Stmt{Kind: StmtIf, Text: d.expr(ctl.cond), Body: taken, Else: notTaken}
+	if len(taken) == 0 {
+		s = &Stmt{Kind: StmtIf, Text: d.expr(negate(ctl.cond)), Body: notTaken}
+	}
+	for _, to := range d.observed[ctl.pc] {
+		if to == ctl.target {
+			s.Comment = "taken when running"
+		}
+	}
+	return append([]*Stmt{s}, tail...)
+}
+
+func (d *decompiler) is_jumpdest(n symbolic.Node) bool {
+	pc, ok := const_u64(n)
+	if !ok {
+		return false
+	}
+	b := d.g.BlockAt(pc)
+	return b != nil && b.Lines[0].Op.OpCode == vm.JUMPDEST
+}
+
+/*
+Structured loop, the header ends with JUMPI, one target in the loop, the other exits:
+
+	v1 = i;
+	while (v1 < n) {
+	    ...
+	    v1 = v1 + 1;
+	}
+
+Stack items changed in the body become variables,
+they are found in a first pass with all items as variables.
+*/
+func (d *decompiler) run_loop(loop *cfg.Loop, b *cfg.Block, s *state) ([]*Stmt, uint64, *state, bool) {
+	if b.Last().Op.OpCode != vm.JUMPI {
+		return nil, 0, nil, false
+	}
+
+	nvars, budget := d.nvars, d.budget
+	_, _, _, lc, ok := d.loop_pass(loop, b, s, nil)
+	d.nvars, d.budget = nvars, budget
+	if !ok {
+		return nil, 0, nil, false
+	}
+
+	stmts, next, ns, _, _ := d.loop_pass(loop, b, s, lc.changed)
+	return stmts, next, ns, true
+}
+
+// `vars`: stack items to be variables, nil for all
+func (d *decompiler) loop_pass(loop *cfg.Loop, b *cfg.Block, s *state, vars map[int]bool) (
+	[]*Stmt, uint64, *state, *loopCtx, bool,
+) {
+	s = s.clone()
+	lc := &loopCtx{loop: loop, depth: len(s.stack), vars: map[int]string{}, changed: map[int]bool{}}
+
+	out := []*Stmt{}
+	for i, n := range s.stack {
+		if (vars != nil && !vars[i]) || d.is_jumpdest(n) {
+			continue
+		}
+		name := d.new_var()
+		out = append(out, line("uint256 %s = %s;", name, d.expr(n)))
+		s.stack[i] = &symbolic.Label{Str: name}
+		lc.vars[i] = name
+	}
+
+	s.visits[b.Start]++
+	ctl := d.exec_block(b, s, &out)
+	if ctl.kind != ctlFork {
+		return nil, 0, nil, nil, false
+	}
+
+	in_body := func(pc uint64) bool {
+		i := sort.Search(len(loop.Body), func(i int) bool { return loop.Body[i] >= pc })
+		return i < len(loop.Body) && loop.Body[i] == pc
+	}
+	body, exit, cond := ctl.target, ctl.next, ctl.cond
+	if !in_body(body) {
+		body, exit, cond = ctl.next, ctl.target, negate(ctl.cond)
+	}
+	if !in_body(body) || in_body(exit) {
+		return nil, 0, nil, nil, false
+	}
+	lc.exit = exit
+
+	bs := s.clone()
+	bs.loops = append(bs.loops, lc)
+	out = append(out, &Stmt{Kind: StmtWhile, Text: d.expr(cond), Body: d.run(body, bs)})
+	return out, exit, s, lc, true
+}
+
+// back to the loop header, assign the changed values
+func (d *decompiler) loop_next(lc *loopCtx, s *state) []*Stmt {
+	if len(s.stack) != lc.depth {
+		return []*Stmt{comment("continue, stack changed")}
+	}
+	out := []*Stmt{}
+	for i := range s.stack {
+		name, ok := lc.vars[i]
+		if !ok {
+			continue
+		}
+		if l, ok := s.stack[i].(*symbolic.Label); ok && l.Str == name {
+			continue
+		}
+		lc.changed[i] = true
+		out = append(out, line("%s = %s;", name, d.expr(s.stack[i])))
+	}
+	return out
+}
+
+// memory words from `offset`, false if not all known
+func (s *state) mem_words(offset, size uint64) ([]symbolic.Node, bool) {
+	words := []symbolic.Node{}
+	for i := uint64(0); i < size; i += 32 {
+		w, ok := s.mem[offset+i]
+		if !ok {
+			return nil, false
+		}
+		words = append(words, w)
+	}
+	return words, true
+}
+
+// forget memory written by `size` bytes at `offset`
+func (s *state) mem_clobber(offset, size symbolic.Node) {
+	off, ok1 := const_u64(offset)
+	n, ok2 := const_u64(size)
+	if !ok1 || !ok2 {
+		return
+	}
+	for k, v := range s.mem {
+		if k+32 <= off || k >= off+n {
+			continue
+		}
+		// the leading bytes of constant are kept, eg: Error(string) selector at 0, then 0x20 at 4
+		if c, ok := is_const(v); ok && k < off {
+			b := c.Bytes32()
+			for i := off - k; i < 32; i++ {
+				b[i] = 0
+			}
+			s.mem[k] = symbolic.NewConst(new(uint256.Int).SetBytes(b[:]))
+			continue
+		}
+		delete(s.mem, k)
+	}
+}
+
+func (d *decompiler) fold(n symbolic.Node) symbolic.Node {
+	if v, ok := symbolic.EvaluateConst(n); ok {
+		return symbolic.NewConst(&v)
+	}
+	// x == x, eg: the argument validation of uint256 `x == cleanup(x)`
+	if b, ok := n.(*symbolic.BinaryOp); ok && b.OpCode == vm.EQ && symbolic.Equal(b.X, b.Y) {
+		return symbolic.NewConst(uint256.NewInt(1))
+	}
+	return n
+}
+
+// execute a block, statements are appended to `out`
+func (d *decompiler) exec_block(b *cfg.Block, s *state, out *[]*Stmt) *control {
+	for _, l := range b.Lines {
+		op := l.Op.OpCode
+
+		switch {
+		case op >= vm.PUSH1 && op <= vm.PUSH32:
+			s.push(symbolic.NewConst(new(uint256.Int).SetBytes(l.Data)))
+			continue
+		case op >= vm.DUP1 && op <= vm.DUP16:
+			s.push(s.peek(int(op - vm.DUP1)))
+			continue
+		case op >= vm.SWAP1 && op <= vm.SWAP16:
+			s.swap(int(op-vm.SWAP1) + 1)
+			continue
+		}
+
+		switch op {
+		case vm.JUMPDEST:
+		case vm.POP:
+			s.pop()
+
+		case vm.JUMP:
+			target := s.pop()
+			if to, ok := d.jump_target(l.Pc, target); ok {
+				return &control{kind: ctlNext, next: to}
+			}
+			*out = append(*out, comment("jump to %s", d.expr(target)))
+			return &control{kind: ctlEnd}
+
+		case vm.JUMPI:
+			target, cond := s.pop(), s.pop()
+			next := b.End + 1
+			to, ok := d.jump_target(l.Pc, target)
+			if !ok {
+				*out = append(*out, comment("if (%s) jump to %s", d.expr(cond), d.expr(target)))
+				return &control{kind: ctlNext, next: next}
+			}
+			if v, ok := symbolic.EvaluateConst(cond); ok {
+				if v.IsZero() {
+					return &control{kind: ctlNext, next: next}
+				}
+				return &control{kind: ctlNext, next: to}
+			}
+			return &control{kind: ctlFork, next: next, target: to, cond: cond, pc: l.Pc}
+
+		case vm.ISZERO, vm.NOT, vm.CALLDATALOAD, vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BLOCKHASH, edb.BLOBHASH:
+			n := &symbolic.UnaryOp{X: s.pop()}
+			n.OpCode = op
+			s.push(d.fold(n))
+
+		case vm.ADD, vm.MUL, vm.SUB, vm.DIV, vm.SDIV, vm.MOD, vm.SMOD, vm.EXP, vm.SIGNEXTEND, vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ, vm.AND, vm.OR, vm.XOR, vm.SHL, vm.SHR, vm.SAR, vm.BYTE:
+			x, y := s.pop(), s.pop()
+			switch op { // same order as `HighLevelTracer`
+			case vm.SHL, vm.SHR, vm.SAR, vm.SIGNEXTEND, vm.BYTE:
+				x, y = y, x
+			}
+			n := &symbolic.BinaryOp{X: x, Y: y}
+			n.OpCode = op
+			s.push(d.fold(n))
+
+		case vm.ADDMOD, vm.MULMOD:
+			n := &symbolic.TernaryOp{X: s.pop(), Y: s.pop(), Z: s.pop()}
+			n.OpCode = op
+			s.push(n)
+
+		case vm.ADDRESS, vm.ORIGIN, vm.CALLER, vm.CALLVALUE, vm.CALLDATASIZE, vm.CODESIZE, vm.GASPRICE, vm.COINBASE, vm.TIMESTAMP, vm.NUMBER, vm.DIFFICULTY, vm.GASLIMIT, vm.CHAINID, vm.SELFBALANCE, vm.BASEFEE, edb.BLOBBASEFEE, vm.GAS, vm.MSIZE, vm.RETURNDATASIZE:
+			n := &symbolic.NullaryOp{Name: d.ctx.OpName(op)}
+			n.OpCode = op
+			s.push(n)
+		case vm.PC:
+			s.push(symbolic.NewConst(uint256.NewInt(l.Pc)))
+
+		case vm.MLOAD:
+			offset := s.pop()
+			if off, ok := const_u64(offset); ok {
+				if v, ok := s.mem[off]; ok {
+					s.push(v)
+					break
+				}
+			}
+			n := &symbolic.UnaryOp{X: offset}
+			n.OpCode = op
+			s.push(n)
+		case vm.MSTORE:
+			offset, val := s.pop(), s.pop()
+			if off, ok := const_u64(offset); ok {
+				s.mem_clobber(offset, symbolic.NewConst(uint256.NewInt(32)))
+				s.mem[off] = val
+			}
+		case vm.MSTORE8:
+			offset, _ := s.pop(), s.pop()
+			s.mem_clobber(offset, symbolic.NewConst(uint256.NewInt(1)))
+
+		case vm.CALLDATACOPY:
+			dst, src, size := s.pop(), s.pop(), s.pop()
+			s.mem_clobber(dst, size)
+			off, ok1 := const_u64(dst)
+			from, ok2 := const_u64(src)
+			n, ok3 := const_u64(size)
+			if ok1 && ok2 && ok3 && n%32 == 0 {
+				for i := uint64(0); i < n; i += 32 {
+					w := &symbolic.UnaryOp{X: symbolic.NewConst(uint256.NewInt(from + i))}
+					w.OpCode = vm.CALLDATALOAD
+					s.mem[off+i] = w
+				}
+			}
+		case vm.CODECOPY, vm.RETURNDATACOPY:
+			dst, _, size := s.pop(), s.pop(), s.pop()
+			s.mem_clobber(dst, size)
+		case vm.EXTCODECOPY:
+			_, dst, _, size := s.pop(), s.pop(), s.pop(), s.pop()
+			s.mem_clobber(dst, size)
+
+		case vm.SHA3:
+			offset, size := s.pop(), s.pop()
+			s.push(d.sha3(s, offset, size))
+
+		case vm.SLOAD:
+			sto := &symbolic.Storage{Slot: s.pop()}
+			name := d.new_var()
+			*out = append(*out, line("uint256 %s = %s;", name, d.expr(sto)))
+			s.push(&symbolic.Label{Str: name})
+		case vm.SSTORE:
+			sto := &symbolic.Storage{Slot: s.pop()}
+			val := s.pop()
+			*out = append(*out, line("%s = %s;", d.expr(sto), d.expr(val)))
+
+		case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+			*out = append(*out, d.call(op, s))
+
+		case vm.CREATE, vm.CREATE2:
+			value, offset, size := s.pop(), s.pop(), s.pop()
+			salt := ""
+			if op == vm.CREATE2 {
+				salt = ", salt: " + d.expr(s.pop())
+			}
+			name := d.new_var()
+			*out = append(*out, line("address %s = new{value: %s%s}(memory[%s:+%s]);",
+				name, d.expr(value), salt, d.expr(offset), d.expr(size)))
+			s.push(&symbolic.Label{Str: name})
+
+		case vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
+			*out = append(*out, d.log(int(op-vm.LOG0), s))
+
+		case vm.STOP:
+			*out = append(*out, &Stmt{Kind: StmtReturn, Text: "return;"})
+			return &control{kind: ctlEnd}
+		case vm.RETURN:
+			offset, size := s.pop(), s.pop()
+			*out = append(*out, &Stmt{Kind: StmtReturn, Text: d.return_text(s, offset, size)})
+			return &control{kind: ctlEnd}
+		case vm.REVERT:
+			offset, size := s.pop(), s.pop()
+			*out = append(*out, d.revert(s, offset, size))
+			return &control{kind: ctlEnd}
+		case vm.SELFDESTRUCT:
+			*out = append(*out, &Stmt{Kind: StmtReturn, Text: fmt.Sprintf("selfdestruct(%s);", d.expr(s.pop()))})
+			return &control{kind: ctlEnd}
+
+		default:
+			if _, ok := edb.OpTable[op]; !ok || op == vm.OpCode(0xfe) { // INVALID
+				*out = append(*out, &Stmt{Kind: StmtRevert, Text: "assert(false);", assert: true})
+				return &control{kind: ctlEnd}
+			}
+			// others, eg: BLOCKHASH
+			args := []string{}
+			for i := 0; i < int(l.Op.NStackIn); i++ {
+				args = append(args, d.expr(s.pop()))
+			}
+			text := strings.ToLower(edb.OpName(op)) + "(" + strings.Join(args, ", ") + ")"
+			if l.Op.NStackOut == 0 {
+				*out = append(*out, line("%s;", text))
+			}
+			for i := 0; i < int(l.Op.NStackOut); i++ {
+				s.push(&symbolic.Label{Str: text})
+			}
+		}
+	}
+	// fall through
+	return &control{kind: ctlNext, next: b.End + 1 + uint64(len(b.Last().Data))}
+}
+
+// constant target, or the only target observed when running
+func (d *decompiler) jump_target(pc uint64, target symbolic.Node) (uint64, bool) {
+	if to, ok := const_u64(target); ok {
+		d.resolved[cfg.Jump{From: pc, To: to}] = true
+		return to, true
+	}
+	if targets := d.observed[pc]; len(targets) == 1 {
+		return targets[0], true
+	}
+	return 0, false
+}
+
+func (d *decompiler) sha3(s *state, offset, size symbolic.Node) symbolic.Node {
+	off, ok1 := const_u64(offset)
+	n, ok2 := const_u64(size)
+	if ok1 && ok2 && n > 0 && n%32 == 0 {
+		if words, ok := s.mem_words(off, n); ok {
+			h := &symbolic.Sha3{Offset: off, Size: n}
+			for i, w := range words {
+				h.Input = append(h.Input, &symbolic.Memory{
+					Offset:   symbolic.NewConst(uint256.NewInt(off + uint64(i)*32)),
+					Val:      w,
+					VmOffset: off + uint64(i)*32,
+				})
+			}
+			return h
+		}
+	}
+	return &symbolic.Label{Str: fmt.Sprintf("keccak256(memory[%s:+%s])", d.expr(offset), d.expr(size))}
+}
+
+// arguments in memory, shown as "transfer(a, b)" if the selector is known
+func (d *decompiler) mem_args(s *state, offset, size symbolic.Node) string {
+	off, ok1 := const_u64(offset)
+	n, ok2 := const_u64(size)
+	if !ok1 || !ok2 {
+		return fmt.Sprintf("memory[%s:+%s]", d.expr(offset), d.expr(size))
+	}
+	if n == 0 {
+		return ""
+	}
+	if n%32 == 4 { // selector + args
+		if sel, ok := s.mem[off]; ok {
+			if v, ok := is_const(sel); ok {
+				b := v.Bytes32()
+				name := fmt.Sprintf("0x%x", b[:4])
+				if sigs := sigdb.Default().Funcs([4]byte{b[0], b[1], b[2], b[3]}); len(sigs) > 0 {
+					name = sigs[0].Name
+				}
+				if args, ok := s.mem_words(off+4, n-4); ok {
+					return name + "(" + d.expr_list(args) + ")"
+				}
+				return name + fmt.Sprintf("(memory[%d:+%d])", off+4, n-4)
+			}
+		}
+	}
+	if n%32 == 0 {
+		if words, ok := s.mem_words(off, n); ok {
+			return d.expr_list(words)
+		}
+	}
+	return fmt.Sprintf("memory[%d:+%d]", off, n)
+}
+
+func (d *decompiler) expr_list(nodes []symbolic.Node) string {
+	arr := []string{}
+	for _, n := range nodes {
+		arr = append(arr, d.expr(n))
+	}
+	return strings.Join(arr, ", ")
+}
+
+func (d *decompiler) call(op vm.OpCode, s *state) *Stmt {
+	gas, addr := s.pop(), s.pop()
+	var value symbolic.Node
+	if op == vm.CALL || op == vm.CALLCODE {
+		value = s.pop()
+	}
+	inOffset, inSize, outOffset, outSize := s.pop(), s.pop(), s.pop(), s.pop()
+	s.mem_clobber(outOffset, outSize)
+
+	opts := []string{}
+	if value != nil {
+		if v, ok := is_const(value); !ok || !v.IsZero() {
+			opts = append(opts, "value: "+d.expr(value))
+		}
+	}
+	if _, ok := gas.(*symbolic.NullaryOp); !ok { // not `gasleft()`
+		opts = append(opts, "gas: "+d.expr(gas))
+	}
+	optStr := ""
+	if len(opts) > 0 {
+		optStr = "{" + strings.Join(opts, ", ") + "}"
+	}
+
+	name := d.new_var()
+	s.push(&symbolic.Label{Str: name})
+	return line("bool %s = address(%s).%s%s(%s);", name, d.expr(addr),
+		strings.ToLower(op.String()), optStr, d.mem_args(s, inOffset, inSize))
+}
+
+func (d *decompiler) log(n int, s *state) *Stmt {
+	offset, size := s.pop(), s.pop()
+	topics := []symbolic.Node{}
+	for i := 0; i < n; i++ {
+		topics = append(topics, s.pop())
+	}
+	data := d.mem_args(s, offset, size)
+
+	name := "log" + fmt.Sprint(n)
+	if n > 0 {
+		if v, ok := is_const(topics[0]); ok {
+			if a, ok := d.ctx.Abis[d.addr]; ok {
+				if ev, e := a.EventByID(v.Bytes32()); e == nil {
+					name, topics = ev.RawName, topics[1:]
+				}
+			}
+			if name == "log"+fmt.Sprint(n) {
+				if sig := sigdb.Default().Event(v.Bytes32()); sig != nil {
+					name, topics = sig.Name, topics[1:]
+				}
+			}
+		}
+	}
+	args := d.expr_list(topics)
+	if data != "" {
+		if args != "" {
+			args += ", "
+		}
+		args += data
+	}
+	return line("emit %s(%s);", name, args)
+}
+
+func (d *decompiler) return_text(s *state, offset, size symbolic.Node) string {
+	if n, ok := const_u64(size); ok && n == 0 {
+		return "return;"
+	}
+	return "return " + d.mem_args(s, offset, size) + ";"
+}
+
+var (
+	selError = [4]byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	selPanic = [4]byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+)
+
+// revert statement, with the reason for `require`
+func (d *decompiler) revert(s *state, offset, size symbolic.Node) *Stmt {
+	r := &Stmt{Kind: StmtRevert}
+	off, ok1 := const_u64(offset)
+	n, ok2 := const_u64(size)
+
+	switch {
+	case ok2 && n == 0:
+		r.Text = "revert();"
+		return r
+
+	case ok1 && ok2 && n >= 4:
+		head, ok := s.mem[off]
+		v, isConst := is_const(head)
+		if !ok || !isConst {
+			break
+		}
+		b := v.Bytes32()
+		sel := [4]byte{b[0], b[1], b[2], b[3]}
+
+		switch sel {
+		case selError:
+			if msg, ok := error_string(s, off); ok {
+				r.reason = fmt.Sprintf("%q", msg)
+				r.Text = "revert(" + r.reason + ");"
+				return r
+			}
+		case selPanic:
+			if code, ok := s.mem[off+4]; ok {
+				r.Text = fmt.Sprintf("revert Panic(%s);", d.expr(code))
+				r.reason = fmt.Sprintf("Panic(%s)", d.expr(code))
+				return r
+			}
+		}
+		// custom error
+		r.reason = d.mem_args(s, offset, size)
+		r.Text = "revert " + r.reason + ";"
+		return r
+	}
+	r.reason = d.mem_args(s, offset, size)
+	r.Text = "revert(" + r.reason + ");"
+	return r
+}
+
+// Error(string) at `off`: selector, 0x20, length, data
+func error_string(s *state, off uint64) (string, bool) {
+	length, ok := const_u64(s.mem[off+36])
+	if !ok || length > 1024 {
+		return "", false
+	}
+	data := []byte{}
+	for i := uint64(0); i < length; i += 32 {
+		v, ok := is_const(s.mem[off+68+i])
+		if !ok {
+			return "", false
+		}
+		b := v.Bytes32()
+		data = append(data, b[:]...)
+	}
+	return string(data[:length]), true
+}
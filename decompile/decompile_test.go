@@ -0,0 +1,100 @@
+package decompile
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func code_context(code string) *edb.Context {
+	ctx := edb.NewSampleContext()
+	contract := edb.NewContract()
+	contract.Balance = big.NewInt(0)
+	contract.Code.Set(util.HexDec(code))
+	ctx.Contracts[ctx.This()] = contract
+	return ctx
+}
+
+func TestDecompileSample(t *testing.T) {
+	ctx := edb.NewSampleContext()
+
+	funcs, e := Decompile(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, len(funcs))
+
+	assert.Equal(t, "uint256 v1 = slot0;\nreturn v1;\n", Print(funcs[0].Body, 0))
+	assert.Equal(t, "require((msg.data.length - 4) >= 32);\nslot0 = arg0;\nreturn;\n", Print(funcs[1].Body, 0))
+}
+
+func TestDecompileLoop(t *testing.T) {
+	// function 0x12345678(uint256 n) { for (uint i = 0; i < n; i++) { slot0 += i; } }
+	ctx := code_context("60003560e01c80631234567814601457600080fd5b60005b8060043511156" +
+		"02f57600054810160005560010160175" + "65b5000")
+
+	funcs, e := Decompile(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, 1, len(funcs))
+	assert.Equal(t, `uint256 v1 = 0;
+while (arg0 > v1) {
+    uint256 v2 = slot0;
+    slot0 = v1 + v2;
+    v1 = v1 + 1;
+}
+return;
+`, Print(funcs[0].Body, 0))
+}
+
+func TestDecompileMapping(t *testing.T) {
+	// function burn(uint256 amount) {
+	//     require(balances[msg.sender] >= amount, "low");
+	//     balances[msg.sender] -= amount;
+	// }
+	ctx := code_context("60003560e01c806342966c6814601457600080fd5b336000526001602052604060002054806004351115606b57" +
+		"6308c379a060e01b600052602060045260036024527f6c6f770000000000000000000000000000000000000000000000000000000000" +
+		"60445260646000fd5b600435900333600052600160205260406000205500")
+
+	funcs, e := Decompile(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, `uint256 v1 = slot1[msg.sender];
+require(arg0 <= v1, "low");
+slot1[msg.sender] = v1 - arg0;
+return;
+`, Print(funcs[0].Body, 0))
+
+	// named by ABI and storage layout
+	a, e := edb.ParseAbi([]byte(`[{"type":"function","name":"burn","inputs":[{"name":"amount","type":"uint256"}],"outputs":[]}]`))
+	assert.Nil(t, e)
+	ctx.SetAbi(ctx.This(), a)
+	layout, e := edb.LoadStorageLayout([]byte(`{
+		"storage": [{"label": "balances", "offset": 0, "slot": "1", "type": "t_mapping(t_address,t_uint256)"}],
+		"types": {
+			"t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+			"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+			"t_mapping(t_address,t_uint256)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => uint256)", "numberOfBytes": "32", "value": "t_uint256"}
+		}
+	}`), "", nil)
+	assert.Nil(t, e)
+	ctx.SetStorageLayout(ctx.This(), layout)
+
+	funcs, e = Decompile(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "burn", funcs[0].Name())
+	assert.Equal(t, `uint256 v1 = balances[msg.sender];
+require(amount <= v1, "low");
+balances[msg.sender] = v1 - amount;
+return;
+`, Print(funcs[0].Body, 0))
+}
+
+func TestDecompileGasNotFolded(t *testing.T) {
+	// function 0x12345678() { slot0 = gasleft() - gasleft(); }
+	ctx := code_context("60003560e01c80631234567814601457600080fd5b" + "5a5a0360005500")
+
+	funcs, e := Decompile(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, 1, len(funcs))
+	assert.Equal(t, "slot0 = gasleft() - gasleft();\nreturn;\n", Print(funcs[0].Body, 0))
+}
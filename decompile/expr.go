@@ -0,0 +1,227 @@
+package decompile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/hooks/symbolic"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+)
+
+var nullaryNames = map[vm.OpCode]string{
+	vm.ADDRESS:        "address(this)",
+	vm.ORIGIN:         "tx.origin",
+	vm.CALLER:         "msg.sender",
+	vm.CALLVALUE:      "msg.value",
+	vm.CALLDATASIZE:   "msg.data.length",
+	vm.CODESIZE:       "codesize()",
+	vm.GASPRICE:       "tx.gasprice",
+	vm.COINBASE:       "block.coinbase",
+	vm.TIMESTAMP:      "block.timestamp",
+	vm.NUMBER:         "block.number",
+	vm.DIFFICULTY:     "block.difficulty",
+	vm.GASLIMIT:       "block.gaslimit",
+	vm.CHAINID:        "block.chainid",
+	vm.SELFBALANCE:    "address(this).balance",
+	vm.BASEFEE:        "block.basefee",
+	edb.BLOBBASEFEE:   "block.blobbasefee",
+	vm.GAS:            "gasleft()",
+	vm.MSIZE:          "msize()",
+	vm.RETURNDATASIZE: "returndatasize()",
+}
+
+var binaryOps = map[vm.OpCode]string{
+	vm.ADD: "+", vm.SUB: "-", vm.MUL: "*", vm.DIV: "/", vm.MOD: "%", vm.EXP: "**",
+	vm.LT: "<", vm.GT: ">", vm.SLT: "<", vm.SGT: ">", vm.EQ: "==",
+	vm.AND: "&", vm.OR: "|", vm.XOR: "^", vm.SHL: "<<", vm.SHR: ">>", vm.SAR: ">>",
+}
+
+var commutativeOps = map[vm.OpCode]bool{
+	vm.ADD: true, vm.MUL: true, vm.EQ: true, vm.AND: true, vm.OR: true, vm.XOR: true,
+}
+
+// ISZERO(a < b) -> a >= b
+var negatedOps = map[vm.OpCode]string{
+	vm.LT: ">=", vm.GT: "<=", vm.SLT: ">=", vm.SGT: "<=", vm.EQ: "!=",
+}
+
+func is_const(n symbolic.Node) (*uint256.Int, bool) {
+	if c, ok := n.(*symbolic.Const); ok {
+		return c.Value(), true
+	}
+	return nil, false
+}
+
+func const_u64(n symbolic.Node) (uint64, bool) {
+	v, ok := is_const(n)
+	if !ok || !v.IsUint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+func format_const(v *uint256.Int) string {
+	if v.IsUint64() && v.Uint64() < 0x10000 {
+		return strconv.FormatUint(v.Uint64(), 10)
+	}
+	return v.Hex()
+}
+
+// "(a + b)" -> "a + b"
+func strip_parens(s string) string {
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return s
+	}
+	depth := 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 && i != len(s)-1 { // "(a) + (b)"
+				return s
+			}
+		}
+	}
+	return s[1 : len(s)-1]
+}
+
+// Solidity-like expression
+func (d *decompiler) expr(n symbolic.Node) string {
+	if sto, ok := n.(*symbolic.Storage); ok { // Storage.Val is not set
+		slot, _ := symbolic.Optimize(sto.Slot, symbolic.DefaultOptimizers)
+		return d.slot_name(slot)
+	}
+	n, _ = symbolic.Optimize(n, symbolic.DefaultOptimizers)
+	return strip_parens(d.render(n))
+}
+
+func (d *decompiler) render(n symbolic.Node) string {
+	switch n := n.(type) {
+	case *symbolic.Const:
+		return format_const(n.Value())
+	case *symbolic.Label:
+		return n.Str
+	case *symbolic.NullaryOp:
+		if n.Name == "PREVRANDAO" {
+			return "block.prevrandao"
+		}
+		if s, ok := nullaryNames[n.OpCode]; ok {
+			return s
+		}
+		return strings.ToLower(edb.OpName(n.OpCode)) + "()"
+
+	case *symbolic.UnaryOp:
+		switch n.OpCode {
+		case vm.ISZERO:
+			if b, ok := n.X.(*symbolic.BinaryOp); ok {
+				if op, ok := negatedOps[b.OpCode]; ok {
+					return fmt.Sprintf("(%s %s %s)", d.render(b.X), op, d.render(b.Y))
+				}
+			}
+			return "!" + d.render(n.X)
+		case vm.NOT:
+			return "~" + d.render(n.X)
+		case vm.CALLDATALOAD:
+			if off, ok := const_u64(n.X); ok && off >= 4 && (off-4)%32 == 0 {
+				return d.arg_name(int((off - 4) / 32))
+			}
+			return "msg.data[" + strip_parens(d.render(n.X)) + "]"
+		case vm.BALANCE:
+			return "address(" + strip_parens(d.render(n.X)) + ").balance"
+		case vm.EXTCODESIZE:
+			return "address(" + strip_parens(d.render(n.X)) + ").code.length"
+		case vm.EXTCODEHASH:
+			return "address(" + strip_parens(d.render(n.X)) + ").codehash"
+		}
+		return strings.ToLower(edb.OpName(n.OpCode)) + "(" + strip_parens(d.render(n.X)) + ")"
+
+	case *symbolic.BinaryOp:
+		if op, ok := binaryOps[n.OpCode]; ok {
+			x, y := n.X, n.Y
+			_, xConst := is_const(x)
+			_, yConst := is_const(y)
+			if xConst && !yConst && commutativeOps[n.OpCode] { // "1 + v1" -> "v1 + 1"
+				x, y = y, x
+			}
+			return fmt.Sprintf("(%s %s %s)", d.render(x), op, d.render(y))
+		}
+		return fmt.Sprintf("%s(%s, %s)", strings.ToLower(edb.OpName(n.OpCode)),
+			strip_parens(d.render(n.X)), strip_parens(d.render(n.Y)))
+
+	case *symbolic.TernaryOp:
+		return fmt.Sprintf("%s(%s, %s, %s)", strings.ToLower(edb.OpName(n.OpCode)),
+			strip_parens(d.render(n.X)), strip_parens(d.render(n.Y)), strip_parens(d.render(n.Z)))
+
+	case *symbolic.Sha3:
+		args := []string{}
+		for _, m := range n.Input {
+			args = append(args, strip_parens(d.render(m.Val)))
+		}
+		return "keccak256(" + strings.Join(args, ", ") + ")"
+	}
+	return n.String()
+}
+
+func (d *decompiler) arg_name(i int) string {
+	if i < len(d.args) && d.args[i] != "" {
+		return d.args[i]
+	}
+	return fmt.Sprintf("arg%d", i)
+}
+
+// state variable name by storage layout, or "slot2"
+func (d *decompiler) var_name(slot *uint256.Int) string {
+	if layout, ok := d.ctx.LayoutOf(d.addr); ok && slot.IsUint64() {
+		n := strconv.FormatUint(slot.Uint64(), 10)
+		for _, item := range layout.Storage {
+			if item.Slot == n {
+				return item.Label
+			}
+		}
+	}
+	if slot.IsUint64() {
+		return "slot" + strconv.FormatUint(slot.Uint64(), 10)
+	}
+	return "storage[" + slot.Hex() + "]"
+}
+
+/*
+Name of storage slot expression:
+  - 2                      -> slot2
+  - keccak256(key, 2)      -> slot2[key]
+  - keccak256(2) + i       -> slot2[i]
+  - keccak256(key, 2) + 1  -> slot2[key].field1
+*/
+func (d *decompiler) slot_name(slot symbolic.Node) string {
+	if v, ok := is_const(slot); ok {
+		return d.var_name(v)
+	}
+	if h, ok := slot.(*symbolic.Sha3); ok {
+		switch len(h.Input) {
+		case 1: // array data
+			return d.slot_name(h.Input[0].Val) + "[0]"
+		case 2: // mapping
+			return d.slot_name(h.Input[1].Val) + "[" + strip_parens(d.render(h.Input[0].Val)) + "]"
+		}
+	}
+	if b, ok := slot.(*symbolic.BinaryOp); ok && b.OpCode == vm.ADD {
+		for _, pair := range [][2]symbolic.Node{{b.X, b.Y}, {b.Y, b.X}} {
+			h, ok := pair[0].(*symbolic.Sha3)
+			if !ok {
+				continue
+			}
+			if len(h.Input) == 1 { // array element
+				return d.slot_name(h.Input[0].Val) + "[" + strip_parens(d.render(pair[1])) + "]"
+			}
+			if i, ok := const_u64(pair[1]); ok && len(h.Input) == 2 { // struct member
+				return fmt.Sprintf("%s.field%d", d.slot_name(h), i)
+			}
+		}
+	}
+	return "storage[" + strip_parens(d.render(slot)) + "]"
+}
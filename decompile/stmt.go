@@ -0,0 +1,127 @@
+package decompile
+
+import (
+	"fmt"
+	"strings"
+)
+
+type StmtKind int
+
+const (
+	StmtLine   StmtKind = iota // "uint256 v1 = balances[msg.sender];"
+	StmtIf                     // if (Text) { Body } else { Else }
+	StmtWhile                  // while (Text) { Body }
+	StmtRevert                 // REVERT, INVALID
+	StmtReturn                 // RETURN, STOP, SELFDESTRUCT
+)
+
+// A line of pseudocode, or a block of them
+type Stmt struct {
+	Kind StmtKind
+	Text string
+	Body []*Stmt
+	Else []*Stmt
+
+	Comment string
+
+	reason string // revert reason for `require`, eg: `"not owner"`, `Unauthorized()`
+	assert bool   // INVALID, for `assert`
+}
+
+func line(format string, args ...any) *Stmt {
+	return &Stmt{Kind: StmtLine, Text: fmt.Sprintf(format, args...)}
+}
+
+func comment(format string, args ...any) *Stmt {
+	return &Stmt{Kind: StmtLine, Text: "// " + fmt.Sprintf(format, args...)}
+}
+
+// the only statement is a revert
+func is_revert(stmts []*Stmt) bool {
+	return len(stmts) == 1 && stmts[0].Kind == StmtRevert
+}
+
+// "require(cond, reason);" for the revert statement `r`
+func require(cond string, r *Stmt) *Stmt {
+	switch {
+	case r.assert:
+		return line("assert(%s);", cond)
+	case r.reason == "":
+		return line("require(%s);", cond)
+	}
+	return line("require(%s, %s);", cond, r.reason)
+}
+
+// split the common tail of `a` and `b`, so it can be moved after the `if`
+func common_suffix(a, b []*Stmt) ([]*Stmt, []*Stmt, []*Stmt) {
+	n := 0
+	for n < len(a) && n < len(b) {
+		if a[len(a)-1-n].String() != b[len(b)-1-n].String() {
+			break
+		}
+		n++
+	}
+	return a[:len(a)-n], b[:len(b)-n], a[len(a)-n:]
+}
+
+type printer struct {
+	sb     strings.Builder
+	indent int
+}
+
+func (p *printer) line(s string) {
+	p.sb.WriteString(strings.Repeat("    ", p.indent) + s + "\n")
+}
+
+func (p *printer) block(stmts []*Stmt) {
+	p.indent++
+	for _, s := range stmts {
+		p.stmt(s)
+	}
+	p.indent--
+}
+
+func (p *printer) stmt(s *Stmt) {
+	cmt := ""
+	if s.Comment != "" {
+		cmt = "  // " + s.Comment
+	}
+	switch s.Kind {
+	case StmtIf:
+		p.line("if (" + s.Text + ") {" + cmt)
+		p.block(s.Body)
+		els := s.Else
+		// else if
+		for len(els) == 1 && els[0].Kind == StmtIf {
+			p.line("} else if (" + els[0].Text + ") {")
+			p.block(els[0].Body)
+			els = els[0].Else
+		}
+		if len(els) > 0 {
+			p.line("} else {")
+			p.block(els)
+		}
+		p.line("}")
+	case StmtWhile:
+		p.line("while (" + s.Text + ") {" + cmt)
+		p.block(s.Body)
+		p.line("}")
+	default:
+		p.line(s.Text + cmt)
+	}
+}
+
+func (s *Stmt) String() string {
+	p := &printer{}
+	p.stmt(s)
+	return p.sb.String()
+}
+
+// print statements with indent level `indent`
+func Print(stmts []*Stmt, indent int) string {
+	p := &printer{indent: indent}
+	for _, s := range stmts {
+		p.stmt(s)
+	}
+	return p.sb.String()
+}
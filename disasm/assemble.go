@@ -0,0 +1,302 @@
+package disasm
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/pkg/errors"
+)
+
+// map[name]opcode, eg: "PUSH1" -> 0x60
+var opCodes = map[string]vm.OpCode{}
+
+func init() {
+	for op := range edb.OpTable {
+		opCodes[edb.OpName(op)] = op
+	}
+	opCodes["PREVRANDAO"] = vm.DIFFICULTY
+	opCodes["KECCAK256"] = vm.SHA3
+	opCodes["INVALID"] = vm.OpCode(0xfe)
+}
+
+// an instruction or data region
+type item struct {
+	lineNum int
+
+	op    vm.OpCode
+	data  []byte // PUSH data, or the whole data region
+	raw   bool   // data region, no opcode
+	label string // label pushed, the data is filled when the address is known
+	size  int    // data size of PUSH <label>, 0 for auto, widened if the label doesn't fit
+}
+
+func (it *item) len(labelSize int) int {
+	switch {
+	case it.raw:
+		return len(it.data)
+	case it.label != "":
+		if it.size > 0 {
+			return 1 + it.size
+		}
+		return 1 + labelSize
+	}
+	return 1 + len(it.data)
+}
+
+// "0x0080" -> 00 80, keeps the leading zeros; "128" -> 80
+func parse_number(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		digits := s[2:]
+		if len(digits)%2 == 1 {
+			digits = "0" + digits
+		}
+		bs, e := hex.DecodeString(digits)
+		if e != nil || len(bs) == 0 {
+			return nil, errors.Errorf("invalid number: %s", s)
+		}
+		return bs, nil
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 {
+		return nil, errors.Errorf("invalid number: %s", s)
+	}
+	bs := n.Bytes()
+	if len(bs) == 0 {
+		bs = []byte{0}
+	}
+	return bs, nil
+}
+
+// bytes needed for `n`, at least 1
+func bytes_of(n int) int {
+	sz := 1
+	for n >= 1<<(8*sz) {
+		sz++
+	}
+	return sz
+}
+
+// Size of `PUSH @label` without size, the smallest one that fits the code size, as solc does.
+// `fixed` is the code size without their data, `n` is the count of them
+func label_size(fixed, n int) int {
+	size := 1
+	for bytes_of(fixed+n*size) > size {
+		size++
+	}
+	return size
+}
+
+// ";; .size 2" -> 2
+func parse_size(s string) (int, error) {
+	arg := strings.TrimSpace(strings.TrimPrefix(s, ";; .size "))
+	size, e := strconv.Atoi(arg)
+	if e != nil || size < 1 || size > 32 {
+		return 0, errors.Errorf("invalid size: %s", arg)
+	}
+	return size, nil
+}
+
+// "PUSH 1", "PUSH2 0x0001", "PUSH @tag_1", "PUSH2 @tag_1"
+func parse_push(it *item, op string, arg string) error {
+	size := 0
+	if op != "PUSH" {
+		c, ok := opCodes[op]
+		if !ok || c < vm.PUSH1 || c > vm.PUSH32 {
+			return errors.Errorf("unknown opcode: %s", op)
+		}
+		size = int(c-vm.PUSH1) + 1
+	}
+
+	if strings.HasPrefix(arg, "@") {
+		it.op = vm.PUSH1
+		it.label = arg[1:]
+		it.size = size
+		return nil
+	}
+
+	bs, e := parse_number(arg)
+	if e != nil {
+		return e
+	}
+	if size == 0 {
+		size = len(bs)
+	}
+	// "PUSH2 0x1" -> 00 01
+	for len(bs) > size && bs[0] == 0 {
+		bs = bs[1:]
+	}
+	if len(bs) > size || size > 32 {
+		return errors.Errorf("number too large for PUSH%d: %s", size, arg)
+	}
+	it.op = vm.PUSH1 + vm.OpCode(size-1)
+	it.data = append(make([]byte, size-len(bs)), bs...)
+	return nil
+}
+
+func parse_line(lineNum int, s string, labels map[string]int, items []*item) ([]*item, error) {
+	if strings.HasPrefix(s, ";; .data ") {
+		data := strings.TrimPrefix(s, ";; .data ")
+		bs, e := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(data), "0x"))
+		if e != nil {
+			return nil, errors.Errorf("invalid data: %s", data)
+		}
+		return append(items, &item{lineNum: lineNum, data: bs, raw: true}), nil
+	}
+	if i := strings.Index(s, ";;"); i >= 0 {
+		s = s[:i]
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return items, nil
+	}
+
+	// label definition
+	if strings.HasSuffix(fields[0], ":") {
+		name := strings.TrimSuffix(fields[0], ":")
+		if len(fields) > 1 {
+			return nil, errors.Errorf("unexpected: %s", fields[1])
+		}
+		if _, dup := labels[name]; dup {
+			return nil, errors.Errorf("duplicated label: %s", name)
+		}
+		labels[name] = len(items)
+		return append(items, &item{lineNum: lineNum, op: vm.JUMPDEST}), nil
+	}
+
+	op := strings.ToUpper(fields[0])
+	if len(fields) > 2 {
+		return nil, errors.Errorf("unexpected: %s", fields[2])
+	}
+
+	it := &item{lineNum: lineNum}
+	if strings.HasPrefix(op, "PUSH") && op != "PUSH0" {
+		if len(fields) != 2 {
+			return nil, errors.New("missing PUSH data")
+		}
+		if e := parse_push(it, op, fields[1]); e != nil {
+			return nil, e
+		}
+		return append(items, it), nil
+	}
+
+	c, ok := opCodes[op]
+	if !ok {
+		return nil, errors.Errorf("unknown opcode: %s", fields[0])
+	}
+	if len(fields) == 2 {
+		if c != vm.JUMP && c != vm.JUMPI {
+			return nil, errors.Errorf("unexpected: %s", fields[1])
+		}
+		// "JUMP @tag" -> "PUSH @tag; JUMP"
+		if e := parse_push(it, "PUSH", fields[1]); e != nil {
+			return nil, e
+		}
+		items = append(items, it)
+		it = &item{lineNum: lineNum}
+	}
+	it.op = c
+	return append(items, it), nil
+}
+
+/*
+Assemble the `evm` format of listing back to bytecode, see `Listing.Evm`:
+  - `label:` is a JUMPDEST, `PUSH @label` pushes its address
+  - "PUSH 0x0080" keeps the leading zeros as PUSH2, "PUSH2 1" is also accepted
+  - `;; .data 0x..` is restored as raw bytes
+  - all `PUSH @label` without size have the same size, the smallest one that fits the code size,
+    as solc does
+  - "PUSH2 @label", or `;; .size 2` before "PUSH @label", is the minimum size,
+    it's widened if the label doesn't fit after code is moved.
+    The listing writes the original size of labels that differ,
+    so the original code is rebuilt byte for byte if not edited
+*/
+func Assemble(src string) ([]byte, error) {
+	labels := map[string]int{} // map[name]index of item
+	items := []*item{}
+
+	size := 0 // by `;; .size`, for the next label push
+	for i, s := range strings.Split(src, "\n") {
+		s = strings.TrimSpace(s)
+		var e error
+		if strings.HasPrefix(s, ";; .size ") {
+			if size, e = parse_size(s); e != nil {
+				return nil, errors.Wrapf(e, "line %d", i+1)
+			}
+			continue
+		}
+
+		n := len(items)
+		items, e = parse_line(i+1, s, labels, items)
+		if e != nil {
+			return nil, errors.Wrapf(e, "line %d", i+1)
+		}
+		if size > 0 && len(items) > n {
+			if items[n].label == "" {
+				return nil, errors.Errorf("line %d: .size is only for PUSH @label", i+1)
+			}
+			if size > items[n].size {
+				items[n].size = size
+			}
+			size = 0
+		}
+	}
+	for _, it := range items {
+		if _, ok := labels[it.label]; it.label != "" && !ok {
+			return nil, errors.Errorf("line %d: undefined label: %s", it.lineNum, it.label)
+		}
+	}
+
+	// grow the sizes until every label fits, the offsets only increase
+	labelSize := 1
+	var offsets []int
+	for {
+		offsets = make([]int, len(items))
+		pc := 0
+		for i, it := range items {
+			offsets[i] = pc
+			pc += it.len(labelSize)
+		}
+
+		grown := false
+		if need := bytes_of(pc); need > labelSize {
+			labelSize = need
+			grown = true
+		}
+		for _, it := range items {
+			if it.label == "" || it.size == 0 {
+				continue
+			}
+			if need := bytes_of(offsets[labels[it.label]]); need > it.size {
+				it.size = need
+				grown = true
+			}
+		}
+		if !grown {
+			break
+		}
+	}
+
+	code := []byte{}
+	for _, it := range items {
+		if it.raw {
+			code = append(code, it.data...)
+			continue
+		}
+		if it.label != "" {
+			size := labelSize
+			if it.size > 0 {
+				size = it.size
+			}
+			addr := big.NewInt(int64(offsets[labels[it.label]])).Bytes()
+			it.op = vm.PUSH1 + vm.OpCode(size-1)
+			it.data = append(make([]byte, size-len(addr)), addr...)
+		}
+		code = append(code, byte(it.op))
+		code = append(code, it.data...)
+	}
+	return code, nil
+}
@@ -0,0 +1,132 @@
+package disasm
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/core/asm"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssembleRoundTrip(t *testing.T) {
+	for _, code := range []string{
+		"", // the sample
+		// PUSH1 labels, from decompile tests
+		"60003560e01c80631234567814601457600080fd5b60005b806004351115602f57600054810160005560010160175" + "65b5000",
+		// unknown opcode and truncated PUSH2 at the end
+		"600160020c0161ff",
+		// mixed label sizes: PUSH2 @tag_10 JUMPI, PUSH1 @tag_10 JUMP
+		"600061000a57600a56005b00",
+	} {
+		ctx := edb.NewSampleContext()
+		if code != "" {
+			assert.Nil(t, ctx.Contract().Code.Set(util.HexDec(code)))
+		}
+		l, e := Disasm(ctx, ctx.This(), nil)
+		assert.Nil(t, e)
+
+		src := l.Evm()
+		bin, e := Assemble(src)
+		assert.Nil(t, e)
+		assert.Equal(t, util.HexEnc(ctx.Contract().Code.Binary), util.HexEnc(bin))
+		if strings.HasPrefix(code, "600061000a") { // PUSH1 fits the code size
+			assert.Contains(t, src, "  ;; .size 2\n  PUSH @tag_10\n  ;; -> tag_10\n  JUMPI\n")
+			assert.NotContains(t, src, ".size 1")
+		}
+
+		// compiled by geth `evm compile` too
+		c := asm.NewCompiler(false)
+		c.Feed(asm.Lex([]byte(src), false))
+		_, errs := c.Compile()
+		assert.Empty(t, errs)
+	}
+}
+
+func TestAssemblePatch(t *testing.T) {
+	ctx := edb.NewSampleContext()
+	l, e := Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+
+	// remove the `CALLVALUE` check at the beginning, the labels are moved
+	src := l.Evm()
+	check := "  CALLVALUE\n  DUP1\n  ISZERO\n  PUSH @tag_16\n  ;; -> tag_16\n  JUMPI\n\n  PUSH 0x00\n  DUP1\n  REVERT\n\ntag_16:\n  POP\n"
+	assert.Contains(t, src, check)
+	src = strings.Replace(src, check, "", 1)
+
+	bin, e := Assemble(src)
+	assert.Nil(t, e)
+	assert.Equal(t, len(ctx.Contract().Code.Binary)-13, len(bin))
+
+	// the old disasm is replaced
+	assert.Nil(t, ctx.Contract().Code.Set(bin))
+	line, e := ctx.Contract().Code.Asm.LineAtPc(5)
+	assert.Nil(t, e)
+	assert.Equal(t, "PUSH1", edb.OpName(line.Op.OpCode))
+
+	ctx.Msg().Value = big.NewInt(1) // not reverted
+	assert.Nil(t, ctx.Run(-1))
+}
+
+func TestAssembleWiden(t *testing.T) {
+	// PUSH1 label in code larger than 255 bytes
+	bin, e := Assemble(`
+		;; .size 1
+		PUSH @a
+		JUMP
+	a:
+		STOP
+		;; .data 0x` + strings.Repeat("00", 300) + `
+		PUSH @a`)
+	assert.Nil(t, e)
+	assert.Equal(t, "600356"+"5b00", util.HexEnc(bin[:5]))
+
+	ctx := edb.NewSampleContext()
+	assert.Nil(t, ctx.Contract().Code.Set(bin))
+	l, e := Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	src := l.Evm()
+	assert.Contains(t, src, "  ;; .size 1\n  PUSH @tag_3\n")
+
+	// insert bytes before the label, it doesn't fit in PUSH1 anymore
+	src = strings.Replace(src, "tag_3:", ";; .data 0x"+strings.Repeat("00", 300)+"\ntag_3:", 1)
+	bin, e = Assemble(src)
+	assert.Nil(t, e)
+	assert.Equal(t, "61013056", util.HexEnc(bin[:4])) // PUSH2 304, JUMP
+	assert.Equal(t, byte(vm.JUMPDEST), bin[304])
+
+	assert.Nil(t, ctx.Contract().Code.Set(bin))
+	assert.Nil(t, ctx.Run(-1)) // jumps to the JUMPDEST
+}
+
+func TestAssembleSyntax(t *testing.T) {
+	bin, e := Assemble(`
+		PUSH 0x0080      ;; PUSH2
+		PUSH1 1
+		push2 0x1
+		JUMP @end
+		;; .data 0x0c
+	end:
+		PUSH2 @end
+		STOP`)
+	assert.Nil(t, e)
+	assert.Equal(t, "6100806001610001600c56"+"0c"+"5b61000c00", util.HexEnc(bin))
+
+	for _, src := range []string{
+		"PUSH",
+		"PUSH1 0x1234",
+		"PUSH @nowhere",
+		"FOO",
+		"ADD 1",
+		"a:\na:",
+		";; .data xyz",
+		";; .size 2\nSTOP",
+		";; .size 0\nPUSH @a\na:",
+	} {
+		_, e := Assemble(src)
+		assert.NotNil(t, e, src)
+	}
+}
@@ -0,0 +1,43 @@
+package disasm
+
+import (
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+var knownConstants = map[common.Hash]string{
+	edb.Eip1967ImplementationSlot.Bytes32(): "EIP-1967 implementation slot",
+	edb.Eip1967AdminSlot.Bytes32():          "EIP-1967 admin slot",
+	edb.Eip1967BeaconSlot.Bytes32():         "EIP-1967 beacon slot",
+
+	edb.Eip1822ProxiableSlot.Bytes32():                                  "EIP-1822 proxiable slot",
+	edb.ZeppelinOSImplementationSlot.Bytes32():                          "ZeppelinOS implementation slot",
+	common.BytesToHash(util.Sha3([]byte("org.zeppelinos.proxy.admin"))): "ZeppelinOS admin slot",
+}
+
+// index is the address, 0 is not a precompile
+var precompiles = []string{
+	"", "ecrecover", "sha256", "ripemd160", "identity", "modexp",
+	"ecadd", "ecmul", "ecpairing", "blake2f", "point_evaluation",
+}
+
+// the pushed address is used by a call:
+//   - PUSH addr, CALL
+//   - PUSH addr, GAS, CALL
+func is_precompile_call(next []*Line) bool {
+	for i, line := range next {
+		if i > 1 || line.IsData {
+			return false
+		}
+		switch line.Op.OpCode {
+		case vm.GAS:
+			continue
+		case vm.CALL, vm.STATICCALL, vm.DELEGATECALL, vm.CALLCODE:
+			return true
+		}
+		return false
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package disasm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/cfg"
+	"github.com/aj3423/edb/sigdb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// An annotated line of the listing
+type Line struct {
+	*edb.Line
+
+	Label   string   // "tag_59" for JUMPDEST
+	Block   bool     // first line of a basic block
+	Ref     string   // label pushed as a jump target, eg: "PUSH2 003b" -> "tag_59"
+	Jump    bool     // JUMP/JUMPI
+	Targets []uint64 // resolved targets of JUMP/JUMPI, static or observed
+	Comment string   // function, selector or known constant
+}
+
+// Whole contract disassembled, with jump labels and annotations
+type Listing struct {
+	Address common.Address
+	Lines   []*Line // ordered by pc
+
+	labels map[uint64]string // map[pc]label
+	at     map[uint64]*Line
+}
+
+// "tag_59"
+func label_of(pc uint64) string {
+	return fmt.Sprintf("tag_%d", pc)
+}
+
+func is_push(op vm.OpCode) bool {
+	return op >= vm.PUSH1 && op <= vm.PUSH32
+}
+
+func push_value(l *edb.Line) (uint64, bool) {
+	v := new(uint256.Int).SetBytes(l.Data)
+	if !v.IsUint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+/*
+Disassemble the code of `addr` with annotations:
+  - every JUMPDEST is labeled, jump targets are resolved by the CFG
+    and the jumps recorded by `rec`, which can be nil
+  - PUSH of a JUMPDEST is a label reference only if it's proven to be a jump target:
+    the target of the following JUMP/JUMPI, or a resolved target of some jump,
+    eg: the return address of internal calls, when the return jump is recorded.
+    other pushes are kept literal, they may be constants that equal a JUMPDEST
+  - function entries and selectors are named by ABI or signature database
+  - known constants are commented, eg: EIP-1967 slots, precompile addresses
+*/
+func Disasm(ctx *edb.Context, addr common.Address, rec *cfg.Recorder) (*Listing, error) {
+	c, ok := ctx.Contracts[addr]
+	if !ok || c.Code.Asm == nil || len(c.Code.Binary) == 0 {
+		return nil, errors.Errorf("no code for: %s", addr.Hex())
+	}
+	asm := c.Code.Asm
+
+	g := cfg.Build(asm)
+	if rec != nil {
+		rec.Apply(g, addr)
+	}
+
+	l := &Listing{
+		Address: addr,
+		labels:  map[uint64]string{},
+		at:      map[uint64]*Line{},
+	}
+	for row := 0; row < asm.LineCount(); row++ {
+		line := &Line{Line: asm.AtRow(row)}
+		if !line.IsData && line.Op.OpCode == vm.JUMPDEST {
+			line.Label = label_of(line.Pc)
+			l.labels[line.Pc] = line.Label
+		}
+		l.Lines = append(l.Lines, line)
+		l.at[line.Pc] = line
+	}
+
+	// block separators and jump targets
+	targets := map[uint64]bool{}
+	for _, b := range g.Blocks {
+		first := l.LineAt(b.Start)
+		first.Block = true
+
+		last := l.LineAt(b.End)
+		op := last.Op.OpCode
+		if op != vm.JUMP && op != vm.JUMPI {
+			continue
+		}
+		last.Jump = true
+		for _, e := range b.Succs {
+			if e.Kind != cfg.EdgeFall {
+				last.Targets = append(last.Targets, e.To)
+				targets[e.To] = true
+			}
+		}
+		sort.Slice(last.Targets, func(i, j int) bool { return last.Targets[i] < last.Targets[j] })
+
+		// PUSH <tag>; JUMP
+		if len(b.Lines) < 2 {
+			continue
+		}
+		prev := l.LineAt(b.Lines[len(b.Lines)-2].Pc)
+		if !is_push(prev.Op.OpCode) {
+			continue
+		}
+		if v, ok := push_value(prev.Line); ok && l.labels[v] != "" {
+			prev.Ref = l.labels[v]
+		}
+	}
+
+	// pushed somewhere else and jumped to later, eg: return address
+	for _, line := range l.Lines {
+		if line.IsData || !is_push(line.Op.OpCode) || line.Ref != "" {
+			continue
+		}
+		if v, ok := push_value(line.Line); ok && targets[v] && l.labels[v] != "" {
+			line.Ref = l.labels[v]
+		}
+	}
+
+	l.annotate(ctx, c.Code.Binary)
+	return l, nil
+}
+
+// the line at `pc`, nil if not found
+func (l *Listing) LineAt(pc uint64) *Line {
+	return l.at[pc]
+}
+
+// name of function, by ABI or signature database
+func signature(ctx *edb.Context, addr common.Address, sel [4]byte) string {
+	if a, ok := ctx.Abis[addr]; ok {
+		if m, e := a.MethodById(sel[:]); e == nil {
+			return sigdb.NewSignature(m.RawName, m.Inputs, m.Outputs).Text()
+		}
+	}
+	if sigs := sigdb.Default().Funcs(sel); len(sigs) > 0 {
+		return sigs[0].Text()
+	}
+	return ""
+}
+
+func (l *Listing) annotate(ctx *edb.Context, code []byte) {
+	// function entries
+	if funcs, e := edb.FindFunctions(code); e == nil {
+		for _, f := range funcs {
+			name := signature(ctx, l.Address, f.Selector)
+			if name == "" {
+				name = f.SelectorHex()
+			}
+			if line := l.LineAt(f.Entry); line != nil {
+				line.Comment = "function " + name
+			}
+		}
+	}
+
+	for i, line := range l.Lines {
+		if line.IsData || !is_push(line.Op.OpCode) || line.Ref != "" {
+			continue
+		}
+		if len(line.Data) == 4 { // selector, custom error
+			var sel [4]byte
+			copy(sel[:], line.Data)
+			if name := signature(ctx, l.Address, sel); name != "" {
+				line.Comment = name
+				continue
+			}
+		}
+		if name, ok := knownConstants[common.BytesToHash(line.Data)]; ok {
+			line.Comment = name
+			continue
+		}
+		if is_precompile_call(l.Lines[i+1:]) {
+			if v, ok := push_value(line.Line); ok && v >= 1 && v < uint64(len(precompiles)) {
+				line.Comment = "precompile " + precompiles[v]
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package disasm
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/cfg"
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleAbi = `[
+	{"type":"function","name":"getData","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"setData","inputs":[{"name":"x","type":"uint256"}],"outputs":[]}
+]`
+
+func TestDisasm(t *testing.T) {
+	ctx := edb.NewSampleContext()
+	a, e := edb.ParseAbi([]byte(sampleAbi))
+	assert.Nil(t, e)
+	ctx.SetAbi(ctx.This(), a)
+
+	l, e := Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+
+	// PUSH2 003b, JUMPI
+	assert.Equal(t, "tag_59", l.LineAt(39).Ref)
+	assert.Equal(t, []uint64{59}, l.LineAt(42).Targets)
+	assert.True(t, l.LineAt(43).Block)
+
+	// return address of internal call, not proven without the return jump
+	assert.Equal(t, "", l.LineAt(60).Ref)
+	// not a jump target
+	assert.Equal(t, "", l.LineAt(68).Ref)
+
+	text := l.Text()
+	assert.Contains(t, text, "tag_59:  // function getData()\n")
+	assert.Contains(t, text, "PUSH4  3bc5de30  // getData()\n")
+	assert.Contains(t, text, "JUMPI  // -> tag_59\n")
+	assert.Contains(t, text, "JUMP  // -> ?\n")
+
+	// the return jump of getData() is observed
+	rec := cfg.NewRecorder()
+	ctx.Hooks.Attach(rec)
+	assert.Nil(t, ctx.Run(-1))
+
+	l, e = Disasm(ctx, ctx.This(), rec)
+	assert.Nil(t, e)
+	assert.Equal(t, []uint64{67}, l.LineAt(125).Targets)
+	assert.Equal(t, "tag_67", l.LineAt(60).Ref)
+}
+
+func TestDisasmFormats(t *testing.T) {
+	ctx := edb.NewSampleContext()
+
+	l, e := Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+
+	// json
+	bs, e := l.Slice(39, 59).JSON()
+	assert.Nil(t, e)
+	var out jsonListing
+	assert.Nil(t, json.Unmarshal(bs, &out))
+	assert.Equal(t, uint64(39), out.Lines[0].Pc)
+	assert.Equal(t, "tag_59", out.Lines[0].Ref)
+	assert.Equal(t, "function 0x3bc5de30", out.Lines[len(out.Lines)-1].Comment)
+
+	// evm
+	src := l.Evm()
+	assert.Contains(t, src, ";; function 0x3bc5de30\ntag_59:\n  PUSH 0x0043\n  PUSH @tag_117\n")
+	assert.Contains(t, src, ";; .data 0xfe\n")
+	assert.Contains(t, src, ";; .data 0xa264")
+}
+
+func TestKnownConstants(t *testing.T) {
+	ctx := edb.NewSampleContext()
+
+	// sload(implementation slot), staticcall(gas(), 2, ...)
+	code := "7f360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc54" +
+		"6000600060006000" + "60025afa" + "00"
+	contract := edb.NewContract()
+	contract.Balance = big.NewInt(0)
+	contract.Code.Set(util.HexDec(code))
+	ctx.Contracts[ctx.This()] = contract
+
+	l, e := Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "EIP-1967 implementation slot", l.LineAt(0).Comment)
+	assert.Equal(t, "precompile sha256", l.LineAt(42).Comment)
+	assert.Equal(t, "", l.LineAt(40).Comment)
+
+	// staticcall(gas(), 0, ...), address 0 is not a precompile
+	contract.Code.Set(util.HexDec("6000600060006000" + "60005afa" + "00"))
+	l, e = Disasm(ctx, ctx.This(), nil)
+	assert.Nil(t, e)
+	assert.Equal(t, "", l.LineAt(8).Comment)
+}
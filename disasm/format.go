@@ -0,0 +1,236 @@
+package disasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+)
+
+// Lines with pc in range [from, to]
+func (l *Listing) Slice(from, to uint64) *Listing {
+	out := &Listing{Address: l.Address, labels: l.labels, at: map[uint64]*Line{}}
+	for _, line := range l.Lines {
+		if line.Pc >= from && line.Pc <= to {
+			out.Lines = append(out.Lines, line)
+			out.at[line.Pc] = line
+		}
+	}
+	return out
+}
+
+// "PUSH1", "DATA", "INVALID(0c)"
+func op_name(line *Line) string {
+	if line.IsData {
+		return "DATA"
+	}
+	name := edb.OpName(line.Op.OpCode)
+	if _, ok := edb.OpTable[line.Op.OpCode]; !ok || strings.Contains(name, " ") { // "opcode 0xfe not defined"
+		return fmt.Sprintf("INVALID(%02x)", byte(line.Op.OpCode))
+	}
+	return name
+}
+
+func (l *Listing) label_list(pcs []uint64) string {
+	names := []string{}
+	for _, pc := range pcs {
+		names = append(names, l.labels[pc])
+	}
+	return strings.Join(names, ", ")
+}
+
+// all annotations of the line
+func (l *Listing) comments(line *Line) []string {
+	ret := []string{}
+	if line.Ref != "" {
+		ret = append(ret, line.Ref)
+	}
+	if line.Jump {
+		if len(line.Targets) == 0 {
+			ret = append(ret, "-> ?")
+		} else {
+			ret = append(ret, "-> "+l.label_list(line.Targets))
+		}
+	}
+	if line.Comment != "" && line.Label == "" { // shown with the label
+		ret = append(ret, line.Comment)
+	}
+	return ret
+}
+
+// "tag_59:  // function getData()"
+func label_line(line *Line) string {
+	if line.Comment != "" {
+		return line.Label + ":  // " + line.Comment
+	}
+	return line.Label + ":"
+}
+
+/*
+Plain text, eg:
+
+	tag_59:  // function getData()
+	      59     JUMPDEST
+	      60        PUSH2  0043  // tag_67
+	      63         JUMP        // -> tag_117
+*/
+func (l *Listing) Text() string {
+	sb := strings.Builder{}
+	for i, line := range l.Lines {
+		if line.Block && i > 0 {
+			sb.WriteString("\n")
+		}
+		if line.Label != "" {
+			sb.WriteString(label_line(line) + "\n")
+		}
+		s := strings.TrimRight(line.String(), " ")
+		if cmts := l.comments(line); len(cmts) > 0 && !line.IsData {
+			s += "  // " + strings.Join(cmts, "; ")
+		}
+		sb.WriteString(s + "\n")
+	}
+	return sb.String()
+}
+
+type jsonLine struct {
+	Pc         uint64   `json:"pc"`
+	Op         string   `json:"op"`
+	Data       string   `json:"data,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Block      bool     `json:"block,omitempty"` // first line of a basic block
+	Ref        string   `json:"ref,omitempty"`
+	Targets    []uint64 `json:"targets,omitempty"`
+	Unresolved bool     `json:"unresolved,omitempty"` // jump target unknown
+	Comment    string   `json:"comment,omitempty"`
+}
+type jsonListing struct {
+	Address string     `json:"address"`
+	Lines   []jsonLine `json:"lines"`
+}
+
+func (l *Listing) JSON() ([]byte, error) {
+	out := jsonListing{
+		Address: l.Address.Hex(),
+		Lines:   []jsonLine{},
+	}
+	for _, line := range l.Lines {
+		jl := jsonLine{
+			Pc:         line.Pc,
+			Op:         op_name(line),
+			Data:       util.HexEnc(line.Data),
+			Label:      line.Label,
+			Block:      line.Block,
+			Ref:        line.Ref,
+			Targets:    line.Targets,
+			Unresolved: line.Jump && len(line.Targets) == 0,
+			Comment:    line.Comment,
+		}
+		if line.Meta != nil {
+			jl.Comment = line.Meta.String()
+		}
+		out.Lines = append(out.Lines, jl)
+	}
+	return json.MarshalIndent(out, "", "  ")
+}
+
+/*
+Source in the syntax of geth `evm compile`, assembled back by `Assemble`:
+  - the label definition `tag_59:` is the JUMPDEST
+  - PUSH keeps the size of the original data, eg: "PUSH 0x0080"
+  - label pushes are `PUSH @tag_67`, sized by `Assemble` like solc does(`evm compile` uses PUSH4),
+    a label push of another size has it in the comment `;; .size 1` before it,
+    so code with mixed label sizes is rebuilt byte for byte
+  - comments are on their own lines, a trailing comment eats the line break in `evm compile`
+  - data regions and unknown opcodes are kept as comments `;; .data 0x..`, skipped by `evm compile`,
+    but restored by `Assemble`
+
+eg:
+
+	;; function getData()
+	tag_59:
+	  PUSH @tag_67
+	  ;; -> tag_117
+	  JUMP
+*/
+func (l *Listing) Evm() string {
+	labelSize := l.label_size()
+
+	sb := strings.Builder{}
+	sb.WriteString(";; " + l.Address.Hex() + "\n")
+	for i, line := range l.Lines {
+		if line.Block && i > 0 {
+			sb.WriteString("\n")
+		}
+		if line.Label != "" {
+			if line.Comment != "" {
+				sb.WriteString(";; " + line.Comment + "\n")
+			}
+			sb.WriteString(line.Label + ":\n")
+			continue
+		}
+
+		if line.IsData {
+			if line.Meta != nil {
+				sb.WriteString(";; " + line.Meta.String() + "\n")
+			}
+			sb.WriteString(";; .data 0x" + util.HexEnc(line.Data) + "\n")
+			continue
+		}
+		if op_name(line) != edb.OpName(line.Op.OpCode) {
+			sb.WriteString(fmt.Sprintf(";; .data 0x%02x\n", byte(line.Op.OpCode)))
+			continue
+		}
+
+		cmts := l.comments(line)
+		if line.Ref != "" {
+			cmts = cmts[1:] // already in the PUSH
+		}
+		if len(cmts) > 0 {
+			sb.WriteString("  ;; " + strings.Join(cmts, "; ") + "\n")
+		}
+		switch {
+		case line.Ref != "":
+			if len(line.Data) != labelSize {
+				sb.WriteString(fmt.Sprintf("  ;; .size %d\n", len(line.Data)))
+			}
+			sb.WriteString("  PUSH @" + line.Ref + "\n")
+		case is_push(line.Op.OpCode):
+			sb.WriteString("  PUSH 0x" + util.HexEnc(line.Data) + "\n")
+		default:
+			sb.WriteString("  " + edb.OpName(line.Op.OpCode) + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// bytes of the code
+func (l *Listing) code_size() int {
+	if len(l.Lines) == 0 {
+		return 0
+	}
+	last := l.Lines[len(l.Lines)-1]
+	if last.IsData {
+		return int(last.Pc) + len(last.Data)
+	}
+	return int(last.Pc) + 1 + len(last.Data)
+}
+
+// The size `Assemble` gives to the label pushes of this size,
+// 0 if none, then all label pushes have `;; .size`
+func (l *Listing) label_size() int {
+	codeSize := l.code_size()
+	for size := 1; size <= 32; size++ {
+		n := 0
+		for _, line := range l.Lines {
+			if line.Ref != "" && len(line.Data) == size {
+				n++
+			}
+		}
+		if label_size(codeSize-n*size, n) == size {
+			return size
+		}
+	}
+	return 0
+}
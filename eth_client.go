@@ -7,14 +7,134 @@ import (
 
 	"github.com/aj3423/edb/util"
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/fatih/color"
 	"github.com/holiman/uint256"
 	"github.com/pkg/errors"
 )
 
+/*
+Block header from `eth_getBlockByNumber`.
+
+The raw json is decoded here instead of `types.Header`,
+it includes the fields added after London.
+*/
+type rpcHeader struct {
+	Hash             common.Hash     `json:"hash"`
+	Number           hexutil.Uint64  `json:"number"`
+	Timestamp        hexutil.Uint64  `json:"timestamp"`
+	Difficulty       *hexutil.Big    `json:"difficulty"`
+	MixHash          common.Hash     `json:"mixHash"`
+	Coinbase         common.Address  `json:"miner"`
+	GasLimit         hexutil.Uint64  `json:"gasLimit"`
+	BaseFee          *hexutil.Big    `json:"baseFeePerGas"`
+	BlobGasUsed      *hexutil.Uint64 `json:"blobGasUsed"`
+	ExcessBlobGas    *hexutil.Uint64 `json:"excessBlobGas"`
+	ParentBeaconRoot *common.Hash    `json:"parentBeaconBlockRoot"`
+}
+
+// blockNum: nil for "latest"
+func get_online_header(
+	client *rpc.Client,
+	blockNum *big.Int,
+) (*rpcHeader, error) {
+	if client == nil {
+		return nil, errors.New("no node connected")
+	}
+	tag := "latest"
+	if blockNum != nil {
+		tag = hexutil.EncodeBig(blockNum)
+	}
+
+	var head *rpcHeader
+	e := client.CallContext(
+		context.Background(), &head, "eth_getBlockByNumber", tag, false)
+	if e != nil {
+		return nil, e
+	}
+	if head == nil {
+		return nil, fmt.Errorf("block not found: %s", tag)
+	}
+	return head, nil
+}
+
+func (h *rpcHeader) toBlock() Block {
+	b := Block{
+		Number:     uint64(h.Number),
+		Timestamp:  uint64(h.Timestamp),
+		Difficulty: big.NewInt(0),
+		PrevRandao: h.MixHash,
+		Coinbase:   h.Coinbase,
+		GasLimit:   uint64(h.GasLimit),
+	}
+	if h.Difficulty != nil {
+		b.Difficulty = h.Difficulty.ToInt()
+	}
+	if h.BaseFee != nil {
+		b.BaseFee = h.BaseFee.ToInt().Uint64()
+	}
+	if h.BlobGasUsed != nil {
+		b.BlobGasUsed = uint64(*h.BlobGasUsed)
+	}
+	if h.ExcessBlobGas != nil {
+		b.ExcessBlobGas = uint64(*h.ExcessBlobGas)
+	}
+	if h.ParentBeaconRoot != nil {
+		b.ParentBeaconRoot = *h.ParentBeaconRoot
+	}
+	return b
+}
+
+/*
+Blob base fee of block `blockNum` from `eth_feeHistory`,
+`next` for the block after it, eg: the pending block a call is simulated in.
+nil if the node doesn't return it.
+*/
+func get_online_blob_base_fee(
+	client *rpc.Client,
+	blockNum uint64,
+	next bool,
+) (*big.Int, error) {
+	var hist struct {
+		// [blockNum, blockNum+1]
+		BaseFeePerBlobGas []*hexutil.Big `json:"baseFeePerBlobGas"`
+	}
+	e := client.CallContext(context.Background(), &hist, "eth_feeHistory",
+		hexutil.Uint64(1), hexutil.EncodeUint64(blockNum), []float64{})
+	if e != nil {
+		return nil, e
+	}
+	i := 0
+	if next {
+		i = 1
+	}
+	if len(hist.BaseFeePerBlobGas) <= i || hist.BaseFeePerBlobGas[i] == nil {
+		return nil, nil
+	}
+	return hist.BaseFeePerBlobGas[i].ToInt(), nil
+}
+
+/*
+The update fraction differs between chains and forks,
+so take the blob base fee from the node, fallback to `Chain.BlobSchedule`.
+*/
+func (ctx *Context) fetch_blob_base_fee(head *rpcHeader, next bool) {
+	if head.ExcessBlobGas == nil { // before Cancun
+		return
+	}
+	fee, e := get_online_blob_base_fee(ctx.rpcClient, uint64(head.Number), next)
+	if e != nil {
+		color.Yellow("blob base fee: %s, derived from excess blob gas instead", e.Error())
+		return
+	}
+	ctx.Block.BlobBaseFee = fee
+}
+
 func get_online_block_hash(
-	client *ethclient.Client,
+	client *rpc.Client,
 	blockNum uint64,
 ) (common.Hash, error) {
 	var hash common.Hash
@@ -24,18 +144,27 @@ func get_online_block_hash(
 	}
 	// color.Blue("get block hash: %d", blockNum)
 
-	block, e := client.BlockByNumber(
-		context.Background(),
-		new(big.Int).SetUint64(blockNum),
-	)
+	head, e := get_online_header(client, new(big.Int).SetUint64(blockNum))
 	if e != nil {
 		return hash, e
 	}
-	hash = block.Hash()
+	hash = head.Hash
 	// color.Green("value: %s", hash.Hex())
 
 	return hash, nil
 }
+
+// connect to node, both clients share the same connection
+func (ctx *Context) dial(node_url string) error {
+	client, e := rpc.Dial(node_url)
+	if e != nil {
+		return e
+	}
+	ctx.rpcClient = client
+	ctx.ethClient = ethclient.NewClient(client)
+	return nil
+}
+
 func get_online_storage(
 	client *ethclient.Client,
 	address common.Address,
@@ -114,84 +243,272 @@ func get_online_balance(
 	return bal, nil
 }
 
+func get_online_nonce(
+	client *ethclient.Client,
+	address common.Address,
+	blockNum uint64,
+) (uint64, error) {
+	if client == nil {
+		return 0, fmt.Errorf("no nonce for: %s", address.String())
+	}
+	if address == util.ZeroAddress || blockNum == 0 {
+		return 0, errors.New("invalid AddressThis or Block.Number")
+	}
+	return client.NonceAt(context.Background(), address, new(big.Int).SetUint64(blockNum))
+}
+
 func ContextFromTx(
 	node_url string,
 	tx_hash string,
 ) (*Context, error) {
 
-	client, e := ethclient.Dial(node_url)
-	if e != nil {
+	ctx := NewContext()
+
+	if e := ctx.dial(node_url); e != nil {
 		return nil, e
 	}
-
-	chain_id, e := client.ChainID(context.Background())
+	chain_id, e := ctx.ethClient.ChainID(context.Background())
 	if e != nil {
 		return nil, e
 	}
 
-	tx, _, e := client.TransactionByHash(context.Background(), common.HexToHash(tx_hash))
+	tx, e := get_online_tx(ctx.rpcClient, common.HexToHash(tx_hash))
 	if e != nil {
 		return nil, e
 	}
-	receipt, e := client.TransactionReceipt(context.Background(), common.HexToHash(tx_hash))
+	if tx.BlockNumber == nil {
+		return nil, fmt.Errorf("tx not mined yet: %s", tx_hash)
+	}
+	sender, e := tx.sender(chain_id)
 	if e != nil {
-		return nil, e
+		return nil, errors.Wrap(e, "recover sender")
 	}
-	msg, e := tx.AsMessage(types.NewLondonSigner(big.NewInt(int64(chain_id.Uint64()))), nil)
+	head, e := get_online_header(ctx.rpcClient, tx.BlockNumber.ToInt())
 	if e != nil {
 		return nil, e
 	}
-	blockNum := receipt.BlockNumber
-	block, e := client.BlockByNumber(context.Background(), blockNum)
+
+	ctx.Block = head.toBlock()
+	ctx.fetch_blob_base_fee(head, false)
+	ctx.BlockHashes[ctx.Block.Number] = head.Hash
+
+	ctx.Tx = Tx{
+		Hash:       tx.Hash,
+		Origin:     sender,
+		GasPrice:   tx.effective_gas_price(ctx.Block.BaseFee).Uint64(),
+		Type:       uint8(tx.Type),
+		AccessList: tx.AccessList,
+		BlobHashes: tx.BlobHashes,
+	}
+
+	ctx.Chain = Chain{
+		Id:      chain_id.Uint64(),
+		NodeUrl: node_url,
+	}
+
+	value := big.NewInt(0)
+	if tx.Value != nil {
+		value = tx.Value.ToInt()
+	}
+	ctx.Call().Msg = Msg{
+		Gas:    uint64(tx.Gas),
+		Sender: sender,
+		Value:  value,
+	}
+
+	if tx.To == nil {
+		// contract creation, run the init code with empty calldata
+		ctx.Tx.Create = true
+		ctx.Call().This = crypto.CreateAddress(sender, uint64(tx.Nonce))
+
+		contract := ensure_contract_at(ctx, ctx.Call().This)
+		if e = contract.Code.Set(tx.Input); e != nil {
+			return nil, e
+		}
+		return ctx, nil
+	}
+
+	ctx.Call().This = *tx.To
+	ctx.Call().Msg.Data = []byte(tx.Input)
+
+	_, e = ensure_code(ctx, *tx.To)
 	if e != nil {
 		return nil, e
 	}
+	// for the ABI and storage layout of the implementation
+	if _, e = ctx.ResolveProxy(*tx.To); e != nil {
+		color.Yellow("resolve proxy: %s", e.Error())
+	}
+
+	return ctx, nil
+}
+
+// Overrides the account state before simulating, like the `eth_call` state override set
+type StateOverride struct {
+	Balance *big.Int
+	Code    []byte
+	Storage map[common.Hash]*uint256.Int // only overrides these slots, others are fetched online
+}
+
+// Overrides fields of the simulated block
+type BlockOverride struct {
+	Number     *uint64
+	Timestamp  *uint64
+	Coinbase   *common.Address
+	Difficulty *big.Int // only used before the Merge
+	PrevRandao *big.Int // the `DIFFICULTY` after the Merge
+	BaseFee    *big.Int
+
+	// added after the above, relative to the new block, eg: timestamp + 6
+	NumberDelta    uint64
+	TimestampDelta uint64
+}
+
+type CallArgs struct {
+	From     common.Address
+	To       common.Address
+	Value    *big.Int
+	Data     []byte
+	Gas      uint64   // default: block gas limit
+	GasPrice *big.Int // default: block base fee
+
+	Block string // block number or "latest", the state at the end of this block is used
+
+	State     map[common.Address]*StateOverride
+	BlockOver BlockOverride
+}
+
+/*
+Simulates a call that is not mined, like `eth_call`.
+
+The call is executed in a new block on top of `args.Block`,
+that is: `number + 1`, and all other fields same as `args.Block`,
+use `args.BlockOver` to change them, eg: `TimestampDelta: 6`.
+*/
+func ContextFromCall(
+	node_url string,
+	args *CallArgs,
+) (*Context, error) {
 
 	ctx := NewContext()
 
-	ctx.ethClient = client
+	if e := ctx.dial(node_url); e != nil {
+		return nil, e
+	}
 
-	ctx.Tx = Tx{
-		Hash:     tx.Hash(),
-		Origin:   msg.From(),
-		GasPrice: tx.GasPrice().Uint64(),
+	chain_id, e := ctx.ethClient.ChainID(context.Background())
+	if e != nil {
+		return nil, e
 	}
-	baseFee := big.NewInt(0)
-	if block.BaseFee() != nil {
-		baseFee = block.BaseFee()
+
+	var blockNum *big.Int // nil for "latest"
+	if args.Block != "" && args.Block != "latest" {
+		n, ok := new(big.Int).SetString(args.Block, 0)
+		if !ok {
+			return nil, fmt.Errorf("invalid block number: %s", args.Block)
+		}
+		blockNum = n
 	}
-	ctx.Block = Block{
-		Number:     block.NumberU64(),
-		Timestamp:  block.Time(),
-		Difficulty: block.Difficulty().Uint64(),
-		Coinbase:   block.Coinbase(),
-		GasLimit:   block.GasLimit(),
-		BaseFee:    baseFee.Uint64(),
+	head, e := get_online_header(ctx.rpcClient, blockNum)
+	if e != nil {
+		return nil, e
 	}
-	ctx.BlockHashes[block.NumberU64()] = block.Hash()
 
 	ctx.Chain = Chain{
-		Id:      chain_id.Uint64(),
-		NodeUrl: node_url,
+		Id:         chain_id.Uint64(),
+		NodeUrl:    node_url,
+		StateBlock: uint64(head.Number),
 	}
-	to := *tx.To()
 
-	ctx.Call().This = to
-	ctx.Call().Msg = Msg{
-		Data:   tx.Data(),
-		Gas:    tx.Gas(),
-		Sender: msg.From(),
-		Value:  msg.Value(),
+	ctx.Block = head.toBlock()
+	ctx.Block.Number++
+	ctx.fetch_blob_base_fee(head, true)
+	ctx.BlockHashes[uint64(head.Number)] = head.Hash
+
+	if e = apply_block_override(ctx, &args.BlockOver); e != nil {
+		return nil, e
 	}
 
-	_, e = ensure_code(ctx, to)
+	gasPrice := new(big.Int).SetUint64(ctx.Block.BaseFee)
+	if args.GasPrice != nil {
+		gasPrice = args.GasPrice
+	}
+	ctx.Tx = Tx{
+		Origin:   args.From,
+		GasPrice: gasPrice.Uint64(),
+	}
+
+	gas := args.Gas
+	if gas == 0 {
+		gas = ctx.Block.GasLimit
+	}
+	value := big.NewInt(0)
+	if args.Value != nil {
+		value = args.Value
+	}
+	ctx.Call().This = args.To
+	ctx.Call().Msg = Msg{
+		Data:   args.Data,
+		Gas:    gas,
+		Sender: args.From,
+		Value:  value,
+	}
+
+	// apply state override before fetching anything,
+	// so the overridden code is not fetched online
+	for addr, over := range args.State {
+		contract := ensure_contract_at(ctx, addr)
+		if over.Balance != nil {
+			contract.Balance = new(big.Int).Set(over.Balance)
+		}
+		if over.Code != nil {
+			if e = contract.Code.Set(over.Code); e != nil {
+				return nil, e
+			}
+		}
+		for slot, val := range over.Storage {
+			contract.Storage[slot] = val.Clone()
+		}
+	}
+
+	_, e = ensure_code(ctx, args.To)
 	if e != nil {
 		return nil, e
 	}
+	if _, e = ctx.ResolveProxy(args.To); e != nil {
+		color.Yellow("resolve proxy: %s", e.Error())
+	}
 
 	return ctx, nil
 }
 
+func apply_block_override(ctx *Context, over *BlockOverride) error {
+	if over.Number != nil {
+		ctx.Block.Number = *over.Number
+	}
+	if over.Timestamp != nil {
+		ctx.Block.Timestamp = *over.Timestamp
+	}
+	if over.Coinbase != nil {
+		ctx.Block.Coinbase = *over.Coinbase
+	}
+	if over.Difficulty != nil {
+		ctx.Block.Difficulty = new(big.Int).Set(over.Difficulty)
+	}
+	if over.PrevRandao != nil {
+		if over.PrevRandao.Sign() < 0 || over.PrevRandao.BitLen() > 256 {
+			return fmt.Errorf("invalid prevrandao: %s", over.PrevRandao.String())
+		}
+		ctx.Block.PrevRandao = common.BigToHash(over.PrevRandao)
+	}
+	if over.BaseFee != nil {
+		ctx.Block.BaseFee = over.BaseFee.Uint64()
+	}
+	ctx.Block.Number += over.NumberDelta
+	ctx.Block.Timestamp += over.TimestampDelta
+	return nil
+}
+
 // get *Contract at addr, create if not exists
 func ensure_contract_at(ctx *Context, addr common.Address) *Contract {
 	contract, ok := ctx.Contracts[addr]
@@ -211,8 +528,12 @@ func ensure_balance(ctx *Context, address common.Address) (*big.Int, error) {
 	if contract.Balance != nil { // if code exists in local cache
 		return contract.Balance, nil
 	}
+	if ctx.Chain.Offline {
+		contract.Balance = big.NewInt(0)
+		return contract.Balance, nil
+	}
 
-	bal, e := get_online_balance(ctx.ethClient, address, ctx.Block.Number-1) // block - 1
+	bal, e := get_online_balance(ctx.ethClient, address, ctx.stateBlock())
 	if e != nil {
 		return nil, e
 	}
@@ -223,6 +544,28 @@ func ensure_balance(ctx *Context, address common.Address) (*big.Int, error) {
 	return bal, nil
 }
 
+// get from local map first
+// fetch online if it's 0, since 0 is also the default
+func ensure_nonce(ctx *Context, address common.Address) (uint64, error) {
+
+	contract := ensure_contract_at(ctx, address)
+
+	if contract.Nonce != 0 || contract.nonceFetched || ctx.Chain.Offline {
+		return contract.Nonce, nil
+	}
+
+	nonce, e := get_online_nonce(ctx.ethClient, address, ctx.stateBlock())
+	if e != nil {
+		return 0, e
+	}
+
+	// cache it
+	contract.Nonce = nonce
+	contract.nonceFetched = true
+
+	return nonce, nil
+}
+
 // get from local map first
 // fetch online if not exists
 func ensure_code(ctx *Context, address common.Address) ([]byte, error) {
@@ -233,9 +576,12 @@ func ensure_code(ctx *Context, address common.Address) ([]byte, error) {
 	if len(contract.Code.Binary) > 0 { // if code exists in local cache
 		return contract.Code.Binary, nil
 	}
+	if ctx.Chain.Offline {
+		return nil, nil
+	}
 
 	var e error
-	binary, e = get_online_code(ctx.ethClient, address, ctx.Block.Number)
+	binary, e = get_online_code(ctx.ethClient, address, ctx.stateBlock())
 	if e != nil {
 		return nil, e
 	}
@@ -264,12 +610,13 @@ func ensure_storage(
 	if ok { // if code exists in local cache
 		return val, nil
 	}
+	if ctx.Chain.Offline {
+		return uint256.NewInt(0), nil
+	}
 
 	var e error
-	// from archive node we only get storage values after the block executed
-	// so we need to query block-1 to get the storage before executed
 	val, e = get_online_storage(
-		ctx.ethClient, address, slot, ctx.Block.Number-1) // block.number-1
+		ctx.ethClient, address, slot, ctx.stateBlock())
 	if e != nil {
 		return nil, e
 	}
@@ -291,9 +638,12 @@ func ensure_block_hash(
 	if ok { // if code exists in local cache
 		return hash, nil
 	}
+	if ctx.Chain.Offline {
+		return hash, nil
+	}
 
 	var e error
-	hash, e = get_online_block_hash(ctx.ethClient, blockNum)
+	hash, e = get_online_block_hash(ctx.rpcClient, blockNum)
 	if e != nil {
 		return hash, e
 	}
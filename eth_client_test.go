@@ -0,0 +1,28 @@
+package edb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBlockOverride(t *testing.T) {
+	ctx := NewContext()
+	ctx.Block.Number = 101
+	ctx.Block.Timestamp = 1000
+
+	assert.Nil(t, apply_block_override(ctx, &BlockOverride{
+		NumberDelta:    2,
+		TimestampDelta: 6,
+	}))
+	assert.Equal(t, uint64(103), ctx.Block.Number)
+	assert.Equal(t, uint64(1006), ctx.Block.Timestamp)
+
+	// relative to the absolute override
+	timestamp := uint64(2000)
+	assert.Nil(t, apply_block_override(ctx, &BlockOverride{
+		Timestamp:      &timestamp,
+		TimestampDelta: 6,
+	}))
+	assert.Equal(t, uint64(2006), ctx.Block.Timestamp)
+}
@@ -0,0 +1,454 @@
+package edb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+type Payability int
+
+const (
+	PayableUnknown Payability = iota
+	Payable
+	NonPayable
+)
+
+func (p Payability) String() string {
+	switch p {
+	case Payable:
+		return "payable"
+	case NonPayable:
+		return "nonpayable"
+	}
+	return "unknown"
+}
+
+// An external function found in the dispatcher
+type Function struct {
+	Selector [4]byte
+	Entry    uint64 // pc where the dispatcher jumps to for this selector
+	Payable  Payability
+}
+
+func (f *Function) SelectorHex() string {
+	return fmt.Sprintf("0x%x", f.Selector[:])
+}
+
+/*
+Recover the function dispatcher, returns functions sorted by selector.
+
+Instead of matching the dispatcher patterns of each compiler version,
+the code is run offline for every candidate selector (all PUSH1~PUSH4 values),
+with a taint tracer marks values derived from the selector,
+the entry is where the execution goes after the selector equals a constant.
+It works for:
+  - solc linear dispatcher: DUP1 PUSH4 sel EQ PUSH2 dest JUMPI
+  - solc binary search dispatcher: DUP1 PUSH4 sel GT PUSH2 dest JUMPI
+  - vyper before 0.3.10: PUSH4 sel DUP2 XOR PUSH2 next JUMPI
+  - vyper 0.3.10+ sparse jump table(-O gas): `selector % n` picks a bucket from a table of labels,
+    the bucket is a PUSH4 sel XOR chain as above
+  - vyper 0.3.10+ dense jump table(-O codesize): the selectors and labels are in the data section,
+    see `dense_selectors`, the entry is where the label read from the table jumps to
+
+The payability is checked by running again with msg.value = 1.
+The result is cached by code hash, it's used by the disassembler, proxy detection and decompiler.
+*/
+func FindFunctions(code []byte) ([]*Function, error) {
+	hash := common.BytesToHash(util.Sha3(code))
+
+	functionsCache.Lock()
+	cached, ok := functionsCache.m[hash]
+	functionsCache.Unlock()
+	if !ok {
+		var e error
+		if cached, e = find_functions(code); e != nil {
+			return nil, e
+		}
+		functionsCache.Lock()
+		functionsCache.m[hash] = cached
+		functionsCache.Unlock()
+	}
+
+	// copy, the callers may modify it
+	result := make([]*Function, len(cached))
+	for i, f := range cached {
+		cp := *f
+		result[i] = &cp
+	}
+	return result, nil
+}
+
+var functionsCache = struct {
+	sync.Mutex
+	m map[common.Hash][]*Function // map[code hash]functions
+}{m: map[common.Hash][]*Function{}}
+
+func find_functions(code []byte) ([]*Function, error) {
+	c := &Code{}
+	if e := c.Set(code); e != nil {
+		return nil, e
+	}
+
+	candidates := map[uint64]bool{}
+	for row := 0; row < c.Asm.LineCount(); row++ {
+		line := c.Asm.AtRow(row)
+		op := line.Op.OpCode
+		if !line.IsData && op >= vm.PUSH1 && op <= vm.PUSH4 {
+			candidates[new(uint256.Int).SetBytes(line.Data).Uint64()] = true
+		}
+	}
+
+	for _, sel := range dense_selectors(c) {
+		candidates[sel] = true
+	}
+
+	result := []*Function{}
+	for sel := range candidates {
+		t, _ := run_dispatcher(c, sel, false)
+		if t.entry == nil {
+			continue
+		}
+		f := &Function{Entry: *t.entry}
+		big.NewInt(0).SetUint64(sel).FillBytes(f.Selector[:])
+
+		t, reverted := run_dispatcher(c, sel, true)
+		if reverted && t.valueChecked {
+			f.Payable = NonPayable
+		} else if !reverted && t.entry != nil {
+			f.Payable = Payable
+		}
+		result = append(result, f)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return string(result[i].Selector[:]) < string(result[j].Selector[:])
+	})
+	return result, nil
+}
+
+const (
+	dispatcherMaxSteps = 10000
+	stepsAfterEntry    = 100  // for checking payability
+	maxBuckets         = 1024 // of the vyper dense jump table
+)
+
+/*
+The selectors in the vyper dense jump table, it's in the data section:
+
+	bucket headers: magic <2 bytes> | bucket location <2 bytes> | bucket size <1 byte>
+	bucket:         selector <4 bytes> | label <2 bytes> | calldatasize and nonpayable bit <1~3 bytes>
+
+The dispatcher copies the header of bucket `selector % n`, then the function info in it.
+Both copies are recorded by running with selector 0, which reads the header of bucket 0,
+n is the first selector that reads the same header again.
+*/
+func dense_selectors(c *Code) []uint64 {
+	t, _ := run_dispatcher(c, 0, false)
+	if len(t.tableReads) < 2 || t.tableReads[0].size != 5 {
+		return nil
+	}
+	headers, infoSize := t.tableReads[0].src, t.tableReads[1].size
+	if infoSize < 4 {
+		return nil
+	}
+
+	n := uint64(0)
+	for sel := uint64(1); sel <= maxBuckets; sel++ {
+		probe, _ := run_dispatcher(c, sel, false)
+		if len(probe.tableReads) > 0 && probe.tableReads[0].src == headers {
+			n = sel
+			break
+		}
+	}
+
+	code := c.Binary
+	result := []uint64{}
+	for b := uint64(0); b < n; b++ {
+		hdr := headers + b*5
+		if hdr+5 > uint64(len(code)) {
+			break
+		}
+		location := uint64(binary.BigEndian.Uint16(code[hdr+2:]))
+		size := uint64(code[hdr+4])
+		for i := uint64(0); i < size; i++ {
+			info := location + i*infoSize
+			if info+4 > uint64(len(code)) {
+				break
+			}
+			result = append(result, uint64(binary.BigEndian.Uint32(code[info:])))
+		}
+	}
+	return result
+}
+
+var errDispatcherStop = errors.New("stop")
+
+// run with calldata: selector + zeros
+func run_dispatcher(code *Code, selector uint64, withValue bool) (
+	t *dispatchTracer, reverted bool,
+) {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+	ctx.Chain.Id = 1
+	ctx.Block.Number = 1
+
+	this := common.HexToAddress("0xedb0000000000000000000000000000000000001")
+	ctx.Contracts[this] = &Contract{
+		Code:    code,
+		Balance: big.NewInt(0),
+		Storage: map[common.Hash]*uint256.Int{},
+	}
+
+	data := make([]byte, 4+32*4)
+	big.NewInt(0).SetUint64(selector).FillBytes(data[:4])
+
+	call := ctx.Call()
+	call.This = this
+	call.Msg = Msg{
+		Data:   data,
+		Gas:    10_000_000,
+		Sender: common.HexToAddress("0xedb0000000000000000000000000000000000002"),
+		Value:  big.NewInt(0),
+	}
+	if withValue {
+		call.Msg.Value = big.NewInt(1)
+	}
+
+	t = &dispatchTracer{
+		selector:  selector,
+		withValue: withValue,
+		mem:       []memTaint{},
+	}
+	ctx.Hooks.Attach(t)
+
+	e := ctx.Run(dispatcherMaxSteps)
+	reverted = errors.Is(e, errReverted)
+
+	return t, reverted
+}
+
+var errReverted = errors.Wrap(errDispatcherStop, "reverted")
+
+// the result of comparing the selector with a constant
+type eqTest struct {
+	selector       uint64
+	matchIfNonZero bool // true for EQ, false for XOR/SUB
+	table          bool // the selector is read from a jump table, not pushed
+}
+
+type taint struct {
+	sel   bool // derived from the selector
+	value bool // derived from msg.value
+	table bool // copied from code at an offset derived from the selector
+	eq    *eqTest
+}
+
+// CODECOPY from an offset derived from the selector
+type tableRead struct {
+	src, size uint64
+}
+
+type memTaint struct {
+	start, end uint64
+	taint
+}
+
+// Taint tracer used by `FindFunctions`
+type dispatchTracer struct {
+	EmptyHook
+
+	selector  uint64
+	withValue bool
+
+	stack []taint
+	mem   []memTaint
+
+	// inputs of current op
+	preLen int
+	in     []taint
+	inVals []uint256.Int
+
+	entry        *uint64
+	stepsLeft    int // after entry found
+	valueChecked bool
+
+	tableReads   []tableRead
+	tableMatched bool // the selector matches the one in jump table, the entry is the next JUMP
+}
+
+func (t *dispatchTracer) PreRun(call *Call, line *Line) error {
+	switch line.Op.OpCode {
+	case vm.REVERT, vm.OpCode(0xfe):
+		return errReverted
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL,
+		vm.CREATE, vm.CREATE2, vm.SELFDESTRUCT:
+		return errDispatcherStop
+	}
+	if t.entry != nil {
+		if t.stepsLeft <= 0 {
+			return errDispatcherStop
+		}
+		t.stepsLeft--
+	}
+
+	// stack may be out of sync, eg: wrong NStackIn, resync with clean taints
+	for len(t.stack) < call.Stack.Len() {
+		t.stack = append([]taint{{}}, t.stack...)
+	}
+	t.stack = t.stack[len(t.stack)-call.Stack.Len():]
+
+	t.preLen = call.Stack.Len()
+	n := int(line.Op.NStackIn)
+	if n > t.preLen {
+		n = t.preLen
+	}
+	t.in = t.in[:0]
+	t.inVals = t.inVals[:0]
+	for i := 0; i < n; i++ { // in[0] is the stack top
+		t.in = append(t.in, t.stack[len(t.stack)-1-i])
+		t.inVals = append(t.inVals, *call.Stack.PeekI(i))
+	}
+	return nil
+}
+
+func (t *dispatchTracer) mem_taint(offset uint64) taint {
+	result := taint{}
+	for _, m := range t.mem {
+		if m.start < offset+32 && offset < m.end { // overlap
+			if m.start == offset && m.end == offset+32 {
+				result = m.taint // exact match keeps eq
+			} else {
+				result.sel = result.sel || m.sel
+				result.value = result.value || m.value
+				result.table = result.table || m.table
+			}
+		}
+	}
+	return result
+}
+
+func (t *dispatchTracer) found(entry uint64) error {
+	t.entry = &entry
+	if !t.withValue {
+		return errDispatcherStop
+	}
+	t.stepsLeft = stepsAfterEntry
+	return nil
+}
+
+func (t *dispatchTracer) PostRun(call *Call, line *Line) error {
+	op := line.Op.OpCode
+
+	switch {
+	case op >= vm.DUP1 && op <= vm.DUP16:
+		n := int(op-vm.DUP1) + 1
+		if n <= len(t.stack) {
+			t.stack = append(t.stack, t.stack[len(t.stack)-n])
+		}
+		return nil
+	case op >= vm.SWAP1 && op <= vm.SWAP16:
+		n := int(op-vm.SWAP1) + 1
+		if n < len(t.stack) {
+			top := len(t.stack) - 1
+			t.stack[top], t.stack[top-n] = t.stack[top-n], t.stack[top]
+		}
+		return nil
+	}
+
+	// output
+	out := taint{}
+	for _, x := range t.in {
+		out.sel = out.sel || x.sel
+		out.value = out.value || x.value
+		out.table = out.table || x.table
+	}
+
+	switch op {
+	case vm.CALLDATALOAD:
+		out = taint{sel: t.inVals[0].IsZero()}
+	case vm.CALLVALUE:
+		out.value = true
+	case vm.EQ, vm.XOR, vm.SUB:
+		if t.in[0].sel != t.in[1].sel {
+			constant := t.inVals[0]
+			if t.in[0].sel {
+				constant = t.inVals[1]
+			}
+			if constant.IsUint64() && constant.Uint64() <= 0xffffffff {
+				out.eq = &eqTest{
+					selector:       constant.Uint64(),
+					matchIfNonZero: op == vm.EQ,
+					table:          t.in[0].table || t.in[1].table,
+				}
+			}
+		}
+	case vm.ISZERO:
+		if eq := t.in[0].eq; eq != nil {
+			out.eq = &eqTest{eq.selector, !eq.matchIfNonZero, eq.table}
+		}
+	case vm.AND: // eg: vyper, and(calldatasize > 3, selector == 0x12345600)
+		for i, eq := range []*eqTest{t.in[0].eq, t.in[1].eq} {
+			if eq != nil && eq.matchIfNonZero && !t.in[1-i].sel {
+				out.eq = eq
+			}
+		}
+	case vm.MLOAD:
+		if t.inVals[0].IsUint64() {
+			out = t.mem_taint(t.inVals[0].Uint64())
+		}
+	case vm.MSTORE:
+		if t.inVals[0].IsUint64() {
+			off := t.inVals[0].Uint64()
+			t.mem = append(t.mem, memTaint{off, off + 32, t.in[1]})
+		}
+	case vm.CALLDATACOPY: // eg: old vyper, calldatacopy(28, 0, 4)
+		if t.inVals[0].IsUint64() && t.inVals[1].IsZero() && t.inVals[2].IsUint64() {
+			off := t.inVals[0].Uint64()
+			t.mem = append(t.mem, memTaint{off, off + t.inVals[2].Uint64(), taint{sel: true}})
+		}
+	case vm.CODECOPY: // eg: vyper jump table, codecopy(30, table + selector % n * 2, 2)
+		if t.in[1].sel && t.inVals[0].IsUint64() && t.inVals[1].IsUint64() && t.inVals[2].IsUint64() {
+			off, size := t.inVals[0].Uint64(), t.inVals[2].Uint64()
+			t.mem = append(t.mem, memTaint{off, off + size, taint{table: true}})
+			t.tableReads = append(t.tableReads, tableRead{t.inVals[1].Uint64(), size})
+		}
+	case vm.JUMP:
+		if t.tableMatched && t.entry == nil {
+			if e := t.found(call.Pc); e != nil {
+				return e
+			}
+		}
+	case vm.JUMPI:
+		cond := t.in[1]
+		if cond.value {
+			t.valueChecked = true
+		}
+		if eq := cond.eq; eq != nil && t.entry == nil && eq.selector == t.selector {
+			matched := !t.inVals[1].IsZero() == eq.matchIfNonZero
+			if matched && eq.table {
+				t.tableMatched = true
+			} else if matched {
+				if e := t.found(call.Pc); e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	// pop inputs, push output
+	t.stack = t.stack[:len(t.stack)-len(t.in)]
+	nOut := call.Stack.Len() - (t.preLen - len(t.in))
+	for i := 0; i < nOut; i++ {
+		t.stack = append(t.stack, out)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package edb
+
+import (
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindFunctions(t *testing.T) {
+	ctx := NewSampleContext()
+
+	funcs, e := FindFunctions(ctx.Contract().Code.Binary)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, len(funcs))
+
+	assert.Equal(t, "0x3bc5de30", funcs[0].SelectorHex()) // getData()
+	assert.Equal(t, uint64(0x3b), funcs[0].Entry)
+	assert.Equal(t, NonPayable, funcs[0].Payable)
+
+	assert.Equal(t, "0x5b4b73a9", funcs[1].SelectorHex()) // setData(uint256)
+	assert.Equal(t, uint64(0x59), funcs[1].Entry)
+}
+
+func TestFindFunctionsVyper(t *testing.T) {
+	// selector = calldataload(0) >> 224
+	// PUSH4 0x11223344, DUP2, XOR, PUSH1 next, JUMPI, STOP (payable, no value check)
+	// next: JUMPDEST, PUSH4 0x55667788, DUP2, XOR, PUSH1 fail, JUMPI,
+	//       CALLVALUE, PUSH1 fail, JUMPI, STOP
+	// fail: JUMPDEST, PUSH1 0, DUP1, REVERT
+	code := "60003560e01c" +
+		"631122334481186011" + "57" + "00" +
+		"5b" + "635566778881186021" + "57" + "34602157" + "00" +
+		"5b600080fd"
+	funcs, e := FindFunctions(util.HexDec(code))
+	assert.Nil(t, e)
+	assert.Equal(t, 2, len(funcs))
+
+	assert.Equal(t, "0x11223344", funcs[0].SelectorHex())
+	assert.Equal(t, uint64(0x10), funcs[0].Entry)
+	assert.Equal(t, Payable, funcs[0].Payable)
+
+	assert.Equal(t, "0x55667788", funcs[1].SelectorHex())
+	assert.Equal(t, NonPayable, funcs[1].Payable)
+}
+
+// Vyper 0.3.10 jump table dispatchers, laid out as `-O gas` and `-O codesize` generate them
+// for evm-version paris(no PUSH0):
+//
+//	@external
+//	def totalSupply() -> uint256      # 0x18160ddd
+//	@external
+//	def balanceOf(a: address) -> uint256  # 0x70a08231
+//	@external
+//	@payable
+//	def deposit()                     # 0xd0e30db0
+func TestFindFunctionsVyperSparse(t *testing.T) {
+	// calldatasize > 3, selector_buckets[method_id % 2] -> JUMP
+	// bucket_0: PUSH4 0xd0e30db0 DUP2 XOR PUSH2 next JUMPI ...
+	//           PUSH4 0x12345600 DUP2 EQ PUSH1 3 CALLDATASIZE GT AND ISZERO PUSH2 next JUMPI ...
+	// bucket_1: PUSH4 0x18160ddd DUP2 XOR PUSH2 next JUMPI ...
+	//           PUSH4 0x70a08231 DUP2 XOR PUSH2 next JUMPI ...
+	// selector_buckets: 0027 0063
+	//
+	// + a function with selector 0x12345600
+	code := "6003361161000c576100af565b60003560e01c60026001821660011b6100b901601e39600051565b" +
+		"63d0e30db0811861003c573460025401600255005b" +
+		"6312345600811460033611161561005e57346100b45760035460405260206040f35b6100af565b" +
+		"6318160ddd811861007f57346100b45760005460405260206040f35b" +
+		"6370a0823181186100aa576024361034176100b45760043560a01c6100b45760015460405260206040f35b6100af565b" +
+		"600080fd5b600080fd" + "0027" + "0063"
+	funcs, e := FindFunctions(util.HexDec(code))
+	assert.Nil(t, e)
+	assert.Equal(t, 4, len(funcs))
+
+	assert.Equal(t, "0x12345600", funcs[0].SelectorHex()) // trailing zero, checked with calldatasize
+	assert.Equal(t, uint64(0x4e), funcs[0].Entry)
+	assert.Equal(t, NonPayable, funcs[0].Payable)
+
+	assert.Equal(t, "0x18160ddd", funcs[1].SelectorHex())
+	assert.Equal(t, uint64(0x6f), funcs[1].Entry)
+	assert.Equal(t, NonPayable, funcs[1].Payable)
+
+	assert.Equal(t, "0x70a08231", funcs[2].SelectorHex())
+	assert.Equal(t, uint64(0x8b), funcs[2].Entry)
+	assert.Equal(t, NonPayable, funcs[2].Payable)
+
+	assert.Equal(t, "0xd0e30db0", funcs[3].SelectorHex())
+	assert.Equal(t, uint64(0x33), funcs[3].Entry)
+	assert.Equal(t, Payable, funcs[3].Payable)
+}
+
+func TestFindFunctionsVyperDense(t *testing.T) {
+	// calldatasize > 3
+	// codecopy(27, BUCKET_HEADERS + method_id % 3 * 5, 5)
+	// codecopy(25, location + ((magic * method_id) >> 24) % size * 7, 7)
+	// if method_id != func_info >> 24: goto fallback
+	// assert calldatasize >= expected and not (nonpayable and callvalue)
+	// jump(func_info >> 8 & 0xffff)
+	//
+	// + approve(address, uint256) # 0x095ea7b3
+	code := "6003361161000c576100b9565b60003560e01c6005600560038306026100c301601b39600051" +
+		"600760078260ff16848460181c0260181c06028260081c61ffff160160193950600051" +
+		"818160181c146003361116156100b957" +
+		"8060fe163610348260011602176100be57" +
+		"60081c61ffff1656" +
+		"5b60005460405260206040f3" + // totalSupply
+		"5b60043560a01c6100be5760015460405260206040f3" + // balanceOf
+		"5b3460025401600255005b" + // deposit
+		"60043560a01c6100be57602435600155600160405260206040f3" + // approve
+		"5b600080fd5b600080fd" +
+		"0001" + "00d2" + "01" + "0002" + "00d9" + "02" + "0001" + "00e7" + "01" + // BUCKET_HEADERS
+		"d0e30db0" + "0094" + "04" + // bucket_0
+		"18160ddd" + "0072" + "05" + "70a08231" + "007e" + "25" + // bucket_1
+		"095ea7b3" + "009e" + "45" // bucket_2
+	funcs, e := FindFunctions(util.HexDec(code))
+	assert.Nil(t, e)
+	assert.Equal(t, 4, len(funcs))
+
+	assert.Equal(t, "0x095ea7b3", funcs[0].SelectorHex())
+	assert.Equal(t, uint64(0x9e), funcs[0].Entry)
+	assert.Equal(t, NonPayable, funcs[0].Payable)
+
+	assert.Equal(t, "0x18160ddd", funcs[1].SelectorHex())
+	assert.Equal(t, uint64(0x72), funcs[1].Entry)
+	assert.Equal(t, NonPayable, funcs[1].Payable)
+
+	assert.Equal(t, "0x70a08231", funcs[2].SelectorHex())
+	assert.Equal(t, uint64(0x7e), funcs[2].Entry)
+
+	assert.Equal(t, "0xd0e30db0", funcs[3].SelectorHex())
+	assert.Equal(t, uint64(0x94), funcs[3].Entry)
+	assert.Equal(t, Payable, funcs[3].Payable)
+}
+
+func TestFindFunctionsBinarySearch(t *testing.T) {
+	// DUP1 PUSH4 0x50000000 GT PUSH1 lower JUMPI
+	//   DUP1 PUSH4 0x99999999 EQ PUSH1 B JUMPI STOP
+	// lower: DUP1 PUSH4 0x11111111 EQ PUSH1 A JUMPI STOP
+	// A: JUMPDEST STOP
+	// B: JUMPDEST STOP
+	code := "60003560e01c" +
+		"80635000000011601b57" +
+		"8063999999991460295700" +
+		"5b80631111111114602757005b005b00"
+	funcs, e := FindFunctions(util.HexDec(code))
+	assert.Nil(t, e)
+	assert.Equal(t, 2, len(funcs))
+	assert.Equal(t, "0x11111111", funcs[0].SelectorHex())
+	assert.Equal(t, uint64(39), funcs[0].Entry)
+	assert.Equal(t, "0x99999999", funcs[1].SelectorHex())
+	assert.Equal(t, uint64(41), funcs[1].Entry)
+}
+
+func TestFindFunctionsCache(t *testing.T) {
+	code := NewSampleContext().Contract().Code.Binary
+	funcs, e := FindFunctions(code)
+	assert.Nil(t, e)
+	funcs[0].Entry = 0 // not shared
+
+	_, cached := functionsCache.m[common.BytesToHash(util.Sha3(code))]
+	assert.True(t, cached)
+	again, e := FindFunctions(code)
+	assert.Nil(t, e)
+	assert.Equal(t, uint64(0x3b), again[0].Entry)
+}
@@ -4,9 +4,10 @@ go 1.18
 
 require (
 	github.com/c-bata/go-prompt v0.2.6
-	github.com/ethereum/go-ethereum v1.10.12
+	github.com/ethereum/go-ethereum v1.10.12 // predates Cancun, so blob/set code txs, post-London header fields and BLOBHASH/BLOBBASEFEE are handled in edb
 	github.com/fatih/color v1.13.0
 	github.com/holiman/uint256 v1.2.0
+	github.com/klauspost/compress v1.15.15
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.7.2
 	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
@@ -1,11 +1,12 @@
 package edb
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"reflect"
 
-	"github.com/aj3423/edb/util"
+	"github.com/fatih/color"
 )
 
 type hook interface {
@@ -20,16 +21,58 @@ type EmptyHook struct{}
 func (h *EmptyHook) PreRun(call *Call, line *Line) error  { return nil }
 func (h *EmptyHook) PostRun(call *Call, line *Line) error { return nil }
 
-var map_hook_types = make(map[string]reflect.Type) // eg: map["BpPc"]*main.BpPc
+/*
+Explicit schema of a saved hook:
+
+	{ "Type": Name, "Version": Version, "Value": {exported fields of the hook} }
+
+"Type" is the Name given here instead of the Go type name,
+so renaming the type doesn't break saved files.
+"Version" changes when the fields change their format,
+values of older versions are converted by Migrations[old_version], each to version+1.
+*/
+type HookSchema struct {
+	Name       string
+	Version    int
+	Migrations map[int]func(value map[string]any) error
+}
 
-// To support save/load of Hooks, they should be Registered first
+type registeredHook struct {
+	t      reflect.Type
+	schema HookSchema
+}
+
+var map_hook_schemas = make(map[string]*registeredHook) // eg: map["BpPc"]{*main.BpPc, schema}
+var map_hook_names = make(map[reflect.Type]string)      // eg: map[*main.BpPc]"BpPc"
+
+// To support save/load of Hooks, they should be Registered first,
+// saved as version 1 with the Go type name, see `RegisterSchema`
 func Register(c hook) {
-	name := reflect.TypeOf(c).Elem().Name()
+	RegisterSchema(c, HookSchema{
+		Name:    reflect.TypeOf(c).Elem().Name(),
+		Version: 1,
+	})
+}
+
+// Register with an explicit name and version of the saved form
+func RegisterSchema(c hook, s HookSchema) {
 	t := reflect.TypeOf(c).Elem()
-	map_hook_types[name] = t
+	map_hook_schemas[s.Name] = &registeredHook{t: t, schema: s}
+	map_hook_names[t] = s.Name
 }
-func makeInstance(name string) interface{} {
-	return reflect.New(map_hook_types[name]).Interface()
+func schemaOf(h hook) (HookSchema, bool) {
+	name, ok := map_hook_names[reflect.TypeOf(h).Elem()]
+	if !ok {
+		return HookSchema{}, false
+	}
+	return map_hook_schemas[name].schema, true
+}
+
+// the saved form of a hook, see `HookSchema`
+type savedHook struct {
+	Type    string
+	Version int
+	Value   json.RawMessage
 }
 
 type Hooks struct {
@@ -38,47 +81,96 @@ type Hooks struct {
 
 /*
 result:
-[
-	{ "Type": "BpPc", "Value": {Pc:3} },
-	{ "Type": "BpOp", "Value": {Op:4} },
-	...
-]
+
+	[
+		{ "Type": "BpPc", "Version": 1, "Value": {"Pc":3} },
+		{ "Type": "BpOpCode", "Version": 1, "Value": {"OpCode":85} },
+		...
+	]
+
+Unregistered hooks are runtime only(eg: tracers), they are not saved, with a warning.
 */
 func (hks *Hooks) MarshalJSON() ([]byte, error) {
-	arr := []any{}
+	arr := []savedHook{}
 	for _, hk := range hks.arr {
-		arr = append(arr, map[string]any{
-			"Type":  reflect.TypeOf(hk).Elem().Name(),
-			"Value": hk,
+		schema, ok := schemaOf(hk)
+		if !ok {
+			color.Yellow("hook '%s' is runtime only, not saved", reflect.TypeOf(hk).Elem().Name())
+			continue
+		}
+		value, e := json.Marshal(hk)
+		if e != nil {
+			return nil, e
+		}
+		arr = append(arr, savedHook{
+			Type:    schema.Name,
+			Version: schema.Version,
+			Value:   value,
 		})
 	}
 	return json.MarshalIndent(arr, "", "  ")
 }
 func (hks *Hooks) UnmarshalJSON(bs []byte) error {
-	arr := []any{}
-	e := json.Unmarshal(bs, &arr)
-	if e != nil {
+	arr := []savedHook{}
+	if e := json.Unmarshal(bs, &arr); e != nil {
 		return e
 	}
-	for i := 0; i < len(arr); i++ {
-		x, ok := arr[i].(map[string]any)
-		if !ok {
-			return fmt.Errorf("invalid hook format: %v", arr[i])
-		}
-		type_, ok := x["Type"].(string)
-		if !ok {
-			return fmt.Errorf("invalid 'Name' field: %v", x)
+	for i, saved := range arr {
+		hk, e := restoreHook(saved)
+		if e != nil {
+			return fmt.Errorf("Hooks[%d].%s", i, e.Error())
 		}
+		hks.Attach(hk)
+	}
+	return nil
+}
+
+// the error starts with the invalid field, eg: "Type: ..."
+func restoreHook(saved savedHook) (hook, error) {
+	reg, ok := map_hook_schemas[saved.Type]
+	if !ok {
+		return nil, fmt.Errorf("Type: unknown hook type: '%s', only registered hooks can be restored", saved.Type)
+	}
 
-		hk := makeInstance(type_)
+	version := saved.Version
+	if version == 0 {
+		version = 1 // saved before hooks have a version
+	}
+	if version > reg.schema.Version {
+		return nil, fmt.Errorf("Version: unsupported version %d of '%s', current version: %d",
+			version, saved.Type, reg.schema.Version)
+	}
 
-		if e := util.MapToStruct(x["Value"], hk); e != nil {
-			return e
+	value := saved.Value
+	if version < reg.schema.Version {
+		m := map[string]any{}
+		dec := json.NewDecoder(bytes.NewReader(value))
+		dec.UseNumber()
+		if e := dec.Decode(&m); e != nil {
+			return nil, fmt.Errorf("Value: %s", e.Error())
+		}
+		for ; version < reg.schema.Version; version++ {
+			migrate, ok := reg.schema.Migrations[version]
+			if !ok {
+				return nil, fmt.Errorf("Version: no migration of '%s' from version %d", saved.Type, version)
+			}
+			if e := migrate(m); e != nil {
+				return nil, fmt.Errorf("Value: migrate from version %d: %s", version, e.Error())
+			}
+		}
+		var e error
+		if value, e = json.Marshal(m); e != nil {
+			return nil, e
 		}
-		hks.Attach(hk.(hook))
 	}
 
-	return nil
+	hk := reflect.New(reg.t).Interface().(hook)
+	if len(value) > 0 {
+		if e := json.Unmarshal(value, hk); e != nil {
+			return nil, fmt.Errorf("Value: %s", e.Error())
+		}
+	}
+	return hk, nil
 }
 
 func (hks *Hooks) PreRunAll(
@@ -112,6 +204,14 @@ func (hks *Hooks) Detach(i int) {
 		hks.arr = append((hks.arr)[0:i], (hks.arr)[i+1:]...)
 	}
 }
+func (hks *Hooks) detach(h hook) {
+	for i, x := range hks.arr {
+		if x == h {
+			hks.Detach(i)
+			return
+		}
+	}
+}
 func (hks *Hooks) List() []hook {
 	return hks.arr
 }
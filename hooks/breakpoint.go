@@ -12,8 +12,9 @@ import (
 var ErrBreakpoint = errors.New("breakpoint")
 
 func init() {
-	edb.Register((*BpPc)(nil))
-	edb.Register((*BpOpCode)(nil))
+	edb.RegisterSchema((*BpPc)(nil), edb.HookSchema{Name: "BpPc", Version: 1})
+	edb.RegisterSchema((*BpOpCode)(nil), edb.HookSchema{Name: "BpOpCode", Version: 1})
+	edb.RegisterSchema((*BpSource)(nil), edb.HookSchema{Name: "BpSource", Version: 1})
 }
 
 // break at Pc of target cotract
@@ -70,3 +71,69 @@ func (bp *BpOpCode) PreRun(call *edb.Call, line *edb.Line) error {
 	}
 	return errors.Wrap(ErrBreakpoint, bp.String())
 }
+
+// break at source line, eg: "Token.sol:42"
+// the line is resolved to pcs when created, see `NewBpSource`
+type BpSource struct {
+	edb.EmptyHook
+	Contract common.Address
+	File     string
+	Line     int
+	Pcs      []uint64
+
+	// break only when entering the line, not for every pc of it,
+	// for each frame, the outer frame stays in the line during a call
+	inLine map[*edb.Call]bool
+}
+
+func NewBpSource(ctx *edb.Context, contract common.Address, file string, line int) (*BpSource, error) {
+	pcs, e := ctx.PcsOfLine(contract, file, line)
+	if e != nil {
+		return nil, e
+	}
+	return &BpSource{
+		Contract: contract,
+		File:     file,
+		Line:     line,
+		Pcs:      pcs,
+	}, nil
+}
+
+func (bp *BpSource) String() string {
+	return fmt.Sprintf("@ %s:%d of %s", bp.File, bp.Line, bp.Contract.Hex())
+}
+
+func (bp *BpSource) PreRun(call *edb.Call, line *edb.Line) error {
+	if bp.Contract != call.CodeAddress() {
+		return nil
+	}
+	in := false
+	for _, pc := range bp.Pcs {
+		if pc == line.Pc {
+			in = true
+			break
+		}
+	}
+	if bp.inLine == nil {
+		bp.inLine = map[*edb.Call]bool{}
+	}
+	entering := in && !bp.inLine[call]
+	if in {
+		bp.inLine[call] = true
+	} else {
+		delete(bp.inLine, call)
+	}
+	if !entering {
+		return nil
+	}
+	return errors.Wrap(ErrBreakpoint, bp.String())
+}
+
+// the frame is gone after returning, forget it
+func (bp *BpSource) PostRun(call *edb.Call, line *edb.Line) error {
+	switch line.Op.OpCode {
+	case vm.STOP, vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, vm.OpCode(0xfe):
+		delete(bp.inLine, call)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package hooks
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBpSourcePerFrame(t *testing.T) {
+	addr := common.HexToAddress("0xaa")
+	bp := &BpSource{Contract: addr, File: "A.sol", Line: 1, Pcs: []uint64{2, 3}}
+	outer, inner := &edb.Call{This: addr}, &edb.Call{This: addr}
+
+	hit := func(call *edb.Call, pc uint64) bool {
+		return bp.PreRun(call, &edb.Line{Pc: pc}) != nil
+	}
+	assert.True(t, hit(outer, 2))
+	assert.False(t, hit(outer, 3)) // same line
+	// the line calls the same contract recursively
+	assert.False(t, hit(inner, 0))
+	assert.True(t, hit(inner, 2))
+	// back to the outer frame, still in the line
+	assert.False(t, hit(outer, 3))
+	assert.False(t, hit(outer, 4))
+	assert.True(t, hit(outer, 2))
+}
+
+func TestBpSourceForgetReturnedFrame(t *testing.T) {
+	addr := common.HexToAddress("0xaa")
+	bp := &BpSource{Contract: addr, File: "A.sol", Line: 1, Pcs: []uint64{2}}
+	call := &edb.Call{This: addr}
+
+	assert.NotNil(t, bp.PreRun(call, &edb.Line{Pc: 2}))
+	assert.Equal(t, 1, len(bp.inLine))
+	assert.Nil(t, bp.PostRun(call, &edb.Line{Pc: 2, Op: edb.OpTable[vm.RETURN]}))
+	assert.Equal(t, 0, len(bp.inLine))
+}
+
+func TestSaveLoadBreakpoints(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "a.json")
+	ctx := edb.NewSampleContext()
+	ctx.Hooks.Attach(&BpPc{Pc: 2})
+	ctx.Hooks.Attach(&BpOpCode{OpCode: vm.SSTORE})
+	ctx.Hooks.Attach(&BpSource{Contract: ctx.This(), File: "A.sol", Line: 1, Pcs: []uint64{2}})
+	assert.Nil(t, ctx.Save(fn))
+
+	ctx2 := &edb.Context{}
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Equal(t, ctx.Hooks.List(), ctx2.Hooks.List())
+}
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/fatih/color"
 	"github.com/holiman/uint256"
@@ -14,11 +15,20 @@ import (
 
 type LowLevelTracer struct {
 	*ParamTracer
+
+	ctx *edb.Context // for opcode names that depend on the block, eg: PREVRANDAO
 }
 
-func NewLowLevelTracer() *LowLevelTracer {
+/*
+The ctx is the one being traced, it's used for decoding calls, returns,
+logs and storage slots, and for opcode names that depend on the block.
+It's required since the tracer decodes with the ABIs and preimages of the ctx,
+the zero-arg NewLowLevelTracer() is removed, pass the ctx instead.
+*/
+func NewLowLevelTracer(ctx *edb.Context) *LowLevelTracer {
 	return &LowLevelTracer{
 		ParamTracer: &ParamTracer{},
+		ctx:         ctx,
 	}
 }
 func (t *LowLevelTracer) PreRun(call *edb.Call, line *edb.Line) error {
@@ -56,9 +66,11 @@ func (t *LowLevelTracer) PostRun(call *edb.Call, line *edb.Line) error {
 	case vm.MSTORE, vm.MSTORE8:
 		color.White("  mem[%s] = %s", t.StackPre.PeekI(0).String(), t.StackPre.PeekI(1).String())
 	case vm.SLOAD:
-		color.White("    %s = storage[%s]", t.StackPost.PeekI(0).String(), t.StackPre.PeekI(0).String())
+		color.White("    %s = storage[%s]%s", t.StackPost.PeekI(0).String(), t.StackPre.PeekI(0).String(),
+			t.slot_comment(call, t.StackPre.PeekI(0)))
 	case vm.SSTORE:
-		color.White("    storage[%s] = %s", t.StackPre.PeekI(0).String(), t.StackPre.PeekI(1).String())
+		color.White("    storage[%s] = %s%s", t.StackPre.PeekI(0).String(), t.StackPre.PeekI(1).String(),
+			t.slot_comment(call, t.StackPre.PeekI(0)))
 	case vm.CALL, vm.DELEGATECALL, vm.STATICCALL:
 		var callee, value, inOffset, inSize *uint256.Int
 		if opcode == vm.CALL {
@@ -76,32 +88,54 @@ func (t *LowLevelTracer) PostRun(call *edb.Call, line *edb.Line) error {
 		}
 		data := t.MemPre[inOffset.ToBig().Uint64() : inOffset.ToBig().Uint64()+inSize.ToBig().Uint64()]
 
-		fn := data[0:4]
-		color.HiCyan("%s -> %s, fn: %x",
-			opcode.String(), callee.String(), fn)
+		addrs := []common.Address{callee.Bytes20()}
+		if opcode == vm.DELEGATECALL { // the ABI may be attached to the proxy
+			addrs = append(addrs, call.This)
+		}
+		color.HiCyan("%s -> %s, fn: %s",
+			opcode.String(), callee.String(), t.ctx.CallString(data, addrs...))
+
+	case vm.RETURN:
+		offset, size := t.StackPre.PeekI(0).Uint64(), t.StackPre.PeekI(1).Uint64()
+		if end := offset + size; size > 0 && end <= uint64(len(t.MemPre)) {
+			d, e := t.ctx.DecodeReturn(call.Msg.Data, t.MemPre[offset:end], call.This, call.CodeAddress())
+			if e == nil {
+				color.HiCyan("RETURN %s", d.String())
+			}
+		}
 
 	case vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
-		_, _ = t.StackPre.Pop(), t.StackPre.Pop()
+		offset, size := t.StackPre.Pop(), t.StackPre.Pop()
 		n := opcode - vm.LOG0
 		topics := []string{}
+		hashes := []common.Hash{}
 		for i := 0; i < int(n); i++ {
 			topic := t.StackPre.Pop()
 			topics = append(topics, topic.String())
+			hashes = append(hashes, topic.Bytes32())
 		}
 		color.Magenta("%s (%s)",
 			opcode.String(), strings.Join(topics, ","))
 
+		// memory may be expanded by the LOG itself
+		if end := offset.Uint64() + size.Uint64(); end <= uint64(len(t.MemPre)) {
+			data := t.MemPre[offset.Uint64():end]
+			if d, e := t.ctx.DecodeLog(hashes, data, call.This, call.CodeAddress()); e == nil {
+				color.Magenta("    %s", d.String())
+			}
+		}
+
 	// 0 arg
 	case vm.TIMESTAMP, vm.NUMBER, vm.ADDRESS, vm.ORIGIN, vm.CALLER, vm.CALLVALUE,
 		vm.GASPRICE, vm.COINBASE, vm.DIFFICULTY, vm.GASLIMIT, vm.CHAINID,
-		vm.SELFBALANCE, vm.BASEFEE, vm.PC, vm.MSIZE, vm.GAS:
-		color.White("  %s = %s", t.StackPost.Peek().String(), opcode.String())
+		vm.SELFBALANCE, vm.BASEFEE, edb.BLOBBASEFEE, vm.PC, vm.MSIZE, vm.GAS:
+		color.White("  %s = %s", t.StackPost.Peek().String(), t.ctx.OpName(opcode))
 
 	// 1 arg
-	case vm.ISZERO, vm.NOT, vm.EXTCODEHASH, vm.BLOCKHASH:
+	case vm.ISZERO, vm.NOT, vm.EXTCODEHASH, vm.BLOCKHASH, edb.BLOBHASH:
 		color.White(
 			"%s (%s) -> %s\n",
-			opcode.String(), t.StackPre.PeekI(0).String(), t.StackPost.Peek().String())
+			t.ctx.OpName(opcode), t.StackPre.PeekI(0).String(), t.StackPost.Peek().String())
 	// 2 arg
 	case vm.ADD, vm.MUL, vm.SUB, vm.DIV, vm.SDIV, vm.MOD, vm.SMOD, vm.EXP,
 		vm.SHL, vm.SHR, vm.SAR, vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ,
@@ -125,10 +159,19 @@ func (t *LowLevelTracer) PostRun(call *edb.Call, line *edb.Line) error {
 //   ...
 type EvmLog struct {
 	edb.EmptyHook
-	Fd *os.File
+	Fd  *os.File
+	Ctx *edb.Context // for opcode names that depend on the block, eg: PREVRANDAO
 }
 
 func (t *EvmLog) PreRun(call *edb.Call, line *edb.Line) error {
-	fmt.Fprintf(t.Fd, "%d\t %s\n", line.Pc, line.Op.OpCode.String())
+	fmt.Fprintf(t.Fd, "%d\t %s\n", line.Pc, t.Ctx.OpName(line.Op.OpCode))
 	return nil
 }
+
+// "  // balances[0x8ba1...]", by the SHA3 preimages
+func (t *LowLevelTracer) slot_comment(call *edb.Call, slot *uint256.Int) string {
+	if name := t.ctx.SlotName(call.This, slot); name != "" {
+		return "  // " + name
+	}
+	return ""
+}
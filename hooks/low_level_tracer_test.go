@@ -0,0 +1,28 @@
+package hooks
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvmLogOpName(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "log")
+	fd, e := os.Create(fn)
+	assert.Nil(t, e)
+
+	ctx := edb.NewContext()
+	ctx.Block.Difficulty = big.NewInt(0) // after the Merge
+	log := &EvmLog{Fd: fd, Ctx: ctx}
+	assert.Nil(t, log.PreRun(ctx.Call(), &edb.Line{Pc: 7, Op: edb.OpTable[vm.DIFFICULTY]}))
+	fd.Close()
+
+	bs, _ := ioutil.ReadFile(fn)
+	assert.Equal(t, "7\t PREVRANDAO\n", string(bs))
+}
@@ -9,7 +9,7 @@ import (
 
 func init() {
 
-	edb.Register((*ParamTracer)(nil))
+	edb.RegisterSchema((*ParamTracer)(nil), edb.HookSchema{Name: "ParamTracer", Version: 1})
 }
 
 // Get stack input/outpus when executing op code
@@ -2,6 +2,7 @@ package symbolic
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/aj3423/edb"
 	"github.com/aj3423/edb/hooks"
@@ -14,10 +15,13 @@ import (
 
 var TODO = errors.New("HighLevelTracer TODO")
 
-func init() {
-	edb.Register((*HighLevelTracer)(nil))
-}
+/*
+Runtime only, not Registered for saving:
+  - the symbolic nodes in CallStack are interfaces, they can't be decoded from the saved file
+  - the trace depends on the Context it's created with, it can't continue on a reloaded one
 
+Start it again after loading.
+*/
 type HighLevelTracer struct {
 	*hooks.ParamTracer
 
@@ -31,8 +35,9 @@ func NewHighLevelTracer(ctx *edb.Context) *HighLevelTracer {
 		ParamTracer: &hooks.ParamTracer{},
 	}
 	vmCall := ctx.Call()
-	t.CallStack.Push(
-		NewCall(vm.CALL, &vmCall.This, vmCall.Msg.Data))
+	root := NewCall(vm.CALL, &vmCall.This, vmCall.Msg.Data)
+	root.Func = ctx.CallString(vmCall.Msg.Data, vmCall.This, vmCall.CodeAddress())
+	t.CallStack.Push(root)
 	return t
 }
 
@@ -57,17 +62,18 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 	switch opcode {
 
 	// Nullary operations, no stack input, 1 output
-	case vm.ADDRESS, vm.BALANCE, vm.ORIGIN, vm.CALLER, vm.CALLVALUE, vm.CALLDATASIZE, vm.CODESIZE, vm.GASPRICE, vm.COINBASE, vm.TIMESTAMP, vm.NUMBER, vm.DIFFICULTY, vm.GASLIMIT, vm.CHAINID, vm.SELFBALANCE, vm.BASEFEE, vm.GAS, vm.PC, vm.MSIZE:
+	case vm.ADDRESS, vm.BALANCE, vm.ORIGIN, vm.CALLER, vm.CALLVALUE, vm.CALLDATASIZE, vm.CODESIZE, vm.GASPRICE, vm.COINBASE, vm.TIMESTAMP, vm.NUMBER, vm.DIFFICULTY, vm.GASLIMIT, vm.CHAINID, vm.SELFBALANCE, vm.BASEFEE, edb.BLOBBASEFEE, vm.GAS, vm.PC, vm.MSIZE:
 
 		n := &NullaryOp{}
 		n.OpCode = opcode
+		n.Name = t.ctx.OpName(opcode)
 		n.Val = t.StackPost.Pop()
 
 		stack.Push(n)
 		return nil
 
 	// unary operation, 1 stack input, 1 output
-	case vm.ISZERO, vm.NOT, vm.CALLDATALOAD, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BLOCKHASH:
+	case vm.ISZERO, vm.NOT, vm.CALLDATALOAD, vm.EXTCODESIZE, vm.EXTCODEHASH, vm.BLOCKHASH, edb.BLOBHASH:
 
 		sym := stack.Pop()
 
@@ -127,6 +133,7 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 		sha3 := &Sha3{
 			Offset:    vmOffset,
 			Size:      vmSize,
+			Data:      util.CloneSlice(t.MemPost[vmOffset : vmOffset+vmSize]),
 			ValueNode: ValueNode{t.StackPost.Pop()},
 		}
 		// All MemoryWrite that inside region
@@ -226,6 +233,7 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 			sto := &Storage{
 				Slot: slot,
 				Val:  NewConst(t.StackPost.Peek()),
+				Name: t.ctx.SlotName(vmCall.This, &vmSlot),
 			}
 			call.StorageMap[vmSlot] = sto
 			stack.Push(sto)
@@ -240,7 +248,7 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 		slot, val := stack.Pop(), stack.Pop()
 		vmSlot := t.StackPre.Pop()
 
-		sto := &Storage{Slot: slot, Val: val}
+		sto := &Storage{Slot: slot, Val: val, Name: t.ctx.SlotName(vmCall.This, &vmSlot)}
 
 		call.StorageMap[vmSlot] = sto
 		call.AddTrace(&StorageWrite{
@@ -256,6 +264,7 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 		vmCond := t.StackPre.PeekI(1)
 
 		n := &If{Cond: cond, Taken: !vmCond.IsZero()}
+		n.Pc = t.PcPre // for folding loops
 		call.AddTrace(n)
 		return nil
 
@@ -284,8 +293,16 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 		topicCount := opcode - vm.LOG0
 
 		n := &Log{}
+		hashes := []common.Hash{}
 		for i := 0; i < int(topicCount); i++ {
 			n.Topics = append(n.Topics, stack.Pop())
+			hashes = append(hashes, t.StackPre.PeekI(2+i).Bytes32())
+		}
+		if vmStart+vmSize <= uint64(len(t.MemPost)) {
+			d, e := t.ctx.DecodeLog(hashes, t.MemPost[vmStart:vmStart+vmSize], vmCall.This, vmCall.CodeAddress())
+			if e == nil {
+				n.Event = d.String()
+			}
 		}
 		// all MemoryWrite inside [vmStart: vmStart+vmSize]
 		for ofst, mem := range call.MemMap {
@@ -342,8 +359,15 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 			return nil
 		}
 
+		// the target has no code, it returned immediately
+		if newVmCall == vmCall {
+			stack.Push(NewConst(uint256.NewInt(1)))
+			return nil
+		}
+
 		toAddr := newVmCall.CodeAddress()
 		newCall := NewCall(opcode, &toAddr, newVmCall.Msg.Data)
+		newCall.Func = t.ctx.CallString(newVmCall.Msg.Data, newVmCall.This, toAddr)
 		t.CallStack.Push(newCall)
 		call.AddTrace(newCall)
 		return nil
@@ -361,6 +385,27 @@ func (t *HighLevelTracer) PostRun(vmCall *edb.Call, line *edb.Line) error {
 
 	case vm.RETURN:
 		_, _ = stack.Pop(), stack.Pop()
+		retOffset, retSize := t.StackPre.PeekI(0).Uint64(), t.StackPre.PeekI(1).Uint64()
+		if retOffset+retSize <= uint64(len(t.MemPre)) {
+			d, e := t.ctx.DecodeReturn(vmCall.Msg.Data, t.MemPre[retOffset:retOffset+retSize],
+				vmCall.This, vmCall.CodeAddress())
+			if e == nil {
+				call.Returned = d.String()
+			}
+		}
+		// all MemoryWrite that overlap the returned region, ordered by offset
+		for _, mem := range call.MemMap {
+			size := uint64(len(mem.VmBytes))
+			if size == 0 {
+				size = 32
+			}
+			if mem.VmOffset < retOffset+retSize && mem.VmOffset+size > retOffset {
+				call.ReturnMem = append(call.ReturnMem, mem)
+			}
+		}
+		sort.Slice(call.ReturnMem, func(i, j int) bool {
+			return call.ReturnMem[i].VmOffset < call.ReturnMem[j].VmOffset
+		})
 		if t.CallStack.Len() == 1 { // return from main call
 			return nil
 		}
@@ -0,0 +1,47 @@
+package symbolic
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// run `code` offline with the high level tracer, returns the root call,
+// `setup` modifies the context before running, can be nil
+func trace(t *testing.T, code string, setup func(ctx *edb.Context)) *Call {
+	ctx := edb.NewSampleContext()
+	ctx.Chain.Offline = true
+	contract := edb.NewContract()
+	contract.Balance = big.NewInt(0)
+	assert.Nil(t, contract.Code.Set(util.HexDec(code)))
+	ctx.Contracts[ctx.This()] = contract
+	if setup != nil {
+		setup(ctx)
+	}
+
+	tr := NewHighLevelTracer(ctx)
+	ctx.Hooks.Attach(tr)
+	assert.Nil(t, ctx.Run(-1))
+	return tr.CallStack.Data[0]
+}
+
+func TestCallWithoutCode(t *testing.T) {
+	// call(0, 0xaa, 0, 0, 1, 0, 0), no code at 0xaa
+	root := trace(t, "6000600060016000600060aa6000f100", nil)
+	for _, n := range root.List {
+		_, ok := n.(*Call)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 1, root.Stack.Len()) // the success flag
+}
+
+func TestReturnMem(t *testing.T) {
+	// mstore(0x20, NUMBER), mstore(0, CALLER), return(0x10, 0x20)
+	root := trace(t, "436020523360005260206010f3", nil)
+	assert.Equal(t, 2, len(root.ReturnMem))
+	assert.Equal(t, "CALLER", root.ReturnMem[0].Val.String())
+	assert.Equal(t, "NUMBER", root.ReturnMem[1].Val.String())
+}
@@ -0,0 +1,223 @@
+package symbolic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// fewer repeats are not folded, they may be just the same function called twice
+const minLoopIterations = 3
+
+// Repeated iterations of a loop, each iteration starts with the `If` at `Pc`
+type Loop struct {
+	PcNode
+	Iterations []*Block
+
+	Expanded map[int]bool // iterations to print, besides the first and last
+}
+
+func (n *Loop) String() string {
+	return strings.TrimSuffix(PrintNode(n), "\n")
+}
+
+// eg: "loop at pc 123, 200 iterations, induction: 0x0 -> 0xc7 (step 0x1)"
+func (n *Loop) Header() string {
+	s := fmt.Sprintf("loop at pc %d, %d iterations", n.Pc, len(n.Iterations))
+	if ind := n.Induction(); ind != "" {
+		s += ", induction: " + ind
+	}
+	return s
+}
+
+// Print the iteration besides the first and last
+func (n *Loop) Expand(i int) error {
+	if i < 0 || i >= len(n.Iterations) {
+		return errors.Errorf("iteration %d out of range [0, %d)", i, len(n.Iterations))
+	}
+	if n.Expanded == nil {
+		n.Expanded = map[int]bool{}
+	}
+	n.Expanded[i] = true
+	return nil
+}
+
+func (n *Loop) head(i int) *If {
+	return n.Iterations[i].List[0].(*If)
+}
+
+// the first differing constants of two nodes,
+// returns the operand path to it
+func const_diff(a, b Node, path []int) ([]int, bool) {
+	ca, okA := a.(*Const)
+	cb, okB := b.(*Const)
+	if okA && okB {
+		return path, !ca.Val.Eq(&cb.Val)
+	}
+	opA, argsA, okA := operands(a)
+	opB, argsB, okB := operands(b)
+	if !okA || !okB || opA != opB || len(argsA) != len(argsB) {
+		return nil, false
+	}
+	for i := range argsA {
+		if p, ok := const_diff(argsA[i], argsB[i], append(path, i)); ok {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+func node_at(n Node, path []int) Node {
+	for _, i := range path {
+		_, args, ok := operands(n)
+		if !ok || i >= len(args) {
+			return nil
+		}
+		n = args[i]
+	}
+	return n
+}
+
+// The constant that changes in the loop condition, eg: `i` in `(i < 0xc8)`,
+// shows its first and last value, with the step if it changes evenly
+func (n *Loop) Induction() string {
+	path, ok := const_diff(n.head(0).Cond, n.head(1).Cond, nil)
+	if !ok {
+		return ""
+	}
+	values := []uint256.Int{}
+	for i := range n.Iterations {
+		c, ok := node_at(n.head(i).Cond, path).(*Const)
+		if !ok {
+			return ""
+		}
+		values = append(values, c.Val)
+	}
+	first, last := values[0], values[len(values)-1]
+	s := fmt.Sprintf("%s -> %s", first.String(), last.String())
+
+	var step uint256.Int
+	step.Sub(&values[1], &values[0])
+	for i := 2; i < len(values); i++ {
+		var d uint256.Int
+		if !d.Sub(&values[i], &values[i-1]).Eq(&step) {
+			return s
+		}
+	}
+	return s + fmt.Sprintf(" (step %s)", step.String())
+}
+
+// nodes with the same shape are the same line of different iterations
+func shape(n Node) string {
+	switch n := n.(type) {
+	case *If:
+		return fmt.Sprintf("if %d %v", n.Pc, n.Taken)
+	case *Loop:
+		return fmt.Sprintf("loop %d", n.Pc)
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+// returns the iteration length and count of the loop starting at `i`
+func find_loop(shapes []string, i int) (period, count int) {
+	if !strings.HasPrefix(shapes[i], "if ") {
+		return 0, 0
+	}
+	// the next `If` of the same pc
+	j := i + 1
+	for j < len(shapes) && shapes[j] != shapes[i] {
+		j++
+	}
+	if j == len(shapes) {
+		return 0, 0
+	}
+	period = j - i
+
+	same := func(k int) bool { // the k-th iteration is the same as the first
+		start := i + k*period
+		if start+period > len(shapes) {
+			return false
+		}
+		for x := 0; x < period; x++ {
+			if shapes[start+x] != shapes[i+x] {
+				return false
+			}
+		}
+		return true
+	}
+	count = 1
+	for same(count) {
+		count++
+	}
+	return period, count
+}
+
+func fold_list(list []Node) ([]Node, bool) {
+	shapes := []string{}
+	for _, n := range list {
+		shapes = append(shapes, shape(n))
+	}
+
+	folded := false
+	ret := []Node{}
+	for i := 0; i < len(list); {
+		period, count := find_loop(shapes, i)
+		if count < minLoopIterations {
+			ret = append(ret, list[i])
+			i++
+			continue
+		}
+		loop := &Loop{}
+		loop.Pc = list[i].(*If).Pc
+		for k := 0; k < count; k++ {
+			start := i + k*period
+			b := &Block{List: util.CloneSlice(list[start : start+period])}
+			b.List = fold_all(b.List)
+			loop.Iterations = append(loop.Iterations, b)
+		}
+		ret = append(ret, loop)
+		i += period * count
+		folded = true
+	}
+	return ret, folded
+}
+
+// fold until no more loop found, for nested loops
+func fold_all(list []Node) []Node {
+	for {
+		var folded bool
+		if list, folded = fold_list(list); !folded {
+			return list
+		}
+	}
+}
+
+/*
+Fold repeated iterations into `Loop` nodes, for all calls in the tree:
+  - iterations are detected by the `If` of the same JUMPI pc
+  - lines between them must be the same kind of nodes
+  - nested loops are folded too
+
+It should be called after `Optimize`, so the loop counters are constants.
+*/
+func FoldLoops(root Node) {
+	Walk(root, func(c *Cursor) {
+		if call, ok := c.Node.(*Call); ok {
+			call.List = fold_all(call.List)
+		}
+	})
+}
+
+// All loops at the JUMPI pc
+func FindLoops(root Node, pc uint64) []*Loop {
+	ret := []*Loop{}
+	Walk(root, func(c *Cursor) {
+		if l, ok := c.Node.(*Loop); ok && l.Pc == pc {
+			ret = append(ret, l)
+		}
+	})
+	return ret
+}
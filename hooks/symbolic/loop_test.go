@@ -0,0 +1,72 @@
+package symbolic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+// for (i = 0; i <= arg; i++) { slot0 += i }
+const loopCode = "60003560e01c80631234567814601457600080fd5b60005b806004351115602f5760005481016000556001016017565b5000"
+
+// trace the loop code, with `arg` as the loop bound
+func loop_trace(t *testing.T, arg byte) *Call {
+	return trace(t, loopCode, func(ctx *edb.Context) {
+		ctx.Msg().Data = append(util.HexDec("12345678"+strings.Repeat("0", 62)), arg)
+	})
+}
+
+func TestFoldLoops(t *testing.T) {
+	root := loop_trace(t, 9)
+	Optimize(root, DefaultOptimizers)
+	FoldLoops(root)
+
+	loops := FindLoops(root, 32)
+	assert.Equal(t, 1, len(loops))
+	l := loops[0]
+	// the first iteration fetches the storage online, it's different
+	assert.Equal(t, "loop at pc 32, 8 iterations, induction: 0x1 -> 0x8 (step 0x1)", l.Header())
+
+	out := PrintNode(root)
+	assert.Contains(t, out, "        #0:\n            if !((CALLDATALOAD(0x4) > 0x1)) <no>\n            Storage[0x0] = (0x1 + Storage[0x0])\n        ...\n        #7:")
+	assert.Contains(t, out, "    }\n    if !((CALLDATALOAD(0x4) > 0x9)) <yes>\n}")
+
+	assert.Nil(t, l.Expand(3))
+	assert.NotNil(t, l.Expand(8))
+	out = PrintNode(root)
+	assert.Contains(t, out, "        ...\n        #3:\n            if !((CALLDATALOAD(0x4) > 0x4)) <no>\n            Storage[0x0] = (0x4 + Storage[0x0])\n        ...\n        #7:")
+
+	// folded only once
+	FoldLoops(root)
+	assert.Equal(t, out, PrintNode(root))
+}
+
+func TestFoldNestedLoops(t *testing.T) {
+	iff := func(pc uint64, cond Node) *If {
+		n := &If{Cond: cond}
+		n.Pc = pc
+		return n
+	}
+	lbl := &Label{"x"}
+
+	// 4 outer iterations, each has 3 + i inner iterations
+	root := NewCall(0, nil, nil)
+	for i := uint64(0); i < 4; i++ {
+		root.AddTrace(iff(10, bin(vm.LT, c(i), c(4))))
+		for j := uint64(0); j < 3+i; j++ {
+			root.AddTrace(iff(20, bin(vm.LT, c(j), c(3+i))))
+			root.AddTrace(&MemoryWrite{Memory: &Memory{Offset: c(j), Val: lbl}})
+		}
+	}
+	root.AddTrace(iff(10, c(0)))
+
+	FoldLoops(root)
+	assert.Equal(t, 2, len(root.List))
+	outer := root.List[0].(*Loop)
+	assert.Equal(t, "loop at pc 10, 4 iterations, induction: 0x0 -> 0x3 (step 0x1)", outer.Header())
+	assert.Equal(t, "loop at pc 20, 6 iterations, induction: 0x0 -> 0x5 (step 0x1)", outer.Iterations[3].List[1].(*Loop).Header())
+}
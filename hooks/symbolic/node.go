@@ -8,6 +8,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/sigdb"
 	"github.com/aj3423/edb/util"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
@@ -29,9 +30,9 @@ type opNode interface { // for opcode with operands, eg: BinaryOp
 	Node
 	Op() vm.OpCode
 }
-type pcNode interface { // pc is used to optimize "for loop"
+type pcNode interface { // pc is used to fold loops
 	Node
-	Pc() uint64
+	PC() uint64
 }
 
 // ---- basic types ----
@@ -94,9 +95,14 @@ func (n *Const) String() string {
 type NullaryOp struct {
 	OpNode
 	ValueNode
+
+	Name string // if set, used instead of the opcode name, eg: "PREVRANDAO"
 }
 
 func (n *NullaryOp) String() string {
+	if n.Name != "" {
+		return n.Name
+	}
 	return PrettifyOp(n.OpCode)
 }
 
@@ -155,8 +161,7 @@ func (n *If) String() string {
 	}
 }
 
-// A block of lines, currently only used for `Call`,
-// will be used for "for loop"(TODO)
+// A block of lines, used for `Call` and iterations of `Loop`
 type Block struct {
 	List []Node
 }
@@ -184,6 +189,10 @@ type Call struct {
 	// for printing target/func_sig
 	Target *common.Address
 	Input  []byte
+
+	Func      string    // decoded calldata by ABI or signature database, set by tracer
+	Returned  string    // decoded return data
+	ReturnMem []*Memory // memory returned by RETURN, set by tracer
 }
 
 func NewCall(
@@ -209,11 +218,13 @@ func (c *Call) String() string {
 	return "use `printer` to print *Call"
 }
 
-// TODO decode func name using 4bytes database:
-//  https://github.com/ethereum-lists/4bytes
+// decoded by ABI or the signature database, or hex selector if unknown
 func (c *Call) FuncSig() string {
+	if c.Func != "" {
+		return c.Func
+	}
 	if len(c.Input) >= 4 {
-		return fmt.Sprintf("%x", c.Input[0:4])
+		return sigdb.Default().CallString(c.Input)
 	}
 	return ""
 }
@@ -268,9 +279,14 @@ func (n *Return) String() string {
 type Storage struct {
 	Slot Node
 	Val  Node // result of get, or value to set
+
+	Name string // variable name of the slot, eg: "balances[0x8ba1...]", set by tracer
 }
 
 func (s *Storage) String() string {
+	if s.Name != "" {
+		return fmt.Sprintf("Storage[%s]", s.Name)
+	}
 	return fmt.Sprintf("Storage[%s]", s.Slot.String())
 }
 
@@ -300,6 +316,8 @@ func (n *StorageWrite) String() string {
 type Log struct {
 	Topics []Node
 	Mem    []*Memory
+
+	Event string // decoded by ABI or signature database, set by tracer
 }
 
 func (n *Log) String() string {
@@ -310,6 +328,15 @@ func (n *Log) String() string {
 		arr = append(arr, top.String())
 	}
 	fmt.Fprintf(sb, "Log (%s)\n", strings.Join(arr, ", "))
+	if n.Event != "" {
+		sb.WriteString("Event: " + n.Event + "\n")
+	} else if len(n.Topics) > 0 { // event name
+		if c, ok := n.Topics[0].(*Const); ok {
+			if sig := sigdb.Default().Event(c.Val.Bytes32()); sig != nil {
+				sb.WriteString("Event: " + sig.Text() + "\n")
+			}
+		}
+	}
 	// Ascending sort "Input" by offset
 	// so it can be printed in order
 	sort.Slice(n.Mem, func(i, j int) bool {
@@ -369,6 +396,7 @@ type Sha3 struct {
 	Input []*Memory
 
 	Offset, Size uint64 // raw memory offset/size
+	Data         []byte // raw input, for re-calculating by solver
 
 	ValueNode
 }
@@ -28,6 +28,33 @@ func Equal(n1, n2 any) bool {
 		v1 := n1.(*BinaryOp)
 		v2 := n2.(*BinaryOp)
 		return Equal(v1.OpCode, v2.OpCode) && Equal(v1.X, v2.X) && Equal(v1.Y, v2.Y)
+	case *NullaryOp:
+		v1 := n1.(*NullaryOp)
+		v2 := n2.(*NullaryOp)
+		// every read of these may differ, even without a known value,
+		// eg: `gasleft() - gasleft()` is not 0
+		switch v1.OpCode {
+		case vm.GAS, vm.MSIZE, vm.RETURNDATASIZE:
+			return v1 == v2
+		}
+		// `PC` may differ
+		return Equal(v1.OpCode, v2.OpCode) && Equal(v1.Val, v2.Val)
+	case *TernaryOp:
+		v1 := n1.(*TernaryOp)
+		v2 := n2.(*TernaryOp)
+		return Equal(v1.OpCode, v2.OpCode) && Equal(v1.X, v2.X) && Equal(v1.Y, v2.Y) && Equal(v1.Z, v2.Z)
+	case *Func:
+		v1 := n1.(*Func)
+		v2 := n2.(*Func)
+		if v1.Name != v2.Name || len(v1.Args) != len(v2.Args) {
+			return false
+		}
+		for i := range v1.Args {
+			if !Equal(v1.Args[i], v2.Args[i]) {
+				return false
+			}
+		}
+		return true
 	case *Const:
 		v1 := n1.(*Const)
 		v2 := n2.(*Const)
@@ -83,13 +110,15 @@ func (c *Cursor) field() reflect.Value {
 }
 
 // Replace replaces the current Node with n.
-// The replacement node is not walked by Walk().
+// The replacement node is not walked by Walk(),
+// but it's the current Node for the following optimizers.
 func (c *Cursor) Replace(n Node) {
 	v := c.field()
 	if i := c.Index(); i >= 0 {
 		v = v.Index(i)
 	}
 	v.Set(reflect.ValueOf(n))
+	c.Node = n
 }
 
 // Delete deletes the current Node from its containing slice.
@@ -207,6 +236,8 @@ func (a *walker) walk(parent Node, name string, iter *iterator, n Node) {
 		a.walk(n, "X", nil, n.X)
 		a.walk(n, "Y", nil, n.Y)
 		a.walk(n, "Z", nil, n.Z)
+	case *Func:
+		a.walkList(n, "Args")
 	case *If:
 		a.walk(n, "Cond", nil, n.Cond)
 	case *Sha3:
@@ -223,6 +254,8 @@ func (a *walker) walk(parent Node, name string, iter *iterator, n Node) {
 		a.walk(n, "Input", nil, n.Input)
 	case *Block, *Call:
 		a.walkList(n, "List")
+	case *Loop:
+		a.walkList(n, "Iterations")
 
 	default:
 		panic(fmt.Sprintf("Walk: unexpected node type %T", n))
@@ -242,3 +275,192 @@ func Walk(
 	a.walk(parent, "Node", nil, root)
 	return parent.Node
 }
+
+// ---- Clone ----
+type cloner struct {
+	done map[Node]Node // for nodes shared in the tree, eg: *Storage, *Memory
+}
+
+func (c *cloner) list(l []Node) []Node {
+	if l == nil {
+		return nil
+	}
+	ret := make([]Node, len(l))
+	for i, n := range l {
+		ret[i] = c.clone(n)
+	}
+	return ret
+}
+func (c *cloner) block(b *Block) *Block {
+	if b == nil {
+		return nil
+	}
+	return c.clone(b).(*Block)
+}
+func (c *cloner) mem(m *Memory) *Memory {
+	if m == nil {
+		return nil
+	}
+	return c.clone(m).(*Memory)
+}
+func (c *cloner) mems(l []*Memory) []*Memory {
+	if l == nil {
+		return nil
+	}
+	ret := make([]*Memory, len(l))
+	for i, m := range l {
+		ret[i] = c.mem(m)
+	}
+	return ret
+}
+func (c *cloner) storage(s *Storage) *Storage {
+	if s == nil {
+		return nil
+	}
+	return c.clone(s).(*Storage)
+}
+func (c *cloner) sha3(s *Sha3) *Sha3 {
+	if s == nil {
+		return nil
+	}
+	return c.clone(s).(*Sha3)
+}
+
+func (c *cloner) clone(n Node) Node {
+	if n == nil {
+		return nil
+	}
+	if x, ok := c.done[n]; ok {
+		return x
+	}
+
+	var ret Node
+	switch n := n.(type) {
+	case *Label:
+		x := *n
+		ret = &x
+	case *Const:
+		x := *n
+		ret = &x
+	case *NullaryOp:
+		x := *n
+		ret = &x
+	case *ReturnValue:
+		ret = &ReturnValue{}
+	case *MoneyTransfer:
+		x := *n
+		ret = &x
+	case *AnyNode:
+		x := *n
+		ret = &x
+	case *AnyConst:
+		x := *n
+		ret = &x
+
+	// the copy is registered before cloning children, for cycles
+	case *UnaryOp:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.X = c.clone(n.X)
+	case *BinaryOp:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.X, x.Y = c.clone(n.X), c.clone(n.Y)
+	case *TernaryOp:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.X, x.Y, x.Z = c.clone(n.X), c.clone(n.Y), c.clone(n.Z)
+	case *OpSet:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Args = c.list(n.Args)
+	case *Func:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Args = c.list(n.Args)
+	case *If:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Cond = c.clone(n.Cond)
+	case *Block:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.List = c.list(n.List)
+	case *Loop:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Iterations = make([]*Block, len(n.Iterations))
+		for i, b := range n.Iterations {
+			x.Iterations[i] = c.block(b)
+		}
+		x.Expanded = map[int]bool{}
+		for k, v := range n.Expanded {
+			x.Expanded[k] = v
+		}
+	case *Call:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Block = c.block(n.Block)
+		x.Stack.Data = c.list(n.Stack.Data)
+		x.MemMap = map[uint64]*Memory{}
+		for k, m := range n.MemMap {
+			x.MemMap[k] = c.mem(m)
+		}
+		x.StorageMap = map[uint256.Int]*Storage{}
+		for k, s := range n.StorageMap {
+			x.StorageMap[k] = c.storage(s)
+		}
+		x.ReturnMem = c.mems(n.ReturnMem)
+	case *Precompiled:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Input = c.mem(n.Input)
+	case *Return:
+		x := *n
+		c.done[n], ret = &x, &x
+		if n.ReturnValue != nil {
+			x.ReturnValue = c.clone(n.ReturnValue).(*ReturnValue)
+		}
+		x.Memory = c.mem(n.Memory)
+	case *Storage:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Slot, x.Val = c.clone(n.Slot), c.clone(n.Val)
+	case *StorageWrite:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Storage = c.storage(n.Storage)
+	case *Log:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Topics = c.list(n.Topics)
+		x.Mem = c.mems(n.Mem)
+	case *Memory:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Offset, x.Val = c.clone(n.Offset), c.clone(n.Val)
+	case *MemoryWrite:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Memory = c.mem(n.Memory)
+	case *Sha3:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Input = c.mems(n.Input)
+	case *Sha3Calc:
+		x := *n
+		c.done[n], ret = &x, &x
+		x.Sha3 = c.sha3(n.Sha3)
+	default:
+		panic(fmt.Sprintf("Clone: unexpected node type %T", n))
+	}
+	c.done[n] = ret
+	return ret
+}
+
+// Deep copy of the tree, nodes shared in the tree are still shared in the copy,
+// eg: optimize a copy and keep the traced tree unchanged
+func Clone(root Node) Node {
+	c := &cloner{done: map[Node]Node{}}
+	return c.clone(root)
+}
@@ -1,6 +1,7 @@
 package symbolic
 
 import (
+	"github.com/aj3423/edb"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/holiman/uint256"
 )
@@ -13,6 +14,8 @@ type optimizer interface {
 type Optimizers []optimizer
 
 var DefaultOptimizers = []optimizer{
+	&FoldConst{},
+	&Simplify{},
 	&FunSig{},
 	&CounterUnaryOp{},
 	&CounterBinaryOp{},
@@ -24,8 +27,9 @@ var DefaultOptimizers = []optimizer{
 // 	 `Const(0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc)`
 // ->
 //   `PROXY_EIP1967_SLOT`
-var PROXY_EIP1967_SLOT, _ = uint256.FromHex("0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc")
-var PROXY_EIP1967_ADMIN, _ = uint256.FromHex("0xb53127684a568b3173ae13b9f8a6016e243e63b6e8ee1178d6a717850b5d6103")
+var PROXY_EIP1967_SLOT = edb.Eip1967ImplementationSlot
+var PROXY_EIP1967_ADMIN = edb.Eip1967AdminSlot
+var PROXY_EIP1967_BEACON = edb.Eip1967BeaconSlot
 
 type ProxyEIP1967 struct {
 	sample Node
@@ -39,51 +43,128 @@ func (op *ProxyEIP1967) Do(c *Cursor) (modified bool) {
 		} else if n.Value().Eq(PROXY_EIP1967_ADMIN) {
 			c.Replace(&Label{"PROXY_EIP1967_ADMIN"})
 			modified = true
+		} else if n.Value().Eq(PROXY_EIP1967_BEACON) {
+			c.Replace(&Label{"PROXY_EIP1967_BEACON"})
+			modified = true
 		}
 	}
 	return
 }
 
-// Evaluate the Node value
+// Evaluate the Node value,
+// operands are in printed order, eg: `(x << shift)`, `(x BYTE index)`
 func EvaluateConst(n Node) (uint256.Int, bool) {
 	switch n := n.(type) {
 	case *Const:
 		return *n.Value(), true
 	case *UnaryOp:
+		v, ok := EvaluateConst(n.X)
+		if !ok {
+			return uint256.Int{}, false
+		}
 		switch n.OpCode {
 		case vm.NOT:
-			v, ok := EvaluateConst(n.X)
-			if !ok {
-				return uint256.Int{}, false
-			}
 			v.Not(&v)
 			return v, true
+		case vm.ISZERO:
+			return bool_const(v.IsZero()), true
 		}
-	case *BinaryOp: // y-x, y<<x
+	case *BinaryOp:
 		x, okx := EvaluateConst(n.X)
 		y, oky := EvaluateConst(n.Y)
 		if !okx || !oky {
 			return uint256.Int{}, false
 		}
+		return evaluate_binary(n.OpCode, x, y)
+	case *TernaryOp:
+		x, okx := EvaluateConst(n.X)
+		y, oky := EvaluateConst(n.Y)
+		z, okz := EvaluateConst(n.Z)
+		if !okx || !oky || !okz {
+			return uint256.Int{}, false
+		}
 		switch n.OpCode {
-		case vm.ADD:
-			x.Add(&x, &y)
+		case vm.ADDMOD:
+			x.AddMod(&x, &y, &z)
 			return x, true
-		case vm.SUB:
-			x.Sub(&x, &y)
+		case vm.MULMOD:
+			x.MulMod(&x, &y, &z)
 			return x, true
-		case vm.SHL:
+		}
+	}
+	return uint256.Int{}, false
+}
+
+func bool_const(b bool) uint256.Int {
+	if b {
+		return *uint256.NewInt(1)
+	}
+	return uint256.Int{}
+}
+
+// same as the EVM
+func evaluate_binary(op vm.OpCode, x, y uint256.Int) (uint256.Int, bool) {
+	switch op {
+	case vm.ADD:
+		x.Add(&x, &y)
+	case vm.SUB:
+		x.Sub(&x, &y)
+	case vm.MUL:
+		x.Mul(&x, &y)
+	case vm.DIV:
+		x.Div(&x, &y)
+	case vm.SDIV:
+		x.SDiv(&x, &y)
+	case vm.MOD:
+		x.Mod(&x, &y)
+	case vm.SMOD:
+		x.SMod(&x, &y)
+	case vm.EXP:
+		x.Exp(&x, &y)
+	case vm.SIGNEXTEND: // (x SIGNEXTEND byteNum)
+		x.ExtendSign(&x, &y)
+	case vm.LT:
+		x = bool_const(x.Lt(&y))
+	case vm.GT:
+		x = bool_const(x.Gt(&y))
+	case vm.SLT:
+		x = bool_const(x.Slt(&y))
+	case vm.SGT:
+		x = bool_const(x.Sgt(&y))
+	case vm.EQ:
+		x = bool_const(x.Eq(&y))
+	case vm.AND:
+		x.And(&x, &y)
+	case vm.OR:
+		x.Or(&x, &y)
+	case vm.XOR:
+		x.Xor(&x, &y)
+	case vm.BYTE: // (x BYTE index)
+		x.Byte(&y)
+	case vm.SHL:
+		if y.LtUint64(256) {
 			x.Lsh(&x, uint(y.Uint64()))
-			return x, true
-		case vm.SHR:
+		} else {
+			x.Clear()
+		}
+	case vm.SHR:
+		if y.LtUint64(256) {
 			x.Rsh(&x, uint(y.Uint64()))
-			return x, true
-		case vm.SAR:
+		} else {
+			x.Clear()
+		}
+	case vm.SAR:
+		if y.LtUint64(256) {
 			x.SRsh(&x, uint(y.Uint64()))
-			return x, true
+		} else if x.Sign() >= 0 {
+			x.Clear()
+		} else {
+			x.SetAllOne()
 		}
+	default:
+		return uint256.Int{}, false
 	}
-	return uint256.Int{}, false
+	return x, true
 }
 
 // replace
@@ -100,36 +181,20 @@ var (
 	U32, _     = uint256.FromHex("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff")
 )
 
-type SignCast struct{}
+// Sometimes it's not simply 0xffffffffffffffffffffffffffffffffffffffff,
+// it may also be `((0x1 << 0xa0) - 0x1)`, constants in rules match evaluated values
+var signCastRules = must_parse_rules(`
+	AND(0xff{20}, $x) -> $x
+	AND(0xff{20}00{12}, $x) -> $x
+	AND(0xff{32}, $x) -> $x
+	AND(0xff{16}, $x) -> $x
+	AND(0xff{16}00{16}, $x) -> $x
+`)
 
-func (op *SignCast) match(x_or_y Node) bool {
-	// Sometimes it's not simply 0xffffffffffffffffffffffffffffffffffffffff,
-	// it may also be `((0x1 << 0xa0) - 0x1)`
-	// So evaluate it
-	if val, ok := EvaluateConst(x_or_y); ok {
-		if val.Eq(U20) || val.Eq(U20High) ||
-			val.Eq(U32) ||
-			val.Eq(U16) || val.Eq(U16High) {
-			return true
-		}
-	}
-	return false
-}
+type SignCast struct{}
 
 func (op *SignCast) Do(c *Cursor) (modified bool) {
-	if bn, ok := c.Node.(*BinaryOp); ok {
-		if bn.OpCode == vm.AND {
-			if op.match(bn.X) {
-				c.Replace(bn.Y)
-				modified = true
-			}
-			if op.match(bn.Y) {
-				c.Replace(bn.X)
-				modified = true
-			}
-		}
-	}
-	return
+	return signCastRules.Do(c)
 }
 
 // replace
@@ -139,33 +204,10 @@ func (op *SignCast) Do(c *Cursor) (modified bool) {
 type FunSig struct {
 }
 
-var funSigSample = &BinaryOp{
-	OpNode: OpNode{OpCode: vm.SHR},
-	X: &UnaryOp{
-		OpNode: OpNode{OpCode: vm.CALLDATALOAD},
-		X:      NewConst(uint256.NewInt(0)),
-	},
-	Y: NewConst(uint256.NewInt(0xe0)),
-}
+var funSigRules = must_parse_rules(`SHR(CALLDATALOAD(0x0), 0xe0) -> func_sig`)
 
 func (op *FunSig) Do(c *Cursor) (modified bool) {
-	if Equal(funSigSample, c.Node) {
-		c.Replace(&Label{Str: "func_sig"})
-
-		modified = true
-	}
-	return
-}
-
-func is_counter_op(op1, op2 vm.OpCode) bool {
-	if op1 == vm.ADD && op2 == vm.SUB {
-		return true
-	}
-	if op1 == vm.SUB && op2 == vm.ADD {
-		return true
-	}
-
-	return false
+	return funSigRules.Do(c)
 }
 
 // replace
@@ -174,26 +216,13 @@ func is_counter_op(op1, op2 vm.OpCode) bool {
 //   `x`
 type CounterBinaryOp struct{}
 
-func (op *CounterBinaryOp) Do(c *Cursor) (modified bool) {
-	n1, ok := c.Node.(*BinaryOp)
-	if !ok { // the `ADD`
-		return
-	}
-	n2, ok := n1.Y.(*BinaryOp)
-	if !ok { // the `SUB`
-		return
-	}
-	if !is_counter_op(n1.OpCode, n2.OpCode) {
-		return
-	}
-	if _, ok = n1.X.(*Const); ok { // it's const number
-		if Equal(n1.X, n2.Y) {
-			c.Replace(n2.X)
-			modified = true
-		}
-	}
+var counterBinaryOpRules = must_parse_rules(`
+	ADD(#c, SUB($x, #c)) -> $x
+	SUB(ADD($x, #c), #c) -> $x
+`)
 
-	return
+func (op *CounterBinaryOp) Do(c *Cursor) (modified bool) {
+	return counterBinaryOpRules.Do(c)
 }
 
 // replace
@@ -202,25 +231,182 @@ func (op *CounterBinaryOp) Do(c *Cursor) (modified bool) {
 //   `x`
 type CounterUnaryOp struct{}
 
+var counterUnaryOpRules = must_parse_rules(`
+	ISZERO(ISZERO($x)) -> $x
+	NOT(NOT($x)) -> $x
+`)
+
 func (op *CounterUnaryOp) Do(c *Cursor) (modified bool) {
-	outer, ok := c.Node.(*UnaryOp)
-	if !ok { // the outer
-		return
+	return counterUnaryOpRules.Do(c)
+}
+
+// replace
+// 	 `((0x1 << 0xa0) - 0x1)`
+// ->
+//   `0xffffffffffffffffffffffffffffffffffffffff`
+type FoldConst struct{}
+
+func (op *FoldConst) Do(c *Cursor) (modified bool) {
+	switch c.Node.(type) {
+	case *UnaryOp, *BinaryOp, *TernaryOp:
+		if v, ok := EvaluateConst(c.Node); ok {
+			c.Replace(NewConst(&v))
+			modified = true
+		}
 	}
-	inner, ok := outer.X.(*UnaryOp)
-	if !ok { // the inner
-		return
+	return
+}
+
+// algebraic identities, eg: `(x + 0)`, `(x & x)`
+var identityRules = must_parse_rules(`
+	ADD($x, 0) -> $x
+	SUB($x, 0) -> $x
+	SUB($x, $x) -> 0
+	MUL($x, 1) -> $x
+	MUL(_, 0) -> 0
+	DIV|SDIV($x, 1) -> $x
+	DIV|SDIV|MOD|SMOD(_, 0) -> 0
+	MOD|SMOD(_, 1) -> 0
+	EXP(_, 0) -> 1
+	EXP($x, 1) -> $x
+	AND($x, $x) -> $x
+	AND(_, 0) -> 0
+	OR($x, $x) -> $x
+	OR($x, 0) -> $x
+	XOR($x, $x) -> 0
+	XOR($x, 0) -> $x
+	EQ($x, $x) -> 1
+	LT|GT|SLT|SGT($x, $x) -> 0
+	LT(_, 0) -> 0
+	GT(0, _) -> 0
+	SHL|SHR|SAR($x, 0) -> $x
+	SHL|SHR(0, _) -> 0
+	AND(BYTE($x, $i), 0xff) -> BYTE($x, $i)
+	ISZERO(ISZERO(ISZERO($x))) -> ISZERO($x)
+`)
+
+// a rule that calculates the replacement from the bound constants,
+// `build` returns nil if it doesn't apply
+type computedRule struct {
+	lhs   Node
+	build func(b Bindings) Node
+}
+
+func computed(ptn string, build func(b Bindings) Node) *computedRule {
+	lhs, e := ParsePattern(ptn)
+	if e != nil {
+		panic(e)
 	}
-	if outer.OpCode == inner.OpCode {
-		switch outer.OpCode {
-		case vm.ISZERO, vm.NOT:
-			c.Replace(inner.X)
+	return &computedRule{lhs: lhs, build: build}
+}
+
+func (r *computedRule) Do(c *Cursor) (modified bool) {
+	if b, ok := Match(r.lhs, c.Node); ok {
+		if n := r.build(b); n != nil {
+			c.Replace(n)
 			modified = true
 		}
 	}
 	return
 }
 
+func const_of(b Bindings, name string) uint256.Int {
+	v, _ := EvaluateConst(b[name])
+	return v
+}
+
+func new_binary(op vm.OpCode, x Node, y uint256.Int) Node {
+	return &BinaryOp{OpNode: OpNode{OpCode: op}, X: x, Y: NewConst(&y)}
+}
+
+// `((x << a) << b)` -> `(x << (a+b))`
+func shift_chain(op vm.OpCode) func(b Bindings) Node {
+	return func(b Bindings) Node {
+		x, y := const_of(b, "a"), const_of(b, "b")
+		if !x.LtUint64(256) || !y.LtUint64(256) {
+			return nil // folded by identities after
+		}
+		x.Add(&x, &y)
+		if !x.LtUint64(256) {
+			return NewConst(uint256.NewInt(0))
+		}
+		return new_binary(op, b["x"], x)
+	}
+}
+
+// `((x & a) & b)` -> `(x & (a&b))`
+func const_chain(op vm.OpCode) func(b Bindings) Node {
+	return func(b Bindings) Node {
+		v, ok := evaluate_binary(op, const_of(b, "a"), const_of(b, "b"))
+		if !ok {
+			return nil
+		}
+		return new_binary(op, b["x"], v)
+	}
+}
+
+var computedRules = []*computedRule{
+	computed("SHL(SHL($x, #a), #b)", shift_chain(vm.SHL)),
+	computed("SHR(SHR($x, #a), #b)", shift_chain(vm.SHR)),
+	computed("AND(AND($x, #a), #b)", const_chain(vm.AND)),
+	computed("OR(OR($x, #a), #b)", const_chain(vm.OR)),
+	computed("XOR(XOR($x, #a), #b)", const_chain(vm.XOR)),
+	computed("ADD(ADD($x, #a), #b)", const_chain(vm.ADD)),
+	computed("MUL(MUL($x, #a), #b)", const_chain(vm.MUL)),
+
+	// `((x << a) >> a)` -> `(x & (2^(256-a) - 1))`
+	computed("SHR(SHL($x, #a), #a)", func(b Bindings) Node {
+		a := const_of(b, "a")
+		if !a.LtUint64(256) {
+			return nil
+		}
+		mask := new(uint256.Int).SetAllOne()
+		mask.Rsh(mask, uint(a.Uint64()))
+		return new_binary(vm.AND, b["x"], *mask)
+	}),
+	// `((x >> a) << a)` -> `(x & ~(2^a - 1))`
+	computed("SHL(SHR($x, #a), #a)", func(b Bindings) Node {
+		a := const_of(b, "a")
+		if !a.LtUint64(256) {
+			return nil
+		}
+		mask := new(uint256.Int).SetAllOne()
+		mask.Lsh(mask, uint(a.Uint64()))
+		return new_binary(vm.AND, b["x"], *mask)
+	}),
+	// `((x >> 0x8) & 0xff)` -> `(x BYTE 0x1e)`
+	computed("AND(SHR($x, #k), 0xff)", func(b Bindings) Node {
+		k := const_of(b, "k")
+		if !k.LtUint64(256) || k.Uint64()%8 != 0 {
+			return nil
+		}
+		return new_binary(vm.BYTE, b["x"], *uint256.NewInt(31 - k.Uint64()/8))
+	}),
+	// `(x >> 0xf8)` -> `(x BYTE 0x0)`
+	computed("SHR($x, 0xf8)", func(b Bindings) Node {
+		return new_binary(vm.BYTE, b["x"], uint256.Int{})
+	}),
+}
+
+// algebraic simplification
+type Simplify struct{}
+
+func (op *Simplify) Do(c *Cursor) (modified bool) {
+	if identityRules.Do(c) {
+		return true
+	}
+	for _, r := range computedRules {
+		if r.Do(c) {
+			return true
+		}
+	}
+	return
+}
+
+// Optimize until nothing changes,
+// the limit is for rules that rewrite each other endlessly
+const maxOptimizePasses = 100
+
 // Returns the optimized node
 // and if it's modified
 func Optimize(
@@ -228,14 +414,22 @@ func Optimize(
 	opts Optimizers,
 ) (newNode Node, modified bool) {
 
-	newNode = Walk(root, func(c *Cursor) {
+	newNode = root
+	for i := 0; i < maxOptimizePasses; i++ {
+		changed := false
+		newNode = Walk(newNode, func(c *Cursor) {
 
-		for _, o := range opts {
-			if o.Do(c) {
-				modified = true
+			for _, o := range opts {
+				if o.Do(c) {
+					changed = true
+				}
 			}
+		})
+		if !changed {
+			break
 		}
-	})
+		modified = true
+	}
 
 	return
 }
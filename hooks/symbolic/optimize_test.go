@@ -3,6 +3,7 @@ package symbolic
 import (
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
@@ -88,3 +89,93 @@ func TestSignCast(t *testing.T) {
 
 	assert.Equal(t, "(CALLER << 0x60)", n2.String())
 }
+
+func TestEvaluateConst(t *testing.T) {
+	max := NewConst(new(uint256.Int).SetAllOne()) // -1
+	for _, tc := range []struct {
+		n        Node
+		expected string
+	}{
+		{bin(vm.MUL, c(6), c(7)), "0x2a"},
+		{bin(vm.DIV, c(7), c(2)), "0x3"},
+		{bin(vm.DIV, c(7), c(0)), "0x0"},
+		{bin(vm.SDIV, max, c(1)), max.String()},
+		{bin(vm.MOD, c(7), c(4)), "0x3"},
+		{bin(vm.SMOD, max, c(2)), max.String()},
+		{bin(vm.EXP, c(2), c(10)), "0x400"},
+		{bin(vm.SIGNEXTEND, c(0x80), c(0)), max.String()[:64] + "80"},
+		{bin(vm.LT, c(1), c(2)), "0x1"},
+		{bin(vm.GT, c(1), c(2)), "0x0"},
+		{bin(vm.SLT, max, c(0)), "0x1"},
+		{bin(vm.SGT, max, c(0)), "0x0"},
+		{bin(vm.EQ, c(3), c(3)), "0x1"},
+		{bin(vm.AND, c(0xf0), c(0x3c)), "0x30"},
+		{bin(vm.OR, c(0xf0), c(0x0f)), "0xff"},
+		{bin(vm.XOR, c(0xff), c(0x0f)), "0xf0"},
+		{bin(vm.BYTE, c(0x1234), c(30)), "0x12"},
+		{bin(vm.SHL, c(1), c(256)), "0x0"},
+		{bin(vm.SAR, max, c(300)), max.String()},
+		{&UnaryOp{OpNode: OpNode{vm.ISZERO}, X: c(0)}, "0x1"},
+		{&TernaryOp{OpNode: OpNode{vm.ADDMOD}, X: max, Y: c(2), Z: c(7)}, "0x3"},
+		{&TernaryOp{OpNode: OpNode{vm.MULMOD}, X: c(3), Y: c(5), Z: c(7)}, "0x1"},
+	} {
+		v, ok := EvaluateConst(tc.n)
+		assert.True(t, ok, tc.n.String())
+		assert.Equal(t, tc.expected, v.String(), tc.n.String())
+	}
+
+	_, ok := EvaluateConst(bin(vm.ADD, c(1), &NullaryOp{OpNode: OpNode{vm.CALLER}}))
+	assert.False(t, ok)
+}
+
+func TestSimplify(t *testing.T) {
+	caller := &NullaryOp{OpNode: OpNode{vm.CALLER}}
+	x := &UnaryOp{OpNode: OpNode{vm.CALLDATALOAD}, X: c(4)}
+
+	for _, tc := range []struct {
+		n        Node
+		expected string
+	}{
+		{bin(vm.ADD, c(0), caller), "CALLER"},
+		{bin(vm.MUL, bin(vm.SUB, c(3), c(2)), caller), "CALLER"},
+		{bin(vm.AND, caller, caller), "CALLER"},
+		{bin(vm.XOR, caller, caller), "0x0"},
+		{bin(vm.EQ, x, x), "0x1"},
+		{bin(vm.SHL, bin(vm.SHL, x, c(8)), c(16)), "(CALLDATALOAD(0x4) << 0x18)"},
+		{bin(vm.SHR, bin(vm.SHR, x, c(128)), c(128)), "0x0"},
+		{bin(vm.ADD, bin(vm.ADD, x, c(1)), c(2)), "(CALLDATALOAD(0x4) + 0x3)"},
+		{bin(vm.SHR, bin(vm.SHL, x, c(96)), c(96)), "(CALLDATALOAD(0x4) & 0xffffffffffffffffffffffffffffffffffffffff)"},
+		{bin(vm.AND, bin(vm.SHR, x, c(8)), c(0xff)), "(CALLDATALOAD(0x4) BYTE 0x1e)"},
+		{bin(vm.AND, bin(vm.SHR, x, c(248)), c(0xff)), "(CALLDATALOAD(0x4) BYTE 0x0)"},
+		// several passes, `(x & x) - (x & x)` -> `x - x` -> 0
+		{bin(vm.SUB, bin(vm.AND, x, x), bin(vm.AND, x, x)), "0x0"},
+		{&UnaryOp{OpNode: OpNode{vm.ISZERO}, X: bin(vm.LT, x, c(0))}, "0x1"},
+	} {
+		n, modified := Optimize(tc.n, Optimizers{&FoldConst{}, &Simplify{}})
+		assert.True(t, modified)
+		assert.Equal(t, tc.expected, n.String())
+	}
+
+	// `GAS` differs on each read
+	gas := bin(vm.SUB, &NullaryOp{OpNode: OpNode{vm.GAS}}, &NullaryOp{OpNode: OpNode{vm.GAS}})
+	gas.X.(*NullaryOp).Val.SetUint64(100)
+	_, modified := Optimize(gas, Optimizers{&FoldConst{}, &Simplify{}})
+	assert.False(t, modified)
+}
+
+func TestClone(t *testing.T) {
+	sto := &Storage{Slot: c(0), Val: bin(vm.AND, &NullaryOp{OpNode: OpNode{vm.CALLER}}, NewConst(U20))}
+	root := NewCall(vm.CALL, &common.Address{}, nil)
+	root.AddTrace(&StorageWrite{Storage: sto})
+	root.AddTrace(&If{Cond: bin(vm.EQ, sto, c(1))})
+	before := PrintNode(root)
+
+	cp := Clone(root).(*Call)
+	Optimize(cp, DefaultOptimizers)
+	assert.NotEqual(t, before, PrintNode(cp))
+	assert.Equal(t, before, PrintNode(root))
+
+	// shared nodes are still shared
+	assert.Same(t, cp.List[0].(*StorageWrite).Storage, cp.List[1].(*If).Cond.(*BinaryOp).X)
+	assert.NotSame(t, sto, cp.List[0].(*StorageWrite).Storage)
+}
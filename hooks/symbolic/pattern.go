@@ -1,22 +1,606 @@
 package symbolic
 
-// match to any node
-// type AnyNode struct{}
-//
-// func (n *AnyNode) String() string {
-// 	return "AnyNode"
-// }
-//
-// // match to any Const node
-// type AnyConst struct{}
-//
-// func (n *AnyConst) String() string {
-// 	return "AnyConst"
-// }
-//
-// func Match(ptn, n Node) bool {
-// 	// switch n1.(type) {
-// 	//
-// 	// }
-// 	return false
-// }
+import (
+	"fmt"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// ---- pattern nodes ----
+
+// match to any node, bound to a variable if named, eg: `$x`
+type AnyNode struct {
+	Name string
+}
+
+func (n *AnyNode) String() string {
+	if n.Name == "" {
+		return "_"
+	}
+	return "$" + n.Name
+}
+
+// match to any node that evaluates to a constant, eg: `#c`
+type AnyConst struct {
+	Name string
+}
+
+func (n *AnyConst) String() string {
+	return "#" + n.Name
+}
+
+// match to any of the opcodes, with operands in printed order,
+// eg: `LT|GT($a, $b)` matches `($a < $b)` and `($a > $b)`
+type OpSet struct {
+	Ops  []vm.OpCode
+	Args []Node
+}
+
+func (n *OpSet) String() string {
+	ops := []string{}
+	for _, op := range n.Ops {
+		ops = append(ops, edb.OpName(op))
+	}
+	args := []string{}
+	for _, a := range n.Args {
+		args = append(args, a.String())
+	}
+	return fmt.Sprintf("%s(%s)", strings.Join(ops, "|"), strings.Join(args, ", "))
+}
+
+// A function-like node created by rules, eg: `address(x)`
+type Func struct {
+	Name string
+	Args []Node
+}
+
+func (n *Func) String() string {
+	args := []string{}
+	for _, a := range n.Args {
+		args = append(args, a.String())
+	}
+	return fmt.Sprintf("%s(%s)", n.Name, strings.Join(args, ", "))
+}
+
+// ---- matcher ----
+
+// variables bound by `$x` and `#c`
+type Bindings map[string]Node
+
+// operands of UnaryOp/BinaryOp/..., in printed order
+func operands(n Node) (vm.OpCode, []Node, bool) {
+	switch n := n.(type) {
+	case *NullaryOp:
+		return n.OpCode, nil, true
+	case *UnaryOp:
+		return n.OpCode, []Node{n.X}, true
+	case *BinaryOp:
+		return n.OpCode, []Node{n.X, n.Y}, true
+	case *TernaryOp:
+		return n.OpCode, []Node{n.X, n.Y, n.Z}, true
+	}
+	return 0, nil, false
+}
+
+func is_commutative(op vm.OpCode) bool {
+	switch op {
+	case vm.ADD, vm.MUL, vm.AND, vm.OR, vm.XOR, vm.EQ:
+		return true
+	}
+	return false
+}
+
+func (b Bindings) clone() Bindings {
+	ret := Bindings{}
+	for k, v := range b {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (b Bindings) bind(name string, n Node) bool {
+	if name == "" {
+		return true
+	}
+	if old, ok := b[name]; ok {
+		return old == n || Equal(old, n)
+	}
+	b[name] = n
+	return true
+}
+
+func (b Bindings) match(ptn, n Node) bool {
+	switch p := ptn.(type) {
+	case *AnyNode:
+		return b.bind(p.Name, n)
+	case *AnyConst:
+		v, ok := EvaluateConst(n)
+		return ok && b.bind(p.Name, NewConst(&v))
+	case *Const: // `0xff` also matches `((0x1 << 0x8) - 0x1)`
+		v, ok := EvaluateConst(n)
+		return ok && v.Eq(p.Value())
+	case *Label:
+		l, ok := n.(*Label)
+		return ok && l.Str == p.Str
+	case *Func:
+		f, ok := n.(*Func)
+		return ok && f.Name == p.Name && b.match_args(p.Args, f.Args)
+	case *OpSet:
+		op, args, ok := operands(n)
+		if !ok || len(args) != len(p.Args) {
+			return false
+		}
+		for _, o := range p.Ops {
+			if o != op {
+				continue
+			}
+			saved := b.clone()
+			if b.match_args(p.Args, args) {
+				return true
+			}
+			if len(args) == 2 && is_commutative(op) {
+				b.restore(saved)
+				if b.match_args(p.Args, []Node{args[1], args[0]}) {
+					return true
+				}
+			}
+			b.restore(saved)
+		}
+		return false
+	}
+	return Equal(ptn, n)
+}
+
+func (b Bindings) match_args(ptns, nodes []Node) bool {
+	if len(ptns) != len(nodes) {
+		return false
+	}
+	for i := range ptns {
+		if !b.match(ptns[i], nodes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b Bindings) restore(saved Bindings) {
+	for k := range b {
+		delete(b, k)
+	}
+	for k, v := range saved {
+		b[k] = v
+	}
+}
+
+// Match node `n` against pattern `ptn`, returns the bound variables
+func Match(ptn, n Node) (Bindings, bool) {
+	b := Bindings{}
+	if !b.match(ptn, n) {
+		return nil, false
+	}
+	return b, true
+}
+
+// build a new node from the template, with variables replaced
+func Rewrite(tmpl Node, b Bindings) Node {
+	switch t := tmpl.(type) {
+	case *AnyNode:
+		return b[t.Name]
+	case *AnyConst:
+		return b[t.Name]
+	case *Func:
+		return &Func{Name: t.Name, Args: rewrite_args(t.Args, b)}
+	case *OpSet:
+		args := rewrite_args(t.Args, b)
+		op := OpNode{OpCode: t.Ops[0]}
+		switch len(args) {
+		case 0:
+			return &NullaryOp{OpNode: op}
+		case 1:
+			return &UnaryOp{OpNode: op, X: args[0]}
+		case 2:
+			return &BinaryOp{OpNode: op, X: args[0], Y: args[1]}
+		default:
+			return &TernaryOp{OpNode: op, X: args[0], Y: args[1], Z: args[2]}
+		}
+	case *Const:
+		return NewConst(t.Value())
+	case *Label:
+		return &Label{Str: t.Str}
+	}
+	return tmpl
+}
+
+func rewrite_args(tmpls []Node, b Bindings) []Node {
+	ret := []Node{}
+	for _, t := range tmpls {
+		ret = append(ret, Rewrite(t, b))
+	}
+	return ret
+}
+
+// ---- rules ----
+
+/*
+A rewrite rule like `AND(0xff..ff{20}, $x) -> address($x)`, syntax:
+  - `ADD(a, b)`: opcode with operands in printed order, eg: `SHL($x, 0x60)` is `($x << 0x60)`,
+    `ADD`, `MUL`, `AND`, `OR`, `XOR`, `EQ` also match the swapped operands
+  - `LT|GT(a, b)`: any of the opcodes, only on the left side
+  - `CALLER`: opcode without operand
+  - `_`: any node
+  - `$x`: any node, the same name must match equal nodes
+  - `#c`, `#`: any node that evaluates to a constant
+  - `0x1f`, `31`: constant, also matches nodes that evaluate to it
+  - `0xff..ff{20}`, `0xff{20}`: the byte `ff` repeated 20 times, eg: the address mask
+  - `func_sig`: label
+  - `address($x)`: function-like node
+*/
+type Rule struct {
+	Lhs, Rhs Node
+	Text     string
+}
+
+func (r *Rule) String() string {
+	return r.Text
+}
+
+func (r *Rule) Do(c *Cursor) (modified bool) {
+	if c.Node == nil {
+		return
+	}
+	if b, ok := Match(r.Lhs, c.Node); ok {
+		c.Replace(Rewrite(r.Rhs, b))
+		modified = true
+	}
+	return
+}
+
+type Rules []*Rule
+
+// the first matched rule is applied
+func (rs Rules) Do(c *Cursor) (modified bool) {
+	for _, r := range rs {
+		if r.Do(c) {
+			return true
+		}
+	}
+	return
+}
+
+func ParseRule(s string) (*Rule, error) {
+	lhs, rhs, ok := strings.Cut(s, "->")
+	if !ok {
+		return nil, errors.Errorf("missing '->' in rule: %s", s)
+	}
+	r := &Rule{Text: strings.TrimSpace(s)}
+	var e error
+	if r.Lhs, e = ParsePattern(lhs); e != nil {
+		return nil, errors.Wrap(e, "left side")
+	}
+	if r.Rhs, e = ParsePattern(rhs); e != nil {
+		return nil, errors.Wrap(e, "right side")
+	}
+	if e := check_template(r.Rhs, pattern_vars(r.Lhs)); e != nil {
+		return nil, errors.Wrap(e, "right side")
+	}
+	return r, nil
+}
+
+// One rule per line, empty lines and `//` comments are ignored
+func ParseRules(text string) (Rules, error) {
+	ret := Rules{}
+	for i, line := range strings.Split(text, "\n") {
+		if idx := strings.Index(line, "//"); idx >= 0 {
+			line = line[:idx]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		r, e := ParseRule(line)
+		if e != nil {
+			return nil, errors.Wrapf(e, "line %d", i+1)
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+func LoadRules(fn string) (Rules, error) {
+	bs, e := os.ReadFile(fn)
+	if e != nil {
+		return nil, e
+	}
+	return ParseRules(string(bs))
+}
+
+// for built-in rules
+func must_parse_rules(text string) Rules {
+	rs, e := ParseRules(text)
+	if e != nil {
+		panic(e)
+	}
+	return rs
+}
+
+func pattern_vars(n Node) map[string]bool {
+	ret := map[string]bool{}
+	var collect func(Node)
+	collect = func(n Node) {
+		switch n := n.(type) {
+		case *AnyNode:
+			ret[n.Name] = true
+		case *AnyConst:
+			ret[n.Name] = true
+		case *OpSet:
+			for _, a := range n.Args {
+				collect(a)
+			}
+		case *Func:
+			for _, a := range n.Args {
+				collect(a)
+			}
+		}
+	}
+	collect(n)
+	return ret
+}
+
+// the right side must be buildable
+func check_template(n Node, vars map[string]bool) error {
+	var args []Node
+	switch n := n.(type) {
+	case *AnyNode:
+		if n.Name == "" || !vars[n.Name] {
+			return errors.Errorf("unbound variable: %s", n)
+		}
+	case *AnyConst:
+		if n.Name == "" || !vars[n.Name] {
+			return errors.Errorf("unbound variable: %s", n)
+		}
+	case *OpSet:
+		if len(n.Ops) != 1 {
+			return errors.Errorf("ambiguous opcode: %s", n)
+		}
+		args = n.Args
+	case *Func:
+		args = n.Args
+	}
+	for _, a := range args {
+		if e := check_template(a, vars); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// ---- parser ----
+
+// initialized before the built-in rules are parsed
+var opCodes = func() map[string]vm.OpCode {
+	m := map[string]vm.OpCode{}
+	for op := range edb.OpTable {
+		m[edb.OpName(op)] = op
+	}
+	m["PREVRANDAO"] = vm.DIFFICULTY
+	m["KECCAK256"] = vm.SHA3
+	return m
+}()
+
+type patternParser struct {
+	s   string
+	pos int
+}
+
+func ParsePattern(s string) (Node, error) {
+	p := &patternParser{s: s}
+	n, e := p.expr()
+	if e != nil {
+		return nil, e
+	}
+	if p.skip(); p.pos < len(p.s) {
+		return nil, errors.Errorf("unexpected '%s'", p.s[p.pos:])
+	}
+	return n, nil
+}
+
+func (p *patternParser) skip() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume the char if it's next
+func (p *patternParser) eat(c byte) bool {
+	p.skip()
+	if p.pos < len(p.s) && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func is_ident_char(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (p *patternParser) ident() string {
+	start := p.pos
+	for p.pos < len(p.s) && is_ident_char(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *patternParser) expr() (Node, error) {
+	p.skip()
+	if p.pos >= len(p.s) {
+		return nil, errors.New("unexpected end of pattern")
+	}
+	switch c := p.s[p.pos]; {
+	case c == '$':
+		p.pos++
+		name := p.ident()
+		if name == "" {
+			return nil, errors.New("missing variable name after '$'")
+		}
+		return &AnyNode{Name: name}, nil
+	case c == '#':
+		p.pos++
+		return &AnyConst{Name: p.ident()}, nil
+	case c >= '0' && c <= '9':
+		return p.number()
+	case is_ident_char(c):
+		return p.name()
+	}
+	return nil, errors.Errorf("unexpected '%c'", p.s[p.pos])
+}
+
+// opcode, opcode set, label or function
+func (p *patternParser) name() (Node, error) {
+	names := []string{p.ident()}
+	for p.eat('|') {
+		p.skip()
+		names = append(names, p.ident())
+	}
+	if len(names) == 1 && names[0] == "_" {
+		return &AnyNode{}, nil
+	}
+
+	var args []Node
+	hasArgs := p.eat('(')
+	if hasArgs && !p.eat(')') {
+		for {
+			a, e := p.expr()
+			if e != nil {
+				return nil, e
+			}
+			args = append(args, a)
+			if p.eat(')') {
+				break
+			}
+			if !p.eat(',') {
+				return nil, errors.New("expect ',' or ')'")
+			}
+		}
+	}
+
+	ops := []vm.OpCode{}
+	for _, name := range names {
+		if op, ok := opCodes[name]; ok {
+			ops = append(ops, op)
+		} else if len(names) > 1 {
+			return nil, errors.Errorf("unknown opcode: %s", name)
+		}
+	}
+	if len(ops) > 0 {
+		if len(args) > 3 {
+			return nil, errors.Errorf("too many operands for %s", strings.Join(names, "|"))
+		}
+		return &OpSet{Ops: ops, Args: args}, nil
+	}
+	if names[0] == "" {
+		return nil, errors.New("missing name before '('")
+	}
+	if hasArgs {
+		return &Func{Name: names[0], Args: args}, nil
+	}
+	return &Label{Str: names[0]}, nil
+}
+
+// `31`, `0x1f`, `0xff..ff{20}`, `0xff{20}`
+func (p *patternParser) number() (Node, error) {
+	start := p.pos
+	for p.pos < len(p.s) && (is_ident_char(p.s[p.pos]) || strings.IndexByte(".{}", p.s[p.pos]) >= 0) {
+		p.pos++
+	}
+	tok := p.s[start:p.pos]
+
+	if !strings.HasPrefix(tok, "0x") {
+		v, ok := new(big.Int).SetString(tok, 10)
+		if !ok {
+			return nil, errors.Errorf("invalid number: %s", tok)
+		}
+		u, overflow := uint256.FromBig(v)
+		if overflow {
+			return nil, errors.Errorf("number overflow: %s", tok)
+		}
+		return NewConst(u), nil
+	}
+
+	hex, e := expand_hex(tok[2:])
+	if e != nil {
+		return nil, errors.Wrap(e, tok)
+	}
+	if len(hex) == 0 || len(hex) > 64 {
+		return nil, errors.Errorf("invalid number: %s", tok)
+	}
+	u, e := uint256.FromHex("0x" + strings.TrimLeft(hex, "0"))
+	if strings.TrimLeft(hex, "0") == "" {
+		u, e = uint256.NewInt(0), nil
+	}
+	if e != nil {
+		return nil, errors.Errorf("invalid number: %s", tok)
+	}
+	return NewConst(u), nil
+}
+
+// `ff..ff{20}00{12}` -> "ff" * 20 + "00" * 12
+func expand_hex(s string) (string, error) {
+	sb := strings.Builder{}
+	for len(s) > 0 {
+		n := 0
+		for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+			n++
+		}
+		if n == 0 {
+			return "", errors.Errorf("unexpected '%c'", s[0])
+		}
+		digits := s[:n]
+		s = s[n:]
+
+		unit := digits
+		if strings.HasPrefix(s, "..") { // `ff..ff{20}`, the unit is after ".."
+			s = s[2:]
+			n = 0
+			for n < len(s) && strings.IndexByte("0123456789abcdefABCDEF", s[n]) >= 0 {
+				n++
+			}
+			unit = s[:n]
+			s = s[n:]
+			if unit == "" || !strings.HasSuffix(digits, unit) || !strings.HasPrefix(s, "{") {
+				return "", errors.New("expect `a..a{n}`")
+			}
+			sb.WriteString(strings.TrimSuffix(digits, unit))
+		}
+		if !strings.HasPrefix(s, "{") {
+			sb.WriteString(digits)
+			continue
+		}
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return "", errors.New("missing '}'")
+		}
+		count, e := strconv.Atoi(s[1:end])
+		if e != nil || count < 0 {
+			return "", errors.Errorf("invalid repeat count: %s", s[1:end])
+		}
+		if unit == digits && len(digits) > 2 { // `abff{20}` repeats the last byte
+			sb.WriteString(digits[:len(digits)-2])
+			unit = digits[len(digits)-2:]
+		}
+		if count > 64 || sb.Len()+count*len(unit) > 64 {
+			return "", errors.New("longer than 32 bytes")
+		}
+		sb.WriteString(strings.Repeat(unit, count))
+		s = s[end+1:]
+	}
+	return sb.String(), nil
+}
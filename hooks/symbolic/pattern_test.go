@@ -0,0 +1,131 @@
+package symbolic
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func bin(op vm.OpCode, x, y Node) *BinaryOp {
+	return &BinaryOp{OpNode: OpNode{op}, X: x, Y: y}
+}
+
+func c(v uint64) *Const {
+	return NewConst(uint256.NewInt(v))
+}
+
+func TestParsePattern(t *testing.T) {
+	for s, expected := range map[string]string{
+		"AND(0xff..ff{20}, $x)":     "AND(0xffffffffffffffffffffffffffffffffffffffff, $x)",
+		"AND(0xff{2}00..00{2}, _)":  "AND(0xffff0000, _)",
+		"LT|GT|SLT($a, #)":          "LT|GT|SLT($a, #)",
+		"ISZERO(CALLER)":            "ISZERO(CALLER())",
+		"address( #c , 31 )":        "address(#c, 0x1f)",
+		"func_sig":                  "func_sig",
+		"SHR(CALLDATALOAD(0), 224)": "SHR(CALLDATALOAD(0x0), 0xe0)",
+	} {
+		n, e := ParsePattern(s)
+		assert.Nil(t, e, s)
+		assert.Equal(t, expected, n.String())
+	}
+
+	for _, s := range []string{
+		"",
+		"AND(",
+		"AND($x $y)",
+		"ADD|FOO($x)",
+		"0x",
+		"0xzz",
+		"0xff..0{2}",
+		"0x1{65}",
+		"0xff{33}",
+		"0xff{9223372036854775807}",
+		"1x",
+		"ADD(1, 2, 3, 4)",
+		"$",
+		"$x)",
+	} {
+		_, e := ParsePattern(s)
+		assert.NotNil(t, e, s)
+	}
+}
+
+func TestParseRule(t *testing.T) {
+	for _, s := range []string{
+		"AND($x, $y)",                  // no "->"
+		"AND($x, 0xff) -> $y",          // unbound
+		"AND($x, 0xff) -> _",           // wildcard on right side
+		"LT|GT($x, 1) -> LT|GT($x, 1)", // ambiguous
+	} {
+		_, e := ParseRule(s)
+		assert.NotNil(t, e, s)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	// (CALLER + 0x4) & ((0x1 << 0xa0) - 0x1)
+	mask := bin(vm.SUB, bin(vm.SHL, c(1), c(0xa0)), c(1))
+	n := bin(vm.AND, bin(vm.ADD, &NullaryOp{OpNode: OpNode{vm.CALLER}}, c(4)), mask)
+
+	// commutative, the mask is evaluated
+	ptn, _ := ParsePattern("AND(0xff{20}, ADD($x, #c))")
+	b, ok := Match(ptn, n)
+	assert.True(t, ok)
+	assert.Equal(t, "CALLER", b["x"].String())
+	assert.Equal(t, "0x4", b["c"].String())
+
+	// opcode set
+	ptn, _ = ParsePattern("SUB|ADD($x, #)")
+	_, ok = Match(ptn, n.X)
+	assert.True(t, ok)
+	ptn, _ = ParsePattern("SUB|MUL($x, #)")
+	_, ok = Match(ptn, n.X)
+	assert.False(t, ok)
+
+	// SUB is not commutative
+	ptn, _ = ParsePattern("SUB(1, SHL(1, 0xa0))")
+	_, ok = Match(ptn, mask)
+	assert.False(t, ok)
+
+	// bound variables must be equal
+	ptn, _ = ParsePattern("ADD($x, $x)")
+	_, ok = Match(ptn, bin(vm.ADD, &Label{"a"}, &Label{"a"}))
+	assert.True(t, ok)
+	_, ok = Match(ptn, bin(vm.ADD, &Label{"a"}, &Label{"b"}))
+	assert.False(t, ok)
+
+	// bindings of the failed order are dropped
+	ptn, _ = ParsePattern("EQ($x, func_sig)")
+	b, ok = Match(ptn, bin(vm.EQ, &Label{"func_sig"}, c(0x11223344)))
+	assert.True(t, ok)
+	assert.Equal(t, "0x11223344", b["x"].String())
+}
+
+func TestRules(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "my.rules")
+	assert.Nil(t, os.WriteFile(fn, []byte(`
+// casts
+AND(0xff..ff{20}, $x) -> address($x)
+
+EQ(#sel, func_sig) -> is_func(#sel) // selector comparison
+`), 0644))
+	rules, e := LoadRules(fn)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, len(rules))
+
+	// if (0x11223344 == func_sig) && CALLER & 0xff..ff
+	n := &Block{List: []Node{
+		&If{Cond: bin(vm.EQ, c(0x11223344), &Label{"func_sig"})},
+		bin(vm.AND, &NullaryOp{OpNode: OpNode{vm.CALLER}}, NewConst(U20)),
+	}}
+	n2, modified := Optimize(n, Optimizers{rules})
+	assert.True(t, modified)
+	assert.Equal(t, "{\n\tif is_func(0x11223344) <no>\n\taddress(CALLER)\n}\n", n2.String())
+
+	_, e = ParseRules("ADD($x, 1) -> $x\nADD($x -> $x")
+	assert.ErrorContains(t, e, "line 2")
+}
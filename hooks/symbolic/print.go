@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/aj3423/edb"
 	"github.com/ethereum/go-ethereum/core/vm"
 )
 
@@ -38,7 +39,36 @@ func (p *printer) print(n Node) {
 		}
 
 		p.indentLevel--
-		p.line("}") // last line "}"
+		if n.Returned != "" {
+			p.line("} -> " + n.Returned)
+		} else {
+			p.line("}") // last line "}"
+		}
+	case *Loop: // first and last iteration, and the expanded ones
+		p.line(n.Header() + " {")
+		p.indentLevel++
+
+		last := len(n.Iterations) - 1
+		prev := -1
+		for i, it := range n.Iterations {
+			if i != 0 && i != last && !n.Expanded[i] {
+				continue
+			}
+			if i != prev+1 {
+				p.line("...")
+			}
+			prev = i
+
+			p.line(fmt.Sprintf("#%d:", i))
+			p.indentLevel++
+			for _, ch := range it.List {
+				p.print(ch)
+			}
+			p.indentLevel--
+		}
+
+		p.indentLevel--
+		p.line("}")
 	case *Sha3Calc, *Log, *Return, *Precompiled:
 		// add indent to all output lines of `Sha3Calc.String()`
 		ss := strings.Split(n.String(), "\n")
@@ -87,6 +117,6 @@ func PrettifyOp(op vm.OpCode) string {
 		return "<<"
 
 	default:
-		return op.String()
+		return edb.OpName(op)
 	}
 }
@@ -0,0 +1,368 @@
+package symbolic
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// All `If` along the executed path, in order, including nested calls and loops
+func PathConditions(root Node) []*If {
+	ret := []*If{}
+	Walk(root, func(c *Cursor) {
+		if n, ok := c.Node.(*If); ok {
+			ret = append(ret, n)
+		}
+	})
+	return ret
+}
+
+// frame index of the nodes, 0 for the root call, sub calls are numbered in order
+func frame_ids(root Node) map[Node]int {
+	ret := map[Node]int{}
+	frame := 0
+	var visit func(list []Node, id int)
+	visit = func(list []Node, id int) {
+		for _, n := range list {
+			switch n := n.(type) {
+			case *Call:
+				frame++
+				visit(n.List, frame)
+			case *Loop:
+				for _, b := range n.Iterations {
+					visit(b.List, id)
+				}
+			case *Block:
+				visit(n.List, id)
+			default:
+				Walk(n, func(c *Cursor) {
+					if _, ok := ret[c.Node]; !ok {
+						ret[c.Node] = id
+					}
+				})
+			}
+		}
+	}
+	if call, ok := root.(*Call); ok {
+		visit(call.List, 0)
+	} else {
+		visit([]Node{root}, 0)
+	}
+	return ret
+}
+
+// inputs of a sub call differ from the tx, eg: `CALLER` is the calling contract
+var frameInputs = map[vm.OpCode]bool{
+	vm.CALLER: true, vm.CALLVALUE: true, vm.CALLDATASIZE: true, vm.SELFBALANCE: true,
+}
+
+// inputs of sub calls are different variables, eg: "caller_f1"
+func frame_name(name string, frame int) string {
+	if frame == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s_f%d", name, frame)
+}
+
+// these are inputs of the tx, other nullary ops use the traced value
+var smtInputs = map[vm.OpCode]bool{
+	vm.CALLER: true, vm.ORIGIN: true, vm.CALLVALUE: true, vm.CALLDATASIZE: true,
+	vm.TIMESTAMP: true, vm.NUMBER: true, vm.COINBASE: true, vm.DIFFICULTY: true,
+	vm.GASLIMIT: true, vm.GASPRICE: true, vm.BASEFEE: true, vm.CHAINID: true,
+	vm.BALANCE: true, vm.SELFBALANCE: true,
+}
+
+var smtAddresses = map[vm.OpCode]bool{vm.CALLER: true, vm.ORIGIN: true, vm.COINBASE: true}
+
+type smtWriter struct {
+	sb    strings.Builder
+	decls []string
+	names map[string]string // key -> declared name
+
+	online    map[*Storage]bool // storage fetched from chain, they are inputs
+	frames    map[Node]int      // node -> call frame
+	addresses []string          // declared variables that are 160-bit
+}
+
+func smt_const(v *uint256.Int) string {
+	b := v.Bytes32()
+	return fmt.Sprintf("#x%x", b[:])
+}
+
+func smt_u64(v uint64) string {
+	return smt_const(uint256.NewInt(v))
+}
+
+func smt_not(cond string) string {
+	if strings.HasPrefix(cond, "(not ") {
+		return strings.TrimSuffix(strings.TrimPrefix(cond, "(not "), ")")
+	}
+	return "(not " + cond + ")"
+}
+
+func smt_bool(cond string) string {
+	return fmt.Sprintf("(ite %s %s %s)", cond, smt_u64(1), smt_u64(0))
+}
+
+// declare a 256-bit variable once for the key
+func (w *smtWriter) declare(key, name, comment string) string {
+	if n, ok := w.names[key]; ok {
+		return n
+	}
+	if _, dup := w.names[name]; dup || name == "" {
+		name = fmt.Sprintf("%s_%d", name, len(w.decls))
+	}
+	w.names[key] = name
+	w.names[name] = name
+
+	d := fmt.Sprintf("(declare-const %s (_ BitVec 256))", name)
+	if comment != "" {
+		d += " ; " + comment
+	}
+	w.decls = append(w.decls, d)
+	return name
+}
+
+// not modeled, eg: SHA3, EXTCODESIZE, it's a free variable
+func (w *smtWriter) opaque(n Node, prefix string) string {
+	comment := strings.ReplaceAll(n.String(), "\n", " ")
+	if v, ok := n.(valueNode); ok {
+		comment += " = " + v.Value().String() + " when traced"
+	}
+	return w.declare(fmt.Sprintf("%p", n), prefix, comment)
+}
+
+func smt_symbol(s string) string {
+	ret := []byte{}
+	for i := 0; i < len(s); i++ {
+		if is_ident_char(s[i]) {
+			ret = append(ret, s[i])
+		}
+	}
+	if len(ret) == 0 || ret[0] >= '0' && ret[0] <= '9' {
+		return "v_" + string(ret)
+	}
+	return string(ret)
+}
+
+// 256-bit term of the node
+func (w *smtWriter) bv(n Node) string {
+	if v, ok := EvaluateConst(n); ok {
+		return smt_const(&v)
+	}
+	switch n := n.(type) {
+	case *NullaryOp:
+		if !smtInputs[n.OpCode] {
+			return smt_const(n.Value())
+		}
+		name := strings.ToLower(n.String())
+		if frameInputs[n.OpCode] {
+			name = frame_name(name, w.frames[n])
+		}
+		if _, ok := w.names[name]; !ok && smtAddresses[n.OpCode] {
+			w.addresses = append(w.addresses, name)
+		}
+		return w.declare(name, name, "")
+	case *UnaryOp:
+		switch n.OpCode {
+		case vm.ISZERO:
+			return smt_bool(w.boolean(n))
+		case vm.NOT:
+			return fmt.Sprintf("(bvnot %s)", w.bv(n.X))
+		case vm.CALLDATALOAD:
+			if off, ok := EvaluateConst(n.X); ok {
+				name := frame_name("calldata_"+off.Hex(), w.frames[n])
+				return w.declare(name, name, "")
+			}
+		}
+	case *BinaryOp:
+		if t, ok := w.binary(n); ok {
+			return t
+		}
+	case *TernaryOp:
+		x, y, z := w.bv(n.X), w.bv(n.Y), w.bv(n.Z)
+		ext := func(s string) string { return fmt.Sprintf("((_ zero_extend 256) %s)", s) }
+		op := "bvadd"
+		if n.OpCode == vm.MULMOD {
+			op = "bvmul"
+		}
+		// 512 bits, no overflow
+		return fmt.Sprintf("(ite (= %s %s) %s ((_ extract 255 0) (bvurem (%s %s %s) %s)))",
+			z, smt_u64(0), smt_u64(0), op, ext(x), ext(y), ext(z))
+	case *Storage:
+		if w.online[n] {
+			frame := w.frames[n]
+			key := frame_name("storage "+n.Slot.String(), frame)
+			if v, ok := EvaluateConst(n.Slot); ok {
+				return w.declare(key, frame_name("storage_"+v.Hex(), frame), n.String())
+			}
+			return w.declare(key, frame_name("storage", frame), n.String())
+		}
+		return w.bv(n.Val) // written in this tx
+	case *Memory:
+		if n.Val != nil {
+			return w.bv(n.Val)
+		}
+	case *Label:
+		if n.Str == "func_sig" {
+			name := frame_name("calldata_0x0", w.frames[n])
+			return fmt.Sprintf("(bvlshr %s %s)", w.declare(name, name, ""), smt_u64(0xe0))
+		}
+		return w.opaque(n, smt_symbol(n.Str))
+	case *Sha3:
+		return w.opaque(n, "sha3")
+	}
+	return w.opaque(n, "unknown")
+}
+
+func (w *smtWriter) binary(n *BinaryOp) (string, bool) {
+	x, y := w.bv(n.X), w.bv(n.Y)
+	zero := smt_u64(0)
+	// EVM returns 0 when divided by zero
+	div := func(op string) string {
+		return fmt.Sprintf("(ite (= %s %s) %s (%s %s %s))", y, zero, zero, op, x, y)
+	}
+
+	switch n.OpCode {
+	case vm.ADD:
+		return fmt.Sprintf("(bvadd %s %s)", x, y), true
+	case vm.SUB:
+		return fmt.Sprintf("(bvsub %s %s)", x, y), true
+	case vm.MUL:
+		return fmt.Sprintf("(bvmul %s %s)", x, y), true
+	case vm.DIV:
+		return div("bvudiv"), true
+	case vm.SDIV:
+		return div("bvsdiv"), true
+	case vm.MOD:
+		return div("bvurem"), true
+	case vm.SMOD: // sign follows the dividend
+		return div("bvsrem"), true
+	case vm.AND:
+		return fmt.Sprintf("(bvand %s %s)", x, y), true
+	case vm.OR:
+		return fmt.Sprintf("(bvor %s %s)", x, y), true
+	case vm.XOR:
+		return fmt.Sprintf("(bvxor %s %s)", x, y), true
+	case vm.SHL: // (x << shift), shift >= 256 is 0, same as EVM
+		return fmt.Sprintf("(bvshl %s %s)", x, y), true
+	case vm.SHR:
+		return fmt.Sprintf("(bvlshr %s %s)", x, y), true
+	case vm.SAR:
+		return fmt.Sprintf("(bvashr %s %s)", x, y), true
+	case vm.LT, vm.GT, vm.SLT, vm.SGT, vm.EQ:
+		return smt_bool(w.boolean(n)), true
+	case vm.BYTE: // (x BYTE index)
+		return fmt.Sprintf("(ite (bvult %s %s) (bvand (bvlshr %s (bvmul (bvsub %s %s) %s)) %s) %s)",
+			y, smt_u64(32), x, smt_u64(31), y, smt_u64(8), smt_u64(0xff), zero), true
+	case vm.SIGNEXTEND: // (x SIGNEXTEND byteNum)
+		b, ok := EvaluateConst(n.Y)
+		if !ok {
+			return "", false
+		}
+		if !b.LtUint64(31) {
+			return x, true
+		}
+		bits := (b.Uint64() + 1) * 8
+		return fmt.Sprintf("((_ sign_extend %d) ((_ extract %d 0) %s))", 256-bits, bits-1, x), true
+	case vm.EXP:
+		if base, ok := EvaluateConst(n.X); ok && base.Eq(uint256.NewInt(2)) {
+			return fmt.Sprintf("(bvshl %s %s)", smt_u64(1), y), true
+		}
+		e, ok := EvaluateConst(n.Y)
+		if !ok || !e.LtUint64(256) {
+			return "", false
+		}
+		// square and multiply
+		ret, sq := smt_u64(1), x
+		for k := e.Uint64(); k > 0; k >>= 1 {
+			if k&1 == 1 {
+				ret = fmt.Sprintf("(bvmul %s %s)", ret, sq)
+			}
+			if k > 1 {
+				sq = fmt.Sprintf("(bvmul %s %s)", sq, sq)
+			}
+		}
+		return ret, true
+	}
+	return "", false
+}
+
+// boolean term of `n != 0`
+func (w *smtWriter) boolean(n Node) string {
+	switch n := n.(type) {
+	case *UnaryOp:
+		if n.OpCode == vm.ISZERO {
+			return smt_not(w.boolean(n.X))
+		}
+	case *BinaryOp:
+		ops := map[vm.OpCode]string{
+			vm.LT: "bvult", vm.GT: "bvugt", vm.SLT: "bvslt", vm.SGT: "bvsgt", vm.EQ: "=",
+		}
+		if op, ok := ops[n.OpCode]; ok {
+			return fmt.Sprintf("(%s %s %s)", op, w.bv(n.X), w.bv(n.Y))
+		}
+	}
+	return fmt.Sprintf("(not (= %s %s))", w.bv(n), smt_u64(0))
+}
+
+/*
+Export the path conditions as SMT-LIB2, all values are 256-bit vectors:
+  - tx inputs are variables: `calldata_0x4`(the word at offset 4), `caller`, `timestamp`, `number`, ...
+  - inputs of sub calls are other variables suffixed with the frame, eg: `calldata_0x4_f1`, `caller_f1`
+  - storage fetched from chain are variables, eg: `storage_0x0`, storage written in the tx are expressions
+  - SHA3 and other values that are not modeled are free variables, with the traced value in comment
+
+If `flip` >= 0, the `flip`-th condition is negated and the later ones are dropped,
+a model of it is the input that makes that branch go the other way.
+*/
+func ExportSmtLib(root Node, flip int) (string, error) {
+	conds := PathConditions(root)
+	if flip >= len(conds) {
+		return "", errors.Errorf("condition %d out of range [0, %d)", flip, len(conds))
+	}
+
+	w := &smtWriter{names: map[string]string{}, online: map[*Storage]bool{}, frames: frame_ids(root)}
+	Walk(root, func(c *Cursor) {
+		if sw, ok := c.Node.(*StorageWrite); ok && sw.IsGetOnline {
+			w.online[sw.Storage] = true
+		}
+	})
+
+	asserts := []string{}
+	for i, n := range conds {
+		cond := w.boolean(n.Cond)
+		taken := n.Taken
+		if i == flip {
+			taken = !taken
+		}
+		if !taken {
+			cond = smt_not(cond)
+		}
+		comment := fmt.Sprintf("; #%d pc %d: %s", i, n.Pc, n.String())
+		if i == flip {
+			comment += " (flipped)"
+		}
+		asserts = append(asserts, comment, fmt.Sprintf("(assert %s)", cond))
+		if i == flip {
+			break
+		}
+	}
+
+	w.sb.WriteString("(set-logic QF_BV)\n")
+	for _, d := range w.decls {
+		w.sb.WriteString(d + "\n")
+	}
+	// addresses are 160-bit
+	for _, name := range w.addresses {
+		fmt.Fprintf(&w.sb, "(assert (bvule %s %s))\n", name, smt_const(U20))
+	}
+	for _, a := range asserts {
+		w.sb.WriteString(a + "\n")
+	}
+	w.sb.WriteString("(check-sat)\n(get-model)\n")
+	return w.sb.String(), nil
+}
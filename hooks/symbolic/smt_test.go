@@ -0,0 +1,100 @@
+package symbolic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportSmtLib(t *testing.T) {
+	// loops 3 times, `i <= arg`
+	root := loop_trace(t, 2)
+	assert.Equal(t, 4, len(PathConditions(root)))
+
+	s, e := ExportSmtLib(root, -1)
+	assert.Nil(t, e)
+	assert.True(t, strings.HasPrefix(s, "(set-logic QF_BV)\n"+
+		"(declare-const calldata_0x0 (_ BitVec 256))\n"+
+		"(declare-const calldata_0x4 (_ BitVec 256))\n"+
+		"; #0 pc 15: if (0x12345678 == (CALLDATALOAD(0x0) >> 0xe0)) <yes>\n"+
+		"(assert (= #x"+strings.Repeat("0", 56)+"12345678 (bvlshr calldata_0x0 #x"+strings.Repeat("0", 62)+"e0)))\n"+
+		"; #1 pc 32: if !((CALLDATALOAD(0x4) > 0x0)) <no>\n"+
+		"(assert (bvugt calldata_0x4 #x"+strings.Repeat("0", 64)+"))\n"))
+	assert.Contains(t, s, "; #3 pc 32: if !((CALLDATALOAD(0x4) > (0x1 + (0x1 + 0x0)))) <yes>\n"+
+		"(assert (not (bvugt calldata_0x4 #x"+strings.Repeat("0", 63)+"2)))\n(check-sat)\n(get-model)\n")
+
+	// the input that loops once more
+	s, e = ExportSmtLib(root, 3)
+	assert.Nil(t, e)
+	assert.Contains(t, s, "<yes> (flipped)\n(assert (bvugt calldata_0x4 #x"+strings.Repeat("0", 63)+"2))\n(check-sat)")
+
+	s, e = ExportSmtLib(root, 1)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, strings.Count(s, "(assert "))
+
+	_, e = ExportSmtLib(root, 4)
+	assert.NotNil(t, e)
+}
+
+func TestExportSmtLibInputs(t *testing.T) {
+	sto := &Storage{Slot: c(1), Val: c(100)}
+	hash := &Sha3{}
+	hash.Val.SetUint64(0x1234)
+	caller := &NullaryOp{OpNode: OpNode{vm.CALLER}}
+	gas := &NullaryOp{OpNode: OpNode{vm.GAS}}
+	gas.Val.SetUint64(5000)
+
+	root := NewCall(vm.CALL, nil, nil)
+	root.AddTrace(&StorageWrite{IsGetOnline: true, Storage: sto})
+	// timestamp - storage[1] < 0x10
+	root.AddTrace(&If{Cond: bin(vm.LT, bin(vm.SUB, &NullaryOp{OpNode: OpNode{vm.TIMESTAMP}}, sto), c(0x10)), Taken: true})
+	// (sha3 % 100) == caller
+	root.AddTrace(&If{Cond: bin(vm.EQ, bin(vm.MOD, hash, c(100)), caller)})
+	// written storage is an expression
+	written := &Storage{Slot: c(2), Val: bin(vm.ADD, caller, c(1))}
+	root.AddTrace(&If{Cond: bin(vm.GT, written, gas), Taken: true})
+	root.AddTrace(&If{Cond: &TernaryOp{OpNode: OpNode{vm.ADDMOD}, X: caller, Y: c(1), Z: c(7)}})
+
+	s, e := ExportSmtLib(root, -1)
+	assert.Nil(t, e)
+	for _, line := range []string{
+		"(declare-const timestamp (_ BitVec 256))",
+		"(declare-const storage_0x1 (_ BitVec 256)) ; Storage[0x1]",
+		"(declare-const sha3 (_ BitVec 256)) ; Sha3_",
+		" = 0x1234 when traced",
+		"(declare-const caller (_ BitVec 256))",
+		"(assert (bvult (bvsub timestamp storage_0x1) #x" + strings.Repeat("0", 62) + "10))",
+		"(assert (not (= (ite (= #x" + strings.Repeat("0", 62) + "64 #x" + strings.Repeat("0", 64) + ") ",
+		"(assert (bvule caller #x000000000000000000000000ffffffffffffffffffffffffffffffffffffffff))",
+		"(assert (bvugt (bvadd caller #x" + strings.Repeat("0", 63) + "1) #x" + smt_const(uint256.NewInt(5000))[2:] + "))",
+		"(bvurem (bvadd ((_ zero_extend 256) caller) ",
+	} {
+		assert.Contains(t, s, line)
+	}
+	assert.NotContains(t, s, "gas")
+}
+
+func TestExportSmtLibSubCall(t *testing.T) {
+	outer := &NullaryOp{OpNode: OpNode{vm.CALLER}}
+	inner := &NullaryOp{OpNode: OpNode{vm.CALLER}}
+	root := NewCall(vm.CALL, nil, nil)
+	root.AddTrace(&If{Cond: bin(vm.EQ, outer, c(1))})
+	sub := NewCall(vm.CALL, nil, nil)
+	sub.AddTrace(&If{Cond: bin(vm.EQ, inner, &UnaryOp{OpNode: OpNode{vm.CALLDATALOAD}, X: c(4)})})
+	root.AddTrace(sub)
+
+	s, e := ExportSmtLib(root, -1)
+	assert.Nil(t, e)
+	for _, line := range []string{
+		"(declare-const caller (_ BitVec 256))",
+		"(declare-const caller_f1 (_ BitVec 256))",
+		"(declare-const calldata_0x4_f1 (_ BitVec 256))",
+		"(assert (bvule caller_f1 #x",
+		"(assert (not (= caller_f1 calldata_0x4_f1)))",
+	} {
+		assert.Contains(t, s, line)
+	}
+}
@@ -0,0 +1,491 @@
+package symbolic
+
+import (
+	"fmt"
+	"math/big"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// Candidate values of a variable
+type Domain interface {
+	Len() uint64
+	At(i uint64) uint256.Int
+}
+
+// From, From+Step, ..., up to To
+type Range struct {
+	From, To uint256.Int
+	Step     uint64
+}
+
+func (r *Range) Len() uint64 {
+	var n uint256.Int
+	n.Sub(&r.To, &r.From)
+	n.Div(&n, uint256.NewInt(r.Step))
+	return n.Uint64() + 1
+}
+
+func (r *Range) At(i uint64) uint256.Int {
+	var v uint256.Int
+	v.Mul(uint256.NewInt(i), uint256.NewInt(r.Step))
+	v.Add(&v, &r.From)
+	return v
+}
+
+type Candidates []uint256.Int
+
+func (c Candidates) Len() uint64 {
+	return uint64(len(c))
+}
+func (c Candidates) At(i uint64) uint256.Int {
+	return c[i]
+}
+
+func parse_u256(s string) (*uint256.Int, error) {
+	if strings.HasPrefix(s, "0x") {
+		return uint256.FromHex(s)
+	}
+	b, ok := new(big.Int).SetString(s, 10)
+	if !ok || b.Sign() < 0 {
+		return nil, errors.Errorf("invalid number: %s", s)
+	}
+	u, overflow := uint256.FromBig(b)
+	if overflow {
+		return nil, errors.Errorf("number overflow: %s", s)
+	}
+	return u, nil
+}
+
+/*
+Parse a domain:
+  - `from..to`, `from..to:step`: range, eg: `1650000000..1650086400`
+  - `v1,v2,...`: candidate list, eg: `0x8ba1...72,0x4a3f...c1`
+*/
+func ParseDomain(s string) (Domain, error) {
+	if from, to, ok := strings.Cut(s, ".."); ok {
+		r := &Range{Step: 1}
+		if t, step, ok := strings.Cut(to, ":"); ok {
+			to = t
+			st, e := parse_u256(step)
+			if e != nil || st.IsZero() || !st.IsUint64() {
+				return nil, errors.Errorf("invalid step: %s", step)
+			}
+			r.Step = st.Uint64()
+		}
+		f, e1 := parse_u256(from)
+		t, e2 := parse_u256(to)
+		if e1 != nil || e2 != nil {
+			return nil, errors.Errorf("invalid range: %s", s)
+		}
+		if t.Lt(f) {
+			return nil, errors.Errorf("empty range: %s", s)
+		}
+		r.From, r.To = *f, *t
+		var n uint256.Int
+		if !n.Sub(t, f).IsUint64() || n.Uint64() == ^uint64(0) {
+			return nil, errors.Errorf("range too large: %s", s)
+		}
+		return r, nil
+	}
+	ret := Candidates{}
+	for _, v := range strings.Split(s, ",") {
+		u, e := parse_u256(strings.TrimSpace(v))
+		if e != nil {
+			return nil, errors.Errorf("invalid value: %s", v)
+		}
+		ret = append(ret, *u)
+	}
+	return ret, nil
+}
+
+// A leaf node treated as variable, the name is:
+//   - `TIMESTAMP`, `CALLER`, ...: opcode name of `NullaryOp`
+//   - `calldata_0x4`: the word of `CALLDATALOAD(0x4)`
+//   - `storage_0x1`: storage slot 1 that fetched from chain
+type Var struct {
+	Name   string
+	Domain Domain
+}
+
+// variable name -> value
+type Solution map[string]uint256.Int
+
+func (s Solution) String() string {
+	names := []string{}
+	for k := range s {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	ss := []string{}
+	for _, k := range names {
+		v := s[k]
+		ss = append(ss, fmt.Sprintf("%s = %s (%s)", k, v.ToBig().String(), v.Hex()))
+	}
+	return strings.Join(ss, ", ")
+}
+
+// solve the conditions by enumerating all candidates
+type solver struct {
+	vars   []Var
+	online map[*Storage]bool
+
+	frames map[Node]int // node -> call frame
+
+	leaves map[Node]int  // leaf -> index of vars
+	deps   map[Node]bool // depends on any variable
+	traced map[Node]uint256.Int
+}
+
+func (s *solver) leaf_name(n Node) string {
+	switch n := n.(type) {
+	case *NullaryOp:
+		if frameInputs[n.OpCode] {
+			return frame_name(n.String(), s.frames[n])
+		}
+		return n.String()
+	case *UnaryOp:
+		if n.OpCode == vm.CALLDATALOAD {
+			if off, ok := n.X.(*Const); ok {
+				return frame_name("calldata_"+off.Val.Hex(), s.frames[n])
+			}
+		}
+	case *Storage:
+		if s.online[n] {
+			if slot, ok := EvaluateConst(n.Slot); ok {
+				return frame_name("storage_"+slot.Hex(), s.frames[n])
+			}
+		}
+	}
+	return ""
+}
+
+// operands of the node, for tracking dependencies
+func children(n Node) []Node {
+	switch n := n.(type) {
+	case *UnaryOp:
+		return []Node{n.X}
+	case *BinaryOp:
+		return []Node{n.X, n.Y}
+	case *TernaryOp:
+		return []Node{n.X, n.Y, n.Z}
+	case *Storage:
+		return []Node{n.Val}
+	case *Memory:
+		return []Node{n.Val}
+	case *Sha3:
+		ret := []Node{}
+		for _, m := range n.Input {
+			ret = append(ret, m)
+		}
+		return ret
+	}
+	return nil
+}
+
+// find variables and the nodes depend on them
+func (s *solver) prepare(n Node) bool {
+	if n == nil {
+		return false
+	}
+	if d, ok := s.deps[n]; ok {
+		return d
+	}
+	s.deps[n] = false // for cycles
+
+	dep := false
+	name := s.leaf_name(n)
+	for i, v := range s.vars {
+		if name != "" && strings.EqualFold(v.Name, name) {
+			s.leaves[n] = i
+			dep = true
+		}
+	}
+	if !dep {
+		for _, ch := range children(n) {
+			if s.prepare(ch) {
+				dep = true
+			}
+		}
+	}
+	s.deps[n] = dep
+	if !dep {
+		if v, ok := s.eval(n, nil, nil); ok {
+			s.traced[n] = v
+		}
+	}
+	return dep
+}
+
+// Evaluate the node with the variable values, `memo` is for shared nodes.
+// Nodes that are not modeled use the traced value.
+func (s *solver) eval(n Node, values []uint256.Int, memo map[Node]uint256.Int) (uint256.Int, bool) {
+	if v, ok := s.traced[n]; ok {
+		return v, true
+	}
+	if i, ok := s.leaves[n]; ok && values != nil {
+		return values[i], true
+	}
+	if v, ok := memo[n]; ok {
+		return v, true
+	}
+
+	v, ok := s.eval_node(n, values, memo)
+	if ok && memo != nil {
+		memo[n] = v
+	}
+	return v, ok
+}
+
+func (s *solver) eval_node(n Node, values []uint256.Int, memo map[Node]uint256.Int) (uint256.Int, bool) {
+	switch n := n.(type) {
+	case *Const:
+		return n.Val, true
+	case *NullaryOp:
+		return n.Val, true
+	case *UnaryOp:
+		switch n.OpCode {
+		case vm.ISZERO, vm.NOT:
+			x, ok := s.eval(n.X, values, memo)
+			if !ok {
+				return x, false
+			}
+			return EvaluateConst(&UnaryOp{OpNode: n.OpNode, X: NewConst(&x)})
+		}
+		return n.Val, true // CALLDATALOAD, EXTCODESIZE, ...
+	case *BinaryOp:
+		x, okx := s.eval(n.X, values, memo)
+		y, oky := s.eval(n.Y, values, memo)
+		if !okx || !oky {
+			return uint256.Int{}, false
+		}
+		return evaluate_binary(n.OpCode, x, y)
+	case *TernaryOp:
+		x, okx := s.eval(n.X, values, memo)
+		y, oky := s.eval(n.Y, values, memo)
+		z, okz := s.eval(n.Z, values, memo)
+		if !okx || !oky || !okz {
+			return uint256.Int{}, false
+		}
+		return EvaluateConst(&TernaryOp{OpNode: n.OpNode, X: NewConst(&x), Y: NewConst(&y), Z: NewConst(&z)})
+	case *Storage:
+		return s.eval(n.Val, values, memo)
+	case *Memory:
+		if n.Val == nil {
+			return uint256.Int{}, false
+		}
+		return s.eval(n.Val, values, memo)
+	case *Sha3:
+		if values == nil || len(n.Data) != int(n.Size) {
+			return n.Val, true
+		}
+		// the traced input, with the changed memory replaced
+		data := util.CloneSlice(n.Data)
+		for _, m := range n.Input {
+			if !s.deps[m] || m.VmOffset < n.Offset {
+				continue
+			}
+			v, ok := s.eval(m, values, memo)
+			if !ok {
+				return v, false
+			}
+			if len(m.VmBytes) != 32 && len(m.VmBytes) != 1 {
+				continue // copied region, not a word
+			}
+			b := v.Bytes32()
+			word := b[32-len(m.VmBytes):] // MSTORE8 writes 1 byte
+			copy(data[m.VmOffset-n.Offset:], word)
+		}
+		var v uint256.Int
+		v.SetBytes(util.Sha3(data))
+		return v, true
+	}
+	return uint256.Int{}, false
+}
+
+// the condition is true
+func (s *solver) test(n Node, values []uint256.Int, memo map[Node]uint256.Int) (bool, bool) {
+	v, ok := s.eval(n, values, memo)
+	return !v.IsZero(), ok
+}
+
+// candidates are split into chunks for workers
+const solveChunkSize = 1024
+
+/*
+Find variable values that flip the `target`-th path condition, by enumerating
+all combinations of the candidates on all cores:
+  - the conditions before the target must keep their direction
+  - nodes that are not modeled, like `CALLDATALOAD(x)` with symbolic `x`, use the traced value
+  - SHA3 is re-calculated with the changed memory input
+
+The first `limit` solutions in the order of candidates are returned, 0 for no limit,
+the first variable changes fastest.
+Variables of sub calls are suffixed with the frame, eg: `CALLER_f1`, see `PathConditions`.
+Optimized trees are not supported, because rewritten nodes like `func_sig` can't be evaluated,
+use the tree from the tracer.
+*/
+func Solve(root Node, target int, vars []Var, limit int) ([]Solution, error) {
+	conds := PathConditions(root)
+	if target < 0 || target >= len(conds) {
+		return nil, errors.Errorf("condition %d out of range [0, %d)", target, len(conds))
+	}
+	if len(vars) == 0 {
+		return nil, errors.New("no variable")
+	}
+
+	s := &solver{
+		vars:   vars,
+		online: map[*Storage]bool{},
+		frames: frame_ids(root),
+		leaves: map[Node]int{},
+		deps:   map[Node]bool{},
+		traced: map[Node]uint256.Int{},
+	}
+	Walk(root, func(c *Cursor) {
+		if sw, ok := c.Node.(*StorageWrite); ok && sw.IsGetOnline {
+			s.online[sw.Storage] = true
+		}
+	})
+
+	// only the conditions that depend on variables matter
+	type check struct {
+		cond  Node
+		taken bool
+	}
+	checks := []check{}
+	for i, n := range conds[:target+1] {
+		if !s.prepare(n.Cond) {
+			if i == target {
+				return nil, errors.Errorf("condition %d doesn't depend on the variables", target)
+			}
+			continue
+		}
+		if _, ok := s.eval(n.Cond, nil, map[Node]uint256.Int{}); !ok {
+			return nil, errors.Errorf("condition %d can't be evaluated: %s", i, n.Cond)
+		}
+		taken := n.Taken
+		if i == target {
+			taken = !taken
+		}
+		checks = append(checks, check{n.Cond, taken})
+	}
+	for _, v := range vars {
+		found := false
+		for _, i := range s.leaves {
+			found = found || strings.EqualFold(vars[i].Name, v.Name)
+		}
+		if !found {
+			return nil, errors.Errorf("variable %s not found in the conditions", v.Name)
+		}
+	}
+
+	// total combinations
+	total := uint64(1)
+	for _, v := range vars {
+		n := v.Domain.Len()
+		if n == 0 {
+			return nil, nil
+		}
+		if total > ^uint64(0)/n {
+			return nil, errors.New("too many combinations")
+		}
+		total *= n
+	}
+
+	var (
+		mu      sync.Mutex
+		results = map[uint64]Solution{}
+		counts  = map[uint64]int{} // chunk -> solutions, for finished chunks after `next`
+		next    uint64             // chunks before it are all finished
+		inOrder int                // solutions in the chunks before `next`
+		stop    int32
+		chunks  = make(chan uint64)
+		wg      sync.WaitGroup
+	)
+	// chunks finish in any order, stop only when the chunks before `next`
+	// have enough solutions, so the result is always the first `limit` ones
+	finish_chunk := func(start uint64, found map[uint64]Solution) {
+		mu.Lock()
+		defer mu.Unlock()
+		for idx, sol := range found {
+			results[idx] = sol
+		}
+		counts[start] = len(found)
+		for {
+			n, ok := counts[next]
+			if !ok {
+				break
+			}
+			delete(counts, next)
+			inOrder += n
+			next += solveChunkSize
+		}
+		if limit > 0 && inOrder >= limit {
+			atomic.StoreInt32(&stop, 1)
+		}
+	}
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			values := make([]uint256.Int, len(vars))
+			for start := range chunks {
+				found := map[uint64]Solution{}
+				for idx := start; idx < start+solveChunkSize && idx < total; idx++ {
+					// mixed radix index -> values
+					k := idx
+					for i, v := range vars {
+						values[i] = v.Domain.At(k % v.Domain.Len())
+						k /= v.Domain.Len()
+					}
+					memo := map[Node]uint256.Int{}
+					ok := true
+					for _, c := range checks {
+						if t, valid := s.test(c.cond, values, memo); !valid || t != c.taken {
+							ok = false
+							break
+						}
+					}
+					if !ok {
+						continue
+					}
+					sol := Solution{}
+					for i, v := range vars {
+						sol[v.Name] = values[i]
+					}
+					found[idx] = sol
+				}
+				finish_chunk(start, found)
+			}
+		}()
+	}
+	for start := uint64(0); start < total && atomic.LoadInt32(&stop) == 0; start += solveChunkSize {
+		chunks <- start
+	}
+	close(chunks)
+	wg.Wait()
+
+	// in the order of candidates
+	indexes := []uint64{}
+	for idx := range results {
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	ret := []Solution{}
+	for _, idx := range indexes {
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+		ret = append(ret, results[idx])
+	}
+	return ret, nil
+}
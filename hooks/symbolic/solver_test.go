@@ -0,0 +1,117 @@
+package symbolic
+
+import (
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+// if (keccak256(abi.encode(block.timestamp, msg.sender)) % 100 < 5) { slot0 = 1 }
+const rareCode = "4260005233602052604060002060649006600511601857005b600160005500"
+
+func rare(ts uint64, caller []byte) bool {
+	data := make([]byte, 64)
+	uint256.NewInt(ts).WriteToSlice(data[:32])
+	copy(data[64-len(caller):], caller)
+	var v uint256.Int
+	v.SetBytes(util.Sha3(data))
+	return v.Mod(&v, uint256.NewInt(100)).LtUint64(5)
+}
+
+func TestSolve(t *testing.T) {
+	var ts uint64
+	var caller common.Address
+	root := trace(t, rareCode, func(ctx *edb.Context) {
+		ts, caller = ctx.Block.Timestamp, ctx.Msg().Sender
+	})
+
+	conds := PathConditions(root)
+	assert.Equal(t, 1, len(conds))
+	assert.Equal(t, rare(ts, caller[:]), conds[0].Taken)
+
+	// all timestamps in the range that go the other way
+	domain, e := ParseDomain("0x0..1999")
+	assert.Nil(t, e)
+	expected := []uint64{}
+	for i := uint64(0); i < 2000; i++ {
+		if rare(i, caller[:]) != conds[0].Taken {
+			expected = append(expected, i)
+		}
+	}
+	sols, e := Solve(root, 0, []Var{{"timestamp", domain}}, 0)
+	assert.Nil(t, e)
+	assert.Equal(t, len(expected), len(sols))
+	for i, s := range sols {
+		v := s["timestamp"]
+		assert.Equal(t, expected[i], v.Uint64())
+	}
+
+	// 2 variables, limited
+	callers, e := ParseDomain("0x1,0x2,0x3")
+	assert.Nil(t, e)
+	sols, e = Solve(root, 0, []Var{{"TIMESTAMP", domain}, {"CALLER", callers}}, 3)
+	assert.Nil(t, e)
+	assert.Equal(t, 3, len(sols))
+	for _, s := range sols {
+		ts, caller := s["TIMESTAMP"], s["CALLER"]
+		assert.NotEqual(t, conds[0].Taken, rare(ts.Uint64(), caller.Bytes()))
+	}
+	assert.Contains(t, sols[0].String(), "CALLER = ")
+
+	// always the first solutions in the order of candidates
+	all, e := Solve(root, 0, []Var{{"TIMESTAMP", domain}, {"CALLER", callers}}, 0)
+	assert.Nil(t, e)
+	for i := 0; i < 5; i++ {
+		sols, e = Solve(root, 0, []Var{{"TIMESTAMP", domain}, {"CALLER", callers}}, 3)
+		assert.Nil(t, e)
+		assert.Equal(t, all[:3], sols)
+	}
+
+	_, e = Solve(root, 0, []Var{{"NUMBER", domain}}, 0)
+	assert.ErrorContains(t, e, "doesn't depend")
+	_, e = Solve(root, 1, []Var{{"TIMESTAMP", domain}}, 0)
+	assert.NotNil(t, e)
+}
+
+func TestParseDomain(t *testing.T) {
+	d, e := ParseDomain("10..20:5")
+	assert.Nil(t, e)
+	assert.Equal(t, uint64(3), d.Len())
+	v := d.At(2)
+	assert.Equal(t, uint64(20), v.Uint64())
+
+	d, e = ParseDomain("0x10, 7")
+	assert.Nil(t, e)
+	assert.Equal(t, uint64(2), d.Len())
+	v = d.At(1)
+	assert.Equal(t, uint64(7), v.Uint64())
+
+	for _, s := range []string{"2..1", "1..x", "1..2:0", "a,b", "-1", "0x0..0x1ffffffffffffffffffffffff"} {
+		_, e := ParseDomain(s)
+		assert.NotNil(t, e, s)
+	}
+}
+
+func TestSolveSubCall(t *testing.T) {
+	// `CALLER` of the sub call is another variable
+	inner := &NullaryOp{OpNode: OpNode{vm.CALLER}}
+	inner.Val.SetUint64(0x10)
+	root := NewCall(vm.CALL, nil, nil)
+	sub := NewCall(vm.CALL, nil, nil)
+	sub.AddTrace(&If{Cond: bin(vm.EQ, inner, c(0x20))})
+	root.AddTrace(sub)
+
+	domain, _ := ParseDomain("0x1f..0x21")
+	_, e := Solve(root, 0, []Var{{"CALLER", domain}}, 0)
+	assert.NotNil(t, e)
+	sols, e := Solve(root, 0, []Var{{"CALLER_f1", domain}}, 0)
+	assert.Nil(t, e)
+	assert.Equal(t, 1, len(sols))
+	v := sols[0]["CALLER_f1"]
+	assert.Equal(t, uint64(0x20), v.Uint64())
+}
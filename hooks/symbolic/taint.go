@@ -0,0 +1,299 @@
+package symbolic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// Kinds of weak randomness sources
+const (
+	SourceBlock   = "block"   // chosen by the miner, readable by contracts in the same block
+	SourceTx      = "tx"      // chosen by the sender
+	SourceStorage = "storage" // fetched from chain, readable by anyone, eg: a mint counter
+)
+
+var blockSources = map[vm.OpCode]bool{
+	vm.TIMESTAMP: true, vm.NUMBER: true, vm.DIFFICULTY: true, vm.COINBASE: true,
+	vm.GASLIMIT: true, vm.BASEFEE: true, edb.BLOBBASEFEE: true,
+}
+var txSources = map[vm.OpCode]bool{
+	vm.CALLER: true, vm.ORIGIN: true, vm.CALLVALUE: true, vm.CALLDATASIZE: true,
+	vm.GASPRICE: true, vm.GAS: true,
+}
+
+// these are not known before the tx is executed, eg: result of an external call
+var opaqueLabels = map[string]bool{
+	"Unknown_Memory": true, "ReturnDataSize": true, "ReturnValue": true,
+}
+
+// A value known or controlled by the attacker before sending the tx
+type Source struct {
+	Name string // eg: "TIMESTAMP", "calldata_0x4", "Storage[totalSupply]"
+	Kind string
+}
+
+func (s Source) String() string {
+	return fmt.Sprintf("%s(%s)", s.Name, s.Kind)
+}
+
+// Block, storage and the sender's address, they look random but are known before the tx is sent.
+// Other tx sources are the inputs chosen by the sender, eg: calldata, branches on them are input checks
+func (s Source) weak() bool {
+	return s.Kind != SourceTx || s.Name == "CALLER" || s.Name == "ORIGIN"
+}
+
+// What a value depends on
+type taint struct {
+	sources map[string]Source
+	opaque  bool // depends on something unknown
+}
+
+func (t *taint) merge(o *taint) {
+	for k, s := range o.sources {
+		t.sources[k] = s
+	}
+	t.opaque = t.opaque || o.opaque
+}
+
+type tainter struct {
+	online map[*Storage]bool
+	memo   map[Node]*taint
+}
+
+func taint_source(name, kind string) *taint {
+	return &taint{sources: map[string]Source{name: {name, kind}}}
+}
+
+func (tt *tainter) of(n Node) *taint {
+	if t, ok := tt.memo[n]; ok {
+		return t
+	}
+	t := &taint{sources: map[string]Source{}}
+	tt.memo[n] = t // for cycles
+	t.merge(tt.node(n))
+	return t
+}
+
+func (tt *tainter) union(nodes ...Node) *taint {
+	t := &taint{sources: map[string]Source{}}
+	for _, n := range nodes {
+		t.merge(tt.of(n))
+	}
+	return t
+}
+
+func (tt *tainter) node(n Node) *taint {
+	switch n := n.(type) {
+	case nil:
+		return &taint{sources: map[string]Source{}, opaque: true}
+	case *NullaryOp:
+		if blockSources[n.OpCode] {
+			return taint_source(n.String(), SourceBlock)
+		}
+		if txSources[n.OpCode] {
+			return taint_source(n.String(), SourceTx)
+		}
+		// ADDRESS, CHAINID, SELFBALANCE, ... are known
+	case *UnaryOp:
+		switch n.OpCode {
+		case vm.CALLDATALOAD:
+			name := "calldata"
+			if off, ok := EvaluateConst(n.X); ok {
+				name += "_" + off.Hex()
+			}
+			t := taint_source(name, SourceTx)
+			t.merge(tt.of(n.X))
+			return t
+		case vm.BLOCKHASH:
+			t := taint_source("BLOCKHASH", SourceBlock)
+			t.merge(tt.of(n.X))
+			return t
+		case edb.BLOBHASH:
+			return taint_source("BLOBHASH", SourceTx)
+		}
+		return tt.union(n.X)
+	case *BinaryOp:
+		return tt.union(n.X, n.Y)
+	case *TernaryOp:
+		return tt.union(n.X, n.Y, n.Z)
+	case *Func:
+		return tt.union(n.Args...)
+	case *Storage:
+		if tt.online[n] {
+			t := taint_source(n.String(), SourceStorage)
+			t.merge(tt.of(n.Slot)) // eg: balances[CALLER]
+			return t
+		}
+		return tt.union(n.Val) // written in this tx
+	case *Memory:
+		return tt.union(n.Val)
+	case *Sha3:
+		t := &taint{sources: map[string]Source{}}
+		for _, m := range n.Input {
+			t.merge(tt.of(m))
+		}
+		return t
+	case *Label:
+		if n.Str == "func_sig" {
+			return taint_source("calldata_0x0", SourceTx)
+		}
+		return &taint{sources: map[string]Source{}, opaque: opaqueLabels[n.Str]}
+	case *ReturnValue:
+		return &taint{sources: map[string]Source{}, opaque: true}
+	}
+	return &taint{sources: map[string]Source{}}
+}
+
+// A branch, storage write, event or return value, and the sources it depends on
+type Tainted struct {
+	Sink    Node // *If, *StorageWrite, *Log, or *Call for its return value
+	Sources []Source
+
+	// depends on some block, storage or CALLER/ORIGIN sources and nothing unknown,
+	// the attacker can predict it before sending the tx
+	Predictable bool
+
+	// depends only on the inputs chosen by the sender, eg: the selector dispatch
+	InputOnly bool
+}
+
+func (t *Tainted) Describe() string {
+	switch n := t.Sink.(type) {
+	case *If:
+		return fmt.Sprintf("pc %d: %s", n.Pc, n.String())
+	case *Log:
+		if n.Event != "" {
+			return "Event: " + n.Event
+		}
+		line, _, _ := strings.Cut(n.String(), "\n")
+		return line
+	case *Call:
+		if sig := n.FuncSig(); sig != "" {
+			return "return of " + sig
+		}
+		return "return"
+	}
+	return t.Sink.String()
+}
+
+/*
+Find the sources that the branches, storage writes, events and return values depend on:
+  - block: `TIMESTAMP`, `NUMBER`, `DIFFICULTY`(PREVRANDAO), `COINBASE`, `BLOCKHASH`, ...
+  - tx: `CALLER`, `ORIGIN`, `CALLVALUE`, calldata, ...
+  - storage: storage fetched from chain, eg: a counter
+
+SHA3 is not a barrier, its result depends on the input memory.
+Only the data flow is tracked, values that depend on nothing are not reported.
+*/
+func TaintAnalysis(root Node) []*Tainted {
+	tt := &tainter{online: map[*Storage]bool{}, memo: map[Node]*taint{}}
+	Walk(root, func(c *Cursor) {
+		if sw, ok := c.Node.(*StorageWrite); ok && sw.IsGetOnline {
+			tt.online[sw.Storage] = true
+		}
+	})
+
+	ret := []*Tainted{}
+	add := func(sink Node, t *taint) {
+		if len(t.sources) == 0 && !t.opaque {
+			return
+		}
+		r := &Tainted{Sink: sink}
+		weak := false
+		for _, s := range t.sources {
+			r.Sources = append(r.Sources, s)
+			weak = weak || s.weak()
+		}
+		r.Predictable = weak && !t.opaque
+		r.InputOnly = len(t.sources) > 0 && !weak && !t.opaque
+		sort.Slice(r.Sources, func(i, j int) bool {
+			return r.Sources[i].Name < r.Sources[j].Name
+		})
+		ret = append(ret, r)
+	}
+	Walk(root, func(c *Cursor) {
+		switch n := c.Node.(type) {
+		case *If:
+			add(n, tt.of(n.Cond))
+		case *StorageWrite:
+			if !n.IsGetOnline {
+				add(n, tt.of(n.Val))
+			}
+		case *Log:
+			t := tt.union(n.Topics...)
+			for _, m := range n.Mem {
+				t.merge(tt.of(m))
+			}
+			add(n, t)
+		case *Call:
+			t := &taint{sources: map[string]Source{}}
+			for _, m := range n.ReturnMem {
+				t.merge(tt.of(m))
+			}
+			add(n, t)
+		}
+	})
+	return ret
+}
+
+/*
+The predictability report, branches decided only by the attacker-known inputs are flagged,
+branches decided only by calldata, CALLVALUE, ... are input checks, they are listed separately:
+
+	[!] pc 24: if ((Sha3_0xc000123456 % 0x64) < 0x5) <no>
+	    sources: CALLER(tx), TIMESTAMP(block)
+
+	input checks:
+	    pc 10: if (func_sig == 0x3bc5de30) <yes>
+*/
+func PredictabilityReport(taints []*Tainted) string {
+	sb := &strings.Builder{}
+	branches, flagged := 0, 0
+	checks := []*Tainted{}
+	for _, t := range taints {
+		if _, ok := t.Sink.(*If); ok {
+			if t.InputOnly {
+				checks = append(checks, t)
+				continue
+			}
+			branches++
+			if t.Predictable {
+				flagged++
+			}
+		}
+	}
+	fmt.Fprintf(sb, "%d of %d tainted branches are decided only by attacker-known inputs\n", flagged, branches)
+
+	for _, t := range taints {
+		mark := "   "
+		if _, ok := t.Sink.(*If); ok {
+			if t.InputOnly {
+				continue
+			}
+			if t.Predictable {
+				mark = "[!]"
+			}
+		}
+		fmt.Fprintf(sb, "%s %s\n", mark, t.Describe())
+		ss := []string{}
+		for _, s := range t.Sources {
+			ss = append(ss, s.String())
+		}
+		if !t.Predictable && !t.InputOnly {
+			ss = append(ss, "unknown")
+		}
+		fmt.Fprintf(sb, "    sources: %s\n", strings.Join(ss, ", "))
+	}
+
+	if len(checks) > 0 {
+		fmt.Fprintf(sb, "\ninput checks:\n")
+		for _, t := range checks {
+			fmt.Fprintf(sb, "    %s\n", t.Describe())
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,92 @@
+package symbolic
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/stretchr/testify/assert"
+)
+
+func source_names(tt *Tainted) []string {
+	ret := []string{}
+	for _, s := range tt.Sources {
+		ret = append(ret, s.String())
+	}
+	return ret
+}
+
+func TestTaintAnalysis(t *testing.T) {
+	// the branch depends on keccak256(TIMESTAMP, CALLER)
+	taints := TaintAnalysis(trace(t, rareCode, nil))
+	assert.Equal(t, 1, len(taints))
+	assert.IsType(t, &If{}, taints[0].Sink)
+	assert.True(t, taints[0].Predictable)
+	assert.Equal(t, []string{"CALLER(tx)", "TIMESTAMP(block)"}, source_names(taints[0]))
+
+	// mstore(0, CALLER)
+	// log1(0, 0x20, NUMBER)
+	// if mload(0x40) {}  // unknown memory
+	// return(0, 0x20)
+	taints = TaintAnalysis(trace(t, "336000524360206000a16040516010575b60206000f3", nil))
+	assert.Equal(t, 3, len(taints))
+
+	assert.IsType(t, &Log{}, taints[0].Sink)
+	assert.Equal(t, []string{"CALLER(tx)", "NUMBER(block)"}, source_names(taints[0]))
+
+	assert.IsType(t, &If{}, taints[1].Sink)
+	assert.False(t, taints[1].Predictable)
+	assert.Empty(t, taints[1].Sources)
+
+	assert.IsType(t, &Call{}, taints[2].Sink)
+	assert.True(t, taints[2].Predictable)
+	assert.Equal(t, []string{"CALLER(tx)"}, source_names(taints[2]))
+
+	report := PredictabilityReport(taints)
+	assert.Contains(t, report, "0 of 1 tainted branches")
+	assert.Contains(t, report, "sources: unknown")
+
+	// if (counter % 10 == 0) counter = counter + 1
+	sto := &Storage{Slot: c(0), Val: c(7), Name: "counter"}
+	root := &Block{List: []Node{
+		&StorageWrite{IsGetOnline: true, Storage: sto},
+		&If{Cond: bin(vm.EQ, bin(vm.MOD, sto, c(10)), c(0))},
+		&StorageWrite{Storage: &Storage{Slot: c(0), Val: bin(vm.ADD, sto, c(1))}},
+	}}
+	taints = TaintAnalysis(root)
+	assert.Equal(t, 2, len(taints))
+	for _, tt := range taints {
+		assert.True(t, tt.Predictable)
+		assert.Equal(t, []string{"Storage[counter](storage)"}, source_names(tt))
+	}
+	report = PredictabilityReport(taints)
+	assert.Contains(t, report, "1 of 1 tainted branches")
+	assert.Contains(t, report, "[!] pc 0: if ")
+}
+
+func TestTaintDispatcher(t *testing.T) {
+	// the sample contract, its selector dispatch and CALLVALUE check are input checks
+	ctx := edb.NewSampleContext()
+	ctx.Chain.Offline = true
+	tr := NewHighLevelTracer(ctx)
+	ctx.Hooks.Attach(tr)
+	assert.Nil(t, ctx.Run(-1))
+
+	taints := TaintAnalysis(tr.CallStack.Data[0])
+	dispatch := 0
+	for _, tt := range taints {
+		n, ok := tt.Sink.(*If)
+		if !ok || !strings.Contains(n.String(), "0x3bc5de30 ==") {
+			continue
+		}
+		dispatch++
+		assert.False(t, tt.Predictable)
+		assert.True(t, tt.InputOnly)
+	}
+	assert.NotZero(t, dispatch)
+
+	report := PredictabilityReport(taints)
+	assert.NotContains(t, report, "[!]")
+	assert.Contains(t, report, "\ninput checks:\n    pc 11: if !(CALLVALUE)")
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/holiman/uint256"
+)
+
+const callUsage = `usage: call <node_url> <from> <to> <calldata> [options...]
+options:
+  value=<wei>  gas=<n>  gasprice=<wei>  block=<n|latest>
+  timestamp=<n|+n>  number=<n|+n>  coinbase=<addr>  basefee=<n>
+  prevrandao=<n>  difficulty=<n>
+  balance.<addr>=<wei>  code.<addr>=<hex>  storage.<addr>.<slot>=<value>`
+
+func parse_big(s string) (*big.Int, error) {
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", s)
+	}
+	return v, nil
+}
+
+// a 256-bit word, eg: storage slot and value
+func parse_word(s string) (*big.Int, error) {
+	v, e := parse_big(s)
+	if e != nil {
+		return nil, e
+	}
+	if v.Sign() < 0 || v.BitLen() > 256 {
+		return nil, fmt.Errorf("not a 256-bit word: %s", s)
+	}
+	return v, nil
+}
+
+// parse "n" or "+n"
+func parse_maybe_delta(s string) (val uint64, isDelta bool, e error) {
+	if strings.HasPrefix(s, "+") {
+		isDelta = true
+		s = s[1:]
+	}
+	val, e = parse_any_int(s)
+	return
+}
+
+func parse_call_args(arg []string) (*edb.CallArgs, error) {
+	if len(arg) < 4 {
+		return nil, errors.New(callUsage)
+	}
+	data, e := hexutil.Decode(arg[3])
+	if e != nil {
+		return nil, fmt.Errorf("invalid calldata: %s", e.Error())
+	}
+	args := &edb.CallArgs{
+		From:  common.HexToAddress(arg[1]),
+		To:    common.HexToAddress(arg[2]),
+		Data:  data,
+		Block: "latest",
+		State: map[common.Address]*edb.StateOverride{},
+	}
+
+	state_of := func(addr string) *edb.StateOverride {
+		a := common.HexToAddress(addr)
+		if args.State[a] == nil {
+			args.State[a] = &edb.StateOverride{
+				Storage: map[common.Hash]*uint256.Int{},
+			}
+		}
+		return args.State[a]
+	}
+
+	for _, opt := range arg[4:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option: %s", opt)
+		}
+		key, val := kv[0], kv[1]
+		keys := strings.Split(key, ".")
+
+		switch keys[0] {
+		case "value":
+			args.Value, e = parse_big(val)
+		case "gas":
+			args.Gas, e = parse_any_int(val)
+		case "gasprice":
+			args.GasPrice, e = parse_big(val)
+		case "block":
+			args.Block = val
+		case "timestamp":
+			var v uint64
+			var isDelta bool
+			if v, isDelta, e = parse_maybe_delta(val); isDelta {
+				args.BlockOver.TimestampDelta = v
+			} else {
+				args.BlockOver.Timestamp = &v
+			}
+		case "number":
+			var v uint64
+			var isDelta bool
+			if v, isDelta, e = parse_maybe_delta(val); isDelta {
+				args.BlockOver.NumberDelta = v
+			} else {
+				args.BlockOver.Number = &v
+			}
+		case "coinbase":
+			addr := common.HexToAddress(val)
+			args.BlockOver.Coinbase = &addr
+		case "prevrandao":
+			args.BlockOver.PrevRandao, e = parse_big(val)
+		case "difficulty":
+			args.BlockOver.Difficulty, e = parse_big(val)
+		case "basefee":
+			args.BlockOver.BaseFee, e = parse_big(val)
+		case "balance":
+			if len(keys) != 2 {
+				return nil, fmt.Errorf("usage: balance.<addr>=<wei>")
+			}
+			state_of(keys[1]).Balance, e = parse_big(val)
+		case "code":
+			if len(keys) != 2 {
+				return nil, fmt.Errorf("usage: code.<addr>=<hex>")
+			}
+			state_of(keys[1]).Code, e = hexutil.Decode(val)
+		case "storage":
+			if len(keys) != 3 {
+				return nil, fmt.Errorf("usage: storage.<addr>.<slot>=<value>")
+			}
+			var slot, v *big.Int
+			if slot, e = parse_word(keys[2]); e != nil {
+				break
+			}
+			if v, e = parse_word(val); e == nil {
+				state_of(keys[1]).Storage[common.BigToHash(slot)], _ = uint256.FromBig(v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown option: %s", key)
+		}
+		if e != nil {
+			return nil, fmt.Errorf("%s: %s", key, e.Error())
+		}
+	}
+	return args, nil
+}
@@ -5,17 +5,24 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/cfg"
+	"github.com/aj3423/edb/decompile"
+	"github.com/aj3423/edb/disasm"
 	"github.com/aj3423/edb/hooks"
 	"github.com/aj3423/edb/hooks/symbolic"
+	"github.com/aj3423/edb/sigdb"
 	"github.com/aj3423/edb/util"
 	"github.com/c-bata/go-prompt"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/fatih/color"
+	"github.com/holiman/uint256"
 )
 
 // global value
@@ -23,6 +30,9 @@ var G = struct {
 	JsonFile string
 	ctx      *edb.Context
 	HiTracer *symbolic.HighLevelTracer
+	CfgRec   *cfg.Recorder
+	Rules    symbolic.Rules          // user rules for `op`
+	Expanded map[uint64]map[int]bool // loop pc -> iterations expanded by `op loop`
 }{
 	JsonFile: "sample.json",
 }
@@ -30,17 +40,40 @@ var G = struct {
 var suggestions = []prompt.Suggest{
 	{Text: "help", Description: "Show all commands"},
 	{Text: "mem [offset [size]]", Description: "Show memory"},
-	{Text: "sto", Description: "Show Storage"},
+	{Text: "sto [address]", Description: "Show Storage, as named variables if storage layout attached"},
 	{Text: "s", Description: "Show Stack items"},
 	{Text: "p [pc]", Description: "Show asm at current/target PC"},
+	{Text: "meta [address]", Description: "Show compiler metadata of current/target contract"},
+	{Text: "bt", Description: "Show call stack, delegatecall is shown as 'proxy → implementation'"},
+	{Text: "proxy [address]", Description: "Detect proxy pattern, the ABI and storage layout of implementation are used for the proxy"},
+	{Text: "funcs [address]", Description: "Show functions found in the dispatcher of current/target contract"},
+	{Text: "decompile [address] [selector]", Description: "Pseudocode of functions found in the dispatcher, jumps recorded by 'cfg record' are used"},
+	{Text: "decode [calldata] | ret <selector> <data> | log <data> <topics...>", Description: "Decode calldata/revert/return data/log by ABI or signature database"},
+	{Text: "abi [<address> <abi.json>]", Description: "List ABIs, or attach ABI to contract for decoding"},
+	{Text: "sig <selector|topic> | import <path> | save <file>", Description: "Lookup signature, import ethereum-lists 4bytes dump"},
 	{Text: "load [.json]", Description: "Reload current .json file(default: sample.json)"},
-	{Text: "save [.json]", Description: "Save context to current .json file(default: sample.json)"},
+	{Text: "save [.json] [preimages]", Description: "Save context to current .json file(default: sample.json), .gz/.zst for compression, SHA3 preimages are only saved with 'preimages', tracers are not saved"},
 	{Text: "tx <tx_hash> <node_url>", Description: "Generate .json file from archive node"},
+	{Text: "call <node_url> <from> <to> <calldata> [options]", Description: "Generate .json file for a call that is not mined"},
+	{Text: "anvil dump|load <.json> [offline]", Description: "Export/import state as Foundry Anvil state dump"},
 	{Text: "low", Description: "start low level trace"},
 	{Text: "hi", Description: "start high level trace"},
-	{Text: "op", Description: "Optimize and print result of high-level-trace"},
+	{Text: "op [loop <pc> <iteration>]", Description: "Optimize and print result of high-level-trace, loops are folded, expand an iteration of loop"},
+	{Text: "pc-export [list|<flip_index>]", Description: "Export path conditions of high-level-trace as SMT-LIB2, negate a condition by index"},
+	{Text: "taint", Description: "Report branches, storage writes, logs and return values that depend on weak randomness sources"},
+	{Text: "solve <cond_index> <var>=<domain>... [limit]", Description: "Enumerate variable values that flip a path condition, eg: solve 1 TIMESTAMP=1650000000..1650086400 CALLER=0x1,0x2"},
+	{Text: "rules [file]", Description: "Load rewrite rules for 'op', list loaded rules if no file"},
 	{Text: "log", Description: "Log every executed EVM instruction to file"},
+	{Text: "verify <trace.json> [gas]", Description: "Compare execution with geth structLog trace"},
+	{Text: "cfg record|dot|json [file] [address]", Description: "Record jumps when running, export control flow graph"},
+	{Text: "disasm [text|json|evm] [file|-] [address] [from-to]", Description: "Export annotated disassembly, 'evm' format can be assembled back by 'patch'"},
+	{Text: "patch <file.asm|bytecode.hex> [address]", Description: "Replace contract code with listing edited from 'disasm evm', or bytecode"},
+	{Text: "layout <address> <storage_layout.json|solc_output.json> [ContractName]", Description: "Attach solc storage layout to contract, storage is shown as named variables"},
+	{Text: "var <name[key].member> [address]", Description: "Read state variable by storage layout, eg: var balances[0x...]"},
+	{Text: "src [<address> <solc_output.json> [ContractName]]", Description: "Show current source line, or attach solc output for source mapping"},
 	{Text: "n", Description: "Single step"},
+	{Text: "sl", Description: "Step one source line, into calls"},
+	{Text: "nl", Description: "Step one source line, over calls"},
 	{Text: "c", Description: "Continue"},
 	{Text: "b", Description: "Breakpoint"},
 }
@@ -61,6 +94,9 @@ func completer(in prompt.Document) []prompt.Suggest {
 func show_disasm(pc uint64) {
 
 	addr := G.ctx.Call().CodeAddress()
+	if loc := G.ctx.SourceAt(addr, pc); loc != nil {
+		show_source(loc)
+	}
 	fmt.Println("---- " + addr.String())
 
 	asm := G.ctx.Code().Asm
@@ -98,7 +134,8 @@ func executor(in string) {
 	cmd := arg[0]
 
 	if G.ctx == nil &&
-		(cmd != "load" && cmd != "tx" && cmd != "help") {
+		(cmd != "load" && cmd != "tx" && cmd != "call" && cmd != "help" &&
+			cmd != "decode" && cmd != "sig") {
 
 		color.Red("'load' first")
 		return
@@ -141,8 +178,93 @@ func executor(in string) {
 			return
 		}
 
-	case "storage":
-		fmt.Println(to_pretty_json(G.ctx.Contract().Storage))
+	case "sto", "storage":
+		addr := G.ctx.This()
+		if argc > 1 {
+			addr = common.HexToAddress(arg[1])
+		}
+		storage := map[common.Hash]*uint256.Int{}
+		if c, ok := G.ctx.Contracts[addr]; ok {
+			storage = c.Storage
+		}
+		others := []common.Hash{}
+		if _, ok := G.ctx.Layouts[addr]; ok {
+			vars, rest, e := G.ctx.StorageVars(addr)
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			for _, v := range vars {
+				if v.Loaded {
+					fmt.Println(v.String())
+				} else {
+					fmt.Println(v.String() + "  (not loaded)")
+				}
+			}
+			others = rest
+		} else {
+			for slot := range storage {
+				others = append(others, slot)
+			}
+			sort.Slice(others, func(i, j int) bool {
+				return others[i].Big().Cmp(others[j].Big()) < 0
+			})
+		}
+		// raw slots, named by SHA3 preimages if possible
+		for _, slot := range others {
+			line := fmt.Sprintf("%s = %s", slot.Hex(), storage[slot].Hex())
+			if name := G.ctx.SlotName(addr, new(uint256.Int).SetBytes(slot[:])); name != "" {
+				line += "  // " + name
+			}
+			fmt.Println(line)
+		}
+		return
+
+	case "var": // read state variable by storage layout
+		if argc < 2 {
+			color.Red("usage: var <name[key].member> [address]")
+			return
+		}
+		addr := G.ctx.This()
+		if argc > 2 {
+			addr = common.HexToAddress(arg[2])
+		}
+		vars, e := G.ctx.ReadStorageVar(addr, arg[1])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		for _, v := range vars {
+			fmt.Println(v.String())
+		}
+		return
+
+	case "layout": // attach storage layout to contract
+		if argc < 3 {
+			color.Red("usage: layout <address> <storage_layout.json|solc_output.json> [ContractName]")
+			return
+		}
+		addr := common.HexToAddress(arg[1])
+		var code []byte
+		if c, ok := G.ctx.Contracts[addr]; ok {
+			code = c.Code.Binary
+		}
+		bs, e := os.ReadFile(arg[2])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		name := ""
+		if argc > 3 {
+			name = arg[3]
+		}
+		layout, e := edb.LoadStorageLayout(bs, name, code)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		G.ctx.SetStorageLayout(addr, layout)
+		color.Green("storage layout attached to %s, %d state variables", addr.Hex(), len(layout.Storage))
 		return
 	case "s", "stack":
 		fmt.Println(to_pretty_json(G.ctx.Stack()))
@@ -163,12 +285,220 @@ func executor(in string) {
 		show_disasm(pc)
 		return
 
+	case "meta", "metadata": // compiler version and source hash
+		addr := G.ctx.Call().CodeAddress()
+		if argc == 2 {
+			addr = common.HexToAddress(arg[1])
+		}
+		contract, ok := G.ctx.Contracts[addr]
+		if !ok || contract.Code.Asm == nil {
+			color.Red("no code for: %s", addr.Hex())
+			return
+		}
+		metas := contract.Code.Asm.Metadata()
+		if len(metas) == 0 {
+			color.Yellow("no metadata found")
+			return
+		}
+		for _, m := range metas {
+			fmt.Printf("pc %d, %d bytes: %s\n", m.Pc, m.Size, m.String())
+		}
+		return
+
+	case "bt", "backtrace": // call stack, "proxy → implementation" for delegatecall
+		for _, s := range G.ctx.Backtrace() {
+			fmt.Println(s)
+		}
+		return
+
+	case "proxy": // detect proxy pattern
+		addr := G.ctx.Call().CodeAddress()
+		if argc == 2 {
+			addr = common.HexToAddress(arg[1])
+		}
+		p, e := G.ctx.ResolveProxy(addr)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		if p == nil {
+			color.Yellow("not a proxy")
+			return
+		}
+		color.Green("%s → %s", addr.Hex(), p.String())
+		return
+
+	case "funcs", "functions": // selector -> entry pc
+		addr := G.ctx.Call().CodeAddress()
+		if argc == 2 {
+			addr = common.HexToAddress(arg[1])
+		}
+		funcs, e := find_functions(addr)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		if len(funcs) == 0 {
+			color.Yellow("no function found")
+			return
+		}
+		for _, f := range funcs {
+			name := ""
+			if sigs := sigdb.Default().Funcs(f.Selector); len(sigs) > 0 {
+				name = sigs[0].Text()
+			}
+			fmt.Printf("%s  pc: %-6d %-10s %s\n", f.SelectorHex(), f.Entry, f.Payable, name)
+		}
+		return
+
+	case "decompile": // pseudocode of external functions
+		addr := G.ctx.Call().CodeAddress()
+		if argc > 1 {
+			addr = common.HexToAddress(arg[1])
+		}
+		funcs, e := decompile.Decompile(G.ctx, addr, G.CfgRec)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		if argc > 2 { // only one function
+			sel, e := parse_selector(arg[2])
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			found := []*decompile.Function{}
+			for _, f := range funcs {
+				if f.Selector == sel {
+					found = append(found, f)
+				}
+			}
+			funcs = found
+		}
+		if len(funcs) == 0 {
+			color.Yellow("no function found")
+			return
+		}
+		for _, f := range funcs {
+			fmt.Println(f.String())
+		}
+		return
+
+	case "decode": // decode calldata/revert/return data/log with ABI or signature database
+		ctx, addrs := decode_ctx()
+		var d *sigdb.Decoded
+		var e error
+		switch {
+		case argc == 1: // calldata of current call
+			if G.ctx == nil {
+				color.Red("'load' first")
+				return
+			}
+			d, e = ctx.DecodeCall(G.ctx.Call().Msg.Data, addrs...)
+		case argc == 2: // calldata or revert data
+			data := parse_hex(arg[1])
+			if d, e = ctx.DecodeCall(data, addrs...); e != nil {
+				d, e = ctx.DecodeRevert(data, addrs...)
+			}
+		case argc == 4 && arg[1] == "ret": // decode ret <selector|signature> <data>
+			sel, e_ := parse_selector(arg[2])
+			if e_ != nil {
+				color.Red(e_.Error())
+				return
+			}
+			d, e = ctx.DecodeReturn(sel[:], parse_hex(arg[3]), addrs...)
+		case argc >= 4 && arg[1] == "log": // decode log <data> <topic0> [topics...]
+			topics := []common.Hash{}
+			for _, t := range arg[3:] {
+				topics = append(topics, common.HexToHash(t))
+			}
+			d, e = ctx.DecodeLog(topics, parse_hex(arg[2]), addrs...)
+		default:
+			color.Red("usage: decode [calldata] | decode ret <selector> <data> | decode log <data> <topic0> [topics...]")
+			return
+		}
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		fmt.Println(d)
+		return
+
+	case "abi": // attach ABI to contract
+		if argc == 1 { // list
+			for addr, a := range G.ctx.Abis {
+				fmt.Printf("%s  %d functions, %d events, %d errors\n",
+					addr.Hex(), len(a.Methods), len(a.Events), len(a.Errors))
+			}
+			return
+		}
+		if argc != 3 {
+			color.Red("usage: abi [<address> <abi.json>]")
+			return
+		}
+		bs, e := os.ReadFile(arg[2])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		a, e := edb.ParseAbi(bs)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		addr := common.HexToAddress(arg[1])
+		G.ctx.SetAbi(addr, a)
+		color.Green("abi attached to %s, 'save' to keep it in .json", addr.Hex())
+		return
+
+	case "sig", "signature": // signature database
+		db := sigdb.Default()
+		if argc == 3 && arg[1] == "import" { // ethereum-lists 4bytes dir, or text file
+			n, e := sigdb.ImportUser(arg[2])
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			color.Green("%d signatures imported, %d total, kept in '%s'", n, db.Len(), sigdb.UserFile)
+			return
+		}
+		if argc == 3 && arg[1] == "save" {
+			if e := db.Save(arg[2]); e != nil {
+				color.Red(e.Error())
+				return
+			}
+			color.Green("%d signatures saved to '%s'", db.Len(), arg[2])
+			return
+		}
+		if argc == 2 { // lookup selector or topic
+			bs := parse_hex(arg[1])
+			switch len(bs) {
+			case 4:
+				var sel [4]byte
+				copy(sel[:], bs)
+				for _, sig := range db.Funcs(sel) {
+					fmt.Println(sig)
+				}
+				return
+			case 32:
+				if sig := db.Event(common.BytesToHash(bs)); sig != nil {
+					fmt.Println(sig)
+				}
+				return
+			}
+		}
+		color.Red("usage: sig <selector|topic> | sig import <4bytes_dir|file> | sig save <file>")
+		return
+
 	case "save":
 		var fn = G.JsonFile
-		if argc == 2 {
+		if argc >= 2 {
 			fn = arg[1]
 		}
-		if e := G.ctx.Save(fn); e != nil {
+		G.ctx.SavePreimages = argc == 3 && arg[2] == "preimages"
+		e := G.ctx.Save(fn)
+		G.ctx.SavePreimages = false
+		if e != nil {
 			color.Red("fail save json: " + e.Error())
 			return
 		}
@@ -185,7 +515,10 @@ func executor(in string) {
 			return
 		}
 		color.Green("loaded: %s", G.JsonFile)
+		use_user_signatures(G.JsonFile)
 		G.ctx = ctx
+		G.CfgRec = nil
+		G.Expanded = nil // of the loops in the previous trace
 
 		show_disasm(G.ctx.Pc())
 
@@ -219,8 +552,62 @@ func executor(in string) {
 		color.Green("saved to '%s' ", fn)
 		return
 
+	case "call":
+		args, e := parse_call_args(arg[1:])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		ctx, e := edb.ContextFromCall(arg[1], args)
+		if e != nil {
+			color.Red("fail: " + e.Error())
+			return
+		}
+
+		fn := "call.json"
+		e = ctx.Save(fn)
+		if e != nil {
+			color.Red("fail save json: " + e.Error())
+			return
+		}
+		G.ctx = ctx
+		G.JsonFile = fn
+		color.Green("saved to '%s' ", fn)
+		return
+
+	case "anvil":
+		if argc < 3 {
+			color.Red("usage: anvil dump|load <.json> [offline]")
+			return
+		}
+		switch arg[1] {
+		case "dump":
+			if e := G.ctx.SaveAnvilState(arg[2]); e != nil {
+				color.Red("fail save state: " + e.Error())
+				return
+			}
+			color.Green("state dumped to '%s'", arg[2])
+			return
+		case "load":
+			st, e := edb.LoadAnvilState(arg[2])
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			offline := argc == 4 && arg[3] == "offline"
+			if e = G.ctx.ImportAnvilState(st, offline); e != nil {
+				color.Red(e.Error())
+				return
+			}
+			color.Green("loaded %d accounts from '%s'", len(st.Accounts), arg[2])
+			return
+		default:
+			color.Red("usage: anvil dump|load <.json> [offline]")
+			return
+		}
+
 	case "low", "lowleveltrace": // trace input/output data for all algorithms
-		G.ctx.Hooks.Attach(hooks.NewLowLevelTracer())
+		G.ctx.Hooks.Attach(hooks.NewLowLevelTracer(G.ctx))
 		color.Yellow("tracing low-level operations")
 		return
 	case "hi", "high", "highleveltrace":
@@ -245,8 +632,55 @@ func executor(in string) {
 			color.Red("no HighLevelTracer, restart with command 'hi' to enable high level ")
 			return
 		}
-		rootCall := G.HiTracer.CallStack.Data[0]
-		symbolic.Optimize(rootCall, symbolic.DefaultOptimizers)
+		// optimize a copy, the traced tree is kept for `pc-export`, `solve`, ...
+		rootCall := symbolic.Clone(G.HiTracer.CallStack.Data[0]).(*symbolic.Call)
+		opts := append(symbolic.Optimizers{}, symbolic.DefaultOptimizers...)
+		if len(G.Rules) > 0 {
+			opts = append(opts, G.Rules)
+		}
+		symbolic.Optimize(rootCall, opts)
+		symbolic.FoldLoops(rootCall)
+
+		if len(arg) >= 4 && arg[1] == "loop" { // expand an iteration
+			pc, e1 := strconv.ParseUint(arg[2], 0, 64)
+			i, e2 := strconv.Atoi(arg[3])
+			if e1 != nil || e2 != nil {
+				color.Red("usage: op loop <pc> <iteration>")
+				return
+			}
+			loops := symbolic.FindLoops(rootCall, pc)
+			if len(loops) == 0 {
+				color.Red("no loop at pc %d", pc)
+				return
+			}
+			// loops at the same pc may have fewer iterations, they are skipped
+			var err error
+			expanded := false
+			for _, l := range loops {
+				if err = l.Expand(i); err == nil {
+					expanded = true
+				}
+			}
+			if !expanded {
+				color.Red(err.Error())
+				return
+			}
+			if G.Expanded == nil {
+				G.Expanded = map[uint64]map[int]bool{}
+			}
+			if G.Expanded[pc] == nil {
+				G.Expanded[pc] = map[int]bool{}
+			}
+			G.Expanded[pc][i] = true
+		}
+		// iterations expanded before
+		for pc, iters := range G.Expanded {
+			for _, l := range symbolic.FindLoops(rootCall, pc) {
+				for i := range iters {
+					l.Expand(i)
+				}
+			}
+		}
 
 		// print result
 		x := symbolic.PrintNode(rootCall)
@@ -256,6 +690,109 @@ func executor(in string) {
 		color.Yellow("Written to file '%s'", fn)
 		return
 
+	case "pc-export": // path conditions as SMT-LIB2
+		if G.HiTracer == nil {
+			color.Red("no HighLevelTracer, restart with command 'hi' to enable high level ")
+			return
+		}
+		rootCall := G.HiTracer.CallStack.Data[0]
+		flip := -1
+		if len(arg) > 1 {
+			if arg[1] == "list" {
+				for i, n := range symbolic.PathConditions(rootCall) {
+					fmt.Printf("#%d  pc: %d  %s\n", i, n.Pc, n)
+				}
+				return
+			}
+			var e error
+			if flip, e = strconv.Atoi(arg[1]); e != nil {
+				color.Red("invalid condition index: %s", arg[1])
+				return
+			}
+		}
+		x, e := symbolic.ExportSmtLib(rootCall, flip)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		fmt.Println(x)
+		fn := strings.ReplaceAll(G.JsonFile, ".json", "") + ".smt2"
+		util.FileWriteStr(fn, x)
+		color.Yellow("Written to file '%s'", fn)
+		return
+
+	case "taint": // predictability report of weak randomness
+		if G.HiTracer == nil {
+			color.Red("no HighLevelTracer, restart with command 'hi' to enable high level ")
+			return
+		}
+		x := symbolic.PredictabilityReport(symbolic.TaintAnalysis(G.HiTracer.CallStack.Data[0]))
+		fmt.Println(x)
+		fn := strings.ReplaceAll(G.JsonFile, ".json", "") + ".taint"
+		util.FileWriteStr(fn, x)
+		color.Yellow("Written to file '%s'", fn)
+		return
+
+	case "solve": // brute force the path condition
+		if G.HiTracer == nil {
+			color.Red("no HighLevelTracer, restart with command 'hi' to enable high level ")
+			return
+		}
+		if len(arg) < 3 {
+			color.Red("usage: solve <cond_index> <var>=<domain>... [limit]")
+			return
+		}
+		idx, e := strconv.Atoi(arg[1])
+		if e != nil {
+			color.Red("invalid condition index: %s", arg[1])
+			return
+		}
+		limit := 10
+		vars := []symbolic.Var{}
+		for _, a := range arg[2:] {
+			name, dom, found := strings.Cut(a, "=")
+			if !found {
+				if limit, e = strconv.Atoi(a); e != nil {
+					color.Red("invalid variable: %s", a)
+					return
+				}
+				continue
+			}
+			d, e := symbolic.ParseDomain(dom)
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			vars = append(vars, symbolic.Var{Name: name, Domain: d})
+		}
+		solutions, e := symbolic.Solve(G.HiTracer.CallStack.Data[0], idx, vars, limit)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		for _, s := range solutions {
+			color.Green(s.String())
+		}
+		color.Yellow("%d solutions found", len(solutions))
+		return
+
+	case "rules": // rewrite rules for `op`
+		if len(arg) < 2 {
+			for _, r := range G.Rules {
+				fmt.Println(r)
+			}
+			color.Yellow("%d rules loaded", len(G.Rules))
+			return
+		}
+		rules, e := symbolic.LoadRules(arg[1])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		G.Rules = append(G.Rules, rules...)
+		color.Green("loaded %d rules from '%s'", len(rules), arg[1])
+		return
+
 	case "log", "evm_log":
 		fn := strings.Replace(G.JsonFile, ".json", ".log", 1)
 
@@ -265,10 +802,267 @@ func executor(in string) {
 			color.Red(e.Error())
 			return
 		}
-		G.ctx.Hooks.Attach(&hooks.EvmLog{Fd: fd})
+		G.ctx.Hooks.Attach(&hooks.EvmLog{Fd: fd, Ctx: G.ctx})
 		color.Yellow("logging to '%s'", fn)
 		return
 
+	case "verify":
+		if argc < 2 {
+			color.Red("usage: verify <trace.json> [gas]")
+			return
+		}
+		trace, e := edb.LoadStructLogs(arg[1])
+		if e != nil {
+			color.Red("fail load trace: " + e.Error())
+			return
+		}
+		// always verify from the beginning
+		ctx := &edb.Context{}
+		if e := ctx.Load(G.JsonFile); e != nil {
+			color.Red(e.Error())
+			return
+		}
+		checkGas := argc == 3 && arg[2] == "gas"
+
+		d, e := edb.Verify(ctx, trace, checkGas)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		if d != nil {
+			color.Red(d.String())
+		} else {
+			color.Green("all %d steps match", len(trace.StructLogs))
+		}
+		return
+
+	case "cfg":
+		if argc < 2 {
+			color.Red("usage: cfg record | cfg dot|json [file] [address]")
+			return
+		}
+		if arg[1] == "record" { // record jumps for dynamic edges
+			if G.CfgRec != nil {
+				color.Yellow("already recording jumps")
+				return
+			}
+			G.CfgRec = cfg.NewRecorder()
+			G.ctx.Hooks.Attach(G.CfgRec)
+			color.Yellow("recording jumps")
+			return
+		}
+		if arg[1] != "dot" && arg[1] != "json" {
+			color.Red("usage: cfg record | cfg dot|json [file] [address]")
+			return
+		}
+		fn := "cfg." + arg[1]
+		if argc > 2 {
+			fn = arg[2]
+		}
+		addr := G.ctx.Call().CodeAddress()
+		if argc > 3 {
+			addr = common.HexToAddress(arg[3])
+		}
+		contract, ok := G.ctx.Contracts[addr]
+		if !ok || contract.Code.Asm == nil {
+			color.Red("no code for: %s", addr.Hex())
+			return
+		}
+
+		g := cfg.Build(contract.Code.Asm)
+		nDynamic := 0
+		if G.CfgRec != nil {
+			nDynamic = G.CfgRec.Apply(g, addr)
+		}
+
+		var bs []byte
+		if arg[1] == "dot" {
+			bs = []byte(g.DOT())
+		} else {
+			var e error
+			if bs, e = g.JSON(); e != nil {
+				color.Red(e.Error())
+				return
+			}
+		}
+		if e := util.FileWrite(fn, bs); e != nil {
+			color.Red(e.Error())
+			return
+		}
+		color.Green("%d blocks, %d edges (%d dynamic), %d loops, %d unresolved jumps, saved to '%s'",
+			len(g.Blocks), g.EdgeCount(), nDynamic, len(g.Loops()), len(g.Unresolved), fn)
+		return
+
+	case "disasm": // annotated listing of whole contract
+		format := "text"
+		if argc > 1 {
+			format = arg[1]
+		}
+		if format != "text" && format != "json" && format != "evm" {
+			color.Red("usage: disasm [text|json|evm] [file|-] [address] [from-to]")
+			return
+		}
+		fn := "-"
+		if argc > 2 {
+			fn = arg[2]
+		}
+		addr := G.ctx.Call().CodeAddress()
+		if argc > 3 {
+			addr = common.HexToAddress(arg[3])
+		}
+		l, e := disasm.Disasm(G.ctx, addr, G.CfgRec)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		if argc > 4 { // pc range
+			from, to, found := strings.Cut(arg[4], "-")
+			if !found {
+				color.Red("invalid pc range: %s", arg[4])
+				return
+			}
+			pcFrom, e1 := parse_any_int(from)
+			pcTo, e2 := parse_any_int(to)
+			if e1 != nil || e2 != nil {
+				color.Red("invalid pc range: %s", arg[4])
+				return
+			}
+			l = l.Slice(pcFrom, pcTo)
+		}
+
+		var bs []byte
+		switch format {
+		case "text":
+			bs = []byte(l.Text())
+		case "json":
+			if bs, e = l.JSON(); e != nil {
+				color.Red(e.Error())
+				return
+			}
+		case "evm":
+			bs = []byte(l.Evm())
+		}
+		if fn == "-" {
+			fmt.Print(string(bs))
+			return
+		}
+		if e := util.FileWrite(fn, bs); e != nil {
+			color.Red(e.Error())
+			return
+		}
+		color.Green("%d lines saved to '%s'", len(l.Lines), fn)
+		return
+
+	case "patch": // replace code with edited listing
+		if argc < 2 {
+			color.Red("usage: patch <file.asm|bytecode.hex> [address]")
+			return
+		}
+		addr := G.ctx.Call().CodeAddress()
+		if argc > 2 {
+			addr = common.HexToAddress(arg[2])
+		}
+		contract, ok := G.ctx.Contracts[addr]
+		if !ok {
+			color.Red("no contract: %s", addr.Hex())
+			return
+		}
+		// the pc of a running frame would point into the old code
+		running := (G.ctx.CallStack.Len() > 1 || G.ctx.Pc() != 0) && !G.ctx.IsDone
+		for _, call := range G.ctx.CallStack.Data {
+			if running && call.CodeAddress() == addr {
+				color.Red("%s is running, 'reload' before patching", addr.Hex())
+				return
+			}
+		}
+		bs, e := os.ReadFile(arg[1])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		src := strings.TrimSpace(string(bs))
+		code, e := hex.DecodeString(strings.TrimPrefix(src, "0x"))
+		if e != nil { // not hex, assemble it
+			if code, e = disasm.Assemble(src); e != nil {
+				color.Red(e.Error())
+				return
+			}
+		}
+		if e := contract.Code.Set(code); e != nil {
+			color.Red(e.Error())
+			return
+		}
+		color.Green("%d bytes patched to %s", len(code), addr.Hex())
+		if G.ctx.Sources[addr] != nil {
+			color.Yellow("source map doesn't match the patched code")
+		}
+		for _, hk := range G.ctx.Hooks.List() {
+			switch bp := hk.(type) {
+			case *hooks.BpPc:
+				if bp.Contract == nil || *bp.Contract == addr {
+					color.Yellow("breakpoint %s may not match the patched code", bp.String())
+				}
+			case *hooks.BpSource:
+				if bp.Contract == addr {
+					color.Yellow("breakpoint %s may not match the patched code", bp.String())
+				}
+			}
+		}
+		return
+
+	case "src", "source":
+		if argc == 1 { // current source line
+			loc := G.ctx.Source()
+			if loc == nil {
+				color.Yellow("no source for current pc")
+				return
+			}
+			show_source(loc)
+			return
+		}
+		if argc < 3 {
+			color.Red("usage: src [<address> <solc_output.json> [ContractName]]")
+			return
+		}
+		addr := common.HexToAddress(arg[1])
+		var code []byte
+		if c, ok := G.ctx.Contracts[addr]; ok {
+			code = c.Code.Binary
+		}
+		bs, e := os.ReadFile(arg[2])
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		name := ""
+		if argc > 3 {
+			name = arg[3]
+		}
+		sm, e := edb.LoadSourceMap(bs, filepath.Dir(arg[2]), name, code)
+		if e != nil {
+			color.Red(e.Error())
+			return
+		}
+		G.ctx.SetSourceMap(addr, sm)
+		color.Green("%s attached to %s, %d source files", sm.Contract, addr.Hex(), len(sm.Files))
+		return
+
+	case "sl", "nl": // step into / step over one source line
+		e := G.ctx.StepLine(cmd == "nl")
+		if e != nil {
+			if errors.Is(e, hooks.ErrBreakpoint) {
+				color.Yellow("interrupted: %s", e.Error())
+			} else {
+				color.Red(e.Error())
+			}
+		}
+		if G.ctx.IsDone {
+			color.Green("\nall done.\n\n")
+			return
+		}
+		show_disasm(G.ctx.Pc())
+		return
+
 	case "n", "next":
 		e := G.ctx.Run(1)
 		if e != nil {
@@ -300,6 +1094,29 @@ func executor(in string) {
 				return
 			}
 		}
+		if argc >= 2 && strings.Contains(arg[1], ":") { // eg: b Token.sol:42 [address]
+			i := strings.LastIndex(arg[1], ":")
+			lineNum, e := strconv.Atoi(arg[1][i+1:])
+			if e != nil {
+				color.Red("wrong line format, usage: b <file>:<line> [address]")
+				return
+			}
+			addr := G.ctx.Call().CodeAddress()
+			if argc > 2 {
+				addr = common.HexToAddress(arg[2])
+			}
+			if G.ctx.Sources[addr] == nil { // source attached to the implementation
+				addr = G.ctx.Implementation(addr)
+			}
+			bp, e := hooks.NewBpSource(G.ctx, addr, arg[1][:i], lineNum)
+			if e != nil {
+				color.Red(e.Error())
+				return
+			}
+			G.ctx.Hooks.Attach(bp)
+			color.Yellow("bp added: %v", bp)
+			return
+		}
 		if argc == 3 {
 			if arg[1] == "d" { // del n'th
 				if i, e := strconv.Atoi(arg[2]); e == nil {
@@ -335,6 +1152,50 @@ func executor(in string) {
 				color.Yellow("bp added: %v", bp)
 				return
 
+			case "func": // break at function entry, eg: b func 0x3bc5de30
+				addr := G.ctx.Call().CodeAddress()
+				if contract != nil {
+					addr = *contract
+				}
+				funcs, e := find_functions(addr)
+				if e != nil {
+					color.Red(e.Error())
+					return
+				}
+				sel, e := parse_selector(arg[2])
+				if e != nil {
+					color.Red(e.Error())
+					return
+				}
+				if impl := G.ctx.Implementation(addr); impl != addr {
+					// also the functions of implementation
+					implFuncs, e := find_functions(impl)
+					if e != nil {
+						color.Red(e.Error())
+						return
+					}
+					for _, f := range implFuncs {
+						if f.Selector == sel {
+							addr = impl
+							funcs = implFuncs
+							break
+						}
+					}
+				}
+				for _, f := range funcs {
+					if f.Selector == sel {
+						bp := &hooks.BpPc{
+							Contract: &addr,
+							Pc:       f.Entry,
+						}
+						G.ctx.Hooks.Attach(bp)
+						color.Yellow("bp added: %v", bp)
+						return
+					}
+				}
+				color.Red("function not found: %x", sel)
+				return
+
 			case "pc": // break by pc
 				pc, e := parse_any_int(arg[2])
 				if e != nil {
@@ -354,7 +1215,15 @@ func executor(in string) {
 	color.Red("unknown command")
 }
 
+// imported signatures are kept next to the context file
+func use_user_signatures(jsonFile string) {
+	// `sigdb.Default` is reloaded with it
+	sigdb.UserFile = filepath.Join(filepath.Dir(jsonFile), "user_signatures.txt")
+}
+
 func main() {
+	use_user_signatures(G.JsonFile)
+
 	if !util.FileExist(G.JsonFile) {
 		edb.NewSampleContext().Save(G.JsonFile)
 		fmt.Printf(
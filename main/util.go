@@ -2,8 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/aj3423/edb/sigdb"
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/fatih/color"
 )
 
 func to_pretty_json[T any](obj T) string {
@@ -30,3 +37,60 @@ func parse_any_int(s string) (uint64, error) {
 		return strconv.ParseUint(s, 10, 64)
 	}
 }
+
+// functions in the dispatcher of contract at `addr`
+func find_functions(addr common.Address) ([]*edb.Function, error) {
+	contract, ok := G.ctx.Contracts[addr]
+	if !ok || len(contract.Code.Binary) == 0 {
+		return nil, fmt.Errorf("no code for: %s", addr.Hex())
+	}
+	return edb.FindFunctions(contract.Code.Binary)
+}
+
+// "0xa9059cbb", "a9059cbb"
+func parse_hex(s string) []byte {
+	return util.HexDec(strings.TrimPrefix(s, "0x"))
+}
+
+// selector in hex, or text signature, eg: "transfer(address,uint256)"
+func parse_selector(s string) ([4]byte, error) {
+	var sel [4]byte
+	if strings.Contains(s, "(") {
+		sig, e := sigdb.ParseSignature(s)
+		if e != nil {
+			return sel, e
+		}
+		return sig.Selector(), nil
+	}
+	bs := parse_hex(s)
+	if len(bs) != 4 {
+		return sel, fmt.Errorf("invalid selector: %s", s)
+	}
+	copy(sel[:], bs)
+	return sel, nil
+}
+
+// context for decoding, and the addresses of current call whose ABI is used
+func decode_ctx() (*edb.Context, []common.Address) {
+	if G.ctx == nil { // not loaded, only signature database
+		return edb.NewContext(), nil
+	}
+	call := G.ctx.Call()
+	return G.ctx, []common.Address{call.This, call.CodeAddress()}
+}
+
+// source line with 2 lines above and below
+func show_source(loc *edb.Location) {
+	fmt.Println("---- " + loc.String())
+	for n := loc.Line - 2; n <= loc.Line+2; n++ {
+		if n < 1 {
+			continue
+		}
+		text := loc.File.Line(n)
+		if n == loc.Line {
+			color.Blue("%5d => %s", n, text)
+		} else {
+			fmt.Printf("%5d    %s\n", n, text)
+		}
+	}
+}
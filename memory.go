@@ -3,7 +3,6 @@ package edb
 import (
 	"encoding/hex"
 	"encoding/json"
-	"strings"
 
 	"github.com/aj3423/edb/util"
 	"github.com/holiman/uint256"
@@ -13,27 +12,17 @@ type Memory struct {
 	store []byte
 }
 
+// saved as a single hex string
 func (m *Memory) MarshalJSON() ([]byte, error) {
-	ss := []string{}
-	p := 0
-	for p < len(m.store) {
-		chunkLen := util.Min(32, len(m.store)-p)
-
-		chunk := m.store[p : p+chunkLen]
-		ss = append(ss, util.HexEnc(chunk))
-		p += chunkLen
-	}
-
-	return json.MarshalIndent(ss, "", "  ")
+	return json.Marshal(util.HexEnc(m.store))
 }
 func (m *Memory) UnmarshalJSON(bs []byte) error {
-	ss := []string{}
-	e := json.Unmarshal(bs, &ss)
+	var s string
+	e := json.Unmarshal(bs, &s)
 	if e != nil {
 		return e
 	}
-	all := strings.Join(ss, "")
-	m.store, e = hex.DecodeString(all)
+	m.store, e = hex.DecodeString(s)
 	return e
 }
 
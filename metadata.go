@@ -0,0 +1,192 @@
+package edb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+/*
+Compiler metadata appended to the code, a CBOR item followed by its 2-byte length:
+
+	solc:          {"ipfs": h, "solc": [0,8,13]} + len
+	vyper 0.3.4~:  {"vyper": [0,3,4]} + len
+	vyper 0.3.10~: [runtime_size, [data_sizes], immutable_size, {"vyper": [0,4,0]}] + len
+
+See:
+
+	https://docs.soliditylang.org/en/latest/metadata.html#encoding-of-the-metadata-hash-in-the-bytecode
+*/
+type Metadata struct {
+	Pc   uint64 // offset in code
+	Size uint64 // the CBOR and the 2-byte length
+
+	Compiler     string // "solc" or "vyper"
+	Version      string // eg: "0.8.13"
+	Ipfs         string // base58, eg: "Qm..."
+	Bzzr         string // hex of swarm hash, "bzzr0" or "bzzr1"
+	Experimental bool
+}
+
+func (m *Metadata) String() string {
+	s := []string{}
+	if m.Compiler != "" {
+		s = append(s, m.Compiler+" "+m.Version)
+	}
+	if m.Experimental {
+		s = append(s, "experimental")
+	}
+	if m.Ipfs != "" {
+		s = append(s, "ipfs: "+m.Ipfs)
+	}
+	if m.Bzzr != "" {
+		s = append(s, "bzzr: "+m.Bzzr)
+	}
+	return strings.Join(s, ", ")
+}
+
+// [0, 8, 13] -> "0.8.13"
+func version_string(v any) string {
+	switch v := v.(type) {
+	case []byte: // solc release
+		parts := []string{}
+		for _, b := range v {
+			parts = append(parts, fmt.Sprint(b))
+		}
+		return strings.Join(parts, ".")
+	case []any: // vyper
+		parts := []string{}
+		for _, x := range v {
+			parts = append(parts, fmt.Sprint(x))
+		}
+		return strings.Join(parts, ".")
+	case string: // solc pre-release, eg: "0.8.14-nightly.2022.4.13+commit..."
+		return v
+	}
+	return fmt.Sprint(v)
+}
+
+// nil if `item` is not a known metadata layout
+func metadata_from_cbor(item any) *Metadata {
+	m := &Metadata{}
+
+	switch item := item.(type) {
+	case map[string]any:
+		if v, ok := item["vyper"]; ok {
+			m.Compiler = "vyper"
+			m.Version = version_string(v)
+			return m
+		}
+		known := false
+		if v, ok := item["solc"]; ok {
+			m.Compiler = "solc"
+			m.Version = version_string(v)
+			known = true
+		}
+		if v, ok := item["ipfs"].([]byte); ok {
+			m.Ipfs = base58_encode(v)
+			known = true
+		}
+		for _, k := range []string{"bzzr0", "bzzr1"} {
+			if v, ok := item[k].([]byte); ok {
+				m.Bzzr = hex.EncodeToString(v)
+				known = true
+			}
+		}
+		if v, ok := item["experimental"].(bool); ok {
+			m.Experimental = v
+		}
+		if known {
+			return m
+		}
+	case []any: // vyper 0.3.10+, the last item is {"vyper": version}
+		if len(item) > 0 {
+			if last, ok := item[len(item)-1].(map[string]any); ok {
+				if v, ok := last["vyper"]; ok {
+					m.Compiler = "vyper"
+					m.Version = version_string(v)
+					return m
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Try to parse metadata at `pos`, nil if not
+func parse_metadata(code []byte, pos int) *Metadata {
+	major := code[pos] >> 5
+	if major != 4 && major != 5 { // array or map
+		return nil
+	}
+	item, n, e := cbor_decode(code[pos:])
+	if e != nil || pos+n+2 > len(code) {
+		return nil
+	}
+	// the length excludes itself for solc, includes itself for vyper 0.3.10+
+	length := int(binary.BigEndian.Uint16(code[pos+n:]))
+	if length != n && length != n+2 {
+		return nil
+	}
+	m := metadata_from_cbor(item)
+	if m == nil {
+		return nil
+	}
+	m.Pc = uint64(pos)
+	m.Size = uint64(n + 2)
+	return m
+}
+
+/*
+Find all metadata in code, not only at the end, there could be multiple, eg:
+  - creation code: init code + runtime code + metadata + constructor args
+  - factory contracts: the child contract code with its own metadata
+
+To not take constants in code as metadata, a candidate is accepted only if:
+  - it follows the INVALID(0xfe) that solc puts before metadata, or
+  - it ends the code, eg: vyper
+*/
+func find_metadata(code []byte) []*Metadata {
+	var result []*Metadata
+
+	for pos := 0; pos+3 <= len(code); pos++ {
+		m := parse_metadata(code, pos)
+		if m == nil {
+			continue
+		}
+		if (pos > 0 && code[pos-1] == 0xfe) || pos+int(m.Size) == len(code) {
+			result = append(result, m)
+			pos += int(m.Size) - 1
+		}
+	}
+	return result
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// for IPFS CIDv0, eg: "Qm..."
+func base58_encode(bs []byte) string {
+	x := new(big.Int).SetBytes(bs)
+	base := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	// leading zeros
+	for _, b := range bs {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	// reverse
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -13,6 +13,33 @@ import (
 	"github.com/pkg/errors"
 )
 
+// opcodes added by Cancun
+const (
+	BLOBHASH    vm.OpCode = 0x49
+	BLOBBASEFEE vm.OpCode = 0x4a
+)
+
+var newOpNames = map[vm.OpCode]string{
+	BLOBHASH:    "BLOBHASH",
+	BLOBBASEFEE: "BLOBBASEFEE",
+}
+
+// same as `op.String()`, also knows the opcodes in `newOpNames`
+func OpName(op vm.OpCode) string {
+	if name, ok := newOpNames[op]; ok {
+		return name
+	}
+	return op.String()
+}
+
+// same as `OpName`, but `DIFFICULTY` is shown as `PREVRANDAO` after the Merge
+func (ctx *Context) OpName(op vm.OpCode) string {
+	if op == vm.DIFFICULTY && ctx.Block.IsMerged() {
+		return "PREVRANDAO"
+	}
+	return OpName(op)
+}
+
 type executionFunc func(*Context) error
 
 type Operation struct {
@@ -54,8 +81,8 @@ func init() {
 		vm.SDIV:           make_op(vm.SDIV, 0, fixedGas(5), 2, 1, opSdiv),                           // 0x5
 		vm.MOD:            make_op(vm.MOD, 0, fixedGas(5), 2, 1, opMod),                             // 0x6
 		vm.SMOD:           make_op(vm.SMOD, 0, fixedGas(5), 2, 1, opSmod),                           // 0x7
-		vm.ADDMOD:         make_op(vm.ADDMOD, 0, fixedGas(8), 2, 1, opAddmod),                       // 0x8
-		vm.MULMOD:         make_op(vm.MULMOD, 0, fixedGas(8), 2, 1, opMulmod),                       // 0x9
+		vm.ADDMOD:         make_op(vm.ADDMOD, 0, fixedGas(8), 3, 1, opAddmod),                       // 0x8
+		vm.MULMOD:         make_op(vm.MULMOD, 0, fixedGas(8), 3, 1, opMulmod),                       // 0x9
 		vm.EXP:            make_op(vm.EXP, 0, gasExp, 2, 1, opExp),                                  // 0xa
 		vm.SIGNEXTEND:     make_op(vm.SIGNEXTEND, 0, fixedGas(5), 2, 1, opSignExtend),               // 0xb
 		vm.LT:             make_op(vm.LT, 0, fixedGas(3), 2, 1, opLt),                               // 0x10
@@ -78,13 +105,13 @@ func init() {
 		vm.ORIGIN:         make_op(vm.ORIGIN, 0, fixedGas(2), 0, 1, opOrigin),                       // 0x32
 		vm.CALLER:         make_op(vm.CALLER, 0, fixedGas(2), 0, 1, opCaller),                       // 0x33
 		vm.CALLVALUE:      make_op(vm.CALLVALUE, 0, fixedGas(2), 0, 1, opCallValue),                 // 0x34
-		vm.CALLDATALOAD:   make_op(vm.CALLDATALOAD, 0, fixedGas(3), 0, 1, opCallDataLoad),           // 0x35
+		vm.CALLDATALOAD:   make_op(vm.CALLDATALOAD, 0, fixedGas(3), 1, 1, opCallDataLoad),           // 0x35
 		vm.CALLDATASIZE:   make_op(vm.CALLDATASIZE, 0, fixedGas(2), 0, 1, opCallDataSize),           // 0x36
 		vm.CALLDATACOPY:   make_op(vm.CALLDATACOPY, 0, gasCallDataCopy, 3, 0, opCallDataCopy),       // 0x37
 		vm.CODESIZE:       make_op(vm.CODESIZE, 0, fixedGas(2), 0, 1, opCodeSize),                   // 0x38
 		vm.CODECOPY:       make_op(vm.CODECOPY, 0, gasCodeCopy, 3, 0, opCodeCopy),                   // 0x39
 		vm.GASPRICE:       make_op(vm.GASPRICE, 0, fixedGas(2), 0, 1, opGasprice),                   // 0x3a
-		vm.EXTCODESIZE:    make_op(vm.EXTCODESIZE, 0, fixedGas(700), 0, 1, opExtCodeSize),           // 0x3b
+		vm.EXTCODESIZE:    make_op(vm.EXTCODESIZE, 0, fixedGas(700), 1, 1, opExtCodeSize),           // 0x3b
 		vm.EXTCODECOPY:    make_op(vm.EXTCODECOPY, 0, gasExtCodeCopy, 4, 0, opExtCodeCopy),          // 0x3c
 		vm.RETURNDATASIZE: make_op(vm.RETURNDATASIZE, 0, fixedGas(2), 0, 1, opReturnDataSize),       // 0x3d
 		vm.RETURNDATACOPY: make_op(vm.RETURNDATACOPY, 0, gasReturnDataCopy, 3, 0, opReturnDataCopy), // 0x3e
@@ -98,6 +125,8 @@ func init() {
 		vm.CHAINID:        make_op(vm.CHAINID, 0, fixedGas(2), 0, 1, opChainID),                     // 0x46
 		vm.SELFBALANCE:    make_op(vm.SELFBALANCE, 0, fixedGas(5), 0, 1, opSelfBalance),             // 0x47
 		vm.BASEFEE:        make_op(vm.BASEFEE, 0, fixedGas(2), 0, 1, opBaseFee),                     // 0x48
+		BLOBHASH:          make_op(BLOBHASH, 0, fixedGas(3), 1, 1, opBlobHash),                      // 0x49
+		BLOBBASEFEE:       make_op(BLOBBASEFEE, 0, fixedGas(2), 0, 1, opBlobBaseFee),                // 0x4a
 		vm.POP:            make_op(vm.POP, 0, fixedGas(2), 1, 0, opPop),                             // 0x50
 		vm.MLOAD:          make_op(vm.MLOAD, 0, fixedGas(3), 1, 1, opMload),                         // 0x51
 		vm.MSTORE:         make_op(vm.MSTORE, 0, fixedGas(3), 2, 0, opMstore),                       // 0x52
@@ -436,6 +465,7 @@ func opSha3(ctx *Context) error {
 	data := ctx.Memory().GetPtr(int64(offset.Uint64()), int64(size.Uint64()))
 
 	bs := util.Sha3(data)
+	ctx.record_preimage(bs, data)
 
 	size.SetBytes(bs)
 	return nil
@@ -643,6 +673,22 @@ func opExtCodeHash(ctx *Context) error {
 		return e
 	}
 
+	// an account is non-exist when it has no code, no balance and no nonce(EIP-161)
+	if len(code) == 0 {
+		bal, e := ensure_balance(ctx, addr)
+		if e != nil {
+			return e
+		}
+		nonce, e := ensure_nonce(ctx, addr)
+		if e != nil {
+			return e
+		}
+		if bal.Sign() == 0 && nonce == 0 {
+			slot.Clear()
+			return nil
+		}
+	}
+
 	slot.SetBytes(util.Sha3(code))
 	return nil
 }
@@ -699,8 +745,14 @@ func opNumber(ctx *Context) error {
 	return nil
 }
 
+// block.difficulty before the Merge, block.prevrandao after
 func opDifficulty(ctx *Context) error {
-	v, _ := uint256.FromBig(big.NewInt(int64(ctx.Block.Difficulty)))
+	v := new(uint256.Int)
+	if ctx.Block.IsMerged() {
+		v.SetBytes(ctx.Block.PrevRandao.Bytes())
+	} else {
+		v.SetFromBig(ctx.Block.Difficulty)
+	}
 	ctx.Stack().Push(*v)
 	return nil
 }
@@ -846,11 +898,19 @@ func do_opcall(
 		return nil
 	}
 
-	_, e := ensure_code(ctx, toAddr) // fetch code + disasm for new Contract
+	code, e := ensure_code(ctx, toAddr) // fetch code + disasm for new Contract
 	if e != nil {
 		color.Red("ensure code fail")
 		return e
 	}
+	if len(code) == 0 { // calling an account without code always succeeds
+		if e := transfer(ctx, ctx.Call().This, toAddr, &value); e != nil {
+			return e
+		}
+		ctx.Call().InnerReturnVal = nil
+		ctx.Stack().Push(*uint256.NewInt(1))
+		return nil
+	}
 
 	var bigVal = big.NewInt(0)
 	if !value.IsZero() {
@@ -875,6 +935,25 @@ func do_opcall(
 	ctx.CallStack.Push(newCall)
 	return nil
 }
+
+// move `value` between accounts, balances are fetched if not cached
+func transfer(ctx *Context, from, to common.Address, value *uint256.Int) error {
+	if value.IsZero() {
+		return nil
+	}
+	fromBalance, e := ensure_balance(ctx, from)
+	if e != nil {
+		return e
+	}
+	toBalance, e := ensure_balance(ctx, to)
+	if e != nil {
+		return e
+	}
+	fromBalance.Sub(fromBalance, value.ToBig())
+	toBalance.Add(toBalance, value.ToBig())
+	return nil
+}
+
 func opCall(ctx *Context) error {
 	stack := ctx.Stack()
 
@@ -883,13 +962,9 @@ func opCall(ctx *Context) error {
 
 	// when input is empty, it's transfer: `addr.call{value:xxx}("")`
 	if inSize.IsZero() {
-		fromAddr := ctx.Call().This
-		toAddr := common.Address(addr.Bytes20())
-		fromBalance := ctx.Contracts[fromAddr].Balance
-		toBalance := ctx.Contracts[toAddr].Balance
-
-		fromBalance.Sub(fromBalance, value.ToBig())
-		toBalance.Sub(toBalance, value.ToBig())
+		if e := transfer(ctx, ctx.Call().This, common.Address(addr.Bytes20()), &value); e != nil {
+			return e
+		}
 
 		// just assume it succeeded
 		stack.Push(*uint256.NewInt(1))
@@ -920,12 +995,20 @@ func opDelegateCall(ctx *Context) error {
 
 	toAddr := common.Address(addr.Bytes20())
 
-	_, e := ensure_code(ctx, toAddr) // fetch code + disasm for new Contract
+	code, e := ensure_code(ctx, toAddr) // fetch code + disasm for new Contract
 	if e != nil {
 		return e
 	}
+	if len(code) == 0 { // calling an account without code always succeeds
+		ctx.Call().InnerReturnVal = nil
+		ctx.Stack().Push(*uint256.NewInt(1))
+		return nil
+	}
 
 	currCall := ctx.Call()
+	if currCall.CodePtr == nil {
+		ctx.on_delegatecall(currCall.This)
+	}
 
 	args := ctx.Memory().GetPtr(int64(inOffset.Uint64()), int64(inSize.Uint64()))
 	newCall := &Call{
@@ -984,6 +1067,13 @@ func opReturn(ctx *Context) error {
 
 		ctx.Stack().Push(*uint256.NewInt(1)) // outerStack
 	} else {
+		if ctx.Tx.Create { // the returned runtime code
+			code := &Code{}
+			if e := code.Set(common.CopyBytes(output)); e != nil {
+				return e
+			}
+			ensure_contract_at(ctx, call.This).Code = code
+		}
 		ctx.IsDone = true
 	}
 
@@ -994,10 +1084,14 @@ func opRevert(ctx *Context) error {
 	stack := ctx.Stack()
 	offset, size := stack.Pop(), stack.Pop()
 	ret := ctx.Memory().GetPtr(int64(offset.Uint64()), int64(size.Uint64()))
-	_ = ret
 
 	//ctx.CurrentCall().ReturnVal = ret
 	color.Red(hex.Dump(ret))
+
+	call := ctx.Call()
+	if d, e := ctx.DecodeRevert(ret, call.This, call.CodeAddress()); e == nil {
+		return errors.New("Reverted: " + d.String())
+	}
 	return errors.New("Reverted")
 }
 func opAssert(ctx *Context) error {
@@ -1049,6 +1143,83 @@ func opBaseFee(ctx *Context) error {
 	return nil
 }
 
+// blobhash(index), 0 if out of range
+func opBlobHash(ctx *Context) error {
+	index := ctx.Stack().Pop()
+
+	v := new(uint256.Int)
+	if index.IsUint64() && index.Uint64() < uint64(len(ctx.Tx.BlobHashes)) {
+		v.SetBytes(ctx.Tx.BlobHashes[index.Uint64()].Bytes())
+	}
+	ctx.Stack().Push(*v)
+	return nil
+}
+
+// block.blobbasefee
+func opBlobBaseFee(ctx *Context) error {
+	v := new(uint256.Int)
+	if ctx.Block.BlobBaseFee != nil {
+		v.SetFromBig(ctx.Block.BlobBaseFee)
+	} else {
+		v.SetFromBig(blob_base_fee(ctx.Block.ExcessBlobGas,
+			ctx.Chain.blob_base_fee_update_fraction(ctx.Block.Timestamp)))
+	}
+	ctx.Stack().Push(*v)
+	return nil
+}
+
+const (
+	minBlobBaseFee                  = 1
+	blobBaseFeeUpdateFractionCancun = 3338477
+	blobBaseFeeUpdateFractionPrague = 5007716
+)
+
+// The blob base fee update fraction that takes effect from `Timestamp`
+type BlobFork struct {
+	Timestamp      uint64
+	UpdateFraction uint64
+}
+
+// Cancun, Prague, Fusaka BPO1 and BPO2
+var MainnetBlobSchedule = []BlobFork{
+	{1710338135, blobBaseFeeUpdateFractionCancun},
+	{1746612311, blobBaseFeeUpdateFractionPrague},
+	{1765290071, 8346193},
+	{1767747671, 11684671},
+}
+
+// The fraction of the last fork activated at `timestamp`, Cancun if none
+func (c *Chain) blob_base_fee_update_fraction(timestamp uint64) uint64 {
+	schedule := c.BlobSchedule
+	if len(schedule) == 0 {
+		schedule = MainnetBlobSchedule
+	}
+	fraction := uint64(blobBaseFeeUpdateFractionCancun)
+	for _, fork := range schedule {
+		if timestamp >= fork.Timestamp {
+			fraction = fork.UpdateFraction
+		}
+	}
+	return fraction
+}
+
+// EIP-4844 `fake_exponential(MIN_BASE_FEE_PER_BLOB_GAS, excess_blob_gas, fraction)`
+func blob_base_fee(excessBlobGas uint64, fraction uint64) *big.Int {
+	factor := big.NewInt(minBlobBaseFee)
+	numerator := new(big.Int).SetUint64(excessBlobGas)
+	denominator := new(big.Int).SetUint64(fraction)
+
+	output := new(big.Int)
+	accum := new(big.Int).Mul(factor, denominator)
+	for i := int64(1); accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+
+		accum.Mul(accum, numerator)
+		accum.Div(accum, new(big.Int).Mul(denominator, big.NewInt(i)))
+	}
+	return output.Div(output, denominator)
+}
+
 // make push instruction function
 func makePush(n uint64) executionFunc {
 	return func(ctx *Context) error {
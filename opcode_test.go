@@ -0,0 +1,201 @@
+package edb
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stretchr/testify/assert"
+)
+
+// run a single opcode and return the stack top
+func run_single_op(t *testing.T, ctx *Context, code []byte) string {
+	contract := NewContract()
+	assert.Nil(t, contract.Code.Set(code))
+	ctx.Contracts[ctx.This()] = contract
+
+	assert.Nil(t, ctx.Run(1))
+	return ctx.Stack().Peek().Hex()
+}
+
+func TestDifficulty(t *testing.T) {
+	randao := "0xf0e1d2c3b4a5968778695a4b3c2d1e0f00112233445566778899aabbccddeeff"
+
+	// after the Merge, the full 256-bit prevrandao
+	ctx := NewContext()
+	ctx.Block.Difficulty = big.NewInt(0)
+	ctx.Block.PrevRandao = common.HexToHash(randao)
+	assert.Equal(t, randao, run_single_op(t, ctx, []byte{0x44}))
+	assert.Equal(t, "PREVRANDAO", ctx.OpName(0x44))
+
+	// before the Merge
+	ctx = NewContext()
+	ctx.Block.Difficulty = big.NewInt(2)
+	ctx.Block.PrevRandao = common.HexToHash(randao)
+	assert.Equal(t, "0x2", run_single_op(t, ctx, []byte{0x44}))
+	assert.Equal(t, "DIFFICULTY", ctx.OpName(0x44))
+}
+
+func TestBlobBaseFee(t *testing.T) {
+	assert.Equal(t, int64(1), blob_base_fee(0, blobBaseFeeUpdateFractionCancun).Int64())
+	// e^10
+	assert.Equal(t, int64(22026), blob_base_fee(
+		10*blobBaseFeeUpdateFractionCancun, blobBaseFeeUpdateFractionCancun).Int64())
+
+	ctx := NewContext()
+	ctx.Block.BlobBaseFee = big.NewInt(0x1234)
+	assert.Equal(t, "0x1234", run_single_op(t, ctx, []byte{0x4a}))
+	assert.Equal(t, "BLOBBASEFEE", OpName(BLOBBASEFEE))
+}
+
+func TestBlobBaseFeeForkBoundary(t *testing.T) {
+	excess := uint64(10 * blobBaseFeeUpdateFractionPrague)
+	bpo1 := MainnetBlobSchedule[2]
+
+	fee_at := func(chain Chain, timestamp uint64) string {
+		ctx := NewContext()
+		ctx.Chain = chain
+		ctx.Block.ExcessBlobGas = excess
+		ctx.Block.Timestamp = timestamp
+		return run_single_op(t, ctx, []byte{0x4a})
+	}
+	mainnet := Chain{Id: 1}
+	assert.Equal(t, "0x560a", fee_at(mainnet, bpo1.Timestamp-1)) // e^10, Prague
+	assert.Equal(t, "0x"+blob_base_fee(excess, bpo1.UpdateFraction).Text(16),
+		fee_at(mainnet, bpo1.Timestamp))
+
+	// other chains have their own schedule
+	chain := Chain{Id: 100, BlobSchedule: []BlobFork{
+		{0, blobBaseFeeUpdateFractionCancun},
+		{100, blobBaseFeeUpdateFractionPrague},
+	}}
+	assert.Equal(t, "0x"+blob_base_fee(excess, blobBaseFeeUpdateFractionCancun).Text(16),
+		fee_at(chain, 99))
+	assert.Equal(t, "0x560a", fee_at(chain, 100))
+}
+
+type feeHistoryService struct{}
+
+func (feeHistoryService) FeeHistory(count hexutil.Uint64, last string, percentiles []float64) map[string]any {
+	return map[string]any{"baseFeePerBlobGas": []string{"0x10", "0x11"}}
+}
+
+func TestBlobBaseFeeFromNode(t *testing.T) {
+	server := rpc.NewServer()
+	assert.Nil(t, server.RegisterName("eth", feeHistoryService{}))
+
+	ctx := NewContext()
+	ctx.rpcClient = rpc.DialInProc(server)
+	excess := hexutil.Uint64(1)
+	head := &rpcHeader{Number: 100, ExcessBlobGas: &excess}
+
+	ctx.fetch_blob_base_fee(head, false)
+	assert.Equal(t, int64(0x10), ctx.Block.BlobBaseFee.Int64())
+	ctx.fetch_blob_base_fee(head, true) // the block after
+	assert.Equal(t, int64(0x11), ctx.Block.BlobBaseFee.Int64())
+}
+
+type accountService struct{ nonce hexutil.Uint64 }
+
+func (accountService) GetCode(addr common.Address, block string) hexutil.Bytes { return nil }
+func (accountService) GetBalance(addr common.Address, block string) *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(0))
+}
+func (s accountService) GetTransactionCount(addr common.Address, block string) hexutil.Uint64 {
+	return s.nonce
+}
+
+func TestExtCodeHashNonce(t *testing.T) {
+	emptyHash := "0xc5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"
+	eoa := common.HexToAddress("0xaa")
+	// PUSH1 0xaa, EXTCODEHASH
+	code := []byte{0x60, 0xaa, 0x3f}
+
+	hash_of := func(ctx *Context) string {
+		contract := NewContract()
+		assert.Nil(t, contract.Code.Set(code))
+		ctx.Contracts[ctx.This()] = contract
+		assert.Nil(t, ctx.Run(2))
+		return ctx.Stack().Peek().Hex()
+	}
+
+	// a sent tx makes the account exist, even without balance
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+	ensure_contract_at(ctx, eoa).Nonce = 1
+	assert.Equal(t, emptyHash, hash_of(ctx))
+
+	ctx = NewContext()
+	ctx.Chain.Offline = true
+	assert.Equal(t, "0x0", hash_of(ctx))
+
+	// fetched online
+	for nonce, expected := range map[hexutil.Uint64]string{0: "0x0", 3: emptyHash} {
+		server := rpc.NewServer()
+		assert.Nil(t, server.RegisterName("eth", accountService{nonce}))
+		ctx = NewContext()
+		ctx.Block.Number = 100
+		ctx.ethClient = ethclient.NewClient(rpc.DialInProc(server))
+		assert.Equal(t, expected, hash_of(ctx))
+	}
+}
+
+// run the code in the root call, returns the stack top
+func run_code(t *testing.T, ctx *Context, code string, steps int) string {
+	contract := NewContract()
+	contract.Balance = big.NewInt(100)
+	assert.Nil(t, contract.Code.Set(util.HexDec(code)))
+	ctx.Contracts[ctx.This()] = contract
+
+	assert.Nil(t, ctx.Run(steps))
+	return ctx.Stack().Peek().Hex()
+}
+
+func TestCallWithoutCode(t *testing.T) {
+	to := common.HexToAddress("0xaa")
+
+	// call{value: 5}(""), transfer only
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+	assert.Equal(t, "0x1", run_code(t, ctx, "60006000600060006005"+"60aa"+"6000f1", 8))
+	assert.Equal(t, int64(95), ctx.Contracts[ctx.This()].Balance.Int64())
+	assert.Equal(t, int64(5), ctx.Contracts[to].Balance.Int64())
+
+	// call{value: 5}("0x00") to an account without code, the value is also transferred
+	ctx = NewContext()
+	ctx.Chain.Offline = true
+	assert.Equal(t, "0x1", run_code(t, ctx, "60006000600160006005"+"60aa"+"6000f1", 8))
+	assert.Equal(t, 1, ctx.CallStack.Len())
+	assert.Equal(t, int64(95), ctx.Contracts[ctx.This()].Balance.Int64())
+	assert.Equal(t, int64(5), ctx.Contracts[to].Balance.Int64())
+
+	// delegatecall to an account without code
+	ctx = NewContext()
+	ctx.Chain.Offline = true
+	assert.Equal(t, "0x1", run_code(t, ctx, "6000600060016000"+"60aa"+"6000f4", 7))
+	assert.Equal(t, 1, ctx.CallStack.Len())
+}
+
+// the stack size after executing matches NStackIn and NStackOut
+func TestStackInOut(t *testing.T) {
+	for _, op := range []vm.OpCode{
+		vm.ADD, vm.ADDMOD, vm.MULMOD, vm.EXP, vm.ISZERO, vm.BYTE, vm.SHL,
+		vm.CALLDATALOAD, vm.MLOAD, vm.MSTORE, vm.POP, vm.DUP3, vm.SWAP2,
+	} {
+		ctx := NewContext()
+		ctx.Chain.Offline = true
+		run_code(t, ctx, strings.Repeat("6001", 8)+util.HexEnc([]byte{byte(op)})+"00", 9)
+
+		o := OpTable[op]
+		assert.Equal(t, 8-int(o.NStackIn)+int(o.NStackOut), ctx.Stack().Len(), OpName(op))
+	}
+	assert.Equal(t, uint8(3), OpTable[vm.ADDMOD].NStackIn)
+	assert.Equal(t, uint8(3), OpTable[vm.MULMOD].NStackIn)
+	assert.Equal(t, uint8(1), OpTable[vm.CALLDATALOAD].NStackIn)
+}
@@ -0,0 +1,225 @@
+package edb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+)
+
+// inputs larger than this are not recorded, slots are hashed from small inputs
+const maxPreimageSize = 1024
+
+// stops recording when full, the earlier ones are kept
+const maxPreimages = 1 << 16
+
+// keccak256 preimages of all SHA3 executed, map[hash]input
+type Preimages map[common.Hash]util.ByteSlice
+
+func (ctx *Context) record_preimage(hash []byte, data []byte) {
+	if len(data) > maxPreimageSize {
+		return
+	}
+	if ctx.Preimages == nil {
+		ctx.Preimages = Preimages{}
+		ctx.preimageIndex = nil
+	}
+	key := common.BytesToHash(hash)
+	if _, ok := ctx.Preimages[key]; ok || len(ctx.Preimages) >= maxPreimages {
+		return
+	}
+	ctx.Preimages[key] = common.CopyBytes(data)
+
+	if len(data) >= 32 && ctx.preimageIndex != nil {
+		h := new(uint256.Int).SetBytes(hash)
+		idx := ctx.preimageIndex
+		i := sort.Search(len(idx), func(i int) bool { return !idx[i].Lt(h) })
+		idx = append(idx, nil)
+		copy(idx[i+1:], idx[i:])
+		idx[i] = h
+		ctx.preimageIndex = idx
+	}
+}
+
+// sorted hashes of the preimages that can be slots, built on first use,
+// then kept in order by `record_preimage`
+func (ctx *Context) preimage_index() []*uint256.Int {
+	if ctx.preimageIndex == nil {
+		idx := []*uint256.Int{}
+		for hash, data := range ctx.Preimages {
+			if len(data) >= 32 {
+				idx = append(idx, new(uint256.Int).SetBytes(hash[:]))
+			}
+		}
+		sort.Slice(idx, func(i, j int) bool { return idx[i].Lt(idx[j]) })
+		ctx.preimageIndex = idx
+	}
+	return ctx.preimageIndex
+}
+
+// a slot +i within this range is considered as array element or struct member
+const maxSlotDelta = 1 << 16
+
+// named slot, `typeId` is empty without storage layout
+type slotRef struct {
+	name   string
+	typeId string
+	array  bool // start of array data, `typeId` is the element type
+}
+
+/*
+Name of a storage slot by the SHA3 preimages, "" if unknown:
+  - keccak(key . slot)     -> balances[0x8ba1...]
+  - keccak(slot) + i       -> owners[42]
+  - keccak(key . slot) + i -> users[0x8ba1...].amount
+
+Variable names come from the storage layout if attached, otherwise "slot2".
+*/
+func (ctx *Context) SlotName(addr common.Address, slot *uint256.Int) string {
+	layout, _ := ctx.LayoutOf(addr)
+	r := &slotResolver{layout: layout, preimages: ctx.Preimages, hashes: ctx.preimage_index()}
+	ref, ok := r.resolve(slot, 0)
+	if !ok {
+		return ""
+	}
+	return ref.name
+}
+
+type slotResolver struct {
+	layout    *StorageLayout // nil if not attached
+	preimages Preimages
+	hashes    []*uint256.Int // sorted, see `preimage_index`
+}
+
+func (r *slotResolver) type_of(id string) *StorageType {
+	if r.layout == nil || id == "" {
+		return nil
+	}
+	return r.layout.Types[id]
+}
+
+// returns false if it's neither a state variable in layout nor a hashed slot
+func (r *slotResolver) resolve(slot *uint256.Int, depth int) (*slotRef, bool) {
+	if depth > 8 {
+		return nil, false
+	}
+	if data, ok := r.preimages[slot.Bytes32()]; ok && len(data) >= 32 {
+		if ref := r.resolve_hashed(data, depth); ref != nil {
+			return r.offset(ref, 0), true
+		}
+		return nil, false
+	}
+
+	// state variable
+	if slot.IsUint64() {
+		n := strconv.FormatUint(slot.Uint64(), 10)
+		if r.layout != nil {
+			for _, item := range r.layout.Storage {
+				if item.Slot == n {
+					return &slotRef{name: item.Label, typeId: item.Type}, true
+				}
+			}
+		}
+		return &slotRef{name: "slot" + n}, false
+	}
+
+	// keccak(...) + i, find the nearest hash below the slot
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i].Gt(slot) })
+	if i == 0 {
+		return nil, false
+	}
+	base := r.hashes[i-1]
+	if new(uint256.Int).Sub(slot, base).GtUint64(maxSlotDelta) {
+		return nil, false
+	}
+	ref := r.resolve_hashed(r.preimages[base.Bytes32()], depth)
+	if ref == nil {
+		return nil, false
+	}
+	return r.offset(ref, new(uint256.Int).Sub(slot, base).Uint64()), true
+}
+
+// slot = keccak(data), nil if the last word isn't a slot
+func (r *slotResolver) resolve_hashed(data []byte, depth int) *slotRef {
+	base, _ := r.resolve(new(uint256.Int).SetBytes(data[len(data)-32:]), depth+1)
+	if base == nil {
+		return nil
+	}
+
+	if len(data) == 32 { // keccak(slot), array data
+		elem := ""
+		if t := r.type_of(base.typeId); t != nil && t.Encoding == "dynamic_array" {
+			elem = t.Base
+		}
+		return &slotRef{name: base.name, typeId: elem, array: true}
+	}
+
+	// keccak(key . slot), mapping
+	key := data[:len(data)-32]
+	if t := r.type_of(base.typeId); t != nil && t.Encoding == "mapping" {
+		if kt := r.type_of(t.Key); kt != nil && (kt.Encoding == "bytes" || len(key) == 32) {
+			return &slotRef{name: base.name + "[" + format_key(key, kt) + "]", typeId: t.Value}
+		}
+	}
+	return &slotRef{name: base.name + "[" + format_word(key) + "]"}
+}
+
+// the slot `delta` after `ref`
+func (r *slotResolver) offset(ref *slotRef, delta uint64) *slotRef {
+	t := r.type_of(ref.typeId)
+
+	if ref.array {
+		index, rest := delta, uint64(0)
+		if t != nil {
+			if size := uint64(t.size()); size > 0 && size <= 16 { // packed, shows the first one
+				index = delta * (32 / size)
+			} else if size > 32 {
+				slots := (size + 31) / 32
+				index, rest = delta/slots, delta%slots
+			}
+		}
+		elem := &slotRef{name: fmt.Sprintf("%s[%d]", ref.name, index), typeId: ref.typeId}
+		return r.offset(elem, rest)
+	}
+	if delta == 0 {
+		return ref
+	}
+
+	if t != nil && t.Encoding == "inplace" {
+		if t.Base != "" { // static array
+			return r.offset(&slotRef{name: ref.name, typeId: t.Base, array: true}, delta)
+		}
+		// struct, the last member starts before `delta`
+		var member *StorageItem
+		var memberSlot uint64
+		for _, m := range t.Members {
+			s, e := strconv.ParseUint(m.Slot, 10, 64)
+			if e == nil && s <= delta && (member == nil || s > memberSlot) {
+				member, memberSlot = m, s
+			}
+		}
+		if member != nil {
+			m := &slotRef{name: ref.name + "." + member.Label, typeId: member.Type}
+			return r.offset(m, delta-memberSlot)
+		}
+	}
+	return &slotRef{name: fmt.Sprintf("%s+%d", ref.name, delta)}
+}
+
+// mapping key without type: number, address or hex
+func format_word(key []byte) string {
+	if len(key) != 32 {
+		return "0x" + util.HexEnc(key)
+	}
+	v := new(uint256.Int).SetBytes(key)
+	if v.IsUint64() {
+		return strconv.FormatUint(v.Uint64(), 10)
+	}
+	if v.BitLen() <= 160 {
+		return common.BytesToAddress(key).Hex()
+	}
+	return "0x" + util.HexEnc(key)
+}
@@ -0,0 +1,90 @@
+package edb
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlotName(t *testing.T) {
+	ctx := sample_layout_context(t)
+	this := ctx.This()
+
+	who := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	mapping := new(uint256.Int).SetBytes(util.Sha3(
+		append(common.LeftPadBytes(who[:], 32), common.LeftPadBytes([]byte{2}, 32)...)))
+
+	ids := uint256.NewInt(4).Bytes32()
+	ctx.record_preimage(util.Sha3(ids[:]), ids[:])
+	array := keccak_slot(uint256.NewInt(4))
+
+	plus := func(x *uint256.Int, n uint64) *uint256.Int {
+		return new(uint256.Int).AddUint64(x, n)
+	}
+
+	// with storage layout
+	assert.Equal(t, "tokenId", ctx.SlotName(this, uint256.NewInt(1)))
+	assert.Equal(t, "balances[0x2222222222222222222222222222222222222222]", ctx.SlotName(this, mapping))
+	assert.Equal(t, "ids[0]", ctx.SlotName(this, array))
+	assert.Equal(t, "ids[4]", ctx.SlotName(this, plus(array, 1))) // 4 uint64 in a slot
+	assert.Equal(t, "", ctx.SlotName(this, uint256.NewInt(0x99)))
+	assert.Equal(t, "", ctx.SlotName(this, uint256.NewInt(0).SetAllOne()))
+
+	// without storage layout
+	delete(ctx.Layouts, this)
+	assert.Equal(t, "", ctx.SlotName(this, uint256.NewInt(1)))
+	assert.Equal(t, "slot2[0x2222222222222222222222222222222222222222]", ctx.SlotName(this, mapping))
+	assert.Equal(t, "slot2[0x2222222222222222222222222222222222222222]+1", ctx.SlotName(this, plus(mapping, 1)))
+	assert.Equal(t, "slot4[3]", ctx.SlotName(this, plus(array, 3)))
+}
+
+func TestRecordPreimage(t *testing.T) {
+	ctx := sample_layout_context(t)
+	ctx.Preimages = nil
+
+	// mstore(0, 0x2222...), mstore(0x20, 2), sstore(keccak256(0, 0x40), 100)
+	code := "73" + strings.Repeat("22", 20) + "600052" + "6002602052" + "6040600020" + "60649055" + "00"
+	ctx.Contract().Code.Set(util.HexDec(code))
+	assert.Nil(t, ctx.Run(-1))
+	assert.Equal(t, 1, len(ctx.Preimages))
+
+	for hash := range ctx.Preimages {
+		assert.Equal(t, "balances[0x2222222222222222222222222222222222222222]",
+			ctx.SlotName(ctx.This(), new(uint256.Int).SetBytes(hash[:])))
+	}
+	vars, _, e := ctx.StorageVars(ctx.This())
+	assert.Nil(t, e)
+	assert.Equal(t, "balances[0x2222222222222222222222222222222222222222] (uint256) = 100", vars[4].String())
+}
+
+func TestPreimageIndex(t *testing.T) {
+	ctx := NewContext()
+	record := func(from, to uint64) {
+		for i := from; i < to; i++ {
+			word := uint256.NewInt(i).Bytes32()
+			ctx.record_preimage(util.Sha3(word[:]), word[:])
+		}
+	}
+	record(0, 50)
+	ctx.record_preimage(util.Sha3([]byte{1}), []byte{1}) // too short for a slot
+	assert.Equal(t, 50, len(ctx.preimage_index()))
+
+	// kept in order after built
+	record(25, 100)
+	idx := ctx.preimage_index()
+	assert.Equal(t, 100, len(idx))
+	ctx.preimageIndex = nil
+	assert.Equal(t, ctx.preimage_index(), idx)
+
+	// full
+	record(0, maxPreimages+1)
+	assert.Equal(t, maxPreimages, len(ctx.Preimages))
+
+	// keccak(7) + 3
+	array := keccak_slot(uint256.NewInt(7))
+	assert.Equal(t, "slot7[3]", ctx.SlotName(ctx.This(), new(uint256.Int).AddUint64(array, 3)))
+}
@@ -0,0 +1,327 @@
+package edb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// keccak256(s) - 1
+func eip1967_slot(s string) *uint256.Int {
+	v := new(uint256.Int).SetBytes(util.Sha3([]byte(s)))
+	return v.SubUint64(v, 1)
+}
+
+var (
+	Eip1967ImplementationSlot = eip1967_slot("eip1967.proxy.implementation")
+	Eip1967AdminSlot          = eip1967_slot("eip1967.proxy.admin")
+	Eip1967BeaconSlot         = eip1967_slot("eip1967.proxy.beacon")
+
+	Eip1822ProxiableSlot         = new(uint256.Int).SetBytes(util.Sha3([]byte("PROXIABLE")))
+	ZeppelinOSImplementationSlot = new(uint256.Int).SetBytes(util.Sha3([]byte("org.zeppelinos.proxy.implementation")))
+)
+
+var (
+	selImplementation = util.HexDec("5c60da1b") // implementation(), of beacon
+	selProxiableUUID  = [4]byte{0x52, 0xd1, 0x90, 0x2d}
+	selMasterCopy     = util.HexDec("a619486e") // masterCopy(), in Gnosis Safe proxy
+)
+
+type ProxyKind int
+
+const (
+	ProxyEIP1967    ProxyKind = iota // transparent proxy
+	ProxyUUPS                        // EIP-1967 slot, upgraded by the implementation
+	ProxyEIP1822                     // UUPS with the "PROXIABLE" slot
+	ProxyBeacon                      // EIP-1967 beacon slot
+	ProxyMinimal                     // ERC-1167 minimal proxy, the address is in the code
+	ProxyGnosisSafe                  // `masterCopy` at slot 0
+	ProxyZeppelinOS                  // legacy OpenZeppelin slot
+)
+
+func (k ProxyKind) String() string {
+	switch k {
+	case ProxyEIP1967:
+		return "EIP-1967"
+	case ProxyUUPS:
+		return "UUPS"
+	case ProxyEIP1822:
+		return "EIP-1822"
+	case ProxyBeacon:
+		return "beacon"
+	case ProxyMinimal:
+		return "ERC-1167"
+	case ProxyGnosisSafe:
+		return "Gnosis Safe"
+	case ProxyZeppelinOS:
+		return "ZeppelinOS"
+	}
+	return "unknown"
+}
+
+type Proxy struct {
+	Kind           ProxyKind
+	Implementation common.Address
+	Beacon         *common.Address `json:",omitempty"` // only for beacon proxy
+}
+
+func (p *Proxy) String() string {
+	if p.Beacon != nil {
+		return fmt.Sprintf("%s (%s %s)", p.Implementation.Hex(), p.Kind, p.Beacon.Hex())
+	}
+	return fmt.Sprintf("%s (%s)", p.Implementation.Hex(), p.Kind)
+}
+
+// 363d3d373d3d3d363d 73<address> 5af43d82803e903d91602b57fd5bf3,
+// the address can be shorter for vanity addresses, eg: PUSH16
+func minimal_proxy_target(code []byte) (common.Address, bool) {
+	prefix := util.HexDec("363d3d373d3d3d363d")
+	suffix := util.HexDec("5af43d82803e903d91")
+	if !bytes.HasPrefix(code, prefix) || len(code) < len(prefix)+1 {
+		return common.Address{}, false
+	}
+	op := vm.OpCode(code[len(prefix)])
+	if op < vm.PUSH1 || op > vm.PUSH20 {
+		return common.Address{}, false
+	}
+	n := int(op-vm.PUSH1) + 1
+	rest := code[len(prefix)+1:]
+	if len(rest) < n+len(suffix)+4 || !bytes.HasPrefix(rest[n:], suffix) ||
+		!bytes.HasSuffix(rest, util.HexDec("57fd5bf3")) {
+		return common.Address{}, false
+	}
+	return common.BytesToAddress(rest[:n]), true
+}
+
+/*
+The selector is pushed at any width, either as the value,
+or left aligned to compare with the unshifted calldata,
+eg: `PUSH32 a619486e00..00` in GnosisSafeProxy 1.1.1 and 1.3.0
+*/
+func pushes_selector(code []byte, sel []byte) bool {
+	for pc := 0; pc < len(code); pc++ {
+		op := vm.OpCode(code[pc])
+		if op < vm.PUSH1 || op > vm.PUSH32 {
+			continue
+		}
+		n := int(op-vm.PUSH1) + 1
+		if pc+1+n > len(code) {
+			return false
+		}
+		data := code[pc+1 : pc+1+n]
+		pc += n
+
+		if bytes.Equal(bytes.TrimLeft(data, "\x00"), sel) {
+			return true
+		}
+		if n == 32 && bytes.HasPrefix(data, sel) &&
+			len(bytes.TrimRight(data[len(sel):], "\x00")) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// the slot holds an address, and the address has code
+func (ctx *Context) address_at_slot(addr common.Address, slot *uint256.Int) (common.Address, bool, error) {
+	v, e := ensure_storage(ctx, addr, slot)
+	if e != nil {
+		return common.Address{}, false, e
+	}
+	if v.IsZero() || v.BitLen() > 160 {
+		return common.Address{}, false, nil
+	}
+	impl := common.Address(v.Bytes20())
+	code, e := ensure_code(ctx, impl)
+	if e != nil {
+		return common.Address{}, false, e
+	}
+	return impl, len(code) > 0, nil
+}
+
+/*
+Detect the proxy pattern of `addr`, returns nil if it's not a proxy:
+  - ERC-1167 minimal proxy, by bytecode
+  - EIP-1967 implementation slot, it's UUPS if the implementation has `proxiableUUID()`
+  - EIP-1967 beacon slot, the implementation is got by calling `beacon.implementation()`
+  - EIP-1822 "PROXIABLE" slot, legacy ZeppelinOS slot
+  - Gnosis Safe proxy, `masterCopy` at slot 0
+
+The result is cached in `ctx.Proxies`, it's used for decoding with the ABI
+and storage layout of the implementation. It only affects decoding, not the execution,
+so callers can go on with a warning when it fails.
+*/
+func (ctx *Context) ResolveProxy(addr common.Address) (*Proxy, error) {
+	code, e := ensure_code(ctx, addr)
+	if e != nil || len(code) == 0 {
+		return nil, e
+	}
+	p, e := ctx.detect_proxy(addr, code)
+	if e != nil || p == nil {
+		return nil, e
+	}
+	if ctx.Proxies == nil {
+		ctx.Proxies = map[common.Address]*Proxy{}
+	}
+	ctx.Proxies[addr] = p
+	return p, nil
+}
+
+func (ctx *Context) detect_proxy(addr common.Address, code []byte) (*Proxy, error) {
+	if impl, ok := minimal_proxy_target(code); ok {
+		return &Proxy{Kind: ProxyMinimal, Implementation: impl}, nil
+	}
+
+	impl, ok, e := ctx.address_at_slot(addr, Eip1967ImplementationSlot)
+	if e != nil {
+		return nil, e
+	}
+	if ok {
+		kind := ProxyEIP1967
+		if funcs, e := FindFunctions(ctx.Contracts[impl].Code.Binary); e == nil {
+			for _, f := range funcs {
+				if f.Selector == selProxiableUUID {
+					kind = ProxyUUPS
+				}
+			}
+		}
+		return &Proxy{Kind: kind, Implementation: impl}, nil
+	}
+
+	beacon, ok, e := ctx.address_at_slot(addr, Eip1967BeaconSlot)
+	if e != nil {
+		return nil, e
+	}
+	if ok {
+		ret, e := ctx.static_call(beacon, selImplementation)
+		if e != nil {
+			return nil, errors.Wrap(e, "beacon.implementation()")
+		}
+		if len(ret) != 32 {
+			return nil, errors.Errorf("beacon.implementation() returns %d bytes", len(ret))
+		}
+		return &Proxy{Kind: ProxyBeacon, Implementation: common.BytesToAddress(ret), Beacon: &beacon}, nil
+	}
+
+	for _, s := range []struct {
+		slot *uint256.Int
+		kind ProxyKind
+	}{
+		{Eip1822ProxiableSlot, ProxyEIP1822},
+		{ZeppelinOSImplementationSlot, ProxyZeppelinOS},
+	} {
+		impl, ok, e := ctx.address_at_slot(addr, s.slot)
+		if e != nil {
+			return nil, e
+		}
+		if ok {
+			return &Proxy{Kind: s.kind, Implementation: impl}, nil
+		}
+	}
+
+	if pushes_selector(code, selMasterCopy) {
+		impl, ok, e := ctx.address_at_slot(addr, uint256.NewInt(0))
+		if e != nil {
+			return nil, e
+		}
+		if ok {
+			return &Proxy{Kind: ProxyGnosisSafe, Implementation: impl}, nil
+		}
+	}
+	return nil, nil
+}
+
+// The implementation if `addr` is a known proxy, otherwise `addr` itself
+func (ctx *Context) Implementation(addr common.Address) common.Address {
+	if p, ok := ctx.Proxies[addr]; ok {
+		return p.Implementation
+	}
+	return addr
+}
+
+// Detect proxy when it delegatecalls, errors are ignored
+func (ctx *Context) on_delegatecall(proxy common.Address) {
+	if _, ok := ctx.Proxies[proxy]; ok || ctx.notProxy[proxy] {
+		return
+	}
+	if p, _ := ctx.ResolveProxy(proxy); p == nil {
+		if ctx.notProxy == nil {
+			ctx.notProxy = map[common.Address]bool{}
+		}
+		ctx.notProxy[proxy] = true
+	}
+}
+
+// captures the return data of the outermost call
+type returnTracer struct {
+	EmptyHook
+	root *Call
+	ret  []byte
+}
+
+func (t *returnTracer) PreRun(call *Call, line *Line) error {
+	if call != t.root || line.Op.OpCode != vm.RETURN {
+		return nil
+	}
+	offset, size := call.Stack.PeekI(0), call.Stack.PeekI(1)
+	t.ret = call.Memory.GetCopy(int64(offset.Uint64()), int64(size.Uint64()))
+	return nil
+}
+
+// rejects state changes, like geth STATICCALL
+type staticGuard struct {
+	EmptyHook
+}
+
+var stateChangingOps = map[vm.OpCode]bool{
+	vm.SSTORE: true, vm.LOG0: true, vm.LOG1: true, vm.LOG2: true, vm.LOG3: true, vm.LOG4: true,
+	vm.CREATE: true, vm.CREATE2: true, vm.SELFDESTRUCT: true,
+}
+
+func (g *staticGuard) PreRun(call *Call, line *Line) error {
+	op := line.Op.OpCode
+	if stateChangingOps[op] {
+		return errors.Errorf("%s in static call, pc: %d", OpName(op), line.Pc)
+	}
+	if op == vm.CALL || op == vm.CALLCODE {
+		if value := call.Stack.PeekI(2); !value.IsZero() {
+			return errors.Errorf("%s with value in static call, pc: %d", OpName(op), line.Pc)
+		}
+	}
+	return nil
+}
+
+const staticCallMaxSteps = 100_000
+
+// Run a view function on current state, the state is shared with `ctx`,
+// so anything that changes it fails the call
+func (ctx *Context) static_call(to common.Address, data []byte) ([]byte, error) {
+	sub := NewContext()
+	sub.ethClient, sub.rpcClient = ctx.ethClient, ctx.rpcClient
+	sub.Chain, sub.Block = ctx.Chain, ctx.Block
+	sub.Contracts, sub.BlockHashes = ctx.Contracts, ctx.BlockHashes
+
+	if _, e := ensure_code(sub, to); e != nil {
+		return nil, e
+	}
+	call := sub.Call()
+	call.This = to
+	call.Msg = Msg{Data: data, Gas: 10_000_000, Value: big.NewInt(0)}
+
+	t := &returnTracer{root: call}
+	sub.Hooks.Attach(t)
+	sub.Hooks.Attach(&staticGuard{})
+	if e := sub.Run(staticCallMaxSteps); e != nil {
+		return nil, e
+	}
+	if !sub.IsDone {
+		return nil, errors.New("too many steps")
+	}
+	return t.ret, nil
+}
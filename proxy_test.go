@@ -0,0 +1,186 @@
+package edb
+
+import (
+	"bytes"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testProxy = common.HexToAddress("0x1111111111111111111111111111111111111111")
+	testImpl  = common.HexToAddress("0x2222222222222222222222222222222222222222")
+)
+
+// runtime code of GnosisSafeProxy 1.3.0, created by GnosisSafeProxyFactory
+const gnosisSafeProxyCode = "608060405273ffffffffffffffffffffffffffffffffffffffff600054167fa619486e" +
+	"0000000000000000000000000000000000000000000000000000000060003514156050578060005260206000f35b" +
+	"3660008037600080366000845af43d6000803e60008114156070573d6000fd5b3d6000f3fe" +
+	"a2646970667358221220d1429297349653a4918076d650332de1a1068c5f3e07c5c82360c277770b9552" +
+	"64736f6c63430007060033"
+
+func minimal_proxy_code(impl common.Address) []byte {
+	return util.HexDec("363d3d373d3d3d363d73" + util.HexEnc(impl[:]) + "5af43d82803e903d91602b57fd5bf3")
+}
+
+// offline context with the sample contract as implementation
+func proxy_context(t *testing.T, proxyCode []byte) *Context {
+	ctx := NewSampleContext()
+	ctx.Chain.Offline = true
+
+	ctx.Contracts[testImpl] = ctx.Contract()
+	delete(ctx.Contracts, ctx.This())
+
+	proxy := NewContract()
+	proxy.Balance = big.NewInt(0)
+	assert.Nil(t, proxy.Code.Set(proxyCode))
+	ctx.Contracts[testProxy] = proxy
+	ctx.Call().This = testProxy
+	return ctx
+}
+
+func set_slot(ctx *Context, addr common.Address, slot *uint256.Int, val common.Address) {
+	ctx.Contracts[addr].Storage[slot.Bytes32()] = new(uint256.Int).SetBytes(val[:])
+}
+
+func TestMinimalProxy(t *testing.T) {
+	ctx := proxy_context(t, minimal_proxy_code(testImpl))
+
+	p, e := ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyMinimal, p.Kind)
+	assert.Equal(t, testImpl, p.Implementation)
+
+	// vanity address with leading zeros, PUSH16
+	short := util.HexDec("363d3d373d3d3d363d6f" + strings.Repeat("22", 16) + "5af43d82803e903d91602757fd5bf3")
+	impl, ok := minimal_proxy_target(short)
+	assert.True(t, ok)
+	assert.Equal(t, common.HexToAddress(strings.Repeat("22", 16)), impl)
+
+	_, ok = minimal_proxy_target(ctx.Contracts[testImpl].Code.Binary)
+	assert.False(t, ok)
+}
+
+func TestSlotProxies(t *testing.T) {
+	code := util.HexDec("6000") // any code
+
+	// EIP-1967
+	ctx := proxy_context(t, code)
+	set_slot(ctx, testProxy, Eip1967ImplementationSlot, testImpl)
+	p, e := ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, "0x2222222222222222222222222222222222222222 (EIP-1967)", p.String())
+
+	// UUPS, the implementation has `proxiableUUID()`
+	binary := ctx.Contracts[testImpl].Code.Binary
+	binary = bytes.Replace(binary, util.HexDec("633bc5de30"), util.HexDec("6352d1902d"), 1)
+	assert.Nil(t, ctx.Contracts[testImpl].Code.Set(binary))
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyUUPS, p.Kind)
+
+	// EIP-1822
+	ctx = proxy_context(t, code)
+	set_slot(ctx, testProxy, Eip1822ProxiableSlot, testImpl)
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyEIP1822, p.Kind)
+
+	// beacon, `implementation()` returns testImpl
+	beacon := common.HexToAddress("0x3333333333333333333333333333333333333333")
+	ctx = proxy_context(t, code)
+	ctx.Contracts[beacon] = NewContract()
+	ctx.Contracts[beacon].Balance = big.NewInt(0)
+	assert.Nil(t, ctx.Contracts[beacon].Code.Set(util.HexDec("73"+util.HexEnc(testImpl[:])+"60005260206000f3")))
+	set_slot(ctx, testProxy, Eip1967BeaconSlot, beacon)
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyBeacon, p.Kind)
+	assert.Equal(t, testImpl, p.Implementation)
+	assert.Equal(t, beacon, *p.Beacon)
+
+	// Gnosis Safe, masterCopy at slot 0
+	ctx = proxy_context(t, util.HexDec(gnosisSafeProxyCode))
+	set_slot(ctx, testProxy, uint256.NewInt(0), testImpl)
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyGnosisSafe, p.Kind)
+	assert.Equal(t, testImpl, p.Implementation)
+
+	// a selector pushed as the value
+	ctx = proxy_context(t, util.HexDec("63a619486e00"))
+	set_slot(ctx, testProxy, uint256.NewInt(0), testImpl)
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Equal(t, ProxyGnosisSafe, p.Kind)
+
+	// the selector bytes inside other push data
+	ctx = proxy_context(t, util.HexDec("65a619486e000000"))
+	set_slot(ctx, testProxy, uint256.NewInt(0), testImpl)
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Nil(t, p)
+
+	// not a proxy, slot 0 holds an address without code
+	ctx = proxy_context(t, code)
+	set_slot(ctx, testProxy, Eip1967ImplementationSlot, common.HexToAddress("0x4444"))
+	p, e = ctx.ResolveProxy(testProxy)
+	assert.Nil(t, e)
+	assert.Nil(t, p)
+	assert.Equal(t, testProxy, ctx.Implementation(testProxy))
+}
+
+func TestProxyDelegateCall(t *testing.T) {
+	ctx := proxy_context(t, minimal_proxy_code(testImpl))
+
+	a, e := ParseAbi([]byte(`[{"type":"function","name":"getData","inputs":[],"outputs":[{"name":"","type":"uint256"}]}]`))
+	assert.Nil(t, e)
+	ctx.SetAbi(testImpl, a)
+	layout, e := LoadStorageLayout([]byte(sampleLayout), "", nil)
+	assert.Nil(t, e)
+	ctx.SetStorageLayout(testImpl, layout)
+
+	// run to the entry of getData() in the implementation
+	for i := 0; i < 100 && !(ctx.CallStack.Len() == 2 && ctx.Pc() == 59); i++ {
+		assert.Nil(t, ctx.Run(1))
+	}
+	assert.Equal(t, testImpl, ctx.Call().CodeAddress())
+
+	// detected when delegatecall
+	assert.Equal(t, testImpl, ctx.Implementation(testProxy))
+
+	bt := ctx.Backtrace()
+	assert.Equal(t, 2, len(bt))
+	assert.Equal(t, "#0  0x1111111111111111111111111111111111111111 → 0x2222222222222222222222222222222222222222 (ERC-1167)  pc: 59  getData()", bt[0])
+	assert.True(t, strings.HasPrefix(bt[1], "#1  0x1111111111111111111111111111111111111111  pc: "))
+	assert.True(t, strings.HasSuffix(bt[1], "getData()"))
+
+	// layout of implementation for the proxy storage
+	assert.Equal(t, "tokenId", ctx.SlotName(testProxy, uint256.NewInt(1)))
+}
+
+func TestStaticCall(t *testing.T) {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+	addr := common.HexToAddress("0xaa")
+	contract := NewContract()
+	contract.Balance = big.NewInt(0)
+	ctx.Contracts[addr] = contract
+
+	// mstore(0, 42) return(0, 0x20)
+	assert.Nil(t, contract.Code.Set(util.HexDec("602a60005260206000f3")))
+	ret, e := ctx.static_call(addr, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, uint64(42), new(uint256.Int).SetBytes(ret).Uint64())
+
+	// sstore(0, 1) stop
+	assert.Nil(t, contract.Code.Set(util.HexDec("600160005500")))
+	_, e = ctx.static_call(addr, nil)
+	assert.ErrorContains(t, e, "SSTORE in static call")
+	assert.Empty(t, contract.Storage)
+}
@@ -0,0 +1,293 @@
+package sigdb
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// Decoded calldata/return data/log
+type Decoded struct {
+	Sig    *Signature
+	Args   abi.Arguments // `Sig.Inputs`, or `Sig.Outputs` for return data
+	Values []any         // for event, in the order of signature, indexed dynamic values are topic hashes
+}
+
+/*
+String of the decoded value:
+  - "transfer(0x8ba1...72, 100)" for text signatures
+  - "transfer(to: 0x8ba1...72, amount: 100)" when the arguments have names, eg: from ABI
+*/
+func (d *Decoded) String() string {
+	arr := []string{}
+	for i, v := range d.Values {
+		s := format_value(reflect.ValueOf(v))
+		if i < len(d.Args) && d.Args[i].Name != "" {
+			s = d.Args[i].Name + ": " + s
+		}
+		arr = append(arr, s)
+	}
+	s := d.Sig.Name + "(" + strings.Join(arr, ", ") + ")"
+
+	if d.Sig.text == "Panic(uint256)" && len(d.Values) == 1 {
+		if code, ok := d.Values[0].(*big.Int); ok && code.IsUint64() {
+			if reason, ok := panicReasons[code.Uint64()]; ok {
+				s += " // " + reason
+			}
+		}
+	}
+	return s
+}
+
+// https://docs.soliditylang.org/en/latest/control-structures.html#panic-via-assert-and-error-via-require
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler panic",
+	0x01: "assert failed",
+	0x11: "arithmetic overflow or underflow",
+	0x12: "division or modulo by zero",
+	0x21: "invalid enum value",
+	0x22: "invalid storage byte array encoding",
+	0x31: "pop() on empty array",
+	0x32: "array index out of bounds",
+	0x41: "out of memory",
+	0x51: "call to zero-initialized function",
+}
+
+// decoding all `data` and nothing more, the re-encoded bytes equal to the input
+func is_exact(args abi.Arguments, values []any, data []byte) bool {
+	packed, e := args.Pack(values...)
+	return e == nil && bytes.Equal(packed, data)
+}
+
+// unpack `data` with one of the candidates, prefer the one decodes exactly
+func unpack_any(candidates []*Signature, data []byte, outputs bool) (*Decoded, error) {
+	var loose *Decoded
+	for _, sig := range candidates {
+		args := sig.Inputs
+		if outputs {
+			args = sig.Outputs
+		}
+		values, e := args.UnpackValues(data)
+		if e != nil {
+			continue
+		}
+		if is_exact(args, values, data) {
+			return &Decoded{Sig: sig, Args: args, Values: values}, nil
+		}
+		if loose == nil {
+			loose = &Decoded{Sig: sig, Args: args, Values: values}
+		}
+	}
+	if loose != nil {
+		return loose, nil
+	}
+	return nil, errors.New("data doesn't match the signature")
+}
+
+// Decode calldata, also works for revert data with `Error(string)`, `Panic(uint256)` or custom errors
+func (db *DB) DecodeCall(input []byte) (*Decoded, error) {
+	if len(input) < 4 {
+		return nil, errors.Errorf("data too short: %d bytes", len(input))
+	}
+	var sel [4]byte
+	copy(sel[:], input)
+
+	candidates := db.Funcs(sel)
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("unknown selector: %x", sel)
+	}
+	return unpack_any(candidates, input[4:], false)
+}
+
+// Decode the return data of calling function `selector`,
+// only signatures with outputs can be used
+func (db *DB) DecodeReturn(selector [4]byte, output []byte) (*Decoded, error) {
+	candidates := []*Signature{}
+	for _, sig := range db.Funcs(selector) {
+		if sig.Outputs != nil {
+			candidates = append(candidates, sig)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.Errorf("no outputs known for selector: %x", selector)
+	}
+	return unpack_any(candidates, output, true)
+}
+
+/*
+Decode a log by topic0.
+The text signature doesn't tell which parameters are indexed,
+so all combinations of `len(topics)-1` indexed parameters are tried,
+starting from the most common one: the first N parameters.
+*/
+func (db *DB) DecodeLog(topics []common.Hash, data []byte) (*Decoded, error) {
+	if len(topics) == 0 {
+		return nil, errors.New("anonymous log")
+	}
+	sig := db.Event(topics[0])
+	if sig == nil {
+		return nil, errors.Errorf("unknown topic: %s", topics[0].Hex())
+	}
+	nIndexed := len(topics) - 1
+	if nIndexed > len(sig.Inputs) {
+		return nil, errors.Errorf("too many topics for %s", sig.Text())
+	}
+
+	var loose *Decoded
+	for _, indexed := range combinations(len(sig.Inputs), nIndexed) {
+		d, exact, e := decode_log(sig, indexed, topics[1:], data)
+		if e != nil {
+			continue
+		}
+		if exact {
+			return d, nil
+		}
+		if loose == nil {
+			loose = d
+		}
+	}
+	if loose != nil {
+		return loose, nil
+	}
+	return nil, errors.Errorf("data doesn't match the event %s", sig.Text())
+}
+
+func decode_log(
+	sig *Signature, indexed []int, topics []common.Hash, data []byte,
+) (*Decoded, bool, error) {
+	isIndexed := map[int]int{} // map[arg index]topic index
+	for ti, ai := range indexed {
+		isIndexed[ai] = ti
+	}
+
+	nonIndexed := abi.Arguments{}
+	for i, arg := range sig.Inputs {
+		if _, ok := isIndexed[i]; !ok {
+			nonIndexed = append(nonIndexed, arg)
+		}
+	}
+	dataValues, e := nonIndexed.UnpackValues(data)
+	if e != nil {
+		return nil, false, e
+	}
+	exact := is_exact(nonIndexed, dataValues, data)
+
+	values := []any{}
+	for i, arg := range sig.Inputs {
+		ti, ok := isIndexed[i]
+		if !ok {
+			values = append(values, dataValues[0])
+			dataValues = dataValues[1:]
+			continue
+		}
+		topic := topics[ti]
+		if is_dynamic(arg.Type) { // only the hash is logged
+			values = append(values, topic)
+			continue
+		}
+		v, e := abi.Arguments{arg}.UnpackValues(topic[:])
+		if e != nil {
+			return nil, false, e
+		}
+		if !is_exact(abi.Arguments{arg}, v, topic[:]) {
+			exact = false
+		}
+		values = append(values, v[0])
+	}
+	return &Decoded{Sig: sig, Args: sig.Inputs, Values: values}, exact, nil
+}
+
+// indexed value types that are logged as keccak hash
+func is_dynamic(t abi.Type) bool {
+	switch t.T {
+	case abi.StringTy, abi.BytesTy, abi.SliceTy, abi.ArrayTy, abi.TupleTy:
+		return true
+	}
+	return false
+}
+
+// all k-element subsets of [0, n), in lexicographic order
+func combinations(n, k int) [][]int {
+	result := [][]int{}
+	var walk func(start int, cur []int)
+	walk = func(start int, cur []int) {
+		if len(cur) == k {
+			result = append(result, append([]int{}, cur...))
+			return
+		}
+		for i := start; i < n; i++ {
+			walk(i+1, append(cur, i))
+		}
+	}
+	walk(0, []int{})
+	return result
+}
+
+/*
+Human readable calldata for tracers:
+  - "transfer(0x8ba1...72, 100)" if decoded, or
+  - "transfer(address,uint256)" if the signature is known but doesn't match, or
+  - "a9059cbb"
+*/
+func (db *DB) CallString(input []byte) string {
+	if len(input) < 4 {
+		return util.HexEnc(input)
+	}
+	if d, e := db.DecodeCall(input); e == nil {
+		return d.String()
+	}
+	var sel [4]byte
+	copy(sel[:], input)
+	if sigs := db.Funcs(sel); len(sigs) > 0 {
+		return sigs[0].Text()
+	}
+	return util.HexEnc(sel[:])
+}
+
+func format_value(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	switch x := v.Interface().(type) {
+	case common.Address:
+		return x.Hex()
+	case common.Hash:
+		return x.Hex()
+	case *big.Int:
+		return x.String()
+	case []byte:
+		return "0x" + util.HexEnc(x)
+	case string:
+		return fmt.Sprintf("%q", x)
+	}
+
+	switch v.Kind() {
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 { // bytes32, ...
+			bs := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(bs), v)
+			return "0x" + util.HexEnc(bs)
+		}
+		fallthrough
+	case reflect.Slice:
+		arr := []string{}
+		for i := 0; i < v.Len(); i++ {
+			arr = append(arr, format_value(v.Index(i)))
+		}
+		return "[" + strings.Join(arr, ", ") + "]"
+	case reflect.Struct: // tuple
+		arr := []string{}
+		for i := 0; i < v.NumField(); i++ {
+			arr = append(arr, format_value(v.Field(i)))
+		}
+		return "(" + strings.Join(arr, ", ") + ")"
+	}
+	return fmt.Sprint(v.Interface())
+}
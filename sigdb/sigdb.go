@@ -0,0 +1,251 @@
+package sigdb
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+// common signatures shipped with the binary, more can be imported with `Import`
+//
+//go:embed signatures.txt
+var embedded string
+
+// Local signature database of function selectors, custom errors and event topics
+type DB struct {
+	funcs  map[[4]byte][]*Signature // multiple signatures may share a selector
+	events map[common.Hash]*Signature
+}
+
+func New() *DB {
+	return &DB{
+		funcs:  map[[4]byte][]*Signature{},
+		events: map[common.Hash]*Signature{},
+	}
+}
+
+var (
+	defaultDB   *DB
+	defaultFile string // the `UserFile` merged into defaultDB
+	defaultLock sync.Mutex
+)
+
+// Signatures imported by `ImportUser` are saved here and merged by `Default`,
+// the CLI puts it next to the context file
+var UserFile = "user_signatures.txt"
+
+/*
+The global database used by tracers and the CLI,
+initialized with the embedded signatures and the ones in `UserFile`,
+initialized again when `UserFile` changes, eg: loading a context in another dir
+*/
+func Default() *DB {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+
+	if defaultDB == nil || defaultFile != UserFile {
+		defaultDB = New()
+		defaultDB.AddText(embedded)
+		if bs, e := os.ReadFile(UserFile); e == nil {
+			defaultDB.AddText(string(bs))
+		}
+		defaultFile = UserFile
+	}
+	return defaultDB
+}
+
+/*
+Import into `Default` and append the signatures to `UserFile`,
+so they are still there after restart.
+Returns count of new signatures in `Default`
+*/
+func ImportUser(path string) (int, error) {
+	imported := New()
+	if _, e := imported.Import(path); e != nil {
+		return 0, e
+	}
+
+	user := New()
+	if bs, e := os.ReadFile(UserFile); e == nil {
+		user.AddText(string(bs))
+	} else if !os.IsNotExist(e) {
+		return 0, e
+	}
+
+	n := 0
+	for _, sigs := range imported.funcs { // every signature is indexed as a function
+		for _, sig := range sigs {
+			if Default().Add(sig) {
+				n++
+			}
+			user.Add(sig)
+		}
+	}
+	if e := user.Save(UserFile); e != nil {
+		return n, errors.Wrap(e, "save user signatures")
+	}
+	return n, nil
+}
+
+// Returns false if already exists.
+// A signature is indexed both as a function and an event,
+// since the text can't tell which one it is
+func (db *DB) Add(sig *Signature) bool {
+	added := false
+
+	sel := sig.Selector()
+	found := false
+	for i, old := range db.funcs[sel] {
+		if old.text == sig.text {
+			found = true
+			if old.Outputs == nil && sig.Outputs != nil { // prefer the one with outputs
+				db.funcs[sel][i] = sig
+				added = true
+			}
+		}
+	}
+	if !found {
+		db.funcs[sel] = append(db.funcs[sel], sig)
+		added = true
+	}
+
+	topic := sig.Topic()
+	if old, ok := db.events[topic]; !ok || (old.Outputs == nil && sig.Outputs != nil) {
+		db.events[topic] = sig
+	}
+	return added
+}
+
+// One signature per line, lines starting with '#' are ignored,
+// returns count of new signatures
+func (db *DB) AddText(text string) int {
+	n := 0
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if sig, e := ParseSignature(line); e == nil && db.Add(sig) {
+			n++
+		}
+	}
+	return n
+}
+
+// Signatures of a function selector or custom error
+func (db *DB) Funcs(selector [4]byte) []*Signature {
+	return db.funcs[selector]
+}
+
+// Signature of an event topic0, nil if not found
+func (db *DB) Event(topic common.Hash) *Signature {
+	return db.events[topic]
+}
+
+// Count of unique signatures
+func (db *DB) Len() int {
+	n := 0
+	for _, sigs := range db.funcs {
+		n += len(sigs)
+	}
+	return n
+}
+
+/*
+Import signatures from:
+  - the ethereum-lists 4bytes dump: https://github.com/ethereum-lists/4bytes
+    a directory of files named by the hex selector(or event topic),
+    each file contains signatures separated by ';'.
+    Both `signatures/` and `with_parameter_names/` are supported,
+    signatures don't match the file name are skipped.
+  - a text file with one signature per line, eg: the one written by `Save`
+
+returns count of new signatures
+*/
+func (db *DB) Import(path string) (int, error) {
+	fi, e := os.Stat(path)
+	if e != nil {
+		return 0, e
+	}
+	if !fi.IsDir() {
+		bs, e := os.ReadFile(path)
+		if e != nil {
+			return 0, e
+		}
+		return db.AddText(string(bs)), nil
+	}
+
+	// the repo root, use its "signatures" folder
+	if sub := filepath.Join(path, "signatures"); is_dir(sub) {
+		path = sub
+	}
+
+	entries, e := os.ReadDir(path)
+	if e != nil {
+		return 0, e
+	}
+	n := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		hash, e := hex.DecodeString(strings.TrimPrefix(entry.Name(), "0x"))
+		if e != nil || (len(hash) != 4 && len(hash) != 32) {
+			continue // not a signature file, eg: README
+		}
+		bs, e := os.ReadFile(filepath.Join(path, entry.Name()))
+		if e != nil {
+			return n, errors.Wrapf(e, "fail read %s", entry.Name())
+		}
+		for _, text := range strings.Split(string(bs), ";") {
+			sig, e := ParseSignature(text)
+			if e != nil {
+				continue
+			}
+			topic := sig.Topic()
+			if string(topic[:len(hash)]) != string(hash) {
+				continue
+			}
+			if db.Add(sig) {
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+func is_dir(path string) bool {
+	fi, e := os.Stat(path)
+	return e == nil && fi.IsDir()
+}
+
+// Write all signatures to a text file, one per line, sorted
+func (db *DB) Save(fn string) error {
+	lines := []string{}
+	for _, sigs := range db.funcs {
+		for _, sig := range sigs {
+			lines = append(lines, sig.String())
+		}
+	}
+	sort.Strings(lines)
+
+	f, e := os.Create(fn)
+	if e != nil {
+		return e
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, line := range lines {
+		w.WriteString(line + "\n")
+	}
+	return w.Flush()
+}
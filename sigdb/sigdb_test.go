@@ -0,0 +1,148 @@
+package sigdb
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSignature(t *testing.T) {
+	sig, e := ParseSignature("Transfer(address indexed from, address indexed to, uint value)")
+	assert.Nil(t, e)
+	assert.Equal(t, "Transfer(address,address,uint256)", sig.Text())
+	assert.Equal(t,
+		"0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef",
+		sig.Topic().Hex())
+
+	sig, e = ParseSignature("aggregate3((address,bool,bytes)[])((bool,bytes)[])")
+	assert.Nil(t, e)
+	assert.Equal(t, "aggregate3((address,bool,bytes)[])", sig.Text())
+	assert.Equal(t, "aggregate3((address,bool,bytes)[])((bool,bytes)[])", sig.String())
+	sel := sig.Selector()
+	assert.Equal(t, "82ad56cb", util.HexEnc(sel[:]))
+
+	_, e = ParseSignature("transfer(address")
+	assert.NotNil(t, e)
+}
+
+func TestDecodeCall(t *testing.T) {
+	db := Default()
+
+	to := common.HexToAddress("0x8ba1f109551bd432803012645ac136ddd64dba72")
+	sig, _ := ParseSignature("transfer(address,uint256)")
+	input, e := sig.Inputs.Pack(to, big.NewInt(100))
+	assert.Nil(t, e)
+	input = append(util.HexDec("a9059cbb"), input...)
+
+	d, e := db.DecodeCall(input)
+	assert.Nil(t, e)
+	assert.Equal(t, "transfer(0x8ba1f109551bD432803012645Ac136ddd64DBA72, 100)", d.String())
+
+	// return data
+	ret := common.LeftPadBytes([]byte{1}, 32)
+	d, e = db.DecodeReturn(sig.Selector(), ret)
+	assert.Nil(t, e)
+	assert.Equal(t, "transfer(true)", d.String())
+
+	// revert reason
+	errSig, _ := ParseSignature("Error(string)")
+	revert, _ := errSig.Inputs.Pack("not enough")
+	revert = append(util.HexDec("08c379a0"), revert...)
+	assert.Equal(t, `Error("not enough")`, db.CallString(revert))
+
+	// unknown
+	assert.Equal(t, "12345678", db.CallString(util.HexDec("12345678")))
+	// known, but wrong data
+	assert.Equal(t, "transfer(address,uint256)", db.CallString(util.HexDec("a9059cbb00")))
+}
+
+func TestDecodeLog(t *testing.T) {
+	db := Default()
+
+	from := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	to := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	topics := []common.Hash{
+		common.HexToHash("0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"),
+		common.BytesToHash(from[:]),
+		common.BytesToHash(to[:]),
+	}
+	data := common.LeftPadBytes([]byte{5}, 32)
+
+	d, e := db.DecodeLog(topics, data)
+	assert.Nil(t, e)
+	assert.Equal(t,
+		"Transfer(0x1111111111111111111111111111111111111111, 0x2222222222222222222222222222222222222222, 5)",
+		d.String())
+
+	// ERC721 Transfer, all 3 indexed
+	topics = append(topics, common.BytesToHash(data))
+	d, e = db.DecodeLog(topics, nil)
+	assert.Nil(t, e)
+	assert.Equal(t, 3, len(d.Values))
+
+	// not the first N indexed: Swap(address indexed sender, uint, uint, uint, uint, address indexed to)
+	sig, _ := ParseSignature("Swap(address,uint256,uint256,uint256,uint256,address)")
+	// the last amount doesn't fit an address, so it's not the first 2 indexed
+	data = make([]byte, 32*4)
+	data[31] = 1
+	for i := 96; i < 128; i++ {
+		data[i] = 0xff
+	}
+	d, e = db.DecodeLog([]common.Hash{sig.Topic(), common.BytesToHash(from[:]), common.BytesToHash(to[:])}, data)
+	assert.Nil(t, e)
+	assert.Equal(t,
+		"Swap(0x1111111111111111111111111111111111111111, 1, 0, 0, "+
+			"115792089237316195423570985008687907853269984665640564039457584007913129639935, "+
+			"0x2222222222222222222222222222222222222222)",
+		d.String())
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	sigs := filepath.Join(dir, "signatures")
+	assert.Nil(t, os.Mkdir(sigs, 0755))
+
+	util.FileWriteStr(filepath.Join(sigs, "a9059cbb"), "transfer(address,uint256);many_msg_babbage(bytes1)")
+	util.FileWriteStr(filepath.Join(sigs, "deadbeef"), "transfer(address,uint256)") // wrong hash
+	util.FileWriteStr(filepath.Join(dir, "README.md"), "")
+
+	db := New()
+	n, e := db.Import(dir)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 2, len(db.Funcs([4]byte{0xa9, 0x05, 0x9c, 0xbb})))
+
+	// save and load back
+	fn := filepath.Join(dir, "sigs.txt")
+	assert.Nil(t, db.Save(fn))
+	db2 := New()
+	n, e = db2.Import(fn)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, n)
+}
+
+func TestImportUser(t *testing.T) {
+	dir := t.TempDir()
+	UserFile = filepath.Join(dir, "user_signatures.txt")
+	defer func() { UserFile = "user_signatures.txt" }()
+
+	fn := filepath.Join(dir, "import.txt")
+	assert.Nil(t, os.WriteFile(fn, []byte("edbImported(uint256)\n"), 0666))
+	n, e := ImportUser(fn)
+	assert.Nil(t, e)
+	assert.Equal(t, 1, n)
+
+	// restart, loaded from the user file
+	defaultDB = nil
+	sig, _ := ParseSignature("edbImported(uint256)")
+	assert.Equal(t, 1, len(Default().Funcs(sig.Selector())))
+
+	// another dir, the previous user file is not merged
+	UserFile = filepath.Join(t.TempDir(), "user_signatures.txt")
+	assert.Empty(t, Default().Funcs(sig.Selector()))
+}
@@ -0,0 +1,236 @@
+package sigdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+/*
+A text signature, eg:
+  - "transfer(address,uint256)"
+  - "balanceOf(address)(uint256)", with optional output types
+  - "swap((address,uint256)[],bytes)", tuples
+
+Parameter names and "indexed" are allowed and ignored,
+eg: "Transfer(address indexed from, address indexed to, uint256 value)"
+*/
+type Signature struct {
+	Name    string
+	Inputs  abi.Arguments
+	Outputs abi.Arguments // nil if unknown, signatures from the 4bytes dump have no outputs
+
+	text    string // canonical inputs, eg: "transfer(address,uint256)"
+	outputs string // canonical outputs, eg: "(uint256)"
+}
+
+func ParseSignature(s string) (*Signature, error) {
+	s = strings.TrimSpace(s)
+
+	open := strings.Index(s, "(")
+	if open <= 0 {
+		return nil, errors.Errorf("invalid signature: '%s'", s)
+	}
+	name := strings.TrimSpace(s[:open])
+	if strings.ContainsAny(name, " ,()[]") {
+		return nil, errors.Errorf("invalid signature name: '%s'", s)
+	}
+
+	close_, e := matching_paren(s, open)
+	if e != nil {
+		return nil, errors.Wrapf(e, "invalid signature: '%s'", s)
+	}
+	sig := &Signature{Name: name}
+
+	var types []string
+	if sig.Inputs, types, e = parse_params(s[open+1 : close_]); e != nil {
+		return nil, errors.Wrapf(e, "invalid signature: '%s'", s)
+	}
+	sig.text = name + "(" + strings.Join(types, ",") + ")"
+
+	// optional outputs
+	rest := strings.TrimSpace(s[close_+1:])
+	rest = strings.TrimSpace(strings.TrimPrefix(rest, "returns"))
+	if rest != "" {
+		if rest[0] != '(' || rest[len(rest)-1] != ')' {
+			return nil, errors.Errorf("invalid outputs of signature: '%s'", s)
+		}
+		if sig.Outputs, types, e = parse_params(rest[1 : len(rest)-1]); e != nil {
+			return nil, errors.Wrapf(e, "invalid outputs of signature: '%s'", s)
+		}
+		sig.outputs = "(" + strings.Join(types, ",") + ")"
+	}
+	return sig, nil
+}
+
+// Signature of an ABI method/event/error, the argument names are kept for decoding
+func NewSignature(name string, inputs, outputs abi.Arguments) *Signature {
+	sig := &Signature{Name: name, Inputs: inputs, Outputs: outputs}
+	sig.text = name + "(" + type_list(inputs) + ")"
+	if outputs != nil {
+		sig.outputs = "(" + type_list(outputs) + ")"
+	}
+	return sig
+}
+
+func type_list(args abi.Arguments) string {
+	types := []string{}
+	for _, arg := range args {
+		types = append(types, arg.Type.String())
+	}
+	return strings.Join(types, ",")
+}
+
+// "transfer(address,uint256)"
+func (s *Signature) Text() string {
+	return s.text
+}
+
+// Text with outputs, "balanceOf(address)(uint256)"
+func (s *Signature) String() string {
+	return s.text + s.outputs
+}
+
+// function selector, or custom error selector
+func (s *Signature) Selector() [4]byte {
+	var sel [4]byte
+	copy(sel[:], util.Sha3([]byte(s.text)))
+	return sel
+}
+
+// event topic0
+func (s *Signature) Topic() common.Hash {
+	return common.BytesToHash(util.Sha3([]byte(s.text)))
+}
+
+// index of the ')' that matches the '(' at `open`
+func matching_paren(s string, open int) (int, error) {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, errors.New("unbalanced parentheses")
+}
+
+// split by top level commas
+func split_params(s string) ([]string, error) {
+	result := []string{}
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced parentheses")
+			}
+		case ',':
+			if depth == 0 {
+				result = append(result, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced parentheses")
+	}
+	return append(result, s[start:]), nil
+}
+
+// "address indexed to, uint256" -> abi.Arguments, canonical types ["address", "uint256"]
+func parse_params(s string) (abi.Arguments, []string, error) {
+	args := abi.Arguments{}
+	types := []string{}
+	if strings.TrimSpace(s) == "" {
+		return args, types, nil
+	}
+
+	params, e := split_params(s)
+	if e != nil {
+		return nil, nil, e
+	}
+	for i, p := range params {
+		m, canonical, e := parse_type(p, i)
+		if e != nil {
+			return nil, nil, e
+		}
+		typ, e := abi.NewType(m.Type, "", m.Components)
+		if e != nil {
+			return nil, nil, e
+		}
+		args = append(args, abi.Argument{Type: typ}) // no name, it's not part of the signature
+		types = append(types, canonical)
+	}
+	return args, types, nil
+}
+
+// the parameter names are ignored, tuple fields are named as f0, f1, ...
+// because geth creates a struct for each tuple which requires field names
+func parse_type(p string, index int) (abi.ArgumentMarshaling, string, error) {
+	p = strings.TrimSpace(p)
+	m := abi.ArgumentMarshaling{Name: fmt.Sprintf("f%d", index)}
+	if p == "" {
+		return m, "", errors.New("empty parameter type")
+	}
+
+	if p[0] == '(' { // tuple
+		close_, e := matching_paren(p, 0)
+		if e != nil {
+			return m, "", e
+		}
+		fields, e := split_params(p[1:close_])
+		if e != nil {
+			return m, "", e
+		}
+		if strings.TrimSpace(p[1:close_]) == "" {
+			return m, "", errors.New("empty tuple")
+		}
+		types := []string{}
+		for i, f := range fields {
+			c, canonical, e := parse_type(f, i)
+			if e != nil {
+				return m, "", e
+			}
+			m.Components = append(m.Components, c)
+			types = append(types, canonical)
+		}
+		suffix := strings.Fields(p[close_+1:]) // "[] indexed name"
+		arr := ""
+		if len(suffix) > 0 && strings.HasPrefix(suffix[0], "[") {
+			arr = suffix[0]
+		}
+		m.Type = "tuple" + arr
+		return m, "(" + strings.Join(types, ",") + ")" + arr, nil
+	}
+
+	m.Type = normalize_type(strings.Fields(p)[0]) // drop "indexed" and name
+	return m, m.Type, nil
+}
+
+// uint -> uint256, int[] -> int256[]
+func normalize_type(t string) string {
+	base, arr := t, ""
+	if i := strings.Index(t, "["); i >= 0 {
+		base, arr = t[:i], t[i:]
+	}
+	switch base {
+	case "uint", "int":
+		base += "256"
+	case "byte":
+		base = "bytes1"
+	}
+	return base + arr
+}
@@ -0,0 +1,118 @@
+package edb
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/pkg/errors"
+)
+
+type solcContract struct {
+	Evm struct {
+		DeployedBytecode struct {
+			Object           string `json:"object"`
+			SourceMap        string `json:"sourceMap"`
+			GeneratedSources []struct {
+				Id       int    `json:"id"`
+				Name     string `json:"name"`
+				Contents string `json:"contents"`
+			} `json:"generatedSources"`
+		} `json:"deployedBytecode"`
+	} `json:"evm"`
+	StorageLayout *StorageLayout `json:"storageLayout"`
+}
+
+// solc standard json output
+type solcJson struct {
+	Contracts map[string]map[string]*solcContract `json:"contracts"` // map[file]map[name]
+	Sources   map[string]struct {
+		Id int `json:"id"`
+	} `json:"sources"`
+}
+
+// hardhat/foundry build-info, which has both input and output
+type buildInfo struct {
+	Input struct {
+		Sources map[string]struct {
+			Content string `json:"content"`
+		} `json:"sources"`
+	} `json:"input"`
+	Output *solcJson `json:"output"`
+}
+
+// solc output or build-info, the `buildInfo.Input` is empty for solc output
+func parse_solc_json(bs []byte) (*buildInfo, *solcJson, error) {
+	bi := &buildInfo{}
+	if e := json.Unmarshal(bs, bi); e != nil {
+		return nil, nil, errors.Wrap(e, "invalid solc json")
+	}
+	out := bi.Output
+	if out == nil { // plain solc output
+		out = &solcJson{}
+		if e := json.Unmarshal(bs, out); e != nil {
+			return nil, nil, errors.Wrap(e, "invalid solc json")
+		}
+	}
+	if len(out.Contracts) == 0 {
+		return nil, nil, errors.New("no contracts found in solc json")
+	}
+	return bi, out, nil
+}
+
+/*
+`name` selects the contract, "Token" or "contracts/Token.sol:Token",
+if empty, the one with bytecode most similar to `code` is used.
+Contracts not `valid` are ignored, eg: interfaces without source map
+*/
+func (out *solcJson) select_contract(
+	name string, code []byte, valid func(*solcContract) bool,
+) (string, *solcContract, error) {
+	var fullName string
+	var best *solcContract
+	bestScore, candidates := -1, 0
+
+	for file, contracts := range out.Contracts {
+		for cname, c := range contracts {
+			full := file + ":" + cname
+			score := 0
+			if name != "" {
+				if name != cname && name != full {
+					continue
+				}
+			} else {
+				obj := c.Evm.DeployedBytecode.Object
+				score = similarity(util.HexDec(strings.TrimPrefix(obj, "0x")), code)
+			}
+			if !valid(c) {
+				continue
+			}
+			candidates++
+			if score > bestScore {
+				bestScore, fullName, best = score, full, c
+			}
+		}
+	}
+	if best == nil {
+		return "", nil, errors.Errorf("contract not found: '%s'", name)
+	}
+	if name == "" && bestScore == 0 && candidates > 1 {
+		return "", nil, errors.New("no contract matches the code, specify the contract name")
+	}
+	return fullName, best, nil
+}
+
+// 1 + count of same bytes, 0 if different size,
+// immutables and metadata hash may differ, so not compared exactly
+func similarity(a, b []byte) int {
+	if len(a) != len(b) {
+		return 0
+	}
+	n := 1
+	for i := range a {
+		if a[i] == b[i] {
+			n++
+		}
+	}
+	return n
+}
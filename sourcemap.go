@@ -0,0 +1,359 @@
+package edb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/pkg/errors"
+)
+
+type SourceFile struct {
+	Name    string
+	Content string
+
+	lineStarts []int // byte offset of each line
+}
+
+// 1-based line and column of byte offset
+func (f *SourceFile) line_col(offset int) (int, int) {
+	if f.lineStarts == nil {
+		f.lineStarts = []int{0}
+		for i := 0; i < len(f.Content); i++ {
+			if f.Content[i] == '\n' {
+				f.lineStarts = append(f.lineStarts, i+1)
+			}
+		}
+	}
+	i := sort.Search(len(f.lineStarts), func(i int) bool {
+		return f.lineStarts[i] > offset
+	})
+	return i, offset - f.lineStarts[i-1] + 1
+}
+
+// text of 1-based line `n`, without "\n"
+func (f *SourceFile) Line(n int) string {
+	f.line_col(0) // init lineStarts
+	if n < 1 || n > len(f.lineStarts) {
+		return ""
+	}
+	end := len(f.Content)
+	if n < len(f.lineStarts) {
+		end = f.lineStarts[n] - 1
+	}
+	return strings.TrimSuffix(f.Content[f.lineStarts[n-1]:end], "\r")
+}
+
+// One entry of the solc source map, "s:l:f:j:m"
+type SrcEntry struct {
+	Start  int
+	Length int
+	File   int  // source id, -1 for compiler generated code
+	Jump   byte // 'i': into function, 'o': out of function, '-': regular jump
+}
+
+// decompress solc source map, one entry for each instruction
+// https://docs.soliditylang.org/en/latest/internals/source_mappings.html
+func ParseSrcMap(s string) ([]SrcEntry, error) {
+	result := []SrcEntry{}
+	if s == "" {
+		return result, nil
+	}
+	prev := SrcEntry{File: -1, Jump: '-'}
+
+	for i, item := range strings.Split(s, ";") {
+		cur := prev
+		for j, field := range strings.Split(item, ":") {
+			if field == "" { // same as previous
+				continue
+			}
+			if j == 3 {
+				cur.Jump = field[0]
+				continue
+			}
+			if j > 3 { // modifier depth, not used
+				continue
+			}
+			v, e := strconv.Atoi(field)
+			if e != nil {
+				return nil, errors.Errorf("invalid source map entry %d: '%s'", i, item)
+			}
+			switch j {
+			case 0:
+				cur.Start = v
+			case 1:
+				cur.Length = v
+			case 2:
+				cur.File = v
+			}
+		}
+		result = append(result, cur)
+		prev = cur
+	}
+	return result, nil
+}
+
+// Source location of a pc
+type Location struct {
+	File *SourceFile
+	SrcEntry
+	Line int // 1-based
+	Col  int // 1-based
+}
+
+// "Token.sol:42:5"
+func (l *Location) String() string {
+	return fmt.Sprintf("%s:%d:%d", l.File.Name, l.Line, l.Col)
+}
+
+// same source line
+func (l *Location) SameLine(o *Location) bool {
+	return o != nil && l.File == o.File && l.Line == o.Line
+}
+
+// Source code of a contract, with the solc source map of the deployed bytecode
+type SourceMap struct {
+	Contract string              // "contracts/Token.sol:Token"
+	Files    map[int]*SourceFile // map[source id]
+	Map      string              // compressed source map
+
+	// cache, map[pc]
+	locations map[uint64]*Location
+	asm       *Asm
+}
+
+// map each instruction to pc, rebuilt when code changed
+func (sm *SourceMap) bind(asm *Asm) error {
+	if sm.asm == asm && sm.locations != nil {
+		return nil
+	}
+	entries, e := ParseSrcMap(sm.Map)
+	if e != nil {
+		return e
+	}
+	sm.locations = map[uint64]*Location{}
+	sm.asm = asm
+
+	i := 0
+	for row := 0; row < asm.LineCount() && i < len(entries); row++ {
+		line := asm.AtRow(row)
+		if line.IsData {
+			continue
+		}
+		entry := entries[i]
+		i++
+
+		f, ok := sm.Files[entry.File]
+		if !ok || entry.Start > len(f.Content) {
+			continue // compiler generated
+		}
+		loc := &Location{File: f, SrcEntry: entry}
+		loc.Line, loc.Col = f.line_col(entry.Start)
+		sm.locations[line.Pc] = loc
+	}
+	return nil
+}
+
+// nil if not mapped
+func (sm *SourceMap) Location(asm *Asm, pc uint64) *Location {
+	if e := sm.bind(asm); e != nil {
+		return nil
+	}
+	return sm.locations[pc]
+}
+
+// match file by suffix, eg: "Token.sol" matches "contracts/Token.sol"
+func (sm *SourceMap) find_file(name string) (*SourceFile, error) {
+	found := []*SourceFile{}
+	for _, f := range sm.Files {
+		if f.Name == name {
+			return f, nil
+		}
+		if strings.HasSuffix(f.Name, "/"+name) {
+			found = append(found, f)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, errors.Errorf("no source file: %s", name)
+	case 1:
+		return found[0], nil
+	}
+	return nil, errors.Errorf("ambiguous source file: %s", name)
+}
+
+// all pcs mapped to the source line, sorted
+func (sm *SourceMap) PcsOfLine(asm *Asm, file string, line int) ([]uint64, error) {
+	f, e := sm.find_file(file)
+	if e != nil {
+		return nil, e
+	}
+	if e := sm.bind(asm); e != nil {
+		return nil, e
+	}
+	pcs := []uint64{}
+	for pc, loc := range sm.locations {
+		if loc.File == f && loc.Line == line {
+			pcs = append(pcs, pc)
+		}
+	}
+	if len(pcs) == 0 {
+		return nil, errors.Errorf("no code at %s:%d", f.Name, line)
+	}
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+	return pcs, nil
+}
+
+/*
+Load source map from:
+  - solc standard json output, the source files are read from `baseDir`
+  - hardhat/foundry build-info, which contains the sources
+
+`name` selects the contract, "Token" or "contracts/Token.sol:Token",
+if empty, the one with bytecode most similar to `code` is used
+*/
+func LoadSourceMap(bs []byte, baseDir string, name string, code []byte) (*SourceMap, error) {
+	bi, out, e := parse_solc_json(bs)
+	if e != nil {
+		return nil, e
+	}
+	fullName, c, e := out.select_contract(name, code, func(c *solcContract) bool {
+		return c.Evm.DeployedBytecode.SourceMap != ""
+	})
+	if e != nil {
+		return nil, errors.Wrap(e, "no source map")
+	}
+	bc := c.Evm.DeployedBytecode
+
+	sm := &SourceMap{
+		Contract: fullName,
+		Files:    map[int]*SourceFile{},
+		Map:      bc.SourceMap,
+	}
+	for _, g := range bc.GeneratedSources {
+		sm.Files[g.Id] = &SourceFile{Name: g.Name, Content: g.Contents}
+	}
+	for file, src := range out.Sources {
+		content, ok := "", false
+		if s, found := bi.Input.Sources[file]; found {
+			content, ok = s.Content, true
+		} else if bs, e := os.ReadFile(filepath.Join(baseDir, file)); e == nil {
+			content, ok = string(bs), true
+		}
+		if ok {
+			sm.Files[src.Id] = &SourceFile{Name: file, Content: content}
+		}
+	}
+	return sm, nil
+}
+
+// Attach source map to a contract address
+func (ctx *Context) SetSourceMap(addr common.Address, sm *SourceMap) {
+	if ctx.Sources == nil {
+		ctx.Sources = map[common.Address]*SourceMap{}
+	}
+	ctx.Sources[addr] = sm
+}
+
+// Source location of pc in contract `addr`, nil if no source map
+func (ctx *Context) SourceAt(addr common.Address, pc uint64) *Location {
+	sm, ok := ctx.Sources[addr]
+	if !ok {
+		return nil
+	}
+	c, ok := ctx.Contracts[addr]
+	if !ok || c.Code.Asm == nil {
+		return nil
+	}
+	return sm.Location(c.Code.Asm, pc)
+}
+
+// Source location of current pc
+func (ctx *Context) Source() *Location {
+	call := ctx.Call()
+	return ctx.SourceAt(call.CodeAddress(), call.Pc)
+}
+
+// all pcs of the source line in contract `addr`
+func (ctx *Context) PcsOfLine(addr common.Address, file string, line int) ([]uint64, error) {
+	sm, ok := ctx.Sources[addr]
+	if !ok {
+		return nil, errors.Errorf("no source map for: %s", addr.Hex())
+	}
+	c, ok := ctx.Contracts[addr]
+	if !ok || c.Code.Asm == nil {
+		return nil, errors.Errorf("no code for: %s", addr.Hex())
+	}
+	return sm.PcsOfLine(c.Code.Asm, file, line)
+}
+
+var errStepDone = errors.New("step done")
+
+// Hook for `StepLine`
+type lineStepper struct {
+	EmptyHook
+	ctx *Context
+
+	start     *Location
+	callDepth int // ctx.CallStack.Len() when started
+	fnDepth   int // internal function depth, by jump 'i'/'o'
+	over      bool
+}
+
+func (s *lineStepper) PreRun(call *Call, line *Line) error {
+	loc := s.ctx.SourceAt(call.CodeAddress(), line.Pc)
+	if loc == nil || loc.SameLine(s.start) {
+		return nil
+	}
+	if s.over && (s.ctx.CallStack.Len() > s.callDepth || s.fnDepth > 0) {
+		return nil
+	}
+	return errStepDone
+}
+
+func (s *lineStepper) PostRun(call *Call, line *Line) error {
+	if !s.over || s.ctx.CallStack.Len() != s.callDepth {
+		return nil
+	}
+	loc := s.ctx.SourceAt(call.CodeAddress(), line.Pc)
+	if loc == nil {
+		return nil
+	}
+	switch loc.Jump {
+	case 'i':
+		s.fnDepth++
+	case 'o':
+		if s.fnDepth > 0 {
+			s.fnDepth--
+		}
+	}
+	return nil
+}
+
+/*
+Run until the source line changes.
+  - over: step over internal function calls and external calls
+  - otherwise step into them
+
+Code without source map is run through.
+*/
+func (ctx *Context) StepLine(over bool) error {
+	s := &lineStepper{
+		ctx:       ctx,
+		start:     ctx.Source(),
+		callDepth: ctx.CallStack.Len(),
+		over:      over,
+	}
+	ctx.Hooks.Attach(s)
+	defer ctx.Hooks.detach(s)
+
+	e := ctx.Run(-1)
+	if errors.Is(e, errStepDone) {
+		return nil
+	}
+	return e
+}
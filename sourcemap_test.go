@@ -0,0 +1,132 @@
+package edb
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSrcMap(t *testing.T) {
+	entries, e := ParseSrcMap("1:2:0:-;:9;2:1:1:i;;-1:::o")
+	assert.Nil(t, e)
+	assert.Equal(t, []SrcEntry{
+		{1, 2, 0, '-'},
+		{1, 9, 0, '-'},
+		{2, 1, 1, 'i'},
+		{2, 1, 1, 'i'},
+		{-1, 1, 1, 'o'},
+	}, entries)
+
+	_, e = ParseSrcMap("1:x:0")
+	assert.NotNil(t, e)
+}
+
+const sampleSource = `contract A {
+    function f() public {
+        x = 1 + 2;
+        y = 3;
+    }
+}
+`
+
+// x = 1 + 2; y = 3; stop
+const sampleSourceCode = "600260010160005560036001550000"
+
+// solc output for `sampleSource`, the source file is written to `dir`
+func sample_solc_output(t *testing.T, dir string) []byte {
+	assert.Nil(t, util.FileWriteStr(filepath.Join(dir, "A.sol"), sampleSource))
+
+	add := strings.Index(sampleSource, "1 + 2")
+	x := strings.Index(sampleSource, "x = 1 + 2")
+	y := strings.Index(sampleSource, "y = 3")
+	srcMap := fmt.Sprintf("%d:5:0:-;;;%d:9;;%d:5;;;0:0:-1", add, x, y)
+
+	return []byte(fmt.Sprintf(`{
+		"contracts": {"A.sol": {"A": {"evm": {"deployedBytecode": {
+			"object": "%s", "sourceMap": "%s"
+		}}}}},
+		"sources": {"A.sol": {"id": 0}}
+	}`, sampleSourceCode, srcMap))
+}
+
+func sample_source_context(t *testing.T) *Context {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+
+	this := common.HexToAddress("0x1234")
+	contract := NewContract()
+	contract.Balance = big.NewInt(0)
+	contract.Code.Set(util.HexDec(sampleSourceCode))
+	ctx.Contracts[this] = contract
+	ctx.Call().This = this
+
+	dir := t.TempDir()
+	sm, e := LoadSourceMap(sample_solc_output(t, dir), dir, "", contract.Code.Binary)
+	assert.Nil(t, e)
+	assert.Equal(t, "A.sol:A", sm.Contract)
+	ctx.SetSourceMap(this, sm)
+	return ctx
+}
+
+func TestSourceLocation(t *testing.T) {
+	ctx := sample_source_context(t)
+	this := ctx.This()
+
+	loc := ctx.SourceAt(this, 0)
+	assert.Equal(t, "A.sol:3:13", loc.String())
+	assert.Equal(t, "        x = 1 + 2;", loc.File.Line(loc.Line))
+
+	assert.Equal(t, "A.sol:3:9", ctx.SourceAt(this, 7).String()) // SSTORE
+	assert.Nil(t, ctx.SourceAt(this, 13))                        // STOP, compiler generated
+
+	pcs, e := ctx.PcsOfLine(this, "A.sol", 4)
+	assert.Nil(t, e)
+	assert.Equal(t, []uint64{8, 10, 12}, pcs)
+
+	_, e = ctx.PcsOfLine(this, "A.sol", 1)
+	assert.NotNil(t, e)
+}
+
+func TestStepLine(t *testing.T) {
+	ctx := sample_source_context(t)
+
+	assert.Nil(t, ctx.StepLine(false))
+	assert.Equal(t, uint64(8), ctx.Pc())
+	assert.Equal(t, uint256.NewInt(3), ctx.Contract().Storage[common.Hash{}])
+
+	// the rest is unmapped, run to the end
+	assert.Nil(t, ctx.StepLine(true))
+	assert.True(t, ctx.IsDone)
+	assert.Equal(t, 0, len(ctx.Hooks.List()))
+}
+
+func TestSourceMapSaveLoad(t *testing.T) {
+	ctx := sample_source_context(t)
+
+	fn := filepath.Join(t.TempDir(), "a.json")
+	assert.Nil(t, ctx.Save(fn))
+
+	ctx2 := &Context{}
+	assert.Nil(t, ctx2.Load(fn))
+	assert.Equal(t, "A.sol:4:9", ctx2.SourceAt(ctx2.This(), 8).String())
+}
+
+func TestFindSourceFile(t *testing.T) {
+	sm := &SourceMap{Files: map[int]*SourceFile{
+		0: {Name: "contracts/Token.sol"},
+		1: {Name: "lib/Math.sol"},
+	}}
+	f, e := sm.find_file("Token.sol")
+	assert.Nil(t, e)
+	assert.Equal(t, "contracts/Token.sol", f.Name)
+
+	_, e = sm.find_file("ken.sol")
+	assert.NotNil(t, e)
+}
@@ -0,0 +1,165 @@
+package statetest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/pkg/errors"
+)
+
+// GeneralStateTests fixture format, see:
+//   https://ethereum-tests.readthedocs.io/en/latest/test_types/state_tests.html
+
+type Env struct {
+	Coinbase   common.Address `json:"currentCoinbase"`
+	Difficulty string         `json:"currentDifficulty"`
+	Random     string         `json:"currentRandom"`
+	GasLimit   string         `json:"currentGasLimit"`
+	Number     string         `json:"currentNumber"`
+	Timestamp  string         `json:"currentTimestamp"`
+	BaseFee    string         `json:"currentBaseFee"`
+
+	ExcessBlobGas string `json:"currentExcessBlobGas"`
+}
+
+type Account struct {
+	Balance string            `json:"balance"`
+	Code    string            `json:"code"`
+	Nonce   string            `json:"nonce"`
+	Storage map[string]string `json:"storage"`
+}
+
+type Transaction struct {
+	Data                 []string        `json:"data"`
+	GasLimit             []string        `json:"gasLimit"`
+	Value                []string        `json:"value"`
+	GasPrice             string          `json:"gasPrice"`
+	MaxFeePerGas         string          `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string          `json:"maxPriorityFeePerGas"`
+	Nonce                string          `json:"nonce"`
+	To                   string          `json:"to"` // empty for contract creation
+	Sender               *common.Address `json:"sender"`
+	SecretKey            string          `json:"secretKey"`
+	BlobHashes           []common.Hash   `json:"blobVersionedHashes"`
+}
+
+type Indexes struct {
+	Data  int `json:"data"`
+	Gas   int `json:"gas"`
+	Value int `json:"value"`
+}
+
+type PostState struct {
+	Hash    common.Hash `json:"hash"`
+	Logs    common.Hash `json:"logs"`
+	Indexes Indexes     `json:"indexes"`
+
+	// The expected post state, only exists in some fixtures
+	// eg: those filled by execution-spec-tests
+	State map[common.Address]Account `json:"state"`
+
+	ExpectException string `json:"expectException"`
+}
+
+type StateTest struct {
+	Name string `json:"-"`
+	File string `json:"-"`
+
+	Env         Env                        `json:"env"`
+	Pre         map[common.Address]Account `json:"pre"`
+	Transaction Transaction                `json:"transaction"`
+	Post        map[string][]PostState     `json:"post"` // map[fork][]PostState
+}
+
+// Sorted fork names of this test
+func (t *StateTest) Forks() []string {
+	forks := []string{}
+	for fork := range t.Post {
+		forks = append(forks, fork)
+	}
+	sort.Strings(forks)
+	return forks
+}
+
+// Load all tests in a fixture file, a file may contain multiple tests
+func LoadFile(fn string) ([]*StateTest, error) {
+	bs, e := ioutil.ReadFile(fn)
+	if e != nil {
+		return nil, e
+	}
+	m := map[string]*StateTest{}
+	if e = json.Unmarshal(bs, &m); e != nil {
+		return nil, errors.Wrap(e, fn)
+	}
+
+	tests := []*StateTest{}
+	for name, t := range m {
+		t.Name = name
+		t.File = fn
+		tests = append(tests, t)
+	}
+	sort.Slice(tests, func(i, j int) bool {
+		return tests[i].Name < tests[j].Name
+	})
+	return tests, nil
+}
+
+// Recursively load all .json fixtures in `dir`
+func LoadDir(dir string) ([]*StateTest, error) {
+	tests := []*StateTest{}
+
+	e := filepath.Walk(dir, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		ts, e := LoadFile(path)
+		if e != nil {
+			return e
+		}
+		tests = append(tests, ts...)
+		return nil
+	})
+	return tests, e
+}
+
+// "0x1a", "26" -> 26
+func parse_big(s string) (*big.Int, error) {
+	if s == "" {
+		return big.NewInt(0), nil
+	}
+	v, ok := math.ParseBig256(s)
+	if !ok {
+		return nil, errors.Errorf("invalid number: %s", s)
+	}
+	return v, nil
+}
+func parse_u64(s string) (uint64, error) {
+	v, e := parse_big(s)
+	if e != nil {
+		return 0, e
+	}
+	return v.Uint64(), nil
+}
+
+// "0x6001", "" -> []byte
+func parse_bytes(s string) (util.ByteSlice, error) {
+	if s == "" || s == "0x" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, "0x") {
+		return nil, errors.Errorf("unsupported data format: %s", s)
+	}
+	return hexutil.Decode(s)
+}
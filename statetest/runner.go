@@ -0,0 +1,594 @@
+package statetest
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+)
+
+var ErrSkipped = errors.New("skipped")
+
+// Collects logs and executed opcodes while running,
+// logs are buffered per call frame and dropped when the frame reverts
+type collector struct {
+	edb.EmptyHook
+	ctx     *edb.Context
+	frames  [][]*types.Log // logs of each frame of the call stack
+	Opcodes map[vm.OpCode]bool
+}
+
+// match the frames with the call stack, logs of returned frames go to the caller
+func (c *collector) sync(depth int) {
+	for len(c.frames) > depth {
+		n := len(c.frames)
+		c.frames[n-2] = append(c.frames[n-2], c.frames[n-1]...)
+		c.frames = c.frames[:n-1]
+	}
+	for len(c.frames) < depth {
+		c.frames = append(c.frames, nil)
+	}
+}
+
+func (c *collector) PreRun(call *edb.Call, line *edb.Line) error {
+	opcode := line.Op.OpCode
+	c.Opcodes[opcode] = true
+
+	depth := c.ctx.CallStack.Len()
+	c.sync(depth)
+
+	switch opcode {
+	case vm.LOG0, vm.LOG1, vm.LOG2, vm.LOG3, vm.LOG4:
+		stack := &call.Stack
+		offset, size := stack.PeekI(0).Uint64(), stack.PeekI(1).Uint64()
+
+		log := &types.Log{
+			Address: call.This,
+			Data:    call.Memory.GetCopy(int64(offset), int64(size)),
+		}
+		for i := 0; i < int(opcode-vm.LOG0); i++ {
+			log.Topics = append(log.Topics, stack.PeekI(2+i).Bytes32())
+		}
+		c.frames[depth-1] = append(c.frames[depth-1], log)
+	case vm.REVERT:
+		c.frames[depth-1] = nil // including logs of the returned sub calls
+	}
+	return nil
+}
+
+// logs of the whole tx
+func (c *collector) Logs() []*types.Log {
+	if len(c.frames) == 0 {
+		return nil
+	}
+	c.sync(1)
+	return c.frames[0]
+}
+
+func rlp_hash(x any) (h common.Hash) {
+	hw := sha3.NewLegacyKeccak256()
+	rlp.Encode(hw, x)
+	hw.Sum(h[:0])
+	return h
+}
+
+// Build the Context for the post state `idx`
+func (t *StateTest) Context(idx Indexes) (*edb.Context, error) {
+	tx := &t.Transaction
+
+	if tx.To == "" {
+		return nil, errors.Wrap(ErrSkipped, "contract creation")
+	}
+	if idx.Data >= len(tx.Data) || idx.Gas >= len(tx.GasLimit) || idx.Value >= len(tx.Value) {
+		return nil, errors.Errorf("invalid indexes: %+v", idx)
+	}
+
+	ctx := edb.NewContext()
+	ctx.Chain.Id = 1
+	ctx.Chain.Offline = true
+
+	// block
+	var e error
+	env := &t.Env
+	ctx.Block.Coinbase = env.Coinbase
+	if ctx.Block.Number, e = parse_u64(env.Number); e != nil {
+		return nil, errors.Wrap(e, "env.currentNumber")
+	}
+	if ctx.Block.Timestamp, e = parse_u64(env.Timestamp); e != nil {
+		return nil, errors.Wrap(e, "env.currentTimestamp")
+	}
+	if ctx.Block.GasLimit, e = parse_u64(env.GasLimit); e != nil {
+		return nil, errors.Wrap(e, "env.currentGasLimit")
+	}
+	if ctx.Block.Difficulty, e = parse_big(env.Difficulty); e != nil {
+		return nil, errors.Wrap(e, "env.currentDifficulty")
+	}
+	// post-Merge fixtures have `currentRandom`, and `currentDifficulty` is 0
+	if env.Random != "" {
+		random, e := parse_big(env.Random)
+		if e != nil {
+			return nil, errors.Wrap(e, "env.currentRandom")
+		}
+		ctx.Block.PrevRandao = common.BigToHash(random)
+		ctx.Block.Difficulty = big.NewInt(0)
+	}
+	if env.ExcessBlobGas != "" {
+		if ctx.Block.ExcessBlobGas, e = parse_u64(env.ExcessBlobGas); e != nil {
+			return nil, errors.Wrap(e, "env.currentExcessBlobGas")
+		}
+	}
+	baseFee, e := parse_big(env.BaseFee)
+	if e != nil {
+		return nil, errors.Wrap(e, "env.currentBaseFee")
+	}
+	ctx.Block.BaseFee = baseFee.Uint64()
+
+	// same as geth's state test runner
+	for n := uint64(0); n < ctx.Block.Number && n < 256; n++ {
+		num := ctx.Block.Number - 1 - n
+		ctx.BlockHashes[num] = crypto.Keccak256Hash(
+			[]byte(new(big.Int).SetUint64(num).String()))
+	}
+
+	// pre state
+	for addr, acc := range t.Pre {
+		contract := edb.NewContract()
+		if contract.Balance, e = parse_big(acc.Balance); e != nil {
+			return nil, errors.Wrapf(e, "pre.%s.balance", addr.Hex())
+		}
+		code, e := parse_bytes(acc.Code)
+		if e != nil {
+			return nil, errors.Wrapf(e, "pre.%s.code", addr.Hex())
+		}
+		if e = contract.Code.Set(code); e != nil {
+			return nil, errors.Wrapf(e, "pre.%s.code", addr.Hex())
+		}
+		if contract.Nonce, e = parse_u64(acc.Nonce); e != nil {
+			return nil, errors.Wrapf(e, "pre.%s.nonce", addr.Hex())
+		}
+		for k, v := range acc.Storage {
+			val, e := parse_big(v)
+			if e != nil {
+				return nil, errors.Wrapf(e, "pre.%s.storage", addr.Hex())
+			}
+			contract.Storage[common.HexToHash(k)], _ = uint256.FromBig(val)
+		}
+		ctx.Contracts[addr] = contract
+	}
+
+	// transaction
+	sender, e := tx.sender()
+	if e != nil {
+		return nil, e
+	}
+	to := common.HexToAddress(tx.To)
+	if code := ctx.Contracts[to]; code == nil || len(code.Code.Binary) == 0 {
+		return nil, errors.Wrap(ErrSkipped, "calling account without code")
+	}
+
+	gasPrice, e := tx.effective_gas_price(baseFee)
+	if e != nil {
+		return nil, e
+	}
+	ctx.Tx = edb.Tx{
+		Origin:     sender,
+		GasPrice:   gasPrice.Uint64(),
+		BlobHashes: tx.BlobHashes,
+	}
+
+	// the tx processor's part, the interpreter doesn't do these
+	// nonce of the sender
+	if from := ctx.Contracts[sender]; from != nil {
+		from.Nonce++
+	}
+	// value transfer
+	value, e := parse_big(tx.Value[idx.Value])
+	if e != nil {
+		return nil, errors.Wrap(e, "transaction.value")
+	}
+	if from := ctx.Contracts[sender]; from != nil {
+		from.Balance.Sub(from.Balance, value)
+	}
+	ctx.Contracts[to].Balance.Add(ctx.Contracts[to].Balance, value)
+
+	call := ctx.Call()
+	call.This = to
+	call.Msg.Sender = sender
+	if call.Msg.Data, e = parse_bytes(tx.Data[idx.Data]); e != nil {
+		return nil, errors.Wrap(e, "transaction.data")
+	}
+	if call.Msg.Gas, e = parse_u64(tx.GasLimit[idx.Gas]); e != nil {
+		return nil, errors.Wrap(e, "transaction.gasLimit")
+	}
+	call.Msg.Value = value
+
+	return ctx, nil
+}
+
+func (tx *Transaction) sender() (common.Address, error) {
+	if tx.Sender != nil {
+		return *tx.Sender, nil
+	}
+	key, e := crypto.HexToECDSA(strings.TrimPrefix(tx.SecretKey, "0x"))
+	if e != nil {
+		return common.Address{}, errors.Wrap(e, "transaction.secretKey")
+	}
+	return crypto.PubkeyToAddress(key.PublicKey), nil
+}
+
+// min(maxFeePerGas, baseFee + maxPriorityFeePerGas) for EIP-1559 tx
+func (tx *Transaction) effective_gas_price(baseFee *big.Int) (*big.Int, error) {
+	if tx.MaxFeePerGas == "" {
+		return parse_big(tx.GasPrice)
+	}
+	feeCap, e := parse_big(tx.MaxFeePerGas)
+	if e != nil {
+		return nil, e
+	}
+	tip, e := parse_big(tx.MaxPriorityFeePerGas)
+	if e != nil {
+		return nil, e
+	}
+	price := new(big.Int).Add(baseFee, tip)
+	if price.Cmp(feeCap) > 0 {
+		price = feeCap
+	}
+	return price, nil
+}
+
+type Result struct {
+	Name  string
+	Fork  string
+	Index Indexes
+
+	Err     error // nil if passed
+	Opcodes map[vm.OpCode]bool
+	FailAt  *vm.OpCode // the opcode that failed the execution, if any
+
+	LogsOnly bool // the fixture has no post state, only the logs are checked
+}
+
+func (r *Result) Passed() bool {
+	return r.Err == nil
+}
+func (r *Result) Skipped() bool {
+	return errors.Is(r.Err, ErrSkipped)
+}
+func (r *Result) String() string {
+	status := "PASS"
+	if r.Skipped() {
+		status = "SKIP"
+	} else if !r.Passed() {
+		status = "FAIL"
+	}
+	s := fmt.Sprintf("%s  %s/%s [d:%d g:%d v:%d]",
+		status, r.Name, r.Fork, r.Index.Data, r.Index.Gas, r.Index.Value)
+	if r.Err != nil {
+		s += ": " + r.Err.Error()
+	} else if r.LogsOnly {
+		s += " (logs only)"
+	}
+	return s
+}
+
+// fixture timestamps are not mainnet ones, the blob fee fraction is decided by the fork name
+var blobForks = map[string]edb.BlobFork{
+	"Cancun": edb.MainnetBlobSchedule[0],
+	"Prague": edb.MainnetBlobSchedule[1],
+	"Osaka":  edb.MainnetBlobSchedule[1],
+}
+
+/*
+Run one post state of one fork.
+
+The post state root can't be checked, because edb doesn't meter gas,
+so balances of the sender and coinbase never match the real chain.
+What's checked instead:
+  - the logs hash
+  - accounts of the expected `state`, if the fixture contains it, see `compare_state`
+*/
+func (t *StateTest) Run(fork string, post *PostState) *Result {
+	r := &Result{
+		Name:    t.Name,
+		Fork:    fork,
+		Index:   post.Indexes,
+		Opcodes: map[vm.OpCode]bool{},
+	}
+
+	if post.ExpectException != "" {
+		r.Err = errors.Wrap(ErrSkipped, "expects exception: "+post.ExpectException)
+		return r
+	}
+
+	ctx, e := t.Context(post.Indexes)
+	if e != nil {
+		r.Err = e
+		return r
+	}
+	if f, ok := blobForks[fork]; ok {
+		ctx.Chain.BlobSchedule = []edb.BlobFork{{Timestamp: 0, UpdateFraction: f.UpdateFraction}}
+	}
+
+	col := &collector{ctx: ctx, Opcodes: r.Opcodes}
+	ctx.Hooks.Attach(col)
+
+	if e = ctx.Run(-1); e != nil {
+		if line, e2 := ctx.Line(); e2 == nil {
+			r.FailAt = &line.Op.OpCode
+		}
+		r.Err = errors.Wrap(e, "execution")
+		return r
+	}
+
+	if h := rlp_hash(col.Logs()); h != post.Logs {
+		r.Err = errors.Errorf("logs hash mismatch, expect: %s, got: %s",
+			post.Logs.Hex(), h.Hex())
+		return r
+	}
+
+	if post.State == nil {
+		r.LogsOnly = true
+		return r
+	}
+	sender, _ := t.Transaction.sender()
+	r.Err = compare_state(ctx, post.State, sender, t.Env.Coinbase)
+	return r
+}
+
+/*
+Compare the accounts with the expected post state:
+  - balance, except the sender and coinbase, they are changed by the gas fee
+  - nonce, code and storage
+
+Accounts that are not in the expected state must be empty.
+*/
+func compare_state(ctx *edb.Context, state map[common.Address]Account, sender, coinbase common.Address) error {
+	for addr, acc := range state {
+		contract, ok := ctx.Contracts[addr]
+		if !ok {
+			contract = edb.NewContract()
+		}
+
+		if addr != sender && addr != coinbase {
+			balance, e := parse_big(acc.Balance)
+			if e != nil {
+				return errors.Wrapf(e, "post.%s.balance", addr.Hex())
+			}
+			got := contract.Balance
+			if got == nil {
+				got = big.NewInt(0)
+			}
+			if got.Cmp(balance) != 0 {
+				return errors.Errorf("balance mismatch %s, expect: %s, got: %s",
+					addr.Hex(), balance.String(), got.String())
+			}
+		}
+
+		nonce, e := parse_u64(acc.Nonce)
+		if e != nil {
+			return errors.Wrapf(e, "post.%s.nonce", addr.Hex())
+		}
+		if contract.Nonce != nonce {
+			return errors.Errorf("nonce mismatch %s, expect: %d, got: %d",
+				addr.Hex(), nonce, contract.Nonce)
+		}
+
+		code, e := parse_bytes(acc.Code)
+		if e != nil {
+			return errors.Wrapf(e, "post.%s.code", addr.Hex())
+		}
+		if !bytes.Equal(code, contract.Code.Binary) {
+			return errors.Errorf("code mismatch %s", addr.Hex())
+		}
+
+		if e = compare_storage(addr, contract, acc.Storage); e != nil {
+			return e
+		}
+	}
+
+	for addr, contract := range ctx.Contracts {
+		if _, ok := state[addr]; ok || addr == sender || addr == coinbase {
+			continue
+		}
+		if (contract.Balance != nil && contract.Balance.Sign() != 0) ||
+			len(contract.Code.Binary) > 0 || contract.Nonce != 0 {
+			return errors.Errorf("unexpected account %s", addr.Hex())
+		}
+		if e := compare_storage(addr, contract, nil); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func compare_storage(addr common.Address, contract *edb.Contract, storage map[string]string) error {
+	expect := map[common.Hash]*uint256.Int{}
+	for k, v := range storage {
+		val, e := parse_big(v)
+		if e != nil {
+			return errors.Wrapf(e, "post.%s.storage", addr.Hex())
+		}
+		if val.Sign() != 0 {
+			expect[common.HexToHash(k)], _ = uint256.FromBig(val)
+		}
+	}
+	actual := map[common.Hash]*uint256.Int{}
+	for k, v := range contract.Storage {
+		if !v.IsZero() {
+			actual[k] = v
+		}
+	}
+
+	for k, v := range expect {
+		if got, ok := actual[k]; !ok || !got.Eq(v) {
+			return errors.Errorf("storage mismatch %s[%s], expect: %s, got: %v",
+				addr.Hex(), k.Hex(), v.Hex(), got)
+		}
+	}
+	for k, v := range actual {
+		if _, ok := expect[k]; !ok {
+			return errors.Errorf("storage mismatch %s[%s], expect: 0, got: %s",
+				addr.Hex(), k.Hex(), v.Hex())
+		}
+	}
+	return nil
+}
+
+// Run all post states of all forks, `forks` filters by fork name, empty for all
+func RunAll(tests []*StateTest, forks ...string) []*Result {
+	results := []*Result{}
+	for _, t := range tests {
+		for _, fork := range t.Forks() {
+			if len(forks) > 0 && !contains(forks, fork) {
+				continue
+			}
+			posts := t.Post[fork]
+			for i := range posts {
+				results = append(results, t.Run(fork, &posts[i]))
+			}
+		}
+	}
+	return results
+}
+
+func contains(arr []string, s string) bool {
+	for _, x := range arr {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- pass/fail matrix ----
+
+type family struct {
+	Name     string
+	From, To vm.OpCode // inclusive
+}
+
+var families = []family{
+	{"arithmetic", vm.STOP, vm.SIGNEXTEND},
+	{"compare/bitwise", vm.LT, vm.SAR},
+	{"sha3", vm.SHA3, vm.SHA3},
+	{"environment", vm.ADDRESS, vm.EXTCODEHASH},
+	{"block", vm.BLOCKHASH, vm.BASEFEE},
+	{"stack/memory/flow", vm.POP, vm.JUMPDEST},
+	{"push", vm.PUSH1, vm.PUSH32},
+	{"dup", vm.DUP1, vm.DUP16},
+	{"swap", vm.SWAP1, vm.SWAP16},
+	{"log", vm.LOG0, vm.LOG4},
+	{"system", vm.CREATE, vm.SELFDESTRUCT},
+}
+
+func family_of(op vm.OpCode) string {
+	for _, f := range families {
+		if op >= f.From && op <= f.To {
+			return f.Name
+		}
+	}
+	return "unknown"
+}
+
+type cell struct {
+	Pass, Total int
+}
+
+/*
+Pass/fail count per opcode family and fork.
+
+A test counts for every family it executed.
+When the execution itself fails, it only counts as a failure for
+the family of the failing opcode, so a broken opcode in `OpTable`
+stands out instead of failing all families.
+*/
+func Matrix(results []*Result) string {
+	m := map[string]map[string]*cell{} // map[family]map[fork]*cell
+	forkSet := map[string]bool{}
+
+	add := func(fam, fork string, pass bool) {
+		if m[fam] == nil {
+			m[fam] = map[string]*cell{}
+		}
+		c := m[fam][fork]
+		if c == nil {
+			c = &cell{}
+			m[fam][fork] = c
+		}
+		c.Total++
+		if pass {
+			c.Pass++
+		}
+	}
+
+	skipped, logsOnly := 0, 0
+	for _, r := range results {
+		if r.Skipped() {
+			skipped++
+			continue
+		}
+		if r.Passed() && r.LogsOnly {
+			logsOnly++
+		}
+		forkSet[r.Fork] = true
+
+		if r.FailAt != nil {
+			add(family_of(*r.FailAt), r.Fork, false)
+			continue
+		}
+		fams := map[string]bool{}
+		for op := range r.Opcodes {
+			fams[family_of(op)] = true
+		}
+		for fam := range fams {
+			add(fam, r.Fork, r.Passed())
+		}
+	}
+
+	forks := []string{}
+	for f := range forkSet {
+		forks = append(forks, f)
+	}
+	sort.Strings(forks)
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%-20s", "family")
+	for _, f := range forks {
+		fmt.Fprintf(sb, "%16s", f)
+	}
+	sb.WriteString("\n")
+
+	for _, fam := range append(families, family{Name: "unknown"}) {
+		row, ok := m[fam.Name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(sb, "%-20s", fam.Name)
+		for _, f := range forks {
+			if c, ok := row[f]; ok {
+				mark := " "
+				if c.Pass != c.Total {
+					mark = "!"
+				}
+				fmt.Fprintf(sb, "%15s%s", fmt.Sprintf("%d/%d", c.Pass, c.Total), mark)
+			} else {
+				fmt.Fprintf(sb, "%16s", "-")
+			}
+		}
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(sb, "skipped: %d\n", skipped)
+	fmt.Fprintf(sb, "passed with logs checked only: %d\n", logsOnly)
+	return sb.String()
+}
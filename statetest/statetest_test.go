@@ -0,0 +1,77 @@
+package statetest
+
+import (
+	"math/big"
+	"os"
+	"testing"
+
+	"github.com/aj3423/edb"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixture(t *testing.T) {
+	tests, e := LoadDir("testdata")
+	assert.Nil(t, e)
+
+	results := RunAll(tests)
+	assert.Equal(t, 1, len(results))
+	for _, r := range results {
+		assert.True(t, r.Passed(), r.String())
+	}
+	// a wrong balance in the expected state
+	post := &tests[0].Post["Berlin"][0]
+	addr := common.HexToAddress("0x1000")
+	acc := post.State[addr]
+	acc.Balance = "0x0"
+	post.State[addr] = acc
+	r := tests[0].Run("Berlin", post)
+	assert.ErrorContains(t, r.Err, "balance mismatch")
+}
+
+// Run ethereum/tests GeneralStateTests from a local directory:
+//
+//	EDB_STATE_TESTS=/path/to/GeneralStateTests go test -v -run GeneralStateTests ./statetest
+func TestGeneralStateTests(t *testing.T) {
+	dir := os.Getenv("EDB_STATE_TESTS")
+	if dir == "" {
+		t.Skip("EDB_STATE_TESTS not set")
+	}
+	tests, e := LoadDir(dir)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	results := RunAll(tests)
+	for _, r := range results {
+		if !r.Passed() && !r.Skipped() {
+			t.Error(r.String())
+		}
+	}
+	t.Log("\n" + Matrix(results))
+}
+
+func TestRevertedLogs(t *testing.T) {
+	ctx := edb.NewContext()
+	col := &collector{ctx: ctx, Opcodes: map[vm.OpCode]bool{}}
+	run := func(op vm.OpCode) {
+		call := ctx.Call()
+		call.Stack.Push(*uint256.NewInt(0)) // size
+		call.Stack.Push(*uint256.NewInt(0)) // offset
+		assert.Nil(t, col.PreRun(call, &edb.Line{Op: edb.OpTable[op]}))
+	}
+
+	run(vm.LOG0) // root
+	ctx.CallStack.Push(&edb.Call{Msg: edb.Msg{Value: big.NewInt(0)}})
+	run(vm.LOG0) // sub call, reverted
+	run(vm.REVERT)
+	ctx.CallStack.Pop()
+	ctx.CallStack.Push(&edb.Call{Msg: edb.Msg{Value: big.NewInt(0)}})
+	run(vm.LOG0) // sub call, returned
+	ctx.CallStack.Pop()
+	run(vm.STOP)
+
+	assert.Equal(t, 2, len(col.Logs()))
+}
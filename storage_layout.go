@@ -0,0 +1,648 @@
+package edb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// The solc `storageLayout` output
+// https://docs.soliditylang.org/en/latest/internals/layout_in_storage.html#json-output
+type StorageLayout struct {
+	Storage []*StorageItem          `json:"storage"`
+	Types   map[string]*StorageType `json:"types"`
+}
+
+// A state variable, or a struct member
+type StorageItem struct {
+	Label  string `json:"label"`
+	Offset int    `json:"offset"` // byte offset in the slot, from the right
+	Slot   string `json:"slot"`   // decimal
+	Type   string `json:"type"`   // id in `StorageLayout.Types`, eg: "t_uint256"
+}
+
+type StorageType struct {
+	Encoding      string         `json:"encoding"` // "inplace", "mapping", "dynamic_array" or "bytes"
+	Label         string         `json:"label"`    // "mapping(address => uint256)"
+	NumberOfBytes string         `json:"numberOfBytes"`
+	Key           string         `json:"key,omitempty"`     // mapping
+	Value         string         `json:"value,omitempty"`   // mapping
+	Base          string         `json:"base,omitempty"`    // array
+	Members       []*StorageItem `json:"members,omitempty"` // struct
+}
+
+func (t *StorageType) size() int {
+	n, _ := strconv.Atoi(t.NumberOfBytes)
+	return n
+}
+
+/*
+Load from:
+  - the `storageLayout` json
+  - solc standard json output, or hardhat/foundry build-info,
+    the contract is selected like `LoadSourceMap`, see `solcJson.select_contract`
+*/
+func LoadStorageLayout(bs []byte, name string, code []byte) (*StorageLayout, error) {
+	var top map[string]json.RawMessage
+	if e := json.Unmarshal(bs, &top); e != nil {
+		return nil, errors.Wrap(e, "invalid storage layout json")
+	}
+	if _, ok := top["storage"]; ok {
+		layout := &StorageLayout{}
+		if e := json.Unmarshal(bs, layout); e != nil {
+			return nil, errors.Wrap(e, "invalid storage layout json")
+		}
+		return layout, nil
+	}
+
+	_, out, e := parse_solc_json(bs)
+	if e != nil {
+		return nil, e
+	}
+	_, c, e := out.select_contract(name, code, func(c *solcContract) bool {
+		return c.StorageLayout != nil
+	})
+	if e != nil {
+		return nil, errors.Wrap(e, "no storage layout, compile with outputSelection \"storageLayout\"")
+	}
+	return c.StorageLayout, nil
+}
+
+// A decoded state variable, or an element of it
+type StorageVar struct {
+	Name   string // "owner", "balances[0x8ba1...]", "s.a", "arr[2]"
+	Type   string // type label
+	Slot   common.Hash
+	Offset int
+	Value  string
+	Loaded bool // false if the slot is not in local storage and treated as 0
+}
+
+func (v *StorageVar) String() string {
+	return fmt.Sprintf("%s (%s) = %s", v.Name, v.Type, v.Value)
+}
+
+const (
+	maxArrayItems = 100 // items shown for an array
+	maxBytesSlots = 128 // slots read for long string/bytes
+)
+
+// read slot value, returns false if not loaded
+type slotReader func(slot *uint256.Int) (*uint256.Int, bool, error)
+
+type mappingKey struct {
+	key  []byte      // padded to 32 bytes for value types
+	hash common.Hash // the slot of value
+}
+
+type layoutDecoder struct {
+	layout  *StorageLayout
+	read    slotReader
+	keys    map[common.Hash][]mappingKey // map[mapping slot]keys, from preimages
+	vars    []*StorageVar
+	visited map[common.Hash]bool
+}
+
+func new_layout_decoder(layout *StorageLayout, read slotReader, preimages Preimages) *layoutDecoder {
+	d := &layoutDecoder{
+		layout:  layout,
+		read:    read,
+		keys:    map[common.Hash][]mappingKey{},
+		visited: map[common.Hash]bool{},
+	}
+	// keccak(key . slot)
+	for hash, data := range preimages {
+		if len(data) < 32 {
+			continue
+		}
+		slot := common.BytesToHash(data[len(data)-32:])
+		d.keys[slot] = append(d.keys[slot], mappingKey{data[:len(data)-32], hash})
+	}
+	for _, keys := range d.keys {
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i].key, keys[j].key) < 0
+		})
+	}
+	return d
+}
+
+func (d *layoutDecoder) read_slot(slot *uint256.Int) (*uint256.Int, bool, error) {
+	d.visited[common.Hash(slot.Bytes32())] = true
+	return d.read(slot)
+}
+
+func (d *layoutDecoder) type_of(id string) (*StorageType, error) {
+	t, ok := d.layout.Types[id]
+	if !ok {
+		return nil, errors.Errorf("unknown storage type: %s", id)
+	}
+	return t, nil
+}
+
+// keccak256(slot)
+func keccak_slot(slot *uint256.Int) *uint256.Int {
+	b := slot.Bytes32()
+	return new(uint256.Int).SetBytes(util.Sha3(b[:]))
+}
+
+// decode variable of type `typeId` at `slot`, append results to `d.vars`
+func (d *layoutDecoder) decode(name string, typeId string, slot *uint256.Int, offset int) error {
+	t, e := d.type_of(typeId)
+	if e != nil {
+		return e
+	}
+	v := &StorageVar{Name: name, Type: t.Label, Slot: slot.Bytes32(), Offset: offset, Loaded: true}
+
+	switch t.Encoding {
+	case "mapping":
+		kt, e := d.type_of(t.Key)
+		if e != nil {
+			return e
+		}
+		keys := d.keys[slot.Bytes32()]
+		known := []mappingKey{}
+		for _, k := range keys {
+			if kt.Encoding == "bytes" || len(k.key) == 32 {
+				known = append(known, k)
+			}
+		}
+		v.Value = fmt.Sprintf("%d known keys", len(known))
+		d.vars = append(d.vars, v)
+
+		for _, k := range known {
+			keyStr := format_key(k.key, kt)
+			valSlot := new(uint256.Int).SetBytes(k.hash[:])
+			if e := d.decode(name+"["+keyStr+"]", t.Value, valSlot, 0); e != nil {
+				return e
+			}
+		}
+		return nil
+
+	case "dynamic_array":
+		length, loaded, e := d.read_slot(slot)
+		if e != nil {
+			return e
+		}
+		v.Value, v.Loaded = "length "+length.ToBig().String(), loaded
+		d.vars = append(d.vars, v)
+		if !length.IsUint64() {
+			return nil
+		}
+		return d.decode_array(name, t.Base, keccak_slot(slot), length.Uint64())
+
+	case "bytes":
+		data, loaded, e := d.read_bytes(slot)
+		if e != nil {
+			return e
+		}
+		v.Loaded = loaded
+		if t.Label == "string" {
+			v.Value = fmt.Sprintf("%q", data)
+		} else {
+			v.Value = "0x" + util.HexEnc(data)
+		}
+		d.vars = append(d.vars, v)
+		return nil
+
+	case "inplace":
+		if len(t.Members) > 0 { // struct
+			for _, m := range t.Members {
+				mslot, e := member_slot(slot, m)
+				if e != nil {
+					return e
+				}
+				if e := d.decode(name+"."+m.Label, m.Type, mslot, m.Offset); e != nil {
+					return e
+				}
+			}
+			return nil
+		}
+		if t.Base != "" { // static array
+			return d.decode_array(name, t.Base, slot, static_array_len(t.Label))
+		}
+
+		val, loaded, e := d.read_slot(slot)
+		if e != nil {
+			return e
+		}
+		v.Value, v.Loaded = format_storage_value(extract_field(val, offset, t.size()), t.Label, t.size()), loaded
+		d.vars = append(d.vars, v)
+		return nil
+	}
+	return errors.Errorf("unknown storage encoding: %s", t.Encoding)
+}
+
+func member_slot(base *uint256.Int, m *StorageItem) (*uint256.Int, error) {
+	rel, ok := new(big.Int).SetString(m.Slot, 10)
+	if !ok {
+		return nil, errors.Errorf("invalid slot of %s: %s", m.Label, m.Slot)
+	}
+	r, _ := uint256.FromBig(rel)
+	return new(uint256.Int).Add(base, r), nil
+}
+
+// "uint256[3]" -> 3, the last dimension
+func static_array_len(label string) uint64 {
+	i := strings.LastIndex(label, "[")
+	if i < 0 {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSuffix(label[i+1:], "]"), 10, 64)
+	return n
+}
+
+// slot and offset of array element `i`,
+// small items are packed in one slot, large ones take multiple slots
+func (d *layoutDecoder) element_pos(base *uint256.Int, baseType string, i uint64) (*uint256.Int, int, error) {
+	bt, e := d.type_of(baseType)
+	if e != nil {
+		return nil, 0, e
+	}
+	size := bt.size()
+	if size <= 0 {
+		return nil, 0, errors.Errorf("invalid size of %s", bt.Label)
+	}
+	if size <= 32 && bt.Encoding == "inplace" && len(bt.Members) == 0 && bt.Base == "" {
+		perSlot := uint64(32 / size)
+		slot := new(uint256.Int).Add(base, uint256.NewInt(i/perSlot))
+		return slot, int(i%perSlot) * size, nil
+	}
+	slots := uint64((size + 31) / 32)
+	return new(uint256.Int).Add(base, uint256.NewInt(i*slots)), 0, nil
+}
+
+func (d *layoutDecoder) decode_array(name string, baseType string, base *uint256.Int, n uint64) error {
+	limit := n
+	if limit > maxArrayItems {
+		limit = maxArrayItems
+	}
+	for i := uint64(0); i < limit; i++ {
+		slot, offset, e := d.element_pos(base, baseType, i)
+		if e != nil {
+			return e
+		}
+		if e := d.decode(fmt.Sprintf("%s[%d]", name, i), baseType, slot, offset); e != nil {
+			return e
+		}
+	}
+	if n > limit {
+		d.vars = append(d.vars, &StorageVar{
+			Name:   name + "[...]",
+			Value:  fmt.Sprintf("%d more items", n-limit),
+			Loaded: true,
+		})
+	}
+	return nil
+}
+
+/*
+string/bytes:
+  - short(< 32 bytes): data in the higher-order bytes, length*2 in the lowest byte
+  - long: length*2+1 in the slot, data from keccak(slot)
+*/
+func (d *layoutDecoder) read_bytes(slot *uint256.Int) ([]byte, bool, error) {
+	v, loaded, e := d.read_slot(slot)
+	if e != nil {
+		return nil, false, e
+	}
+	b := v.Bytes32()
+	if b[31]&1 == 0 { // short
+		n := int(b[31]) / 2
+		if n > 31 {
+			n = 31
+		}
+		return b[:n], loaded, nil
+	}
+
+	length := new(uint256.Int).Rsh(v, 1)
+	if !length.IsUint64() || length.Uint64() > maxBytesSlots*32 {
+		return nil, loaded, errors.Errorf("string/bytes too long: %s", length.ToBig().String())
+	}
+	n := int(length.Uint64())
+	data := []byte{}
+	pos := keccak_slot(slot)
+	for len(data) < n {
+		chunk, ok, e := d.read_slot(pos)
+		if e != nil {
+			return nil, false, e
+		}
+		loaded = loaded && ok
+		b := chunk.Bytes32()
+		data = append(data, b[:]...)
+		pos = new(uint256.Int).AddUint64(pos, 1)
+	}
+	return data[:n], loaded, nil
+}
+
+// `size` bytes at `offset` from the right
+func extract_field(v *uint256.Int, offset, size int) *uint256.Int {
+	x := new(uint256.Int).Rsh(v, uint(offset*8))
+	if size < 32 {
+		mask := new(uint256.Int).Lsh(uint256.NewInt(1), uint(size*8))
+		mask.SubUint64(mask, 1)
+		x.And(x, mask)
+	}
+	return x
+}
+
+func format_storage_value(v *uint256.Int, label string, size int) string {
+	switch {
+	case label == "bool":
+		return strconv.FormatBool(!v.IsZero())
+	case strings.HasPrefix(label, "address"), strings.HasPrefix(label, "contract "):
+		return common.Address(v.Bytes20()).Hex()
+	case strings.HasPrefix(label, "uint"), strings.HasPrefix(label, "enum "):
+		return v.ToBig().String()
+	case strings.HasPrefix(label, "int"):
+		x := v.ToBig()
+		if size > 0 && x.Bit(size*8-1) == 1 { // negative
+			x.Sub(x, new(big.Int).Lsh(big.NewInt(1), uint(size*8)))
+		}
+		return x.String()
+	case strings.HasPrefix(label, "bytes") && size > 0 && size <= 32:
+		b := v.Bytes32()
+		return "0x" + util.HexEnc(b[32-size:])
+	}
+	return v.Hex()
+}
+
+// mapping key for printing
+func format_key(key []byte, kt *StorageType) string {
+	if kt.Encoding == "bytes" {
+		if kt.Label == "string" {
+			return fmt.Sprintf("%q", key)
+		}
+		return "0x" + util.HexEnc(key)
+	}
+	size := kt.size()
+	if strings.HasPrefix(kt.Label, "bytes") && size > 0 && size <= 32 { // left aligned
+		return "0x" + util.HexEnc(key[:size])
+	}
+	return format_storage_value(extract_field(new(uint256.Int).SetBytes(key), 0, size), kt.Label, size)
+}
+
+// mapping key from user input, eg: "0x8ba1...", "42", "true", "abc"
+func encode_key(s string, kt *StorageType) ([]byte, error) {
+	if kt.Encoding == "bytes" {
+		if kt.Label == "string" {
+			return []byte(strings.Trim(s, `"`)), nil
+		}
+		return util.HexDec(strings.TrimPrefix(s, "0x")), nil
+	}
+
+	size := kt.size()
+	switch {
+	case kt.Label == "bool":
+		v, e := strconv.ParseBool(s)
+		if e != nil {
+			return nil, e
+		}
+		if v {
+			return common.LeftPadBytes([]byte{1}, 32), nil
+		}
+		return make([]byte, 32), nil
+	case strings.HasPrefix(kt.Label, "address"), strings.HasPrefix(kt.Label, "contract "):
+		if !common.IsHexAddress(s) {
+			return nil, errors.Errorf("invalid address: %s", s)
+		}
+		return common.LeftPadBytes(common.HexToAddress(s).Bytes(), 32), nil
+	case strings.HasPrefix(kt.Label, "bytes") && size > 0 && size <= 32:
+		b := util.HexDec(strings.TrimPrefix(s, "0x"))
+		if len(b) != size {
+			return nil, errors.Errorf("invalid %s: %s", kt.Label, s)
+		}
+		return common.RightPadBytes(b, 32), nil
+	}
+
+	// integers and enums
+	x, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, errors.Errorf("invalid %s: %s", kt.Label, s)
+	}
+	if x.Sign() < 0 { // two's complement
+		x.Add(x, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	if x.BitLen() > 256 {
+		return nil, errors.Errorf("invalid %s: %s", kt.Label, s)
+	}
+	return common.LeftPadBytes(x.Bytes(), 32), nil
+}
+
+// "balances[0x8ba1...][3].amount" -> ["balances", "[0x8ba1...]", "[3]", ".amount"]
+func split_var_path(path string) ([]string, error) {
+	i := strings.IndexAny(path, "[.")
+	if i < 0 {
+		return []string{path}, nil
+	}
+	result := []string{path[:i]}
+	rest := path[i:]
+	for rest != "" {
+		switch rest[0] {
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, errors.Errorf("missing ']' in %s", path)
+			}
+			result = append(result, rest[:end+1])
+			rest = rest[end+1:]
+		case '.':
+			end := strings.IndexAny(rest[1:], "[.")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			result = append(result, rest[:end+1])
+			rest = rest[end+1:]
+		default:
+			return nil, errors.Errorf("invalid path: %s", path)
+		}
+	}
+	return result, nil
+}
+
+// resolve "balances[0x8ba1...]" to type id, slot and offset
+func (d *layoutDecoder) resolve(path string) (string, *uint256.Int, int, error) {
+	parts, e := split_var_path(path)
+	if e != nil {
+		return "", nil, 0, e
+	}
+
+	var item *StorageItem
+	for _, it := range d.layout.Storage {
+		if it.Label == parts[0] {
+			item = it
+			break
+		}
+	}
+	if item == nil {
+		return "", nil, 0, errors.Errorf("no state variable: %s", parts[0])
+	}
+	typeId, offset := item.Type, item.Offset
+	slot, e := member_slot(uint256.NewInt(0), item)
+	if e != nil {
+		return "", nil, 0, e
+	}
+
+	for _, part := range parts[1:] {
+		t, e := d.type_of(typeId)
+		if e != nil {
+			return "", nil, 0, e
+		}
+
+		if part[0] == '.' { // struct member
+			var m *StorageItem
+			for _, x := range t.Members {
+				if x.Label == part[1:] {
+					m = x
+				}
+			}
+			if m == nil {
+				return "", nil, 0, errors.Errorf("no member %s in %s", part[1:], t.Label)
+			}
+			if slot, e = member_slot(slot, m); e != nil {
+				return "", nil, 0, e
+			}
+			typeId, offset = m.Type, m.Offset
+			continue
+		}
+
+		key := part[1 : len(part)-1]
+		switch {
+		case t.Encoding == "mapping":
+			kt, e := d.type_of(t.Key)
+			if e != nil {
+				return "", nil, 0, e
+			}
+			kb, e := encode_key(key, kt)
+			if e != nil {
+				return "", nil, 0, e
+			}
+			b := slot.Bytes32()
+			slot = new(uint256.Int).SetBytes(util.Sha3(append(kb, b[:]...)))
+			typeId, offset = t.Value, 0
+
+		case t.Base != "": // array
+			i, e := strconv.ParseUint(key, 0, 64)
+			if e != nil {
+				return "", nil, 0, errors.Errorf("invalid index: %s", key)
+			}
+			base := slot
+			if t.Encoding == "dynamic_array" {
+				length, _, e := d.read_slot(slot)
+				if e != nil {
+					return "", nil, 0, e
+				}
+				if !length.IsUint64() || i >= length.Uint64() {
+					return "", nil, 0, errors.Errorf("index out of range: %d >= %s", i, length.ToBig().String())
+				}
+				base = keccak_slot(slot)
+			} else if n := static_array_len(t.Label); i >= n {
+				return "", nil, 0, errors.Errorf("index out of range: %d >= %d", i, n)
+			}
+			if slot, offset, e = d.element_pos(base, t.Base, i); e != nil {
+				return "", nil, 0, e
+			}
+			typeId = t.Base
+
+		default:
+			return "", nil, 0, errors.Errorf("%s is not indexable", t.Label)
+		}
+	}
+	return typeId, slot, offset, nil
+}
+
+// Attach storage layout to a contract address
+func (ctx *Context) SetStorageLayout(addr common.Address, layout *StorageLayout) {
+	if ctx.Layouts == nil {
+		ctx.Layouts = map[common.Address]*StorageLayout{}
+	}
+	ctx.Layouts[addr] = layout
+}
+
+// Storage layout of `addr`, or of its implementation if it's a proxy,
+// since the proxy storage is used by the implementation code
+func (ctx *Context) LayoutOf(addr common.Address) (*StorageLayout, bool) {
+	if layout, ok := ctx.Layouts[addr]; ok {
+		return layout, true
+	}
+	layout, ok := ctx.Layouts[ctx.Implementation(addr)]
+	return layout, ok
+}
+
+/*
+All state variables decoded from local storage, slots not loaded are treated as 0.
+Mapping entries are found by the SHA3 preimages seen when running.
+Also returns the local slots that don't belong to any variable.
+*/
+func (ctx *Context) StorageVars(addr common.Address) ([]*StorageVar, []common.Hash, error) {
+	layout, ok := ctx.LayoutOf(addr)
+	if !ok {
+		return nil, nil, errors.Errorf("no storage layout for: %s", addr.Hex())
+	}
+	storage := map[common.Hash]*uint256.Int{}
+	if c, ok := ctx.Contracts[addr]; ok {
+		storage = c.Storage
+	}
+	read := func(slot *uint256.Int) (*uint256.Int, bool, error) {
+		v, ok := storage[common.Hash(slot.Bytes32())]
+		if !ok {
+			return uint256.NewInt(0), false, nil
+		}
+		return v, true, nil
+	}
+
+	d := new_layout_decoder(layout, read, ctx.Preimages)
+	for _, item := range layout.Storage {
+		slot, e := member_slot(uint256.NewInt(0), item)
+		if e != nil {
+			return nil, nil, e
+		}
+		if e := d.decode(item.Label, item.Type, slot, item.Offset); e != nil {
+			return nil, nil, e
+		}
+	}
+
+	others := []common.Hash{}
+	for slot := range storage {
+		if !d.visited[slot] {
+			others = append(others, slot)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool {
+		return bytes.Compare(others[i][:], others[j][:]) < 0
+	})
+	return d.vars, others, nil
+}
+
+/*
+Read a variable by path, eg: "tokenId", "balances[0x8ba1...]", "s.arr[2]",
+slots not in local storage are fetched online.
+*/
+func (ctx *Context) ReadStorageVar(addr common.Address, path string) ([]*StorageVar, error) {
+	layout, ok := ctx.LayoutOf(addr)
+	if !ok {
+		return nil, errors.Errorf("no storage layout for: %s", addr.Hex())
+	}
+	read := func(slot *uint256.Int) (*uint256.Int, bool, error) {
+		v, e := ensure_storage(ctx, addr, slot)
+		return v, e == nil, e
+	}
+
+	d := new_layout_decoder(layout, read, ctx.Preimages)
+	typeId, slot, offset, e := d.resolve(path)
+	if e != nil {
+		return nil, e
+	}
+	if e := d.decode(path, typeId, slot, offset); e != nil {
+		return nil, e
+	}
+	return d.vars, nil
+}
@@ -0,0 +1,157 @@
+package edb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/assert"
+)
+
+/*
+	contract A {
+		address owner;
+		bool paused;
+		uint tokenId;
+		mapping(address => uint) balances;
+		string name;
+		uint64[] ids;
+		S s; // struct S { uint128 a; int128 b; }
+	}
+*/
+const sampleLayout = `{
+	"storage": [
+		{"label": "owner", "offset": 0, "slot": "0", "type": "t_address"},
+		{"label": "paused", "offset": 20, "slot": "0", "type": "t_bool"},
+		{"label": "tokenId", "offset": 0, "slot": "1", "type": "t_uint256"},
+		{"label": "balances", "offset": 0, "slot": "2", "type": "t_mapping(t_address,t_uint256)"},
+		{"label": "name", "offset": 0, "slot": "3", "type": "t_string_storage"},
+		{"label": "ids", "offset": 0, "slot": "4", "type": "t_array(t_uint64)dyn_storage"},
+		{"label": "s", "offset": 0, "slot": "5", "type": "t_struct(S)storage"}
+	],
+	"types": {
+		"t_address": {"encoding": "inplace", "label": "address", "numberOfBytes": "20"},
+		"t_bool": {"encoding": "inplace", "label": "bool", "numberOfBytes": "1"},
+		"t_uint256": {"encoding": "inplace", "label": "uint256", "numberOfBytes": "32"},
+		"t_uint64": {"encoding": "inplace", "label": "uint64", "numberOfBytes": "8"},
+		"t_uint128": {"encoding": "inplace", "label": "uint128", "numberOfBytes": "16"},
+		"t_int128": {"encoding": "inplace", "label": "int128", "numberOfBytes": "16"},
+		"t_string_storage": {"encoding": "bytes", "label": "string", "numberOfBytes": "32"},
+		"t_mapping(t_address,t_uint256)": {"encoding": "mapping", "key": "t_address", "label": "mapping(address => uint256)", "numberOfBytes": "32", "value": "t_uint256"},
+		"t_array(t_uint64)dyn_storage": {"base": "t_uint64", "encoding": "dynamic_array", "label": "uint64[]", "numberOfBytes": "32"},
+		"t_struct(S)storage": {"encoding": "inplace", "label": "struct A.S", "numberOfBytes": "32", "members": [
+			{"label": "a", "offset": 0, "slot": "0", "type": "t_uint128"},
+			{"label": "b", "offset": 16, "slot": "0", "type": "t_int128"}
+		]}
+	}
+}`
+
+func sample_layout_context(t *testing.T) *Context {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+
+	this := common.HexToAddress("0x1234")
+	contract := NewContract()
+	contract.Balance = big.NewInt(0)
+	ctx.Contracts[this] = contract
+	ctx.Call().This = this
+
+	layout, e := LoadStorageLayout([]byte(sampleLayout), "", nil)
+	assert.Nil(t, e)
+	ctx.SetStorageLayout(this, layout)
+
+	set := func(slot *uint256.Int, val []byte) {
+		contract.Storage[slot.Bytes32()] = new(uint256.Int).SetBytes(val)
+	}
+	// owner and paused packed in slot 0
+	set(uint256.NewInt(0), util.HexDec("01"+"1111111111111111111111111111111111111111"))
+	set(uint256.NewInt(1), []byte{42})
+
+	// balances[0x2222...] = 100, the key is recorded when running SHA3
+	who := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	key := append(common.LeftPadBytes(who[:], 32), common.LeftPadBytes([]byte{2}, 32)...)
+	hash := util.Sha3(key)
+	ctx.record_preimage(hash, key)
+	set(new(uint256.Int).SetBytes(hash), []byte{100})
+
+	// short string "abc"
+	set(uint256.NewInt(3), append(common.RightPadBytes([]byte("abc"), 31), 6))
+
+	// ids = [7, 8, 9], packed 4 in a slot
+	set(uint256.NewInt(4), []byte{3})
+	set(keccak_slot(uint256.NewInt(4)), util.HexDec("0000000000000009"+"0000000000000008"+"0000000000000007"))
+
+	// s.a = 5, s.b = -1
+	set(uint256.NewInt(5), util.HexDec("ffffffffffffffffffffffffffffffff"+"00000000000000000000000000000005"))
+
+	// slot not in layout
+	set(uint256.NewInt(0x99), []byte{1})
+	return ctx
+}
+
+func TestStorageVars(t *testing.T) {
+	ctx := sample_layout_context(t)
+
+	vars, others, e := ctx.StorageVars(ctx.This())
+	assert.Nil(t, e)
+
+	result := []string{}
+	for _, v := range vars {
+		result = append(result, v.String())
+	}
+	assert.Equal(t, []string{
+		"owner (address) = 0x1111111111111111111111111111111111111111",
+		"paused (bool) = true",
+		"tokenId (uint256) = 42",
+		"balances (mapping(address => uint256)) = 1 known keys",
+		"balances[0x2222222222222222222222222222222222222222] (uint256) = 100",
+		`name (string) = "abc"`,
+		"ids (uint64[]) = length 3",
+		"ids[0] (uint64) = 7",
+		"ids[1] (uint64) = 8",
+		"ids[2] (uint64) = 9",
+		"s.a (uint128) = 5",
+		"s.b (int128) = -1",
+	}, result)
+	assert.Equal(t, []common.Hash{common.BigToHash(big.NewInt(0x99))}, others)
+}
+
+func TestLongString(t *testing.T) {
+	ctx := sample_layout_context(t)
+	storage := ctx.Contract().Storage
+
+	s := "a string longer than thirty-one bytes"
+	storage[uint256.NewInt(3).Bytes32()] = uint256.NewInt(uint64(len(s)*2 + 1))
+	data := keccak_slot(uint256.NewInt(3))
+	storage[data.Bytes32()] = new(uint256.Int).SetBytes([]byte(s[:32]))
+	storage[new(uint256.Int).AddUint64(data, 1).Bytes32()] = new(uint256.Int).SetBytes(
+		common.RightPadBytes([]byte(s[32:]), 32))
+
+	vars, e := ctx.ReadStorageVar(ctx.This(), "name")
+	assert.Nil(t, e)
+	assert.Equal(t, `name (string) = "`+s+`"`, vars[0].String())
+}
+
+func TestReadStorageVar(t *testing.T) {
+	ctx := sample_layout_context(t)
+	this := ctx.This()
+
+	for path, expected := range map[string]string{
+		"tokenId": "tokenId (uint256) = 42",
+		"balances[0x2222222222222222222222222222222222222222]": "balances[0x2222222222222222222222222222222222222222] (uint256) = 100",
+		"balances[0x3333333333333333333333333333333333333333]": "balances[0x3333333333333333333333333333333333333333] (uint256) = 0",
+		"ids[2]": "ids[2] (uint64) = 9",
+		"s.b":    "s.b (int128) = -1",
+	} {
+		vars, e := ctx.ReadStorageVar(this, path)
+		assert.Nil(t, e, path)
+		assert.Equal(t, expected, vars[0].String())
+	}
+
+	for _, path := range []string{"nope", "ids[3]", "tokenId[1]", "s.c", "balances[xyz]"} {
+		_, e := ctx.ReadStorageVar(this, path)
+		assert.NotNil(t, e, path)
+	}
+}
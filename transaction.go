@@ -0,0 +1,223 @@
+package edb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/pkg/errors"
+)
+
+const (
+	LegacyTxType     = 0
+	AccessListTxType = 1
+	DynamicFeeTxType = 2
+	BlobTxType       = 3 // EIP-4844
+	SetCodeTxType    = 4 // EIP-7702
+)
+
+/*
+Transaction from `eth_getTransactionByHash`.
+
+The raw json is decoded here, it's converted to
+`types.Transaction` only for legacy, access list and dynamic fee txs.
+*/
+type rpcTx struct {
+	raw json.RawMessage
+
+	Type                 hexutil.Uint64     `json:"type"`
+	Hash                 common.Hash        `json:"hash"`
+	BlockNumber          *hexutil.Big       `json:"blockNumber"` // nil if pending
+	ChainId              *hexutil.Big       `json:"chainId"`
+	Nonce                hexutil.Uint64     `json:"nonce"`
+	From                 common.Address     `json:"from"`
+	To                   *common.Address    `json:"to"` // nil for contract creation
+	Input                hexutil.Bytes      `json:"input"`
+	Value                *hexutil.Big       `json:"value"`
+	Gas                  hexutil.Uint64     `json:"gas"`
+	GasPrice             *hexutil.Big       `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big       `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big       `json:"maxPriorityFeePerGas"`
+	MaxFeePerBlobGas     *hexutil.Big       `json:"maxFeePerBlobGas"`
+	AccessList           types.AccessList   `json:"accessList"`
+	BlobHashes           []common.Hash      `json:"blobVersionedHashes"`
+	AuthorizationList    []rpcAuthorization `json:"authorizationList"`
+	V                    *hexutil.Big       `json:"v"`
+	R                    *hexutil.Big       `json:"r"`
+	S                    *hexutil.Big       `json:"s"`
+}
+
+// EIP-7702 authorization tuple, signed by the authority
+type rpcAuthorization struct {
+	ChainId *hexutil.Big   `json:"chainId"`
+	Address common.Address `json:"address"`
+	Nonce   hexutil.Uint64 `json:"nonce"`
+	YParity hexutil.Uint64 `json:"yParity"`
+	R       *hexutil.Big   `json:"r"`
+	S       *hexutil.Big   `json:"s"`
+}
+
+func get_online_tx(
+	client *rpc.Client,
+	hash common.Hash,
+) (*rpcTx, error) {
+	if client == nil {
+		return nil, errors.New("no node connected")
+	}
+	var raw json.RawMessage
+	e := client.CallContext(
+		context.Background(), &raw, "eth_getTransactionByHash", hash)
+	if e != nil {
+		return nil, e
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, fmt.Errorf("tx not found: %s", hash.Hex())
+	}
+	tx := &rpcTx{raw: raw}
+	if e = json.Unmarshal(raw, tx); e != nil {
+		return nil, errors.Wrap(e, "decode tx")
+	}
+	return tx, nil
+}
+
+// recover sender from signature
+func (tx *rpcTx) sender(chainId *big.Int) (common.Address, error) {
+	switch tx.Type {
+	case LegacyTxType, AccessListTxType, DynamicFeeTxType:
+		gtx := &types.Transaction{}
+		if e := gtx.UnmarshalJSON(tx.raw); e != nil {
+			return common.Address{}, e
+		}
+		return types.Sender(types.LatestSignerForChainID(chainId), gtx)
+
+	case BlobTxType:
+		return tx.blob_tx_sender(chainId)
+
+	case SetCodeTxType:
+		return tx.set_code_tx_sender(chainId)
+
+	default:
+		// unknown tx type, trust the node
+		return tx.From, nil
+	}
+}
+
+// fields shared by blob and set code txs, nil if any is missing
+func (tx *rpcTx) dynamic_fee_fields(chainId *big.Int) []interface{} {
+	if tx.To == nil || tx.MaxFeePerGas == nil || tx.MaxPriorityFeePerGas == nil || tx.Value == nil {
+		return nil
+	}
+	accessList := tx.AccessList
+	if accessList == nil {
+		accessList = types.AccessList{}
+	}
+	return []interface{}{
+		chainId,
+		uint64(tx.Nonce),
+		tx.MaxPriorityFeePerGas.ToInt(),
+		tx.MaxFeePerGas.ToInt(),
+		uint64(tx.Gas),
+		*tx.To,
+		tx.Value.ToInt(),
+		[]byte(tx.Input),
+		accessList,
+	}
+}
+
+// sig hash: keccak256(0x03 || rlp([chain_id, nonce, max_priority_fee_per_gas,
+// max_fee_per_gas, gas_limit, to, value, data, access_list,
+// max_fee_per_blob_gas, blob_versioned_hashes]))
+func (tx *rpcTx) blob_tx_sender(chainId *big.Int) (common.Address, error) {
+	fields := tx.dynamic_fee_fields(chainId)
+	if fields == nil || tx.MaxFeePerBlobGas == nil {
+		return common.Address{}, errors.New("blob tx: missing fields")
+	}
+	blobHashes := tx.BlobHashes
+	if blobHashes == nil {
+		blobHashes = []common.Hash{}
+	}
+	fields = append(fields, tx.MaxFeePerBlobGas.ToInt(), blobHashes)
+	return tx.typed_tx_sender(BlobTxType, fields)
+}
+
+// sig hash: keccak256(0x04 || rlp([chain_id, nonce, max_priority_fee_per_gas,
+// max_fee_per_gas, gas_limit, destination, value, data, access_list,
+// authorization_list])),
+// authorization_list: [[chain_id, address, nonce, y_parity, r, s], ...]
+func (tx *rpcTx) set_code_tx_sender(chainId *big.Int) (common.Address, error) {
+	fields := tx.dynamic_fee_fields(chainId)
+	if fields == nil {
+		return common.Address{}, errors.New("set code tx: missing fields")
+	}
+	auths := []interface{}{}
+	for i, a := range tx.AuthorizationList {
+		if a.ChainId == nil || a.R == nil || a.S == nil {
+			return common.Address{}, fmt.Errorf("set code tx: authorizationList[%d]: missing fields", i)
+		}
+		auths = append(auths, []interface{}{
+			a.ChainId.ToInt(),
+			a.Address,
+			uint64(a.Nonce),
+			uint64(a.YParity),
+			a.R.ToInt(),
+			a.S.ToInt(),
+		})
+	}
+	fields = append(fields, auths)
+	return tx.typed_tx_sender(SetCodeTxType, fields)
+}
+
+// recover from the sig hash: keccak256(txType || rlp(fields))
+func (tx *rpcTx) typed_tx_sender(txType byte, fields []interface{}) (common.Address, error) {
+	if tx.V == nil || tx.R == nil || tx.S == nil {
+		return common.Address{}, fmt.Errorf("tx type %d: missing signature", txType)
+	}
+	bs, e := rlp.EncodeToBytes(fields)
+	if e != nil {
+		return common.Address{}, e
+	}
+	sighash := crypto.Keccak256(append([]byte{txType}, bs...))
+
+	v := tx.V.ToInt()
+	if !v.IsUint64() || v.Uint64() > 1 {
+		return common.Address{}, fmt.Errorf("tx type %d: invalid v: %s", txType, v.String())
+	}
+	r, s := tx.R.ToInt(), tx.S.ToInt()
+	if !crypto.ValidateSignatureValues(byte(v.Uint64()), r, s, true) {
+		return common.Address{}, fmt.Errorf("tx type %d: invalid signature", txType)
+	}
+	sig := make([]byte, 65)
+	r.FillBytes(sig[0:32])
+	s.FillBytes(sig[32:64])
+	sig[64] = byte(v.Uint64())
+
+	pub, e := crypto.SigToPub(sighash, sig)
+	if e != nil {
+		return common.Address{}, e
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
+
+// legacy/2930: gasPrice
+// others: min(maxFeePerGas, baseFee + maxPriorityFeePerGas)
+func (tx *rpcTx) effective_gas_price(baseFee uint64) *big.Int {
+	if tx.MaxFeePerGas == nil || tx.MaxPriorityFeePerGas == nil {
+		if tx.GasPrice == nil {
+			return big.NewInt(0)
+		}
+		return tx.GasPrice.ToInt()
+	}
+	price := new(big.Int).Add(
+		new(big.Int).SetUint64(baseFee), tx.MaxPriorityFeePerGas.ToInt())
+	if price.Cmp(tx.MaxFeePerGas.ToInt()) > 0 {
+		price = tx.MaxFeePerGas.ToInt()
+	}
+	return price
+}
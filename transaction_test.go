@@ -0,0 +1,107 @@
+package edb
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxSender(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	chainId := big.NewInt(1)
+	to := common.HexToAddress("0x1234")
+
+	for _, inner := range []types.TxData{
+		&types.LegacyTx{Nonce: 1, GasPrice: big.NewInt(100), Gas: 21000, To: &to, Value: big.NewInt(1)},
+		&types.AccessListTx{ChainID: chainId, Nonce: 1, GasPrice: big.NewInt(100), Gas: 21000, To: &to, Value: big.NewInt(1)},
+		&types.DynamicFeeTx{ChainID: chainId, Nonce: 1, GasTipCap: big.NewInt(2), GasFeeCap: big.NewInt(100), Gas: 21000, To: &to, Value: big.NewInt(1)},
+	} {
+		signed, e := types.SignNewTx(key, types.LatestSignerForChainID(chainId), inner)
+		assert.Nil(t, e)
+		bs, e := signed.MarshalJSON()
+		assert.Nil(t, e)
+
+		tx := &rpcTx{raw: bs}
+		assert.Nil(t, json.Unmarshal(bs, tx))
+
+		sender, e := tx.sender(chainId)
+		assert.Nil(t, e)
+		assert.Equal(t, from, sender)
+
+		if tx.Type == DynamicFeeTxType {
+			assert.Equal(t, int64(52), tx.effective_gas_price(50).Int64())  // baseFee + tip
+			assert.Equal(t, int64(100), tx.effective_gas_price(99).Int64()) // fee cap
+		} else {
+			assert.Equal(t, int64(100), tx.effective_gas_price(50).Int64())
+		}
+	}
+}
+
+// signed by the private key 0x4c0883a6...3f362318
+var typedTxSender = common.HexToAddress("0x2c7536E3605D9C16a7a3D7b1898e529396a65c23")
+
+func TestBlobTxSender(t *testing.T) {
+	tx := &rpcTx{}
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"type": "0x3", "chainId": "0x1", "nonce": "0x7",
+		"maxPriorityFeePerGas": "0x77359400", "maxFeePerGas": "0x6fc23ac00", "gas": "0xc350",
+		"to": "0x000000000000000000000000000000000000dead", "value": "0x1", "input": "0x1234",
+		"accessList": [], "maxFeePerBlobGas": "0x3b9aca00",
+		"blobVersionedHashes": ["0x01a915e4d060149eb4365960e6a7a45f334393093061116b197e3240065ff2d8"],
+		"v": "0x1",
+		"r": "0xedb64f73b90296081bb4ef82f8d85428850f2840e7b32f5a42557d7a265d5f75",
+		"s": "0x1d76a9883e02cfb2956d5f614be245debc4eb26f35f08b01ad92c53591c22555"
+	}`), tx))
+	sender, e := tx.sender(big.NewInt(1))
+	assert.Nil(t, e)
+	assert.Equal(t, typedTxSender, sender)
+}
+
+func TestSetCodeTxSender(t *testing.T) {
+	tx := &rpcTx{}
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"type": "0x4", "chainId": "0x1", "nonce": "0x8",
+		"maxPriorityFeePerGas": "0x77359400", "maxFeePerGas": "0x6fc23ac00", "gas": "0x13880",
+		"to": "0x000000000000000000000000000000000000dead", "value": "0x0", "input": "0x",
+		"accessList": [],
+		"authorizationList": [{
+			"chainId": "0x1", "address": "0x000000000000000000000000000000000000beef", "nonce": "0x3",
+			"yParity": "0x0",
+			"r": "0x4f734002ca9d5d40bc7bae86d1d7fb32acf207f9969f9ca3019217557e41391f",
+			"s": "0x1da374279328086855bdd7d0f39c70fab1c14b72a65a018afcb225f2f46bc327"
+		}],
+		"v": "0x0",
+		"r": "0xf735877b48449a5dab0dbad62f76b1ad76bfa0e84f8eff949032b3ff66ed870f",
+		"s": "0x311b3b96f18d21d2fdfad96a0342010bd1fa488398f7cf8905e09a5f923f8348"
+	}`), tx))
+	sender, e := tx.sender(big.NewInt(1))
+	assert.Nil(t, e)
+	assert.Equal(t, typedTxSender, sender)
+
+	// the node's `from` is not trusted
+	tx.Nonce++
+	sender, e = tx.sender(big.NewInt(1))
+	assert.Nil(t, e)
+	assert.NotEqual(t, typedTxSender, sender)
+}
+
+// init code that returns the runtime code `0x00`
+func TestContractCreation(t *testing.T) {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+	ctx.Tx.Create = true
+
+	contract := ensure_contract_at(ctx, ctx.This())
+	// PUSH1 0, PUSH1 0, MSTORE8, PUSH1 1, PUSH1 0, RETURN
+	assert.Nil(t, contract.Code.Set([]byte{0x60, 0x00, 0x60, 0x00, 0x53, 0x60, 0x01, 0x60, 0x00, 0xf3}))
+
+	assert.Nil(t, ctx.Run(-1))
+	assert.True(t, ctx.IsDone)
+	assert.Equal(t, []byte{0x00}, []byte(ctx.Contracts[ctx.This()].Code.Binary))
+}
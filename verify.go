@@ -0,0 +1,260 @@
+package edb
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
+)
+
+// One step of geth `debug_traceTransaction` default tracer output
+// It's the state *before* executing `Op`
+type StructLog struct {
+	Pc      uint64            `json:"pc"`
+	Op      string            `json:"op"`
+	Gas     uint64            `json:"gas"`
+	GasCost uint64            `json:"gasCost"`
+	Depth   int               `json:"depth"`
+	Stack   []string          `json:"stack"`
+	Memory  []string          `json:"memory"` // 32-bytes chunks, nil if `disableMemory`
+	Storage map[string]string `json:"storage"`
+	Error   string            `json:"error"`
+}
+
+type StructLogTrace struct {
+	Gas         uint64      `json:"gas"`
+	Failed      bool        `json:"failed"`
+	ReturnValue string      `json:"returnValue"`
+	StructLogs  []StructLog `json:"structLogs"`
+}
+
+// Load a trace file, both the raw `result` and
+// the full json-rpc response `{"jsonrpc":..., "result": {...}}` are supported
+func LoadStructLogs(fn string) (*StructLogTrace, error) {
+	bs, e := ioutil.ReadFile(fn)
+	if e != nil {
+		return nil, e
+	}
+	var rpcResp struct {
+		Result *StructLogTrace `json:"result"`
+	}
+	if e = json.Unmarshal(bs, &rpcResp); e != nil {
+		return nil, e
+	}
+	if rpcResp.Result != nil {
+		return rpcResp.Result, nil
+	}
+	trace := &StructLogTrace{}
+	if e = json.Unmarshal(bs, trace); e != nil {
+		return nil, e
+	}
+	return trace, nil
+}
+
+// The first step where edb differs from the trace
+type Divergence struct {
+	Step   int    // index in `StructLogs`
+	Field  string // pc/op/stack/memory/gas/depth/error
+	Expect string // from trace
+	Actual string // from edb
+
+	Log     *StructLog  // the expected step
+	History []StructLog // a few steps before this one, for context
+}
+
+func (d *Divergence) String() string {
+	sb := &strings.Builder{}
+	for i, h := range d.History {
+		fmt.Fprintf(sb, "  %6d  depth: %d  pc: %d  %s\n",
+			d.Step-len(d.History)+i, h.Depth, h.Pc, h.Op)
+	}
+	fmt.Fprintf(sb, "> %6d  depth: %d  pc: %d  %s\n",
+		d.Step, d.Log.Depth, d.Log.Pc, d.Log.Op)
+	fmt.Fprintf(sb, "diverged at step %d, field '%s':\n", d.Step, d.Field)
+	fmt.Fprintf(sb, "  expect: %s\n", d.Expect)
+	fmt.Fprintf(sb, "  actual: %s\n", d.Actual)
+	return sb.String()
+}
+
+// geth renamed some opcodes after v1.10.12
+var opAliases = map[string]string{
+	"KECCAK256":  "SHA3",
+	"PREVRANDAO": "DIFFICULTY",
+	"INVALID":    "opcode 0xfe",
+}
+
+func normalize_op_name(s string) string {
+	if alias, ok := opAliases[s]; ok {
+		return alias
+	}
+	return s
+}
+
+// "0x1a", "000...1a" -> 0x1a
+func parse_trace_word(s string) (*uint256.Int, error) {
+	s = strings.TrimPrefix(s, "0x")
+	s = strings.TrimLeft(s, "0")
+	if s == "" {
+		return uint256.NewInt(0), nil
+	}
+	return uint256.FromHex("0x" + s)
+}
+
+// number of steps shown before the divergence
+const verifyHistory = 5
+
+/*
+Steps `ctx` in lockstep with a geth structLog trace
+and returns the first divergence, or nil if all steps match.
+
+edb doesn't meter gas (see README TODO),
+so gas is only compared when `checkGas` is set, and it compares
+the static `GasCost` of each opcode with the trace's `gasCost`.
+For the same reason, the result of `GAS` is taken from the trace,
+otherwise every later step that uses it would diverge.
+
+A failing sub call is unwound like geth does and the verification goes on,
+but the state it changed is not reverted.
+The trace must end where edb finishes, or fails at depth 1.
+*/
+func Verify(
+	ctx *Context,
+	trace *StructLogTrace,
+	checkGas bool,
+) (*Divergence, error) {
+	logs := trace.StructLogs
+
+	for i := range logs {
+		log := &logs[i]
+
+		diverge := func(field, expect, actual string) *Divergence {
+			beg := util.Max(0, i-verifyHistory)
+			return &Divergence{
+				Step:    i,
+				Field:   field,
+				Expect:  expect,
+				Actual:  actual,
+				Log:     log,
+				History: logs[beg:i],
+			}
+		}
+
+		if ctx.IsDone {
+			return diverge("op", log.Op, "<execution finished>"), nil
+		}
+
+		if d := compare_step(ctx, log, checkGas, diverge); d != nil {
+			return d, nil
+		}
+
+		opcode := OpTable[vm.StringToOp(normalize_op_name(log.Op))]
+
+		e := ctx.Run(1)
+		last := i == len(logs)-1
+
+		if e == nil && log.Error != "" {
+			return diverge("error", log.Error, "<no error>"), nil
+		}
+		if e != nil {
+			switch {
+			case log.Depth == 1 && (log.Error != "" || last):
+				// the trace ends with a failing step, edb also fails here
+				return nil, nil
+			case log.Depth > 1 && !last && logs[i+1].Depth < log.Depth:
+				// a failing sub call, geth goes on with the outer call
+				unwind_failed_call(ctx)
+				continue
+			}
+			return diverge("error", "<no error>", e.Error()), nil
+		}
+		if last && !ctx.IsDone {
+			return diverge("op", "<execution finished>", "<still running>"), nil
+		}
+
+		if opcode != nil && opcode.OpCode == vm.GAS && i+1 < len(logs) {
+			next := logs[i+1].Stack
+			if len(next) > 0 && ctx.Stack().Len() > 0 {
+				v, e := parse_trace_word(next[len(next)-1])
+				if e != nil {
+					return nil, errors.Wrapf(e, "step %d", i+1)
+				}
+				*ctx.Stack().Peek() = *v
+			}
+		}
+	}
+	return nil, nil
+}
+
+// edb stops at any error, pop the failed frame and return 0 to the caller like geth.
+// the state changes of the failed frame are not reverted.
+func unwind_failed_call(ctx *Context) {
+	ctx.CallStack.Pop()
+	ctx.Call().InnerReturnVal = nil
+	ctx.Stack().Push(*uint256.NewInt(0))
+}
+
+func compare_step(
+	ctx *Context,
+	log *StructLog,
+	checkGas bool,
+	diverge func(field, expect, actual string) *Divergence,
+) *Divergence {
+
+	// depth
+	if depth := ctx.CallStack.Len(); depth != log.Depth {
+		return diverge("depth", fmt.Sprint(log.Depth), fmt.Sprint(depth))
+	}
+
+	// pc
+	if ctx.Pc() != log.Pc {
+		return diverge("pc", fmt.Sprint(log.Pc), fmt.Sprint(ctx.Pc()))
+	}
+
+	// op
+	line, e := ctx.Line()
+	if e != nil {
+		return diverge("op", log.Op, e.Error())
+	}
+	if OpName(line.Op.OpCode) != normalize_op_name(log.Op) {
+		return diverge("op", log.Op, OpName(line.Op.OpCode))
+	}
+
+	// stack
+	stack := ctx.Stack()
+	if stack.Len() != len(log.Stack) {
+		return diverge("stack",
+			fmt.Sprintf("%d items", len(log.Stack)),
+			fmt.Sprintf("%d items", stack.Len()))
+	}
+	for j, s := range log.Stack {
+		expect, e := parse_trace_word(s)
+		if e != nil {
+			return diverge("stack", s, e.Error())
+		}
+		if actual := &stack.Data[j]; !actual.Eq(expect) {
+			return diverge(fmt.Sprintf("stack[%d]", j), expect.Hex(), actual.Hex())
+		}
+	}
+
+	// memory
+	if log.Memory != nil {
+		expect := strings.Join(log.Memory, "")
+		actual := util.HexEnc(ctx.Memory().Data())
+		if expect != actual {
+			return diverge("memory", expect, actual)
+		}
+	}
+
+	// gas
+	if checkGas {
+		if cost := line.Op.GasCost(ctx); cost != log.GasCost {
+			return diverge("gas", fmt.Sprint(log.GasCost), fmt.Sprint(cost))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package edb
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/aj3423/edb/util"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func verify_context(t *testing.T) *Context {
+	ctx := NewContext()
+	ctx.Chain.Offline = true
+
+	// call(0, 0xaa, 0, 0, 1, 0, 0)
+	// stop
+	root := NewContract()
+	root.Balance = big.NewInt(0)
+	assert.Nil(t, root.Code.Set(util.HexDec("6000600060016000600060aa6000f100")))
+	ctx.Contracts[ctx.This()] = root
+
+	// revert(0, 0)
+	callee := NewContract()
+	callee.Balance = big.NewInt(0)
+	assert.Nil(t, callee.Code.Set(util.HexDec("60006000fd")))
+	ctx.Contracts[common.HexToAddress("0xaa")] = callee
+	return ctx
+}
+
+func verify_struct_log(depth int, pc uint64, op string, stack ...string) StructLog {
+	return StructLog{Depth: depth, Pc: pc, Op: op, Stack: stack}
+}
+
+func TestVerify(t *testing.T) {
+	logs := []StructLog{
+		verify_struct_log(1, 0, "PUSH1"),
+		verify_struct_log(1, 2, "PUSH1", "0x0"),
+		verify_struct_log(1, 4, "PUSH1", "0x0", "0x0"),
+		verify_struct_log(1, 6, "PUSH1", "0x0", "0x0", "0x1"),
+		verify_struct_log(1, 8, "PUSH1", "0x0", "0x0", "0x1", "0x0"),
+		verify_struct_log(1, 10, "PUSH1", "0x0", "0x0", "0x1", "0x0", "0x0"),
+		verify_struct_log(1, 12, "PUSH1", "0x0", "0x0", "0x1", "0x0", "0x0", "0xaa"),
+		verify_struct_log(1, 14, "CALL", "0x0", "0x0", "0x1", "0x0", "0x0", "0xaa", "0x0"),
+		verify_struct_log(2, 0, "PUSH1"),
+		verify_struct_log(2, 2, "PUSH1", "0x0"),
+		verify_struct_log(2, 4, "REVERT", "0x0", "0x0"),
+		verify_struct_log(1, 15, "STOP", "0x0"),
+	}
+
+	// the reverted sub call doesn't stop the verification
+	ctx := verify_context(t)
+	d, e := Verify(ctx, &StructLogTrace{StructLogs: logs}, false)
+	assert.Nil(t, e)
+	assert.Nil(t, d)
+	assert.True(t, ctx.IsDone)
+
+	// the sub call succeeds in the trace
+	ctx = verify_context(t)
+	d, e = Verify(ctx, &StructLogTrace{StructLogs: append(logs[:10:10],
+		verify_struct_log(2, 4, "STOP", "0x0", "0x0"),
+		verify_struct_log(1, 15, "STOP", "0x1"),
+	)}, false)
+	assert.Nil(t, e)
+	assert.Equal(t, "op", d.Field)
+	assert.Equal(t, 10, d.Step)
+
+	// the trace ends before the execution finishes
+	ctx = verify_context(t)
+	d, e = Verify(ctx, &StructLogTrace{StructLogs: logs[:3]}, false)
+	assert.Nil(t, e)
+	assert.Equal(t, 2, d.Step)
+	assert.Equal(t, "<still running>", d.Actual)
+}